@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go/mirror_backup/pkg/mirror"
+)
+
+// auditReport is the JSON report of an audit
+type auditReport struct {
+	Entries []mirror.AuditEntry `json:"entries"`
+	Totals  map[string]int64    `json:"totals"`
+}
+
+// runAudit compares the source and destination directories of the config files as mirroring does, and reports the differences without
+// changing anything. the exit code is 0 if the directories are in sync, 1 if they differ, or 2 if they could not be compared
+func runAudit(args []string) int {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	output := flags.String("output", "", "file to write the report into, as CSV if its name ends with .csv, otherwise as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage:\n  %s audit [-output file] config1.yml [config2.yml ...]\n\nFlags:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	configs, err := mirror.LoadConfigFiles(flags.Args(), false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	report := auditReport{Totals: map[string]int64{mirror.AuditStatusMissing: 0, mirror.AuditStatusExtra: 0, mirror.AuditStatusDiffers: 0, mirror.AuditStatusError: 0}}
+	for _, config := range configs {
+		entries, err := mirror.Audit(config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Job '%s': %s\n", config.General.Name, err)
+			return 2
+		}
+
+		for _, entry := range entries {
+			fmt.Printf("%-8s %s", entry.Status, filepath.Join(entry.Destination, entry.Path))
+			if len(entry.Reason) > 0 {
+				fmt.Printf(" (%s)", entry.Reason)
+			}
+			fmt.Println()
+
+			report.Totals[entry.Status]++
+		}
+		report.Entries = append(report.Entries, entries...)
+	}
+
+	if len(*output) > 0 {
+		if err := writeAuditReport(*output, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report; %s\n", err)
+			return 2
+		}
+	}
+
+	if len(report.Entries) < 1 {
+		fmt.Println("In sync")
+		return 0
+	}
+
+	fmt.Printf("Missing %d, extra %d, differing %d, failed to compare %d\n", report.Totals[mirror.AuditStatusMissing], report.Totals[mirror.AuditStatusExtra],
+		report.Totals[mirror.AuditStatusDiffers], report.Totals[mirror.AuditStatusError])
+	return 1
+}
+
+// writeAuditReport writes the report into the file, as CSV if its name ends with .csv, otherwise as JSON
+func writeAuditReport(path string, report auditReport) error {
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		var buffer strings.Builder
+		writer := csv.NewWriter(&buffer)
+		writer.Write([]string{"job", "destination", "path", "status", "reason", "sourceSize", "destinationSize", "sourceModTime", "destinationModTime"})
+		for _, entry := range report.Entries {
+			writer.Write([]string{entry.Job, entry.Destination, entry.Path, entry.Status, entry.Reason, formatAuditSize(entry.SourceSize),
+				formatAuditSize(entry.DestinationSize), formatAuditTime(entry.SourceModTime), formatAuditTime(entry.DestinationModTime)})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+		data = []byte(buffer.String())
+	} else {
+		if report.Entries == nil {
+			report.Entries = []mirror.AuditEntry{}
+		}
+
+		var err error
+		if data, err = json.MarshalIndent(report, "", "  "); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func formatAuditSize(size *int64) string {
+	if size == nil {
+		return ""
+	}
+	return strconv.FormatInt(*size, 10)
+}
+
+func formatAuditTime(modTime *time.Time) string {
+	if modTime == nil {
+		return ""
+	}
+	return modTime.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go/mirror_backup/pkg/mirror"
+)
+
+// runDecrypt runs the decrypt command, which restores the files of an encrypted destination into another directory, and returns the
+// exit code
+func runDecrypt(args []string) int {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	var keyFiles, passphrases stringListFlag
+	flags.Var(&keyFiles, "key-file", "key file the files were encrypted with (can be repeated, e.g. for the keys before a rotation)")
+	flags.Var(&passphrases, "passphrase", "passphrase the files were encrypted with (can be repeated)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage:\n  %s decrypt [-key-file file] [-passphrase passphrase] <encrypted path> <output path>\n\nFlags:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || len(keyFiles)+len(passphrases) < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	decrypted, problems, err := mirror.Decrypt(flags.Arg(0), flags.Arg(1), keyFiles, passphrases)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("Decrypted %d files, failed %d\n", decrypted, len(problems))
+
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
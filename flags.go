@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// version of the build, injected at build time (go build -ldflags "-X main.version=1.2.3")
+var version = "dev"
+
+// stringListFlag collects the values of a flag which can be repeated
+type stringListFlag []string
+
+func (values *stringListFlag) String() string {
+	return strings.Join(*values, ", ")
+}
+
+func (values *stringListFlag) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "Usage:\n")
+	fmt.Fprintf(out, "  %s [flags] [config1.yml config2.yml ...]\n", os.Args[0])
+	fmt.Fprintf(out, "  %s validate [config1.yml config2.yml ...]\n", os.Args[0])
+	fmt.Fprintf(out, "  %s audit [-output file] [config1.yml config2.yml ...]\n", os.Args[0])
+	fmt.Fprintf(out, "  %s verify-manifest <destination directory> [destination directory ...]\n", os.Args[0])
+	fmt.Fprintf(out, "  %s decrypt [-key-file file] [-passphrase passphrase] <encrypted path> <output path>\n", os.Args[0])
+	fmt.Fprintf(out, "\nConfig files are given by --config flags, or as positional arguments (or both).\n")
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// usageError reports an invalid command line along with the usage, and exits with the exit code of invalid usage
+func usageError(msg string) {
+	fmt.Fprintf(flag.CommandLine.Output(), "%s\n\n", msg)
+	printUsage()
+	os.Exit(2)
+}
@@ -1,29 +1,184 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+
+	"go/mirror_backup/pkg/mirror"
 )
 
 func main() {
-	// we expect one or more config files provided via args
+	// parse optional flags, which override the matching config file settings
+	var configFlags stringListFlag
+	flag.Var(&configFlags, "config", "config file to run (can be repeated)")
+	runOnce := flag.Bool("once", false, "run a single scan-and-mirror iteration per config, then exit")
+	dryRun := flag.Bool("dry-run", false, "only report planned copies and deletes, without touching the destination")
+	forceDelete := flag.Bool("force-delete", false, "ignore the deletion safety thresholds, for legitimate large cleanups")
+	breakLock := flag.Bool("break-lock", false, "mirror into destinations locked by another instance, with a warning")
+	maxTotalWorkers := flag.Int("max-total-workers", 0, "maximum count of concurrent operations of all jobs together")
+	logLevel := flag.String("log-level", "", "minimum level of logged messages: debug, info, warn or error")
+	printVersion := flag.Bool("version", false, "print the version, then exit")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if *printVersion {
+		fmt.Println(version)
+		return
+	}
+
+	if _, err := mirror.ParseLogLevel(*logLevel); len(*logLevel) > 0 && err != nil {
+		usageError(fmt.Sprintf("Unknown log level '%s'", *logLevel))
+	}
+	if *maxTotalWorkers < 0 {
+		usageError("Max total workers must not be negative")
+	}
+
+	// in decrypt mode, restore the files of an encrypted destination, without mirroring
+	if flag.NArg() > 0 && flag.Arg(0) == "decrypt" {
+		os.Exit(runDecrypt(flag.Args()[1:]))
+	}
+	// in verify manifest mode, check the destination directories against their manifests, without mirroring
+	if flag.NArg() > 0 && flag.Arg(0) == "verify-manifest" {
+		os.Exit(runVerifyManifest(flag.Args()[1:]))
+	}
+	// in audit mode, report the differences between the source and the destinations, without mirroring
+	if flag.NArg() > 0 && (flag.Arg(0) == "audit" || flag.Arg(0) == "diff") {
+		os.Exit(runAudit(flag.Args()[1:]))
+	}
+
+	// config files are given by flags, and by the remaining args (for compatibility), except for the validate command
+	args := flag.Args()
+	validate := len(args) > 0 && args[0] == "validate"
+	if validate {
+		args = args[1:]
+	}
+	configFiles := append([]string(configFlags), args...)
 
 	// make sure at least one config was specified
-	if len(os.Args) < 2 {
-		panic("Config file name argument is missing")
+	if len(configFiles) < 1 {
+		usageError("Config file name argument is missing")
+	}
+
+	// in validate mode, only check the config files and report the problems found, without mirroring
+	if validate {
+		problems := mirror.ValidateConfigFiles(configFiles)
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+
+		fmt.Println("Configuration is valid")
+		return
 	}
 
-	// first argument is the application path, so ignore it and get other args
-	configFiles := os.Args[1:]
+	// apply flag overrides
+	applyFlags := func(configs []mirror.Config) []mirror.Config {
+		for i := range configs {
+			if *runOnce {
+				configs[i].General.RunOnce = true
+			}
+			if *dryRun {
+				configs[i].General.DryRun = true
+			}
+			if *forceDelete {
+				configs[i].General.ForceDelete = true
+			}
+			if *breakLock {
+				configs[i].General.BreakLock = true
+			}
+			if *maxTotalWorkers > 0 {
+				configs[i].Global.MaxTotalConcurrentWorkers = *maxTotalWorkers
+			}
+			if len(*logLevel) > 0 {
+				configs[i].General.LogLevel = *logLevel
+			}
+		}
+		return configs
+	}
 
-	// iterate every configuration and initialize watcher job for it
-	for _, config := range ReadFromFile(configFiles) {
-		// run watcher job in coroutine to allow multiple jobs to run concurrently
-		go RunScanLoop(config)
+	// read the configurations of every config file
+	supervisor, err := mirror.NewSupervisor(configFiles, applyFlags)
+	if err != nil {
+		// an invalid configuration is reported with the exit code of invalid usage
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	fmt.Println("Running, press Enter key to terminate")
+	// create a context which is cancelled once termination is requested by signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// run the jobs in the background, until termination is requested or all jobs ended (which happens when all jobs run once)
+	jobsDone := make(chan error, 1)
+	go func() {
+		jobsDone <- supervisor.Run(ctx)
+	}()
+
+	// allow to terminate using Enter key only when there is someone to press it
+	if isTerminal(os.Stdin) {
+		fmt.Println("Running, press Enter key or Ctrl+C to terminate")
+
+		go func() {
+			// wait for Enter key to allow the application to continue running until user wish to terminate
+			// (end of input, for example when stdin is the null device, is not a termination request)
+			if _, err := bufio.NewReader(os.Stdin).ReadString('\n'); err == nil {
+				stop()
+			}
+		}()
+	} else {
+		fmt.Println("Running, send SIGINT or SIGTERM to terminate")
+	}
+
+	// wait until termination is requested or all jobs ended
+	select {
+	case <-ctx.Done():
+		// restore default signal behavior, so another signal terminates immediately
+		stop()
+
+		fmt.Println("Terminating, waiting for in-flight operations to finish")
+
+		// wait for all jobs to end
+		err = <-jobsDone
+	case err = <-jobsDone:
+	}
+
+	printJobSummaries()
+
+	// exit with a non-zero code if any operation failed, so wrappers (e.g. cron jobs) can alert on failure
+	if err != nil {
+		// failed jobs were logged already
+		if !errors.Is(err, mirror.ErrJobsFailed) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// printJobSummaries prints the totals of every job, once all jobs ended
+func printJobSummaries() {
+	for _, stats := range mirror.JobStats() {
+		fmt.Printf("Job '%s': copied %d, deleted %d, failed %d", stats.Job, stats.FilesCopied, stats.FilesDeleted, stats.FilesFailed)
+		if stats.LastError != nil {
+			fmt.Printf(", last error: %s", stats.LastError)
+		}
+		fmt.Println()
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	// get file info, to check whether it is a character device (terminal) rather than a pipe or a file
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
 
-	// use scanln to allow the application to continue running until user wish to terminate
-	fmt.Scanln()
+	return fileInfo.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,50 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// suffix of temporary files, which are renamed over the final destination path once completely written
+const tempFileSuffix = ".dmtmp"
+
+func getTempPath(path string) string {
+	// use a hidden name in the same directory, so the rename does not cross file systems
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+tempFileSuffix)
+}
+
+func isTempPath(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempFileSuffix)
+}
+
+func cleanupTempFiles(configs Config, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) {
+	// temporary files are never mirrored
+	for srcPath, srcFile := range srcFiles {
+		if !srcFile.IsDir() && isTempPath(srcPath) {
+			delete(srcFiles, srcPath)
+		}
+	}
+
+	// temporary files left over in the destination (by a run which was killed mid-copy) are removed
+	for dstPath, dstFile := range destFiles {
+		if dstFile.IsDir() || !isTempPath(dstPath) {
+			continue
+		}
+
+		delete(destFiles, dstPath)
+
+		// in dry run mode, the destination must not be touched
+		if configs.General.DryRun {
+			continue
+		}
+
+		path := filepath.Join(configs.General.DestinationDirectory, dstPath)
+		if err := configs.General.destination.Remove(path); err != nil {
+			logOperationError(configs.General.logger, "Remove", path, err)
+		} else {
+			configs.General.logger.Info("Remove", "path", path)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package mirror
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statuses of the differences found by an audit
+const (
+	AuditStatusMissing = "missing"
+	AuditStatusExtra   = "extra"
+	AuditStatusDiffers = "differs"
+	AuditStatusError   = "error"
+)
+
+// AuditEntry is a difference between the source and a destination, found by an audit
+type AuditEntry struct {
+	Job         string `json:"job"`
+	Destination string `json:"destination"`
+	// relative path of the file
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	// reason the files differ (or the error comparing them)
+	Reason             string     `json:"reason,omitempty"`
+	SourceSize         *int64     `json:"sourceSize,omitempty"`
+	DestinationSize    *int64     `json:"destinationSize,omitempty"`
+	SourceModTime      *time.Time `json:"sourceModTime,omitempty"`
+	DestinationModTime *time.Time `json:"destinationModTime,omitempty"`
+}
+
+// Audit compares the source directory of the job with its destination directories, scanning and filtering them exactly as an iteration
+// does (in dry run mode, so nothing is touched), and returns the differences of every destination sorted by destination and path. an error
+// is returned if the directories could not be compared
+func Audit(config Config) ([]AuditEntry, error) {
+	prepared, err := Prepare(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(prepared) != 1 {
+		return nil, fmt.Errorf("Configuration has %d sources, every configuration returned by Prepare must be audited", len(prepared))
+	}
+
+	configs := prepared[0]
+	configs.General.DryRun = true
+	// the differences are returned rather than logged, so only problems are logged (and no events are written)
+	configs.General.EventOutput = ""
+	configs.General.logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})).With("job", getJobName(configs))
+	if configs, err = initJobState(configs); err != nil {
+		return nil, err
+	}
+	configs.General.destination = newDestinationFS(configs)
+	defer configs.General.destination.Close()
+
+	if err := checkReadableDir(configs.General.source, configs.General.SourceDirectory); err != nil {
+		return nil, fmt.Errorf("source directory '%s' is unavailable; %w", configs.General.SourceDirectory, err)
+	}
+	if err := configs.General.destination.Connect(); err != nil {
+		return nil, fmt.Errorf("destination '%s' is unreachable; %w", getDestinationsDescription(configs), err)
+	}
+
+	var entries []AuditEntry
+
+	destConfigsList := setModTimeTolerances(getDestinationConfigs(configs))
+	trees := getFullScan(configs)(destConfigsList)
+	for i, destConfigs := range destConfigsList {
+		srcFiles := trees[i].srcFiles
+		destFiles := trees[i].destFiles
+
+		excludeUnmirroredFiles(destConfigs, srcFiles, destFiles)
+
+		// filtering counts the files it removes off the operations, which are never run here
+		var wg sync.WaitGroup
+		wg.Add(len(srcFiles) + len(destFiles))
+		filterFiles(destConfigs, srcFiles, destFiles, true, &wg)
+
+		entries = append(entries, compareFiles(destConfigs, srcFiles, destFiles)...)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Destination != entries[j].Destination {
+			return entries[i].Destination < entries[j].Destination
+		}
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries, nil
+}
+
+// compareFiles returns the differences between the source files and the destination files, compared by the configured compare mode
+func compareFiles(configs Config, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) []AuditEntry {
+	var entries []AuditEntry
+
+	for srcPath, srcFile := range srcFiles {
+		// symlinks are mirrored only when copied
+		if isSymlink(srcFile) && configs.General.SymlinkMode != symlinkModeCopy {
+			continue
+		}
+
+		srcFullPath := filepath.Join(configs.General.SourceDirectory, srcPath)
+		destFullPath := filepath.Join(configs.General.DestinationDirectory, srcPath)
+
+		destFile, exists := destFiles[srcPath]
+		if !exists {
+			entries = append(entries, newAuditEntry(configs, srcPath, AuditStatusMissing, "", srcFile, nil))
+			continue
+		}
+
+		var reason string
+		var err error
+		switch {
+		case srcFile.IsDir() != destFile.IsDir() || isSymlink(srcFile) != isSymlink(destFile):
+			reason = "type differs"
+		case srcFile.IsDir():
+			// directories are compared by their contents
+			continue
+		case isSymlink(srcFile):
+			var target string
+			if target, err = os.Readlink(srcFullPath); err == nil && !isSymlinkTarget(destFullPath, target) {
+				reason = "target differs"
+			}
+		default:
+			reason, err = getChangeReason(configs, srcFullPath, srcFile, destFullPath, destFile)
+		}
+
+		if err != nil {
+			entries = append(entries, newAuditEntry(configs, srcPath, AuditStatusError, err.Error(), srcFile, destFile))
+		} else if len(reason) > 0 {
+			entries = append(entries, newAuditEntry(configs, srcPath, AuditStatusDiffers, reason, srcFile, destFile))
+		}
+	}
+
+	for dstPath, destFile := range destFiles {
+		if _, exists := srcFiles[dstPath]; !exists {
+			entries = append(entries, newAuditEntry(configs, dstPath, AuditStatusExtra, "", nil, destFile))
+		}
+	}
+
+	return entries
+}
+
+func newAuditEntry(configs Config, relativePath string, status string, reason string, srcFile os.FileInfo, destFile os.FileInfo) AuditEntry {
+	entry := AuditEntry{
+		Job:         getJobName(configs),
+		Destination: configs.General.DestinationDirectory,
+		Path:        normalizeRelativePath(relativePath),
+		Status:      status,
+		Reason:      reason,
+	}
+	if srcFile != nil && !srcFile.IsDir() {
+		size, modTime := srcFile.Size(), srcFile.ModTime()
+		entry.SourceSize, entry.SourceModTime = &size, &modTime
+	}
+	if destFile != nil && !destFile.IsDir() {
+		size, modTime := destFile.Size(), destFile.ModTime()
+		entry.DestinationSize, entry.DestinationModTime = &size, &modTime
+	}
+
+	return entry
+}
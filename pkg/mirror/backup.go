@@ -0,0 +1,161 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// placeholder in the backup suffix which is replaced by the backup time, so multiple generations of a file can be kept
+const backupTimestampPlaceholder = "{timestamp}"
+
+func backupFile(configs Config, path string) error {
+	// nothing to do unless requested
+	if len(configs.General.BackupDirectory) < 1 || configs.General.DryRun {
+		return nil
+	}
+
+	// make sure there is something to backup
+	if _, err := os.Lstat(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	// build backup path, preserving the relative path of the file
+	now := time.Now()
+	suffix := strings.ReplaceAll(configs.General.BackupSuffix, backupTimestampPlaceholder, now.Format("20060102-150405"))
+	backupPath := filepath.Join(configs.General.BackupDirectory, getRelativePath(configs.General.DestinationDirectory, path)) + suffix
+
+	// make sure backup parent directory exists
+	if err := mkdirAll(filepath.Dir(backupPath), os.ModePerm); err != nil {
+		return err
+	}
+
+	// only a single generation is kept for the same backup path
+	if err := os.RemoveAll(backupPath); err != nil {
+		return err
+	}
+
+	// move the file into the backup directory
+	if err := moveFile(path, backupPath); err != nil {
+		return err
+	}
+
+	// stamp the backup with the backup time, so retention is counted from the moment it was backed up
+	stampTree(backupPath, now)
+
+	configs.General.logger.Info("Backup", "path", path, "backup", backupPath)
+	return nil
+}
+
+// stampTree sets the modification time of every entry of the tree (or of the single file) to the time, except for symlinks
+func stampTree(root string, now time.Time) {
+	filepath.Walk(root, func(walkPath string, info os.FileInfo, err error) error {
+		if err == nil && !isSymlink(info) {
+			os.Chtimes(walkPath, now, now)
+		}
+		return nil
+	})
+}
+
+func moveFile(src string, dst string) error {
+	// try to rename, which is instant on the same volume
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	// rename failed (e.g. across volumes), so fall back to copy and delete
+	if err := copyTree(src, dst); err != nil {
+		// do not leave a partial copy behind
+		os.RemoveAll(dst)
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
+
+func copyTree(src string, dst string) error {
+	// copy every entry of the tree (or the single file), preserving permissions and modification times
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, getRelativePath(src, path))
+
+		switch {
+		case info.IsDir():
+			if err := mkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		case isSymlink(info):
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(linkTarget, target)
+		default:
+			// the backup is completed even when termination is requested, since the operation it precedes already started
+			if err := copyFile(context.Background(), path, target, copyOptions{}); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
+
+func pruneBackups(configs Config) {
+	// nothing to do unless requested
+	if len(configs.General.BackupDirectory) < 1 || configs.General.BackupRetentionDays < 1 || configs.General.DryRun {
+		return
+	}
+
+	// backups older than this time are removed
+	pruneDirectory(configs, configs.General.BackupDirectory, time.Now().AddDate(0, 0, -configs.General.BackupRetentionDays), "Remove")
+}
+
+// pruneDirectory removes the files of the directory modified before the cutoff, logged as the given operation, and then the directories
+// left empty
+func pruneDirectory(configs Config, root string, cutoff time.Time, operation string) {
+	// collect directories, so the empty ones can be removed after their contents
+	var dirs []string
+
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		// ignore root path dir, and entries which could not be read
+		if err != nil || path == root {
+			return nil
+		}
+
+		if info.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(path); err != nil {
+				logOperationError(configs.General.logger, operation, path, err)
+			} else {
+				configs.General.logger.Info(operation, "path", path)
+			}
+		}
+
+		return nil
+	})
+
+	// remove directories left empty, deepest first (removing a non-empty directory fails, which is expected)
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		os.Remove(dir)
+	}
+}
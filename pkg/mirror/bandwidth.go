@@ -0,0 +1,179 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// size of the chunks a throttled copy reads, so the throughput is spread evenly over time
+const throttleChunkSize = 32 * 1024
+
+// bandwidthWindow is a daily time window with its own bandwidth limit (in minutes since midnight, the end is exclusive)
+type bandwidthWindow struct {
+	start          int
+	end            int
+	bytesPerSecond int64
+}
+
+// bandwidthLimiter is a token bucket shared by all the copy operations of a job, so their aggregate throughput is limited
+type bandwidthLimiter struct {
+	mutex sync.Mutex
+
+	bytesPerSecond int64
+	schedule       []bandwidthWindow
+
+	// available bytes, negative when the bucket is overdrawn by reservations which are waited for
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newBandwidthLimiter(general GeneralConfigurations) *bandwidthLimiter {
+	// schedule is validated when the configuration is read
+	schedule, _ := parseBandwidthSchedule(general.BandwidthSchedule)
+
+	// nothing to limit
+	if general.MaxBytesPerSecond < 1 && len(schedule) < 1 {
+		return nil
+	}
+
+	return &bandwidthLimiter{bytesPerSecond: general.MaxBytesPerSecond, schedule: schedule, lastRefill: time.Now()}
+}
+
+// getLimit returns the bandwidth limit in effect at the given time (0 for unlimited)
+func (limiter *bandwidthLimiter) getLimit(now time.Time) int64 {
+	minute := now.Hour()*60 + now.Minute()
+
+	for _, window := range limiter.schedule {
+		// a window may cross midnight (e.g. 22:00-06:00), and a window which ends when it starts lasts the whole day
+		if window.start == window.end || (window.start < window.end && minute >= window.start && minute < window.end) ||
+			(window.start > window.end && (minute >= window.start || minute < window.end)) {
+			return window.bytesPerSecond
+		}
+	}
+
+	return limiter.bytesPerSecond
+}
+
+// wait blocks until the given count of bytes may be transferred
+func (limiter *bandwidthLimiter) wait(bytes int) {
+	limiter.mutex.Lock()
+
+	now := time.Now()
+	limit := limiter.getLimit(now)
+	if limit < 1 {
+		// unlimited at the moment
+		limiter.lastRefill = now
+		limiter.mutex.Unlock()
+		return
+	}
+
+	// refill the bucket by the elapsed time, holding up to a second worth of bytes
+	limiter.tokens += now.Sub(limiter.lastRefill).Seconds() * float64(limit)
+	if limiter.tokens > float64(limit) {
+		limiter.tokens = float64(limit)
+	}
+	limiter.lastRefill = now
+
+	// reserve the bytes, and compute how long it takes until the bucket is no longer overdrawn
+	limiter.tokens -= float64(bytes)
+	delay := time.Duration(-limiter.tokens / float64(limit) * float64(time.Second))
+
+	limiter.mutex.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// throttledReader limits the throughput of reading from the underlying reader
+type throttledReader struct {
+	reader  io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (throttled *throttledReader) Read(p []byte) (int, error) {
+	// read in small chunks, so a single read does not take a large share of the bandwidth at once
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+
+	n, err := throttled.reader.Read(p)
+	if n > 0 {
+		throttled.limiter.wait(n)
+	}
+
+	return n, err
+}
+
+// parseBandwidthSchedule parses entries in the form of 'HH:MM-HH:MM=<size>' (e.g. '09:00-18:00=5MB')
+func parseBandwidthSchedule(entries []string) ([]bandwidthWindow, error) {
+	var schedule []bandwidthWindow
+
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid bandwidth schedule entry '%s'", entry)
+		}
+
+		times := strings.SplitN(strings.TrimSpace(parts[0]), "-", 2)
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid bandwidth schedule entry '%s'", entry)
+		}
+
+		start, err := parseTimeOfDay(times[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(times[1])
+		if err != nil {
+			return nil, err
+		}
+
+		bytesPerSecond, err := parseByteSize(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		schedule = append(schedule, bandwidthWindow{start: start, end: end, bytesPerSecond: bytesPerSecond})
+	}
+
+	return schedule, nil
+}
+
+// parseTimeOfDay parses a time in the form of 'HH:MM' into minutes since midnight
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day '%s'", value)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseByteSize parses a size with an optional binary unit (e.g. '512KB', '5MB', '1G')
+func parseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", value)
+	}
+
+	return int64(size * float64(multiplier)), nil
+}
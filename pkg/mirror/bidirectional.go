@@ -0,0 +1,426 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	syncModeOneWay        = "oneWay"
+	syncModeBidirectional = "bidirectional"
+)
+
+const (
+	conflictPolicyNewer    = "newer"
+	conflictPolicyKeepBoth = "keepBoth"
+)
+
+// syncedEntry is a path which was in sync after the last bidirectional iteration, along with its info on both sides
+type syncedEntry struct {
+	Dir           bool  `json:"dir,omitempty"`
+	Size          int64 `json:"size,omitempty"`
+	SourceModTime int64 `json:"sourceModTime,omitempty"`
+	DestModTime   int64 `json:"destModTime,omitempty"`
+}
+
+// syncedState is the JSON format of the paths which were in sync after the last bidirectional iteration, which tells a file deleted on one
+// side from a file created on the other side. it belongs to the directories it was recorded for
+type syncedState struct {
+	SourceDirectory      string                 `json:"sourceDirectory"`
+	DestinationDirectory string                 `json:"destinationDirectory"`
+	Paths                map[string]syncedEntry `json:"paths"`
+}
+
+// bidirectionalPlan is what a bidirectional iteration planned for its destination, which the synced state is updated by once it ended
+type bidirectionalPlan struct {
+	// paths which were in sync after the previous iteration
+	synced map[string]syncedEntry
+	// paths copied in either direction
+	copied map[string]bool
+}
+
+// validateBidirectionalMode checks the settings which both directories can not be synchronized with. each directory is read and written as
+// the other one, so both are local and stored as they are
+func validateBidirectionalMode(general GeneralConfigurations) error {
+	if len(general.StateFile) < 1 {
+		return errors.New("Bidirectional sync mode requires a state file, which records the files in sync after every iteration")
+	}
+	if len(general.DestinationURL) > 0 || general.CompressDestination != compressionNone || isEncrypted(general) {
+		return errors.New("Bidirectional sync mode requires a local destination, without compression or encryption")
+	}
+	if len(general.DestinationDirectories) > 1 {
+		return errors.New("Bidirectional sync mode requires a single destination directory")
+	}
+	if general.SnapshotMode {
+		return errors.New("Snapshot mode cannot be used with bidirectional sync mode")
+	}
+	if general.WatchMode == watchModeEvents {
+		return errors.New("Events watch mode cannot be used with bidirectional sync mode, changes of the destination are found by full scans")
+	}
+	if general.SymlinkMode == symlinkModeFollow {
+		return errors.New("Follow symlink mode cannot be used with bidirectional sync mode, the destination would be written back through them")
+	}
+	if general.CopyMode != copyModeCopy {
+		return fmt.Errorf("Copy mode '%s' cannot be used with bidirectional sync mode", general.CopyMode)
+	}
+	if general.OverwritePolicy != overwritePolicyAlways {
+		return errors.New("Overwrite policy cannot be used with bidirectional sync mode, conflicts are resolved by the conflict policy")
+	}
+	if general.ConflictPolicy != conflictPolicyNewer && general.ConflictPolicy != conflictPolicyKeepBoth {
+		return fmt.Errorf("Unknown conflict policy '%s'", general.ConflictPolicy)
+	}
+	return nil
+}
+
+// getReversedConfigs returns the configuration which mirrors the destination directory into the source directory
+func getReversedConfigs(configs Config) Config {
+	reversed := configs
+	reversed.General.SourceDirectory, reversed.General.DestinationDirectory = configs.General.DestinationDirectory, configs.General.SourceDirectory
+	// both directories are local
+	reversed.General.source = configs.General.destination
+	reversed.General.destination = localFS{}
+
+	return reversed
+}
+
+// processBidirectionalChanges plans the operations which bring both directories in sync: a file created or changed on one side is copied to
+// the other side, and a file deleted on one side is deleted on the other side, as told by the paths which were in sync after the previous
+// iteration. a file changed on both sides is a conflict, which is resolved by the conflict policy
+func processBidirectionalChanges(ctx context.Context, configs Config, stats *iterationStats, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo, wg *sync.WaitGroup) ([]func(), []func(), *bidirectionalPlan) {
+	reversed := getReversedConfigs(configs)
+
+	// paths of both sides before filtering, since a path filtered on one side only (e.g. by the size of its file) is left alone on the
+	// other side as well
+	srcScanned := getPathSet(srcFiles)
+	destScanned := getPathSet(destFiles)
+
+	// remove any filtered paths from both containers, so such files are neither copied nor deleted on either side (each side keeps the
+	// directories whose contents are filtered)
+	filterFiles(configs, srcFiles, destFiles, true, wg)
+	filterFiles(reversed, destFiles, srcFiles, false, wg)
+
+	// every entry counts as one operation so far, the planned operations replace them
+	planned := len(srcFiles) + len(destFiles)
+	plan := &bidirectionalPlan{synced: configs.General.hashes.getSynced(configs.General.SourceDirectory, configs.General.DestinationDirectory), copied: make(map[string]bool)}
+
+	// create containers for the planned operations, by the side they change
+	toDest := make(map[string]os.FileInfo)
+	toSource := make(map[string]os.FileInfo)
+	deleteDest := make(map[string]os.FileInfo)
+	deleteSource := make(map[string]os.FileInfo)
+	var conflicts []string
+
+	paths := getPathSet(srcFiles)
+	for relativePath := range destFiles {
+		paths[relativePath] = true
+	}
+
+	for relativePath := range paths {
+		srcFile, srcExists := srcFiles[relativePath]
+		destFile, destExists := destFiles[relativePath]
+		entry, wasSynced := plan.synced[relativePath]
+
+		// filtered on both sides, or on one side only
+		if (!srcExists && !destExists) || (srcExists && destScanned[relativePath] && !destExists) || (destExists && srcScanned[relativePath] && !srcExists) {
+			continue
+		}
+
+		// only files and directories are synchronized, anything else is left alone on both sides
+		if (srcExists && !srcFile.Mode().IsRegular() && !srcFile.IsDir()) || (destExists && !destFile.Mode().IsRegular() && !destFile.IsDir()) {
+			configs.General.logger.Debug("Skip", "path", filepath.Join(configs.General.SourceDirectory, relativePath), "reason", "not a regular file")
+			continue
+		}
+
+		switch {
+		case srcExists && destExists:
+			if srcFile.IsDir() != destFile.IsDir() {
+				configs.General.logger.Warn("Conflict", "path", filepath.Join(configs.General.DestinationDirectory, relativePath), "reason", "file and directory")
+				continue
+			}
+			if srcFile.IsDir() {
+				continue
+			}
+
+			reason, err := getChangeReason(configs, filepath.Join(configs.General.SourceDirectory, relativePath), srcFile, filepath.Join(configs.General.DestinationDirectory, relativePath), destFile)
+			if err != nil {
+				recordOperationFailure(configs, stats, "Compare", filepath.Join(configs.General.DestinationDirectory, relativePath), err)
+				continue
+			}
+			if len(reason) < 1 {
+				stats.addUnchanged()
+				continue
+			}
+
+			// a file changed on one side only is copied to the other side, while a file changed on both sides (or never in sync) conflicts
+			srcChanged := !wasSynced || isChangedSinceSync(entry, srcFile, entry.SourceModTime)
+			destChanged := !wasSynced || isChangedSinceSync(entry, destFile, entry.DestModTime)
+			switch {
+			case srcChanged && !destChanged:
+				toDest[relativePath] = srcFile
+			case destChanged && !srcChanged:
+				toSource[relativePath] = destFile
+			default:
+				conflicts = append(conflicts, relativePath)
+			}
+		case srcExists:
+			// a path which was in sync was deleted from the destination, unless it changed in the source since (which is kept)
+			if wasSynced && !isChangedSinceSync(entry, srcFile, entry.SourceModTime) {
+				deleteSource[relativePath] = srcFile
+			} else {
+				toDest[relativePath] = srcFile
+			}
+		case destExists:
+			if wasSynced && !isChangedSinceSync(entry, destFile, entry.DestModTime) {
+				deleteDest[relativePath] = destFile
+			} else {
+				toSource[relativePath] = destFile
+			}
+		}
+	}
+
+	// a deleted directory is kept while anything new is copied into it
+	keepCopiedDirs(deleteSource, toDest)
+	keepCopiedDirs(deleteDest, toSource)
+
+	// files which were not missing from the other side for enough consecutive scans are kept for now
+	configs.General.missing.keepRecent(configs, srcFiles, deleteDest, true)
+	configs.General.missing.keepRecent(reversed, destFiles, deleteSource, true)
+
+	// make sure the planned deletions of each side are within the safety threshold, otherwise skip the deletions of that side
+	if !isDeletionAllowed(configs, len(deleteDest), len(destFiles)) {
+		skipDeletions(configs, stats, deleteDest)
+	}
+	if !isDeletionAllowed(reversed, len(deleteSource), len(srcFiles)) {
+		skipDeletions(reversed, stats, deleteSource)
+	}
+
+	// a removed directory takes its contents along, so only the topmost removed paths are removed
+	collapseDeletions(deleteSource)
+	collapseDeletions(deleteDest)
+
+	// create a container for operations, and for the deletions (which may run once all other operations ended)
+	var jobFunctions []func()
+	var deleteFunctions []func()
+
+	addWrite := func(configs Config, relativePath string, file os.FileInfo) {
+		plan.copied[relativePath] = true
+
+		p1 := filepath.Join(configs.General.SourceDirectory, relativePath)
+		p2 := file
+		p3 := filepath.Join(configs.General.DestinationDirectory, relativePath)
+
+		jobFunctions = append(jobFunctions, func() {
+			defer wg.Done()
+
+			err := retryOperation(ctx, configs, "Write", p3, func() error {
+				return writeFile(ctx, configs, stats, p1, p2, p3)
+			})
+			if err != nil {
+				recordOperationFailure(configs, stats, "Write", p3, err)
+			}
+		})
+	}
+	addDelete := func(configs Config, relativePath string, file os.FileInfo, reason string) {
+		p1 := file
+		p2 := filepath.Join(configs.General.DestinationDirectory, relativePath)
+
+		configs.General.logger.Debug("Changed", "path", p2, "reason", reason)
+
+		deleteFunctions = append(deleteFunctions, func() {
+			defer wg.Done()
+
+			err := retryOperation(ctx, configs, "Remove", p2, func() error {
+				return deleteFile(ctx, configs, stats, p1, p2, "Remove")
+			})
+			if err != nil {
+				recordOperationFailure(configs, stats, "Remove", p2, err)
+			}
+		})
+	}
+
+	for _, relativePath := range getCopyOrder(configs, toDest) {
+		addWrite(configs, relativePath, toDest[relativePath])
+	}
+	for _, relativePath := range getCopyOrder(configs, toSource) {
+		addWrite(reversed, relativePath, toSource[relativePath])
+	}
+	for relativePath, srcFile := range deleteSource {
+		addDelete(reversed, relativePath, srcFile, "destination missing")
+	}
+	for relativePath, destFile := range deleteDest {
+		addDelete(configs, relativePath, destFile, "source missing")
+	}
+	for _, relativePath := range conflicts {
+		plan.copied[relativePath] = true
+
+		p1 := relativePath
+		p2 := srcFiles[relativePath]
+		p3 := destFiles[relativePath]
+
+		jobFunctions = append(jobFunctions, func() {
+			defer wg.Done()
+
+			path := filepath.Join(configs.General.DestinationDirectory, p1)
+			err := retryOperation(ctx, configs, "Write", path, func() error {
+				return resolveSyncConflict(ctx, configs, reversed, stats, p1, p2, p3)
+			})
+			if err != nil {
+				recordOperationFailure(configs, stats, "Write", path, err)
+			}
+		})
+	}
+
+	// the entries are replaced by the planned operations
+	wg.Add(len(jobFunctions) + len(deleteFunctions) - planned)
+
+	return jobFunctions, deleteFunctions, plan
+}
+
+// getPathSet returns the paths of the files
+func getPathSet(files map[string]os.FileInfo) map[string]bool {
+	paths := make(map[string]bool, len(files))
+	for relativePath := range files {
+		paths[relativePath] = true
+	}
+	return paths
+}
+
+// isChangedSinceSync reports whether the file changed on its side since it was last in sync
+func isChangedSinceSync(entry syncedEntry, file os.FileInfo, syncedModTime int64) bool {
+	if file.IsDir() || entry.Dir {
+		return file.IsDir() != entry.Dir
+	}
+
+	return file.Size() != entry.Size || file.ModTime().UnixNano() != syncedModTime
+}
+
+// keepCopiedDirs removes the directories which contain copied paths from the deletions
+func keepCopiedDirs(deletions map[string]os.FileInfo, copies map[string]os.FileInfo) {
+	for copiedPath := range copies {
+		for dir := filepath.Dir(copiedPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			delete(deletions, dir)
+		}
+	}
+}
+
+// collapseDeletions removes the paths whose parent directory is deleted as well from the deletions
+func collapseDeletions(deletions map[string]os.FileInfo) {
+	for relativePath := range deletions {
+		for dir := filepath.Dir(relativePath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			if parent, exists := deletions[dir]; exists && parent.IsDir() {
+				delete(deletions, relativePath)
+				break
+			}
+		}
+	}
+}
+
+// skipDeletions keeps the files planned for deletion, since the deletions exceed the safety threshold
+func skipDeletions(configs Config, stats *iterationStats, deletions map[string]os.FileInfo) {
+	stats.deletionsSkipped += int64(len(deletions))
+
+	for relativePath := range deletions {
+		configs.General.logger.Debug("Keep", "path", filepath.Join(configs.General.DestinationDirectory, relativePath), "reason", "deletions skipped")
+
+		delete(deletions, relativePath)
+	}
+}
+
+// resolveSyncConflict resolves a file changed on both sides by the conflict policy: the newer file replaces the other file (the source file
+// wins a tie), which is kept as its conflict copy on both sides when both are kept
+func resolveSyncConflict(ctx context.Context, configs Config, reversed Config, stats *iterationStats, relativePath string, srcFile os.FileInfo, destFile os.FileInfo) error {
+	winner, loser := configs, reversed
+	winnerFile, loserFile := srcFile, destFile
+	winnerSide := "source"
+	if destFile.ModTime().After(srcFile.ModTime()) {
+		winner, loser = reversed, configs
+		winnerFile, loserFile = destFile, srcFile
+		winnerSide = "destination"
+	}
+
+	winnerPath := filepath.Join(winner.General.SourceDirectory, relativePath)
+	loserPath := filepath.Join(winner.General.DestinationDirectory, relativePath)
+
+	configs.General.logger.Warn("Conflict", "path", filepath.Join(configs.General.DestinationDirectory, relativePath), "reason", "changed on both sides",
+		"sourceModTime", srcFile.ModTime(), "destinationModTime", destFile.ModTime(), "winner", winnerSide)
+
+	// keep the losing file under its conflict name, and copy it to the side of the winning file as well
+	if configs.General.ConflictPolicy == conflictPolicyKeepBoth {
+		conflictPath, err := getConflictPath(winner.General.destination, loserPath)
+		if err != nil {
+			return err
+		}
+
+		if configs.General.DryRun {
+			configs.General.logger.Info("WOULD Move", "path", loserPath, "target", conflictPath)
+		} else {
+			if err := winner.General.destination.Rename(loserPath, conflictPath); err != nil {
+				return err
+			}
+
+			configs.General.logger.Info("Move", "path", loserPath, "target", conflictPath)
+			emitEvent(winner, eventActionMove, conflictPath, 0, 0, nil)
+		}
+
+		conflictRelativePath := getRelativePath(winner.General.DestinationDirectory, conflictPath)
+		if err := writeFile(ctx, loser, stats, conflictPath, loserFile, filepath.Join(loser.General.DestinationDirectory, conflictRelativePath)); err != nil {
+			return err
+		}
+	}
+
+	return writeFile(ctx, winner, stats, winnerPath, winnerFile, loserPath)
+}
+
+// saveSyncedState records the paths which are in sync once the iteration ended, so the next iteration tells deletions from creations. a path
+// found on one side only keeps its previous entry unless it was copied, so a deletion which failed (or was skipped) is planned again
+func saveSyncedState(configs Config, plan *bidirectionalPlan) {
+	scope := newPathScope(configs.General)
+	srcFiles := getDirFiles(configs.General.logger, configs.General.source, configs.General.SourceDirectory, false, scope)
+	destFiles := getDestFiles(configs.General.logger, configs.General.destination, configs.General.DestinationDirectory, scope, getInternalPaths(configs))
+
+	synced := make(map[string]syncedEntry)
+	for relativePath, srcFile := range srcFiles {
+		destFile, exists := destFiles[relativePath]
+		if !exists || len(relativePath) < 1 {
+			continue
+		}
+
+		// an entry which could not be read keeps its previous state
+		_, srcUnreadable := srcFile.(unreadableFile)
+		_, destUnreadable := destFile.(unreadableFile)
+		if srcUnreadable || destUnreadable {
+			if entry, exists := plan.synced[relativePath]; exists {
+				synced[relativePath] = entry
+			}
+			continue
+		}
+
+		switch {
+		case srcFile.IsDir() && destFile.IsDir():
+			synced[relativePath] = syncedEntry{Dir: true}
+		case srcFile.Mode().IsRegular() && destFile.Mode().IsRegular():
+			reason, err := getChangeReason(configs, filepath.Join(configs.General.SourceDirectory, relativePath), srcFile, filepath.Join(configs.General.DestinationDirectory, relativePath), destFile)
+			if err == nil && len(reason) < 1 {
+				synced[relativePath] = syncedEntry{Size: srcFile.Size(), SourceModTime: srcFile.ModTime().UnixNano(), DestModTime: destFile.ModTime().UnixNano()}
+			}
+		}
+	}
+
+	for relativePath, entry := range plan.synced {
+		if _, exists := synced[relativePath]; exists || plan.copied[relativePath] {
+			continue
+		}
+
+		_, srcExists := srcFiles[relativePath]
+		_, destExists := destFiles[relativePath]
+		if srcExists != destExists {
+			synced[relativePath] = entry
+		}
+	}
+
+	configs.General.hashes.setSynced(configs.General.SourceDirectory, configs.General.DestinationDirectory, synced)
+}
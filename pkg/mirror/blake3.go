@@ -0,0 +1,215 @@
+package mirror
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// sizes of the BLAKE3 compression: a chunk of 1 KiB is compressed by blocks of 64 bytes, into a chaining value of 8 words
+const (
+	blake3BlockSize = 64
+	blake3ChunkSize = 1024
+	blake3OutSize   = 32
+)
+
+// domain flags of a BLAKE3 compression
+const (
+	blake3ChunkStart uint32 = 1 << iota
+	blake3ChunkEnd
+	blake3Parent
+	blake3Root
+)
+
+var blake3IV = [8]uint32{0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A, 0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19}
+
+// order of the message words in each of the 7 rounds, which are permuted between rounds (by 2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14,
+// 15, 8)
+var blake3Schedule = [7][16]uint8{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8},
+	{3, 4, 10, 12, 13, 2, 7, 14, 6, 5, 9, 0, 11, 15, 8, 1},
+	{10, 7, 12, 9, 14, 3, 13, 15, 4, 0, 11, 2, 5, 8, 1, 6},
+	{12, 13, 9, 11, 15, 10, 14, 8, 7, 2, 5, 3, 0, 1, 6, 4},
+	{9, 14, 11, 5, 8, 12, 15, 1, 13, 3, 0, 10, 2, 6, 4, 7},
+	{11, 15, 5, 0, 1, 9, 8, 6, 14, 10, 2, 12, 3, 4, 7, 13},
+}
+
+// blake3 is the BLAKE3 hash (in its default, unkeyed mode) of 32 bytes, a cryptographic hash. chunks are compressed one after another, so
+// it is not as fast as native implementations hashing several chunks at once (and slower than SHA-256 on processors with SHA instructions)
+type blake3 struct {
+	// chaining value of the current chunk, and the number of its blocks compressed
+	chunkValue       [8]uint32
+	chunkCounter     uint64
+	blocksCompressed int
+	// contents of the current chunk which do not fill a block yet (a full block is kept until more contents follow, as the last block of a
+	// chunk is compressed with a flag of its own)
+	block    [blake3BlockSize]byte
+	buffered int
+	// chaining values of complete subtrees, merged as the number of chunks grows
+	stack [54][8]uint32
+	depth int
+}
+
+func newBLAKE3() hash.Hash {
+	digest := &blake3{}
+	digest.Reset()
+	return digest
+}
+
+func (digest *blake3) Reset() {
+	*digest = blake3{chunkValue: blake3IV}
+}
+
+func (digest *blake3) Size() int {
+	return blake3OutSize
+}
+
+func (digest *blake3) BlockSize() int {
+	return blake3BlockSize
+}
+
+func (digest *blake3) Write(data []byte) (int, error) {
+	n := len(data)
+
+	for len(data) > 0 {
+		// a complete chunk is merged into the tree once more contents follow it
+		if digest.blocksCompressed*blake3BlockSize+digest.buffered == blake3ChunkSize {
+			output := digest.chunkOutput()
+			digest.pushChunk(output.chainingValue(), digest.chunkCounter+1)
+			digest.chunkValue = blake3IV
+			digest.chunkCounter++
+			digest.blocksCompressed = 0
+			digest.buffered = 0
+		}
+
+		if digest.buffered == blake3BlockSize {
+			var words [16]uint32
+			blake3Words(&words, digest.block[:])
+			state := blake3Compress(&digest.chunkValue, &words, digest.chunkCounter, blake3BlockSize, digest.startFlag())
+			copy(digest.chunkValue[:], state[:8])
+			digest.blocksCompressed++
+			digest.buffered = 0
+		}
+
+		copied := copy(digest.block[digest.buffered:], data)
+		digest.buffered += copied
+		data = data[copied:]
+	}
+
+	return n, nil
+}
+
+// startFlag returns the flag of the next block compressed, which tells the first block of a chunk
+func (digest *blake3) startFlag() uint32 {
+	if digest.blocksCompressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+// pushChunk adds the chaining value of a complete chunk to the tree, merging the complete subtrees by the number of chunks so far
+func (digest *blake3) pushChunk(value [8]uint32, totalChunks uint64) {
+	for ; totalChunks&1 == 0; totalChunks >>= 1 {
+		digest.depth--
+		value = blake3ParentOutput(&digest.stack[digest.depth], &value).chainingValue()
+	}
+	digest.stack[digest.depth] = value
+	digest.depth++
+}
+
+// chunkOutput returns the output of the current chunk, its last block not compressed yet
+func (digest *blake3) chunkOutput() blake3Output {
+	output := blake3Output{
+		inputValue: digest.chunkValue,
+		counter:    digest.chunkCounter,
+		blockSize:  uint32(digest.buffered),
+		flags:      digest.startFlag() | blake3ChunkEnd,
+	}
+	var block [blake3BlockSize]byte
+	copy(block[:], digest.block[:digest.buffered])
+	blake3Words(&output.words, block[:])
+	return output
+}
+
+func (digest *blake3) Sum(b []byte) []byte {
+	// the current chunk is merged with the stacked subtrees from the right, the last merge being the root
+	output := digest.chunkOutput()
+	for depth := digest.depth - 1; depth >= 0; depth-- {
+		value := output.chainingValue()
+		output = blake3ParentOutput(&digest.stack[depth], &value)
+	}
+
+	state := blake3Compress(&output.inputValue, &output.words, 0, output.blockSize, output.flags|blake3Root)
+	for _, word := range state[:8] {
+		b = binary.LittleEndian.AppendUint32(b, word)
+	}
+	return b
+}
+
+// blake3Output is a compression which was not done yet, since whether it is the root is not known yet
+type blake3Output struct {
+	inputValue [8]uint32
+	words      [16]uint32
+	counter    uint64
+	blockSize  uint32
+	flags      uint32
+}
+
+func (output blake3Output) chainingValue() [8]uint32 {
+	var value [8]uint32
+	state := blake3Compress(&output.inputValue, &output.words, output.counter, output.blockSize, output.flags)
+	copy(value[:], state[:8])
+	return value
+}
+
+func blake3ParentOutput(left *[8]uint32, right *[8]uint32) blake3Output {
+	output := blake3Output{inputValue: blake3IV, blockSize: blake3BlockSize, flags: blake3Parent}
+	copy(output.words[:8], left[:])
+	copy(output.words[8:], right[:])
+	return output
+}
+
+func blake3Words(words *[16]uint32, block []byte) {
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(block[i*4:])
+	}
+}
+
+func blake3Compress(value *[8]uint32, words *[16]uint32, counter uint64, blockSize uint32, flags uint32) [16]uint32 {
+	// the state is kept in variables rather than in an array, which makes the rounds several times faster
+	s0, s1, s2, s3, s4, s5, s6, s7 := value[0], value[1], value[2], value[3], value[4], value[5], value[6], value[7]
+	s8, s9, s10, s11 := blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3]
+	s12, s13, s14, s15 := uint32(counter), uint32(counter>>32), blockSize, flags
+
+	for round := range blake3Schedule {
+		m := &blake3Schedule[round]
+
+		// mix the columns, then the diagonals
+		s0, s4, s8, s12 = blake3Mix(s0, s4, s8, s12, words[m[0]&15], words[m[1]&15])
+		s1, s5, s9, s13 = blake3Mix(s1, s5, s9, s13, words[m[2]&15], words[m[3]&15])
+		s2, s6, s10, s14 = blake3Mix(s2, s6, s10, s14, words[m[4]&15], words[m[5]&15])
+		s3, s7, s11, s15 = blake3Mix(s3, s7, s11, s15, words[m[6]&15], words[m[7]&15])
+		s0, s5, s10, s15 = blake3Mix(s0, s5, s10, s15, words[m[8]&15], words[m[9]&15])
+		s1, s6, s11, s12 = blake3Mix(s1, s6, s11, s12, words[m[10]&15], words[m[11]&15])
+		s2, s7, s8, s13 = blake3Mix(s2, s7, s8, s13, words[m[12]&15], words[m[13]&15])
+		s3, s4, s9, s14 = blake3Mix(s3, s4, s9, s14, words[m[14]&15], words[m[15]&15])
+	}
+
+	return [16]uint32{
+		s0 ^ s8, s1 ^ s9, s2 ^ s10, s3 ^ s11, s4 ^ s12, s5 ^ s13, s6 ^ s14, s7 ^ s15,
+		s8 ^ value[0], s9 ^ value[1], s10 ^ value[2], s11 ^ value[3], s12 ^ value[4], s13 ^ value[5], s14 ^ value[6], s15 ^ value[7],
+	}
+}
+
+func blake3Mix(a, b, c, d, x, y uint32) (uint32, uint32, uint32, uint32) {
+	a += b + x
+	d = bits.RotateLeft32(d^a, -16)
+	c += d
+	b = bits.RotateLeft32(b^c, -12)
+	a += b + y
+	d = bits.RotateLeft32(d^a, -8)
+	c += d
+	b = bits.RotateLeft32(b^c, -7)
+	return a, b, c, d
+}
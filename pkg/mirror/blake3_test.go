@@ -0,0 +1,41 @@
+package mirror
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestBLAKE3Vectors(t *testing.T) {
+	// the hashes of the official test vectors (test_vectors.json of the reference implementation), by the length of their input
+	tests := []struct {
+		length   int
+		expected string
+	}{
+		{length: 0, expected: "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{length: 1, expected: "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+		{length: 63, expected: "e9bc37a594daad83be9470df7f7b3798297c3d834ce80ba85d6e207627b7db7b"},
+		{length: 64, expected: "4eed7141ea4a5cd4b788606bd23f46e212af9cacebacdc7d1f4c6dc7f2511b98"},
+		{length: 65, expected: "de1e5fa0be70df6d2be8fffd0e99ceaa8eb6e8c93a63f2d8d1c30ecb6b263dee"},
+		{length: 1023, expected: "10108970eeda3eb932baac1428c7a2163b0e924c9a9e25b35bba72b28f70bd11"},
+		{length: 1024, expected: "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{length: 1025, expected: "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+		{length: 2048, expected: "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+		{length: 2049, expected: "5f4d72f40d7a5f82b15ca2b2e44b1de3c2ef86c426c95c1af0b6879522563030"},
+		{length: 3072, expected: "b98cb0ff3623be03326b373de6b9095218513e64f1ee2edd2525c7ad1e5cffd2"},
+		{length: 4096, expected: "015094013f57a5277b59d8475c0501042c0b642e531b0a1c8f58d2163229e969"},
+		{length: 5121, expected: "628bd2cb2004694adaab7bbd778a25df25c47b9d4155a55f8fbd79f2fe154cff"},
+		{length: 102400, expected: "bc3e3d41a1146b069abffad3c0d44860cf664390afce4d9661f7902e7943e085"},
+	}
+
+	digest := newBLAKE3()
+	for _, test := range tests {
+		input := getHashInput(test.length)
+
+		// pieces which split blocks and chunks, and which fill them exactly
+		for _, size := range []int{1, 13, blake3BlockSize, blake3ChunkSize, test.length + 1} {
+			if sum := hex.EncodeToString(hashInPieces(digest, input, size)); sum != test.expected {
+				t.Errorf("BLAKE3 of %d bytes written in pieces of %d bytes is %s, expected %s", test.length, size, sum, test.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package mirror
+
+import (
+	"sync"
+)
+
+// default size of copy buffers, same as the buffer io.Copy allocates
+const defaultCopyBufferKB = 32
+
+// pool of default size copy buffers, for copies which are not part of a job (e.g. backups across volumes)
+var defaultBufferPool = newBufferPool(defaultCopyBufferKB * 1024)
+
+// newBufferPool creates a pool of copy buffers of the given size, so concurrent copies reuse buffers instead of allocating one per copy
+func newBufferPool(size int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			buffer := make([]byte, size)
+			return &buffer
+		},
+	}
+}
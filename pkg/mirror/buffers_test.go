@@ -0,0 +1,90 @@
+package mirror
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// size of the large files copied by the benchmarks, in MB. real trees hold multi-GB files, which take as much space in the temporary
+// directory (e.g. -benchsize=64 for a quick run)
+var benchmarkLargeFileMB = flag.Int64("benchsize", 2048, "size of the large files copied by the benchmarks, in MB")
+
+// writeBenchmarkFiles writes the number of files of the size into the directory, and returns their paths. the contents are written in
+// pieces, so files larger than the memory can be written
+func writeBenchmarkFiles(b *testing.B, dir string, count int, size int64) []string {
+	b.Helper()
+
+	data := getHashInput(int(min(size, 4*1024*1024)))
+	paths := make([]string, count)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%05d.bin", i))
+		file, err := os.Create(paths[i])
+		if err != nil {
+			b.Fatal(err)
+		}
+		for written := int64(0); written < size; written += int64(len(data)) {
+			if _, err := file.Write(data[:min(int64(len(data)), size-written)]); err != nil {
+				file.Close()
+				b.Fatal(err)
+			}
+		}
+		if err := file.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func BenchmarkCopyBuffers(b *testing.B) {
+	// many small files, where allocating a buffer per copy adds up, and a few large ones (of -benchsize MB), where the size of the buffer
+	// matters
+	trees := []struct {
+		name  string
+		count int
+		size  int64
+	}{
+		{name: "small", count: 10000, size: 4 * 1024},
+		{name: "large", count: 4, size: *benchmarkLargeFileMB * 1024 * 1024},
+	}
+	// a pool per copy allocates a buffer for every copy, as io.Copy does
+	buffers := []struct {
+		name string
+		pool func() *sync.Pool
+	}{
+		{name: "unpooled", pool: func() *sync.Pool { return newBufferPool(defaultCopyBufferKB * 1024) }},
+		{name: "pooled", pool: func() *sync.Pool { return defaultBufferPool }},
+		{name: "pooled-1MB", pool: func() func() *sync.Pool {
+			pool := newBufferPool(1024 * 1024)
+			return func() *sync.Pool { return pool }
+		}()},
+	}
+
+	for _, tree := range trees {
+		// the files of a tree are written only if one of its benchmarks runs
+		b.Run(tree.name, func(b *testing.B) {
+			source, destination := b.TempDir(), b.TempDir()
+			paths := writeBenchmarkFiles(b, source, tree.count, tree.size)
+
+			for _, buffer := range buffers {
+				b.Run(buffer.name, func(b *testing.B) {
+					b.SetBytes(int64(tree.count) * tree.size)
+					b.ReportAllocs()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						for _, path := range paths {
+							dst := filepath.Join(destination, filepath.Base(path))
+							if err := copyFile(context.Background(), path, dst, copyOptions{buffers: buffer.pool()}); err != nil {
+								b.Fatal(err)
+							}
+						}
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package mirror
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+)
+
+// hash algorithms of the contents of files
+const (
+	hashAlgorithmSHA256   = "sha256"
+	hashAlgorithmSHA1     = "sha1"
+	hashAlgorithmMD5      = "md5"
+	hashAlgorithmXXHash64 = "xxhash64"
+	hashAlgorithmBLAKE3   = "blake3"
+)
+
+// constructors of the hashes, by their algorithm
+var hashAlgorithms = map[string]func() hash.Hash{
+	hashAlgorithmSHA256:   sha256.New,
+	hashAlgorithmSHA1:     sha1.New,
+	hashAlgorithmMD5:      md5.New,
+	hashAlgorithmXXHash64: func() hash.Hash { return newXXHash64() },
+	hashAlgorithmBLAKE3:   newBLAKE3,
+}
+
+// isHashAlgorithm reports whether the hash algorithm is supported
+func isHashAlgorithm(algorithm string) bool {
+	_, exists := hashAlgorithms[algorithm]
+	return exists
+}
+
+// newHash returns a new hash of the algorithm, which is SHA-256 for an unknown one (the configured algorithms are validated, and a manifest
+// of an unknown algorithm is rejected)
+func newHash(algorithm string) hash.Hash {
+	if newAlgorithmHash, exists := hashAlgorithms[algorithm]; exists {
+		return newAlgorithmHash()
+	}
+	return sha256.New()
+}
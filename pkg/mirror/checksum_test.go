@@ -0,0 +1,98 @@
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"sort"
+	"testing"
+)
+
+// getHashInput returns the input of the official BLAKE3 test vectors, the bytes 0 to 250 repeated up to the length
+func getHashInput(length int) []byte {
+	input := make([]byte, length)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+	return input
+}
+
+// hashInPieces returns the sum of the input, written to the hash in pieces of the size
+func hashInPieces(digest hash.Hash, input []byte, size int) []byte {
+	digest.Reset()
+	for len(input) > 0 {
+		piece := input[:min(size, len(input))]
+		digest.Write(piece)
+		input = input[len(piece):]
+	}
+	return digest.Sum(nil)
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	input := getHashInput(5000)
+
+	for algorithm := range hashAlgorithms {
+		if !isHashAlgorithm(algorithm) {
+			t.Errorf("isHashAlgorithm(%q) = false", algorithm)
+		}
+
+		digest := newHash(algorithm)
+		expected := hashInPieces(digest, input, len(input))
+		if len(expected) != digest.Size() {
+			t.Errorf("sum of %s has %d bytes, expected %d", algorithm, len(expected), digest.Size())
+		}
+
+		// the sum does not depend on how the contents are written
+		for _, size := range []int{1, 7, digest.BlockSize() - 1, digest.BlockSize(), digest.BlockSize() + 1, 1000, 1024, 4096} {
+			if sum := hashInPieces(digest, input, size); !bytes.Equal(sum, expected) {
+				t.Errorf("sum of %s written in pieces of %d bytes is %x, expected %x", algorithm, size, sum, expected)
+			}
+		}
+
+		// the sum is appended to the given bytes, and the hash goes on after it
+		digest.Reset()
+		digest.Write(input[:100])
+		if sum := digest.Sum([]byte("prefix")); !bytes.HasPrefix(sum, []byte("prefix")) || len(sum) != len("prefix")+digest.Size() {
+			t.Errorf("Sum() of %s with a prefix is %x, expected the prefix followed by the sum", algorithm, sum)
+		}
+		digest.Write(input[100:])
+		if sum := digest.Sum(nil); !bytes.Equal(sum, expected) {
+			t.Errorf("sum of %s written after a sum is %x, expected %x", algorithm, sum, expected)
+		}
+	}
+
+	// unknown algorithms (of old manifests) are hashed by SHA-256
+	if isHashAlgorithm("crc32") {
+		t.Error("isHashAlgorithm(\"crc32\") = true")
+	}
+	if sum, expected := hashInPieces(newHash("crc32"), input, 100), hashInPieces(newHash(hashAlgorithmSHA256), input, 100); !bytes.Equal(sum, expected) {
+		t.Errorf("sum of an unknown algorithm is %x, expected the SHA-256 sum %x", sum, expected)
+	}
+}
+
+func BenchmarkHashAlgorithms(b *testing.B) {
+	algorithms := make([]string, 0, len(hashAlgorithms))
+	for algorithm := range hashAlgorithms {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+
+	for _, algorithm := range algorithms {
+		for _, size := range []int{64, 4 * 1024, 1024 * 1024} {
+			b.Run(fmt.Sprintf("%s/%d", algorithm, size), func(b *testing.B) {
+				input := getHashInput(size)
+				digest := newHash(algorithm)
+				sum := make([]byte, 0, digest.Size())
+
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					digest.Reset()
+					digest.Write(input)
+					sum = digest.Sum(sum[:0])
+				}
+			})
+		}
+	}
+}
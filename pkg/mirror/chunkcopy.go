@@ -0,0 +1,188 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// countedReader adds the bytes read from the underlying reader to the count of a progress, which is shared by the concurrent readers of the
+// chunks of a file
+type countedReader struct {
+	reader   io.Reader
+	progress *progressReader
+}
+
+func (counted *countedReader) Read(p []byte) (int, error) {
+	n, err := counted.reader.Read(p)
+	atomic.AddInt64(&counted.progress.copied, int64(n))
+
+	return n, err
+}
+
+// isChunkedCopy reports whether the copy of the file of the size is split into chunks copied concurrently, which takes files that can be
+// read and written at any offset (local ones), and a copy which is not resumed (its partial file would have holes)
+func isChunkedCopy(source io.Reader, destination destinationFile, size int64, options copyOptions) bool {
+	if options.chunkStreams < 2 || size < options.chunkThreshold || options.resumeOffset > 0 || options.keepPartial {
+		return false
+	}
+
+	_, readable := source.(*os.File)
+	_, writable := destination.(*os.File)
+	return readable && writable
+}
+
+// forEachChunk calls the function for every chunk of the file of the size, by the given number of concurrent streams. the first failing
+// chunk cancels the others, and its error is returned
+func forEachChunk(ctx context.Context, size int64, chunkSize int64, streams int, copyChunk func(ctx context.Context, index int, offset int64, length int64) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	count := int((size + chunkSize - 1) / chunkSize)
+	indexes := make(chan int, count)
+	for index := 0; index < count; index++ {
+		indexes <- index
+	}
+	close(indexes)
+
+	var wg sync.WaitGroup
+	for stream := 0; stream < min(streams, count); stream++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range indexes {
+				if ctx.Err() != nil {
+					return
+				}
+
+				offset := int64(index) * chunkSize
+				if err := copyChunk(ctx, index, offset, min(chunkSize, size-offset)); err != nil {
+					cancel(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
+
+// copyChunks copies the contents of the source file into the (preallocated) destination file by concurrent streams, each writing its own
+// chunks, and returns the number of bytes written. the hash of every chunk is returned when the copy is verified, since contents written
+// out of order can not be hashed as a whole while they are copied
+func copyChunks(ctx context.Context, source *os.File, destination *os.File, size int64, options copyOptions, progress *progressReader) (int64, [][]byte, error) {
+	buffers := options.buffers
+	if buffers == nil {
+		buffers = defaultBufferPool
+	}
+
+	var hashes [][]byte
+	if options.verify {
+		hashes = make([][]byte, (size+options.chunkSize-1)/options.chunkSize)
+	}
+
+	var written int64
+	err := forEachChunk(ctx, size, options.chunkSize, options.chunkStreams, func(ctx context.Context, index int, offset int64, length int64) error {
+		var reader io.Reader = io.NewSectionReader(source, offset, length)
+		if options.limiter != nil {
+			reader = &throttledReader{reader: reader, limiter: options.limiter}
+		}
+		if progress != nil {
+			reader = &countedReader{reader: reader, progress: progress}
+		}
+		if options.transfer != nil {
+			reader = &countedReader{reader: reader, progress: &options.transfer.progressReader}
+		}
+		var chunkHash hash.Hash
+		if hashes != nil {
+			chunkHash = newHash(options.hashAlgorithm)
+			reader = io.TeeReader(reader, chunkHash)
+		}
+		reader = &contextReader{ctx: ctx, reader: reader}
+
+		buffer := buffers.Get().(*[]byte)
+		defer buffers.Put(buffer)
+
+		// hide the writer behind a plain writer, so the copy uses the pooled buffer
+		n, err := io.CopyBuffer(struct{ io.Writer }{io.NewOffsetWriter(destination, offset)}, reader, *buffer)
+		atomic.AddInt64(&written, n)
+		if err != nil {
+			return err
+		}
+		if n != length {
+			return fmt.Errorf("chunk at offset %d ended short, the source file shrank while copied", offset)
+		}
+
+		if chunkHash != nil {
+			hashes[index] = chunkHash.Sum(nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return written, nil, err
+	}
+
+	// a source file which grew while copied is not copied whole (its chunks cover the size it had)
+	if info, err := source.Stat(); err != nil {
+		return written, nil, err
+	} else if info.Size() != size {
+		return written, nil, fmt.Errorf("source file size changed while copied; %v != %v", info.Size(), size)
+	}
+
+	return written, hashes, nil
+}
+
+// verifyChunks re-reads the chunks of the written file by concurrent streams, and reports whether any of them does not match the hash of
+// its source contents
+func verifyChunks(ctx context.Context, fsys readableFS, path string, size int64, hashes [][]byte, options copyOptions) (bool, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	// the written file is a local one, as chunked copies are
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		return false, fmt.Errorf("'%s' can not be read at any offset", path)
+	}
+
+	err = forEachChunk(ctx, size, options.chunkSize, options.chunkStreams, func(ctx context.Context, index int, offset int64, length int64) error {
+		chunkHash := newHash(options.hashAlgorithm)
+		if _, err := io.Copy(chunkHash, &contextReader{ctx: ctx, reader: io.NewSectionReader(readerAt, offset, length)}); err != nil {
+			return err
+		}
+		if !bytes.Equal(chunkHash.Sum(nil), hashes[index]) {
+			return errChunkMismatch
+		}
+		return nil
+	})
+	if errors.Is(err, errChunkMismatch) {
+		return true, fmt.Errorf("verification failed, contents of '%s' differ from the source", path)
+	}
+	return false, err
+}
+
+// errChunkMismatch is the cause of a verification which found a chunk whose contents differ from the source
+var errChunkMismatch = errors.New("Chunk differs from the source")
+
+// hashWrittenFile writes the contents of the file into the hash, reading it in order (the contents of a chunked copy were written out of
+// order, so they could not be hashed while copied)
+func hashWrittenFile(contentsHash hash.Hash, fsys readableFS, path string) error {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.CopyBuffer(contentsHash, file, make([]byte, hashBufferSize))
+	return err
+}
@@ -0,0 +1,135 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// name of the probe file written into a destination directory to measure its clock, which is removed right away
+const clockProbeFileName = ".directorymirror.clockprobe"
+
+// the clock of a destination is probed again after this long, since it keeps drifting
+const clockProbeInterval = time.Hour
+
+// file systems stamp files by a coarse clock (e.g. the tick of the kernel), so a stamp which is off by less than this is not skewed
+const clockStampResolution = 20 * time.Millisecond
+
+// clockSkewProbe is what probing the clock of a destination measured
+type clockSkewProbe struct {
+	// how far the clock of the destination is ahead of the local one (negative if behind), as far as its granularity tells
+	skew time.Duration
+	// how far a modification time set on the destination was off when it was read back (e.g. by the destination stamping files by its own
+	// clock, or keeping times coarsely)
+	modTimeError time.Duration
+	probed       time.Time
+}
+
+// clockSkewProbes keeps the latest probe of every destination directory of the job
+type clockSkewProbes struct {
+	mutex  sync.Mutex
+	probes map[string]clockSkewProbe
+}
+
+// newClockSkewProbes returns the container of clock probes, or nil if the clocks of the destinations are not probed
+func newClockSkewProbes(general GeneralConfigurations) *clockSkewProbes {
+	if !general.DetectClockSkew {
+		return nil
+	}
+
+	return &clockSkewProbes{probes: make(map[string]clockSkewProbe)}
+}
+
+// getModTimeTolerance probes the clock of the destination once the latest probe is old, and returns the tolerance its modification times
+// need to match the source ones (the error of a time set on it)
+func (probes *clockSkewProbes) getModTimeTolerance(configs Config) time.Duration {
+	if probes == nil {
+		return 0
+	}
+
+	probes.mutex.Lock()
+	defer probes.mutex.Unlock()
+
+	destDir := configs.General.DestinationDirectory
+	probe, exists := probes.probes[destDir]
+	// a dry run does not touch the destination at all
+	if (exists && time.Since(probe.probed) < clockProbeInterval) || configs.General.DryRun {
+		return probe.modTimeError
+	}
+
+	probe, err := probeClock(configs)
+	if err != nil {
+		// e.g. the destination directory is not created yet, so it is probed again by the next iteration
+		configs.General.logger.Debug("Clock probe failed", "destination", destDir, "error", err)
+		return probe.modTimeError
+	}
+	probes.probes[destDir] = probe
+	configs.General.status.setClockSkew(destDir, probe)
+
+	threshold := time.Duration(configs.General.ClockSkewWarningMS) * time.Millisecond
+	if probe.skew > threshold || probe.skew < -threshold || probe.modTimeError > threshold {
+		configs.General.logger.Warn("Destination clock is skewed, widening the modification time tolerance", "destination", destDir, "skew", probe.skew,
+			"modTimeError", probe.modTimeError)
+	} else {
+		configs.General.logger.Debug("Clock probe", "destination", destDir, "skew", probe.skew, "modTimeError", probe.modTimeError)
+	}
+
+	return probe.modTimeError
+}
+
+// probeClock writes a probe file into the destination directory, and measures the skew of the clock of the destination by the time the
+// probe was stamped with, and the error of a time set on it by the time read back. the probe file is removed once measured
+func probeClock(configs Config) (clockSkewProbe, error) {
+	// the probe is written as it is, since an encoded file keeps the time of its source file in its header
+	fsys := configs.General.destination
+	if encoded, ok := fsys.(*encodedFS); ok {
+		fsys = encoded.base
+	}
+	path := filepath.Join(configs.General.DestinationDirectory, clockProbeFileName)
+	granularity := fsys.ModTimeGranularity()
+
+	before := time.Now()
+	file, err := fsys.Create(path, nil)
+	if err != nil {
+		return clockSkewProbe{}, err
+	}
+	defer fsys.Remove(path)
+	if err := file.Close(); err != nil {
+		return clockSkewProbe{}, err
+	}
+	after := time.Now()
+
+	info, err := fsys.Lstat(path)
+	if err != nil {
+		return clockSkewProbe{}, err
+	}
+
+	// the destination stamped the probe while it was created, so its clock is skewed by as far as the stamp is outside of that
+	probe := clockSkewProbe{probed: after}
+	if latest := after.Add(clockStampResolution); info.ModTime().After(latest) {
+		probe.skew = info.ModTime().Sub(latest)
+	} else if earliest := before.Add(-clockStampResolution).Truncate(granularity); info.ModTime().Before(earliest) {
+		probe.skew = info.ModTime().Sub(earliest)
+	}
+
+	// a time with a fraction of a second is set, as times of source files are, shortly before now (so a destination which stamps files by
+	// its own clock instead is off by about its skew)
+	modTime := before.Add(-time.Second).Truncate(time.Second).Add(123456789 * time.Nanosecond)
+	if err := fsys.Chtimes(path, modTime, modTime); err != nil {
+		return clockSkewProbe{}, err
+	}
+	if info, err = fsys.Lstat(path); err != nil {
+		return clockSkewProbe{}, err
+	}
+	probe.modTimeError = info.ModTime().Sub(modTime.Truncate(granularity)).Abs()
+
+	return probe, nil
+}
+
+// excludeClockProbeFiles removes the clock probe file of the root directory from both containers, in case a probe was seen by a scan (or is
+// left over by a probe which was interrupted)
+func excludeClockProbeFiles(srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) {
+	delete(srcFiles, clockProbeFileName)
+	delete(destFiles, clockProbeFileName)
+}
@@ -0,0 +1,106 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	copyModeAuto     = "auto"
+	copyModeCopy     = "copy"
+	copyModeHardlink = "hardlink"
+	copyModeReflink  = "reflink"
+)
+
+// cloneFile creates the destination file as a reflink or a hard link of the source file when the copy mode allows it,
+// and reports whether it did (otherwise the file must be copied)
+func cloneFile(configs Config, stats *iterationStats, srcPath string, srcFile os.FileInfo, path string, overwrite bool) (bool, error) {
+	mode := configs.General.CopyMode
+	if mode == copyModeCopy || !srcFile.Mode().IsRegular() {
+		return false, nil
+	}
+
+	// files can only be cloned within a file system, across file systems they are silently copied
+	srcDevice, srcOk := getDeviceID(srcPath, srcFile)
+	destDir, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return false, err
+	}
+	destDevice, destOk := getDeviceID(filepath.Dir(path), destDir)
+	if !srcOk || !destOk || srcDevice != destDevice {
+		return false, nil
+	}
+
+	// create the clone under a temporary name, so the existing destination file is only replaced once the clone is complete
+	tempPath := getTempPath(path)
+	os.Remove(tempPath)
+	// make sure the temporary file does not survive a failure (after a successful rename, there is nothing left to remove)
+	defer os.Remove(tempPath)
+
+	// a reflink is preferred in auto mode, since it is an independent file (sharing only the data blocks until either file changes)
+	method := ""
+	if mode == copyModeAuto || mode == copyModeReflink {
+		if err := reflinkFile(srcPath, tempPath); err == nil {
+			method = copyModeReflink
+		} else if mode == copyModeReflink && stats.warnOnce(&stats.cloneWarned) {
+			configs.General.logger.Warn("Reflinks can not be created, copying instead", "path", path, "error", err)
+		}
+	}
+	if method == "" && (mode == copyModeAuto || mode == copyModeHardlink) {
+		if err := os.Link(srcPath, tempPath); err == nil {
+			method = copyModeHardlink
+		} else if mode == copyModeHardlink && stats.warnOnce(&stats.cloneWarned) {
+			configs.General.logger.Warn("Hard links can not be created, copying instead", "path", path, "error", err)
+		}
+	}
+	if method == "" {
+		return false, nil
+	}
+
+	// a hard link is the source file itself, so its metadata already matches (and changing it would change the source file)
+	if method == copyModeReflink {
+		// set same permission as source file, if requested
+		if err := preservePermissions(configs, srcFile, tempPath); err != nil {
+			return false, err
+		}
+		// set same owner as source file, if requested
+		if err := preserveOwnership(configs, stats, srcFile, tempPath); err != nil {
+			return false, err
+		}
+		// set same ACLs and attributes as source file, if requested
+		if err := preserveSecurity(configs, stats, srcPath, srcFile, tempPath); err != nil {
+			return false, err
+		}
+		// set same creation time as source file, if requested
+		if err := preserveCreationTime(configs, srcFile, tempPath); err != nil {
+			return false, err
+		}
+		// set same 'last modified' value as source file so it wont be falsely detected as 'changed' on next iteration
+		if err := os.Chtimes(tempPath, srcFile.ModTime(), srcFile.ModTime()); err != nil {
+			return false, err
+		}
+	}
+
+	// replace the destination file with the clone (backing up the existing file first)
+	if overwrite {
+		if err := backupFile(configs, path); err != nil {
+			return false, err
+		}
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return false, err
+	}
+
+	if method == copyModeHardlink {
+		stats.addLinked()
+
+		configs.General.logger.Info("Link", "path", path, "target", srcPath)
+		emitEvent(configs, eventActionLink, path, 0, 0, nil)
+	} else {
+		stats.addCloned()
+
+		configs.General.logger.Info("Clone", "path", path)
+		emitEvent(configs, eventActionWrite, path, srcFile.Size(), 0, nil)
+	}
+	return true, nil
+}
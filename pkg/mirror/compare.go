@@ -0,0 +1,170 @@
+package mirror
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	compareModeMtime = "mtime"
+	compareModeSize  = "size"
+	compareModeHash  = "hash"
+)
+
+// size of the buffer used to stream file contents while hashing
+const hashBufferSize = 64 * 1024
+
+// tolerance of modification times of destinations on file systems which keep them in 2 second steps
+const coarseModTimeTolerance = 2 * time.Second
+
+// getChangeReason compares the source file against the existing destination file using the configured compare mode,
+// and returns the reason the file should be copied, or an empty string if the file is unchanged
+func getChangeReason(configs Config, srcPath string, srcFile os.FileInfo, path string, destFile os.FileInfo) (string, error) {
+	switch configs.General.CompareMode {
+	case compareModeSize:
+		// compare file size only
+		if destFile.Size() != srcFile.Size() {
+			return "size differs", nil
+		}
+	case compareModeHash:
+		// files of different size can not have the same contents, so avoid reading them
+		if destFile.Size() != srcFile.Size() {
+			return "size differs", nil
+		}
+
+		// compare contents hash of both files (cached hashes of files which did not change are trusted)
+		srcHash, err := configs.General.hashes.getHash(configs.General.HashAlgorithm, configs.General.source, configs.General.SourceDirectory, srcPath, srcFile)
+		if err != nil {
+			return "", err
+		}
+		destHash, err := configs.General.hashes.getHash(configs.General.HashAlgorithm, configs.General.destination, configs.General.DestinationDirectory, path, destFile)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(srcHash, destHash) {
+			return "hash differs", nil
+		}
+	default:
+		// compare last modification time against source file
+		if !isSameModTime(configs, srcFile.ModTime(), destFile.ModTime()) {
+			return "mtime differs", nil
+		}
+		// times matched within a tolerance could still belong to different contents, which their sizes tell apart
+		if configs.General.mtimeTolerance > 0 && destFile.Size() != srcFile.Size() {
+			return "size differs", nil
+		}
+	}
+
+	// file is unchanged
+	return "", nil
+}
+
+// getUnchangedReason returns the reason files are unchanged, by the configured compare mode
+func getUnchangedReason(configs Config) string {
+	return configs.General.CompareMode + " match"
+}
+
+// getChangeDetails returns the compared values of a changed file as log fields, for the reason it changed
+func getChangeDetails(reason string, srcFile os.FileInfo, destFile os.FileInfo) []any {
+	switch {
+	case destFile == nil:
+		return nil
+	case reason == "mtime differs":
+		return []any{"sourceModTime", srcFile.ModTime(), "destinationModTime", destFile.ModTime()}
+	case reason == "size differs":
+		return []any{"sourceSize", srcFile.Size(), "destinationSize", destFile.Size()}
+	}
+	return nil
+}
+
+// isSameModTime reports whether the modification time of a destination file matches the source one, as far as the destination keeps it
+// (e.g. an SFTP destination keeps whole seconds only, and the directories of an S3 destination keep no time at all), or within the tolerance
+// of the destination (e.g. FAT keeps times in 2 second steps, rounding them its own way)
+func isSameModTime(configs Config, srcModTime time.Time, destModTime time.Time) bool {
+	if destModTime.IsZero() {
+		return true
+	}
+
+	// times are compared in UTC, so the time zone a remote share reports them in makes no difference
+	srcModTime = srcModTime.UTC().Truncate(configs.General.destination.ModTimeGranularity())
+	destModTime = destModTime.UTC()
+
+	if tolerance := configs.General.mtimeTolerance; tolerance > 0 {
+		difference := destModTime.Sub(srcModTime)
+		return difference <= tolerance && difference >= -tolerance
+	}
+	return destModTime.Equal(srcModTime)
+}
+
+// isSameDirModTime reports whether the modification time of a destination directory matches the source one, unless directory times are not
+// preserved (then they never differ)
+func isSameDirModTime(configs Config, srcModTime time.Time, destModTime time.Time) bool {
+	return !configs.General.PreserveDirTimes || isSameModTime(configs, srcModTime, destModTime)
+}
+
+// isSamePermissions reports whether the destination file has the permissions of the source file, unless permissions are not preserved
+func isSamePermissions(configs Config, srcFile os.FileInfo, destFile os.FileInfo) bool {
+	return !configs.General.PreservePermissions || destFile.Mode().Perm() == srcFile.Mode().Perm()
+}
+
+// setModTimeTolerances sets the tolerance of modification times of every destination, for the iteration
+func setModTimeTolerances(destConfigsList []Config) []Config {
+	for i := range destConfigsList {
+		destConfigsList[i].General.mtimeTolerance = getModTimeTolerance(destConfigsList[i])
+
+		// a detected tolerance is widened to the error of times set on the destination, which its clock probe measured (if probed)
+		if destConfigsList[i].General.MtimeToleranceMS < 0 {
+			destConfigsList[i].General.mtimeTolerance = max(destConfigsList[i].General.mtimeTolerance, destConfigsList[i].General.clockSkew.getModTimeTolerance(destConfigsList[i]))
+		}
+	}
+
+	return destConfigsList
+}
+
+// getModTimeTolerance returns the configured tolerance of modification times, or detects it by the file system of the destination directory:
+// FAT and exFAT keep times too coarsely to match the source exactly
+func getModTimeTolerance(configs Config) time.Duration {
+	if configs.General.MtimeToleranceMS >= 0 {
+		return time.Duration(configs.General.MtimeToleranceMS) * time.Millisecond
+	}
+	// a remote destination reports the precision it keeps instead
+	if len(configs.General.DestinationURL) > 0 {
+		return 0
+	}
+
+	// a missing destination directory is created on the file system of its nearest existing parent
+	dir := configs.General.DestinationDirectory
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	if isCoarseModTimeFS(dir) {
+		return coarseModTimeTolerance
+	}
+	return 0
+}
+
+// hashFile returns the hash of the contents of the file, by the hash algorithm
+func hashFile(fsys readableFS, path string, algorithm string) ([]byte, error) {
+	// try to open file for read
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// make sure to close file before end of context
+	defer file.Close()
+
+	// stream file contents through a fixed buffer into the hash, so large files are not loaded into memory
+	hash := newHash(algorithm)
+	if _, err := io.CopyBuffer(hash, file, make([]byte, hashBufferSize)); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,103 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsSameModTime(t *testing.T) {
+	srcModTime := time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC)
+	// a time zone with daylight saving time, whose change falls on the source time
+	zone := time.FixedZone("CEST", 2*60*60)
+
+	tests := []struct {
+		name        string
+		toleranceMS int
+		destModTime time.Time
+		same        bool
+	}{
+		{name: "equal", destModTime: srcModTime, same: true},
+		{name: "equal in another time zone", destModTime: srcModTime.In(zone), same: true},
+		{name: "nanosecond apart without tolerance", destModTime: srcModTime.Add(time.Nanosecond), same: false},
+		{name: "second apart without tolerance", destModTime: srcModTime.Add(-time.Second), same: false},
+		{name: "equal with tolerance", toleranceMS: 2000, destModTime: srcModTime, same: true},
+		{name: "within tolerance after", toleranceMS: 2000, destModTime: srcModTime.Add(1500 * time.Millisecond), same: true},
+		{name: "within tolerance before", toleranceMS: 2000, destModTime: srcModTime.Add(-1999 * time.Millisecond), same: true},
+		{name: "at tolerance", toleranceMS: 2000, destModTime: srcModTime.Add(2 * time.Second), same: true},
+		{name: "within tolerance in another time zone", toleranceMS: 2000, destModTime: srcModTime.Add(time.Second).In(zone), same: true},
+		{name: "beyond tolerance after", toleranceMS: 2000, destModTime: srcModTime.Add(2001 * time.Millisecond), same: false},
+		{name: "beyond tolerance before", toleranceMS: 2000, destModTime: srcModTime.Add(-3 * time.Second), same: false},
+		{name: "an hour apart (a time zone shift) with tolerance", toleranceMS: 2000, destModTime: srcModTime.Add(time.Hour), same: false},
+		// a destination which keeps no times never differs
+		{name: "unknown", destModTime: time.Time{}, same: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var configs Config
+			configs.General.MtimeToleranceMS = test.toleranceMS
+			configs.General.destination = newMemFS()
+			configs = setModTimeTolerances([]Config{configs})[0]
+
+			if same := isSameModTime(configs, srcModTime, test.destModTime); same != test.same {
+				t.Errorf("isSameModTime(%s, %s) = %v, expected %v", srcModTime, test.destModTime, same, test.same)
+			}
+		})
+	}
+}
+
+func TestModTimeToleranceComparesSizes(t *testing.T) {
+	fsys := newMemFS("/dir")
+	fsys.writeFile("/dir/src", "source", modTime)
+	fsys.writeFile("/dir/same", "SOURCE", modTime.Add(time.Second))
+	fsys.writeFile("/dir/longer", "source!", modTime.Add(time.Second))
+
+	var configs Config
+	configs.General.CompareMode = compareModeMtime
+	configs.General.MtimeToleranceMS = 2000
+	configs.General.destination = fsys
+	configs = setModTimeTolerances([]Config{configs})[0]
+
+	srcFile, _ := fsys.Stat("/dir/src")
+	tests := []struct {
+		path   string
+		reason string
+	}{
+		{path: "/dir/same", reason: ""},
+		{path: "/dir/longer", reason: "size differs"},
+	}
+	for _, test := range tests {
+		destFile, _ := fsys.Stat(test.path)
+		if reason, err := getChangeReason(configs, "/dir/src", srcFile, test.path, destFile); err != nil || reason != test.reason {
+			t.Errorf("getChangeReason() of '%s' = %q (%v), expected %q", test.path, reason, err, test.reason)
+		}
+	}
+}
+
+func TestSyncWithModTimeTolerance(t *testing.T) {
+	tests := []struct {
+		name        string
+		toleranceMS int
+		difference  time.Duration
+		copied      int64
+	}{
+		{name: "sub-tolerance difference", toleranceMS: 2000, difference: time.Second, copied: 0},
+		{name: "super-tolerance difference", toleranceMS: 2000, difference: 3 * time.Second, copied: 1},
+		{name: "difference without tolerance", toleranceMS: 0, difference: time.Second, copied: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mirror, fsys := newMemMirror(t, func(config *Config) {
+				config.General.MtimeToleranceMS = test.toleranceMS
+			})
+			fsys.writeFile("/src/a.txt", "a", modTime)
+			// a destination which rounds the times it keeps
+			fsys.writeFile("/dst/a.txt", "a", modTime.Add(test.difference))
+
+			if summary := mustSyncOnce(t, mirror); summary.FilesCopied != test.copied {
+				t.Errorf("copied %d files, expected %d", summary.FilesCopied, test.copied)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package mirror
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compression of destination files
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// suffixes of compressed files, by their compression
+var compressionSuffixes = map[string]string{
+	compressionGzip: ".gz",
+	compressionZstd: ".zst",
+}
+
+// extensions of files whose contents are compressed already, which are stored as they are
+var defaultCompressSkipExtensions = []string{
+	".gz", ".tgz", ".zst", ".bz2", ".xz", ".lz4", ".zip", ".7z", ".rar",
+	".jpg", ".jpeg", ".png", ".gif", ".webp", ".mp3", ".mp4", ".mkv", ".mov", ".avi", ".webm",
+}
+
+const (
+	// id of the gzip extra field holding the metadata of the source file
+	compressedGzipFieldID1 = 'D'
+	compressedGzipFieldID2 = 'M'
+	// magic number of the zstd skippable frame holding the metadata of the source file, followed by its size and tag
+	compressedZstdFrameMagic = 0x184D2A5D
+	compressedZstdFrameTag   = "DMIR"
+)
+
+// compression compresses the files stored by an encodedFS. small files and already compressed ones are stored as they are
+type compression struct {
+	algorithm string
+	// files smaller than the size are stored as they are
+	minSize int64
+	// lowercase extensions of files which are stored as they are
+	skipExtensions map[string]bool
+}
+
+// newCompressedFS returns the file system storing files compressed in the base file system
+func newCompressedFS(configs Config, base destinationFS) *encodedFS {
+	encoding := &compression{
+		algorithm:      configs.General.CompressDestination,
+		minSize:        int64(configs.General.CompressMinSizeKB) * 1024,
+		skipExtensions: make(map[string]bool),
+	}
+	for _, ext := range configs.General.CompressSkipExtensions {
+		encoding.skipExtensions[strings.ToLower(ext)] = true
+	}
+
+	return &encodedFS{base: base, encoding: encoding, suffix: compressionSuffixes[encoding.algorithm]}
+}
+
+func (encoding *compression) ready() error {
+	return nil
+}
+
+func (encoding *compression) isEncoded(path string, srcFile os.FileInfo) bool {
+	if srcFile == nil || !srcFile.Mode().IsRegular() || srcFile.Size() < encoding.minSize {
+		return false
+	}
+
+	// a temporary file is judged by the name of the file it is written for
+	name := filepath.Base(path)
+	if isTempPath(path) {
+		name = strings.TrimSuffix(name, tempFileSuffix)
+	}
+
+	// a file named like a compressed file is never compressed again, so the names of compressed files are never ambiguous
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, suffix := range compressionSuffixes {
+		if ext == suffix {
+			return false
+		}
+	}
+	return !encoding.skipExtensions[ext]
+}
+
+func (encoding *compression) readMetadata(reader io.Reader) (int64, bool, error) {
+	var metadata []byte
+	switch encoding.algorithm {
+	case compressionGzip:
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return 0, false, nil
+		}
+		metadata = getGzipExtraField(gzipReader.Header.Extra, compressedGzipFieldID1, compressedGzipFieldID2)
+	case compressionZstd:
+		frame := make([]byte, 8+len(compressedZstdFrameTag)+encodedMetadataSize)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return 0, false, nil
+		}
+		if binary.LittleEndian.Uint32(frame) == compressedZstdFrameMagic && int(binary.LittleEndian.Uint32(frame[4:])) == len(frame)-8 &&
+			string(frame[8:8+len(compressedZstdFrameTag)]) == compressedZstdFrameTag {
+			metadata = frame[8+len(compressedZstdFrameTag):]
+		}
+	}
+
+	if len(metadata) != encodedMetadataSize {
+		return 0, false, nil
+	}
+	size, _ := decodeMetadata(metadata)
+	return size, true, nil
+}
+
+// getGzipExtraField returns the data of the subfield of a gzip extra field, or nil if missing
+func getGzipExtraField(extra []byte, id1 byte, id2 byte) []byte {
+	for len(extra) >= 4 {
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return nil
+		}
+		if extra[0] == id1 && extra[1] == id2 {
+			return extra[4 : 4+size]
+		}
+		extra = extra[4+size:]
+	}
+
+	return nil
+}
+
+func (encoding *compression) newReader(reader io.Reader) (io.ReadCloser, error) {
+	switch encoding.algorithm {
+	case compressionGzip:
+		return gzip.NewReader(reader)
+	default:
+		decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+}
+
+func (encoding *compression) newWriter(writer io.Writer, srcFile os.FileInfo) (io.WriteCloser, error) {
+	switch encoding.algorithm {
+	case compressionGzip:
+		gzipWriter := gzip.NewWriter(writer)
+		gzipWriter.Header = gzip.Header{ModTime: srcFile.ModTime(), OS: 255}
+		gzipWriter.Header.Extra = append([]byte{compressedGzipFieldID1, compressedGzipFieldID2, encodedMetadataSize, 0}, encodeMetadata(srcFile)...)
+		// the header is written right away, so even an incomplete file is recognized
+		return gzipWriter, gzipWriter.Flush()
+	default:
+		frame := binary.LittleEndian.AppendUint32(nil, compressedZstdFrameMagic)
+		frame = binary.LittleEndian.AppendUint32(frame, uint32(len(compressedZstdFrameTag)+encodedMetadataSize))
+		frame = append(append(frame, compressedZstdFrameTag...), encodeMetadata(srcFile)...)
+		if _, err := writer.Write(frame); err != nil {
+			return nil, err
+		}
+		return zstd.NewWriter(writer, zstd.WithEncoderConcurrency(1))
+	}
+}
@@ -0,0 +1,1054 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// config file types by their extension
+var configFileTypes = map[string]string{
+	".yml":  "yaml",
+	".yaml": "yaml",
+	".json": "json",
+	".toml": "toml",
+}
+
+// Config is the configuration of a mirror job, as read from a config file (where every option of the job is under the general section, and
+// the options of the whole process are under the global section)
+type Config struct {
+	General GeneralConfigurations
+	Global  GlobalConfigurations
+}
+
+// GlobalConfigurations holds the options which apply to all jobs of the process, the lowest limit set by any config file applies
+type GlobalConfigurations struct {
+	MaxTotalConcurrentWorkers int
+}
+
+// GeneralConfigurations holds the options of a mirror job, which are described in the README (by their config file names)
+type GeneralConfigurations struct {
+	SourceDirectory        string
+	Sources                []SourceConfigurations
+	DestinationDirectory   string
+	DestinationDirectories []string
+	DestinationSubpath     string
+	DestinationURL         string
+	// environment variables referenced by paths which are not set are kept as they are, rather than rejected
+	KeepUnsetVariables bool
+	// the source and destination directories can overlap, for setups where it is intended (e.g. excluded destination subdirectories)
+	AllowOverlappingDirectories bool
+	SftpKeyFile                 string
+	SftpKeyPassphrase           string
+	SftpPassword                string
+	SftpKnownHostsFile          string
+	SftpMaxSessions             int
+	S3Endpoint                  string
+	S3Region                    string
+	S3AccessKey                 string
+	S3SecretKey                 string
+	S3PathStyle                 bool
+	S3MultipartThresholdMB      int
+	LoopIntervalMS              int
+	AdaptiveInterval            *AdaptiveIntervalConfigurations
+	Schedule                    string
+	ScheduleOverlap             string
+	MaxConcurrentWorkers        int
+	CopyOrder                   string
+	PriorityPatterns            []string
+	OnInsufficientSpace         string
+	ExcludePatterns             []string
+	IncludePatterns             []string
+	RespectMirrorIgnore         bool
+	ExcludeMirrorIgnoreFiles    bool
+	MaxDepth                    int
+	IncludeSubdirectories       []string
+	MaxFileSizeMB               int
+	MinFileSizeKB               int
+	MaxSourceAgeDays            int
+	PruneExpired                bool
+	DestinationRetentionDays    int
+	MinFileAgeSeconds           int
+	SkipUnstableFiles           bool
+	WatchMode                   string
+	FullRescanIntervalMS        int
+	EventDebounceMS             int
+	SkipUnchangedDirs           bool
+	RunOnce                     bool
+	DryRun                      bool
+	CompareMode                 string
+	HashAlgorithm               string
+	MtimeToleranceMS            int
+	DetectClockSkew             bool
+	ClockSkewWarningMS          int
+	UnicodeNormalization        string
+	CaseInsensitive             bool
+	InvalidNameHandling         string
+	InvalidNameSubstitute       string
+	Verbose                     bool
+	RetryCount                  int
+	RetryDelayMS                int
+	OperationTimeoutSeconds     int
+	SymlinkMode                 string
+	PreservePermissions         bool
+	PreserveDirTimes            bool
+	MirrorEmptyDirectories      bool
+	PreserveOwnership           bool
+	PreserveACLs                bool
+	PreserveAttributes          bool
+	PreserveCreationTime        bool
+	AtomicWrites                bool
+	FsyncAfterCopy              bool
+	FsyncThresholdKB            int
+	VerifyAfterCopy             bool
+	ResumePartialCopies         bool
+	PreserveHardLinks           bool
+	CopyMode                    string
+	CompressDestination         string
+	CompressMinSizeKB           int
+	CompressSkipExtensions      []string
+	EncryptionKeyFile           string
+	EncryptionPassphrase        string
+	// files encrypted with previous keys are still read, and encrypted with the current key once they change
+	EncryptionPreviousKeyFiles     []string
+	EncryptionPreviousPassphrases  []string
+	SnapshotMode                   bool
+	SnapshotRetention              int
+	StateFile                      string
+	HealthFile                     string
+	PreSyncCommand                 []string
+	PostSyncCommand                []string
+	HookTimeoutSeconds             int
+	BackupDirectory                string
+	BackupSuffix                   string
+	BackupRetentionDays            int
+	QuarantineDirectory            string
+	QuarantineRetentionHours       int
+	DeleteMode                     string
+	DeleteAfterMissingIterations   int
+	DeletePhase                    string
+	DeletePhaseFailureThreshold    int
+	OverwritePolicy                string
+	DetectDrift                    bool
+	EnforceDestination             bool
+	WriteManifest                  bool
+	ManifestHashAlgorithm          string
+	ConflictBackup                 bool
+	SyncMode                       string
+	ConflictPolicy                 string
+	MaxDeletePercent               int
+	MaxDeleteCount                 int
+	ForceDelete                    bool
+	BreakLock                      bool
+	EmptySourceGuard               int
+	MaxErrorsPerIteration          int
+	MaxConsecutiveFailedIterations int
+	FailureCooldownSeconds         int
+	MaxBytesPerSecond              int64
+	BandwidthSchedule              []string
+	CopyBufferKB                   int
+	Preallocate                    bool
+	ParallelChunkCopy              *ParallelChunkCopyConfigurations
+	MetricsListenAddr              string
+	StatusListenAddr               string
+	Name                           string
+	// former name of the name option, still read
+	JobName             string
+	LogLevel            string
+	LogFormat           string
+	LogFile             string
+	LogMaxSizeMB        int
+	LogMaxBackups       int
+	LogConsole          bool
+	LogIdleIterations   bool
+	ProgressThresholdMB int
+	WebhookURL          string
+	WebhookEvents       []string
+	WebhookSecret       string
+	EventOutput         string
+	EventFile           string
+
+	// state shared by all operations of a job, set once the job starts (not read from the config file)
+	limiter *bandwidthLimiter
+	buffers *sync.Pool
+	metrics *jobMetrics
+	status  *jobStatus
+	control *jobControl
+	// source files skipped by their size, which were logged already
+	skipped *skippedFiles
+	// destination files missing from the source, which are deleted once missing for long enough
+	missing *missingFiles
+	// files written by the mirror, if drift is detected
+	written *writtenFiles
+	// manifests of the destination directories, if written
+	manifests *manifestWriter
+	// parsed schedule of iterations, if scheduled
+	schedule *cronSchedule
+	logger   *slog.Logger
+	totals   *jobTotals
+	// hashes of files kept between iterations, if the state file is enabled
+	hashes *hashCache
+	// workers running the operations of all iterations
+	workers *workerPool
+	// limit of the operations of all jobs of the supervisor running the job, nil if not run by a supervisor
+	totalWorkers *workerLimit
+	// tolerance of modification times of the destination, configured or detected by its file system (set for every iteration)
+	mtimeTolerance time.Duration
+	// file system of the source directory, the local one unless set before the job starts
+	source readableFS
+	// file system of the destination directories, kept across iterations along with its connections (if remote)
+	destination destinationFS
+	// updated settings to apply in place, sent when the config file changes
+	updates chan Config
+	// in snapshot mode, the directory of the snapshots of the destination and the latest of them (set for every iteration)
+	snapshotRoot   string
+	snapshotLatest string
+	// ACLs which could not be preserved for missing privileges, which was warned about once per job
+	securityWarned *int32
+	// output of the event stream, nil if not requested
+	events io.Writer
+	// ignore files of the source directory, nil if not respected
+	ignores *ignoreRules
+	// listings of the directories seen by the previous scan, nil if unchanged directories are listed anyway
+	listings *dirListings
+	// latest clock probes of the destinations, nil if their clocks are not probed
+	clockSkew *clockSkewProbes
+	// the configuration was validated and normalized already
+	prepared bool
+}
+
+// SourceConfigurations is a source directory of a job which mirrors multiple sources
+type SourceConfigurations struct {
+	Directory          string
+	DestinationSubpath string
+}
+
+// AdaptiveIntervalConfigurations is the range of the interval between scans of a job whose interval adapts to the changes found: the
+// minimum once a scan found changes, doubled for every scan which found none up to the maximum
+type AdaptiveIntervalConfigurations struct {
+	MinIntervalMS int
+	MaxIntervalMS int
+}
+
+// ParallelChunkCopyConfigurations splits the copy of every file of at least the threshold size into chunks, which are copied by the given
+// number of concurrent streams
+type ParallelChunkCopyConfigurations struct {
+	ThresholdMB int
+	ChunkMB     int
+	Streams     int
+}
+
+// LoadConfigFiles reads the configurations of every config file, in strict mode unknown options are rejected too
+func LoadConfigFiles(filePaths []string, strict bool) ([]Config, error) {
+	// create a container for our configs
+	configs := make([]Config, 0)
+
+	// iterate every config file path and attempt to read it
+	for _, arg := range filePaths {
+		// read configuration from file, transform it to configuration types (one for each source), and add to config container
+		fileConfigs, err := LoadConfigFile(arg, strict)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid configuration in '%s'; %w", arg, err)
+		}
+		configs = append(configs, fileConfigs...)
+	}
+
+	// jobs are told apart by their names (e.g. by the control API), so the names must be unique, and so must their files
+	if err := ValidateJobs(configs); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+func resolveConfigPath(name string) string {
+	// a file name may be provided without its extension, so look for the file with known extensions in that case
+	if _, err := os.Stat(name); err != nil && len(filepath.Ext(name)) < 1 {
+		for _, ext := range []string{".yml", ".yaml", ".json", ".toml"} {
+			if _, err := os.Stat(name + ext); err == nil {
+				return name + ext
+			}
+		}
+	}
+
+	return name
+}
+
+// LoadConfigFile reads the configurations of a config file, one for every source of it, validated and ready to be mirrored. in strict mode
+// unknown (e.g. misspelled) options are rejected too
+func LoadConfigFile(name string, strict bool) ([]Config, error) {
+	// use a dedicated viper instance for every file, so configurations of multiple files do not mix
+	v := viper.New()
+
+	// use the provided path as is (relative paths are resolved from the working directory)
+	path := resolveConfigPath(name)
+	v.SetConfigFile(path)
+
+	// set the config file type by its extension
+	configType, ok := configFileTypes[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return nil, fmt.Errorf("Unknown config file extension of '%s', supported extensions are .yml, .yaml, .json and .toml", path)
+	}
+	v.SetConfigType(configType)
+
+	// try to read the file
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("Error reading config file; %w", err)
+	}
+
+	setDefaults(v)
+
+	var config Config
+	// try to transform to configuration type (in strict mode, an unknown option, for example a misspelled one, is an error)
+	var err error
+	if strict {
+		err = v.UnmarshalExact(&config)
+	} else {
+		err = v.Unmarshal(&config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding config file; %w", err)
+	}
+
+	// the job is named after the config file, unless named (by the former option too)
+	if len(config.General.Name) < 1 && len(config.General.JobName) < 1 {
+		config.General.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return Prepare(config)
+}
+
+// DefaultConfig returns a configuration with the default value of every option, as a config file which sets none of them is read. the
+// source and destination directories have no default, so they must be set
+func DefaultConfig() Config {
+	v := viper.New()
+	setDefaults(v)
+
+	var config Config
+	// the defaults are always decoded
+	v.Unmarshal(&config)
+
+	return config
+}
+
+// setDefaults sets the default value of every option which has one
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("general.sftpMaxSessions", 4)
+	v.SetDefault("general.s3Region", "us-east-1")
+	v.SetDefault("general.s3MultipartThresholdMB", 16)
+	v.SetDefault("general.loopIntervalMS", 60000)
+	v.SetDefault("general.scheduleOverlap", scheduleOverlapSkip)
+	v.SetDefault("general.maxConcurrentWorkers", 100)
+	v.SetDefault("general.copyOrder", copyOrderNone)
+	v.SetDefault("general.onInsufficientSpace", insufficientSpaceNone)
+	v.SetDefault("general.watchMode", watchModePoll)
+	v.SetDefault("general.fullRescanIntervalMS", 600000)
+	v.SetDefault("general.eventDebounceMS", 1000)
+	v.SetDefault("general.compareMode", compareModeMtime)
+	v.SetDefault("general.hashAlgorithm", hashAlgorithmXXHash64)
+	v.SetDefault("general.manifestHashAlgorithm", hashAlgorithmSHA256)
+	v.SetDefault("general.unicodeNormalization", unicodeNormalizationNone)
+	v.SetDefault("general.invalidNameHandling", invalidNameHandlingNone)
+	v.SetDefault("general.invalidNameSubstitute", "_")
+	v.SetDefault("general.mtimeToleranceMS", -1)
+	v.SetDefault("general.clockSkewWarningMS", 2000)
+	v.SetDefault("general.retryDelayMS", 1000)
+	v.SetDefault("general.symlinkMode", symlinkModeSkip)
+	v.SetDefault("general.atomicWrites", true)
+	v.SetDefault("general.preservePermissions", true)
+	v.SetDefault("general.preserveDirTimes", false)
+	v.SetDefault("general.mirrorEmptyDirectories", true)
+	v.SetDefault("general.preserveCreationTime", defaultPreserveCreationTime)
+	v.SetDefault("general.copyMode", copyModeCopy)
+	v.SetDefault("general.compressDestination", compressionNone)
+	v.SetDefault("general.compressMinSizeKB", 1)
+	v.SetDefault("general.compressSkipExtensions", defaultCompressSkipExtensions)
+	v.SetDefault("general.deleteMode", deleteModePermanent)
+	v.SetDefault("general.deletePhase", deletePhaseAfterCopies)
+	v.SetDefault("general.deleteAfterMissingIterations", 1)
+	v.SetDefault("general.overwritePolicy", overwritePolicyAlways)
+	v.SetDefault("general.syncMode", syncModeOneWay)
+	v.SetDefault("general.conflictPolicy", conflictPolicyNewer)
+	v.SetDefault("general.emptySourceGuard", 100)
+	v.SetDefault("general.copyBufferKB", defaultCopyBufferKB)
+	v.SetDefault("general.logFormat", logFormatText)
+	v.SetDefault("general.logMaxSizeMB", 100)
+	v.SetDefault("general.logMaxBackups", 5)
+	v.SetDefault("general.logConsole", true)
+	v.SetDefault("general.progressThresholdMB", 1024)
+	v.SetDefault("general.webhookEvents", []string{webhookEventError, webhookEventDelete, webhookEventJobFailed, webhookEventInsufficientSpace})
+}
+
+// Prepare validates the configuration and normalizes its paths, as it is done for a config file. a configuration of multiple sources is
+// returned as a configuration for every source, each mirrored by a job of its own. an already prepared configuration is returned as it is
+func Prepare(config Config) ([]Config, error) {
+	if config.General.prepared {
+		return []Config{config}, nil
+	}
+
+	// paths are expanded before they are validated, so validation reflects the real paths
+	if err := expandConfigPaths(&config.General); err != nil {
+		return nil, err
+	}
+
+	// the job is named by the former option too
+	if len(config.General.Name) > 0 && len(config.General.JobName) > 0 && config.General.Name != config.General.JobName {
+		return nil, errors.New("Name and job name cannot be configured together")
+	}
+	if len(config.General.Name) < 1 {
+		config.General.Name = config.General.JobName
+	}
+
+	// make sure mandatory configs has been set
+
+	// a destination URL is mirrored into the path on the remote host, which is a destination directory for everything else
+	if len(config.General.DestinationURL) > 0 {
+		if len(config.General.DestinationDirectory) > 0 || len(config.General.DestinationDirectories) > 0 {
+			return nil, errors.New("Destination URL and destination directories cannot be configured together")
+		}
+		if err := validateDestinationURL(config.General); err != nil {
+			return nil, err
+		}
+
+		destURL, _ := parseDestinationURL(config.General.DestinationURL)
+		config.General.DestinationDirectories = []string{destURL.Path}
+
+		// the prefix of a bucket is mirrored into as a directory of its root, and an object is stored once its upload completes,
+		// so it never needs a temporary object (objects keep no permissions either)
+		if destURL.Scheme == destinationSchemeS3 {
+			config.General.DestinationDirectories = []string{"/" + strings.Trim(destURL.Path, "/")}
+			config.General.AtomicWrites = false
+			config.General.PreservePermissions = false
+		}
+	}
+
+	if len(config.General.DestinationDirectory) < 1 && len(config.General.DestinationDirectories) < 1 {
+		return nil, errors.New("Destination directory is not configured")
+	}
+	if len(config.General.DestinationDirectory) > 0 && len(config.General.DestinationDirectories) > 0 {
+		return nil, errors.New("Destination directory and destination directories cannot be configured together")
+	}
+	if len(config.General.SourceDirectory) < 1 && len(config.General.Sources) < 1 {
+		return nil, errors.New("Source directory is not configured")
+	}
+	if len(config.General.SourceDirectory) > 0 && len(config.General.Sources) > 0 {
+		return nil, errors.New("Source directory and sources cannot be configured together")
+	}
+
+	// a single destination is the same as a list of one destination
+	if len(config.General.DestinationDirectory) > 0 {
+		config.General.DestinationDirectories = []string{config.General.DestinationDirectory}
+	}
+	// the destination directory is set for every destination separately, once the source is mirrored
+	config.General.DestinationDirectory = ""
+
+	// normalize directories (absolute, without trailing separators), so paths are built the same way regardless of how they were configured
+	// (remote directories are paths on the remote host, which are kept as they are)
+	destinationNames := make(map[string]bool)
+	for i, dir := range config.General.DestinationDirectories {
+		if len(dir) < 1 {
+			return nil, errors.New("Destination directory is not configured")
+		}
+		if len(config.General.DestinationURL) < 1 {
+			config.General.DestinationDirectories[i] = normalizeDirectory(dir)
+		}
+
+		// backups of multiple destinations are kept apart in subfolders named after the destinations, so the names must be unique
+		name := filepath.Base(config.General.DestinationDirectories[i])
+		if len(config.General.DestinationDirectories) > 1 && (len(config.General.BackupDirectory) > 0 || len(config.General.QuarantineDirectory) > 0) && destinationNames[name] {
+			return nil, fmt.Errorf("Destination directories must have unique names when a backup or quarantine directory is configured, '%s' is repeated", name)
+		}
+		destinationNames[name] = true
+	}
+	// local paths which can be inside the destination directory are normalized the same way, so they are recognized there
+	if len(config.General.BackupDirectory) > 0 {
+		config.General.BackupDirectory = normalizeDirectory(config.General.BackupDirectory)
+	}
+	if len(config.General.QuarantineDirectory) > 0 {
+		config.General.QuarantineDirectory = normalizeDirectory(config.General.QuarantineDirectory)
+	}
+	if len(config.General.HealthFile) > 0 {
+		config.General.HealthFile = normalizeDirectory(config.General.HealthFile)
+	}
+	if len(config.General.StateFile) > 0 {
+		config.General.StateFile = normalizeDirectory(config.General.StateFile)
+	}
+	if config.General.WatchMode != watchModePoll && config.General.WatchMode != watchModeEvents {
+		return nil, fmt.Errorf("Unknown watch mode '%s'", config.General.WatchMode)
+	}
+	if config.General.CompareMode != compareModeMtime && config.General.CompareMode != compareModeSize && config.General.CompareMode != compareModeHash {
+		return nil, fmt.Errorf("Unknown compare mode '%s'", config.General.CompareMode)
+	}
+	if !isHashAlgorithm(config.General.HashAlgorithm) {
+		return nil, fmt.Errorf("Unknown hash algorithm '%s'", config.General.HashAlgorithm)
+	}
+	if !isHashAlgorithm(config.General.ManifestHashAlgorithm) {
+		return nil, fmt.Errorf("Unknown manifest hash algorithm '%s'", config.General.ManifestHashAlgorithm)
+	}
+	if config.General.UnicodeNormalization != unicodeNormalizationNone && config.General.UnicodeNormalization != unicodeNormalizationNFC &&
+		config.General.UnicodeNormalization != unicodeNormalizationNFD {
+		return nil, fmt.Errorf("Unknown unicode normalization '%s'", config.General.UnicodeNormalization)
+	}
+	if config.General.InvalidNameHandling != invalidNameHandlingNone && config.General.InvalidNameHandling != invalidNameHandlingSkip &&
+		!isNameMapped(config.General) {
+		return nil, fmt.Errorf("Unknown invalid name handling '%s'", config.General.InvalidNameHandling)
+	}
+	if isNameMapped(config.General) {
+		if err := validateNameMapping(config.General); err != nil {
+			return nil, err
+		}
+	}
+	if config.General.ClockSkewWarningMS < 0 {
+		return nil, errors.New("Clock skew warning threshold must not be negative")
+	}
+	if config.General.MtimeToleranceMS < -1 {
+		return nil, errors.New("Modification time tolerance must not be negative (or -1 to detect it)")
+	}
+	if config.General.SymlinkMode != symlinkModeSkip && config.General.SymlinkMode != symlinkModeCopy && config.General.SymlinkMode != symlinkModeFollow {
+		return nil, fmt.Errorf("Unknown symlink mode '%s'", config.General.SymlinkMode)
+	}
+	if config.General.CopyOrder != copyOrderNone && config.General.CopyOrder != copyOrderSmallestFirst && config.General.CopyOrder != copyOrderLargestFirst &&
+		config.General.CopyOrder != copyOrderPriorityPatterns {
+		return nil, fmt.Errorf("Unknown copy order '%s'", config.General.CopyOrder)
+	}
+	if config.General.CopyOrder == copyOrderPriorityPatterns && len(config.General.PriorityPatterns) < 1 {
+		return nil, errors.New("Copy order 'priorityPatterns' requires priority patterns")
+	}
+	if config.General.OnInsufficientSpace != insufficientSpaceNone && config.General.OnInsufficientSpace != insufficientSpaceSkip &&
+		config.General.OnInsufficientSpace != insufficientSpacePartial {
+		return nil, fmt.Errorf("Unknown insufficient space handling '%s'", config.General.OnInsufficientSpace)
+	}
+	if config.General.OnInsufficientSpace != insufficientSpaceNone && (len(config.General.DestinationURL) > 0 || config.General.SnapshotMode ||
+		config.General.SyncMode == syncModeBidirectional) {
+		return nil, errors.New("Free space check requires a local destination, and cannot be used with snapshot mode or bidirectional sync mode")
+	}
+	if config.General.MaxSourceAgeDays < 0 || config.General.DestinationRetentionDays < 0 {
+		return nil, errors.New("Maximum source age and destination retention must not be negative")
+	}
+	if config.General.PruneExpired && config.General.MaxSourceAgeDays < 1 {
+		return nil, errors.New("Pruning expired files requires a maximum source age")
+	}
+	if (config.General.PruneExpired || config.General.DestinationRetentionDays > 0) && (config.General.SnapshotMode || config.General.SyncMode == syncModeBidirectional) {
+		return nil, errors.New("Destination retention and pruning expired files cannot be used with snapshot mode or bidirectional sync mode")
+	}
+	if config.General.CopyMode != copyModeAuto && config.General.CopyMode != copyModeCopy && config.General.CopyMode != copyModeHardlink && config.General.CopyMode != copyModeReflink {
+		return nil, fmt.Errorf("Unknown copy mode '%s'", config.General.CopyMode)
+	}
+	if (config.General.DetectDrift || config.General.EnforceDestination) && (config.General.SnapshotMode || config.General.SyncMode == syncModeBidirectional) {
+		return nil, errors.New("Drift detection cannot be used with snapshot mode or bidirectional sync mode")
+	}
+	if config.General.EnforceDestination && config.General.OverwritePolicy != overwritePolicyAlways {
+		return nil, errors.New("Enforcing the destination requires the 'always' overwrite policy")
+	}
+	if config.General.WriteManifest && (len(config.General.DestinationURL) > 0 || config.General.CompressDestination != compressionNone || isEncrypted(config.General) ||
+		config.General.SnapshotMode || config.General.SyncMode == syncModeBidirectional) {
+		return nil, errors.New("Manifest requires a local destination without compression or encryption, and cannot be used with snapshot mode or bidirectional sync mode")
+	}
+	if config.General.DeletePhase != deletePhaseInterleaved && config.General.DeletePhase != deletePhaseAfterCopies {
+		return nil, fmt.Errorf("Unknown delete phase '%s'", config.General.DeletePhase)
+	}
+	if config.General.DeletePhaseFailureThreshold < 0 {
+		return nil, errors.New("Delete phase failure threshold must not be negative")
+	}
+	if config.General.DeleteMode != deleteModePermanent && config.General.DeleteMode != deleteModeTrash {
+		return nil, fmt.Errorf("Unknown delete mode '%s'", config.General.DeleteMode)
+	}
+	if len(config.General.QuarantineDirectory) > 0 && (len(config.General.BackupDirectory) > 0 || config.General.DeleteMode == deleteModeTrash) {
+		return nil, errors.New("Quarantine directory cannot be used along with a backup directory or the trash delete mode")
+	}
+	if config.General.QuarantineRetentionHours < 0 {
+		return nil, errors.New("Quarantine retention must not be negative")
+	}
+	if config.Global.MaxTotalConcurrentWorkers < 0 {
+		return nil, errors.New("Max total concurrent workers must not be negative")
+	}
+	if config.General.DeleteAfterMissingIterations < 1 {
+		return nil, errors.New("Delete after missing iterations must be positive")
+	}
+	if config.General.OverwritePolicy != overwritePolicyAlways && config.General.OverwritePolicy != overwritePolicyIfNewer && config.General.OverwritePolicy != overwritePolicyNever {
+		return nil, fmt.Errorf("Unknown overwrite policy '%s'", config.General.OverwritePolicy)
+	}
+	if _, exists := compressionSuffixes[config.General.CompressDestination]; !exists && config.General.CompressDestination != compressionNone {
+		return nil, fmt.Errorf("Unknown compression '%s'", config.General.CompressDestination)
+	}
+	if config.General.CompressDestination != compressionNone {
+		if err := validateIndirectDestination(config.General, "compression"); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.General.EncryptionKeyFile) > 0 && len(config.General.EncryptionPassphrase) > 0 {
+		return nil, errors.New("Encryption key file and passphrase cannot be both configured")
+	}
+	if isEncrypted(config.General) {
+		if err := validateIndirectDestination(config.General, "encryption"); err != nil {
+			return nil, err
+		}
+		if _, err := getEncryptionKeys(config.General); err != nil {
+			return nil, fmt.Errorf("Invalid encryption key; %w", err)
+		}
+	} else if len(config.General.EncryptionPreviousKeyFiles) > 0 || len(config.General.EncryptionPreviousPassphrases) > 0 {
+		return nil, errors.New("Previous encryption keys require an encryption key file or passphrase")
+	}
+	if config.General.SnapshotMode {
+		if err := validateSnapshotMode(config.General); err != nil {
+			return nil, err
+		}
+	}
+	if config.General.SyncMode != syncModeOneWay && config.General.SyncMode != syncModeBidirectional {
+		return nil, fmt.Errorf("Unknown sync mode '%s'", config.General.SyncMode)
+	}
+	if config.General.SyncMode == syncModeBidirectional {
+		if err := validateBidirectionalMode(config.General); err != nil {
+			return nil, err
+		}
+	}
+	if config.General.MaxDepth < 0 {
+		return nil, errors.New("Max depth must not be negative")
+	}
+	includeSubdirectories, err := normalizeSubdirectories(config.General.IncludeSubdirectories)
+	if err != nil {
+		return nil, err
+	}
+	config.General.IncludeSubdirectories = includeSubdirectories
+	// creation times are set on the destination files directly, which are not local (or not the source files) otherwise. it is on by
+	// default, so it is turned off rather than rejected
+	if len(config.General.DestinationURL) > 0 || config.General.CompressDestination != compressionNone || isEncrypted(config.General) {
+		config.General.PreserveCreationTime = false
+	}
+	// verbose logging is the same as debug level, unless a level is set
+	if len(config.General.LogLevel) < 1 {
+		config.General.LogLevel = "info"
+		if config.General.Verbose {
+			config.General.LogLevel = "debug"
+		}
+	}
+	if _, err := ParseLogLevel(config.General.LogLevel); err != nil {
+		return nil, fmt.Errorf("Unknown log level '%s'", config.General.LogLevel)
+	}
+	if config.General.LogFormat != logFormatText && config.General.LogFormat != logFormatJSON {
+		return nil, fmt.Errorf("Unknown log format '%s'", config.General.LogFormat)
+	}
+	if len(config.General.EventOutput) > 0 && config.General.EventOutput != eventOutputNDJSON {
+		return nil, fmt.Errorf("Unknown event output '%s'", config.General.EventOutput)
+	}
+	for _, event := range config.General.WebhookEvents {
+		if event != webhookEventError && event != webhookEventDelete && event != webhookEventIterationSummary && event != webhookEventJobFailed &&
+			event != webhookEventInsufficientSpace {
+			return nil, fmt.Errorf("Unknown webhook event '%s'", event)
+		}
+	}
+	if config.General.HookTimeoutSeconds < 0 {
+		return nil, errors.New("Hook timeout must not be negative")
+	}
+	if config.General.OperationTimeoutSeconds < 0 {
+		return nil, errors.New("Operation timeout must not be negative")
+	}
+	if config.General.FsyncThresholdKB < 0 {
+		return nil, errors.New("Fsync threshold must not be negative")
+	}
+	if config.General.CopyBufferKB < 1 {
+		return nil, errors.New("Copy buffer size must be positive")
+	}
+	if chunks := config.General.ParallelChunkCopy; chunks != nil && (chunks.ThresholdMB < 1 || chunks.ChunkMB < 1 || chunks.Streams < 2) {
+		return nil, errors.New("Parallel chunk copy threshold and chunk size must be positive, with at least 2 streams")
+	}
+	if _, err := parseBandwidthSchedule(config.General.BandwidthSchedule); err != nil {
+		return nil, fmt.Errorf("Invalid bandwidth schedule; %w", err)
+	}
+	if len(config.General.Schedule) > 0 {
+		if _, err := parseCronSchedule(config.General.Schedule); err != nil {
+			return nil, fmt.Errorf("Invalid schedule; %w", err)
+		}
+		if config.General.WatchMode == watchModeEvents {
+			return nil, errors.New("Schedule cannot be used in events watch mode")
+		}
+	}
+	if adaptive := config.General.AdaptiveInterval; adaptive != nil {
+		if adaptive.MinIntervalMS < 1 || adaptive.MaxIntervalMS < adaptive.MinIntervalMS {
+			return nil, errors.New("Adaptive interval minimum must be positive, and not above its maximum")
+		}
+		if len(config.General.Schedule) > 0 || config.General.WatchMode == watchModeEvents {
+			return nil, errors.New("Adaptive interval cannot be used along with a schedule, or in events watch mode")
+		}
+	}
+	if config.General.ScheduleOverlap != scheduleOverlapSkip && config.General.ScheduleOverlap != scheduleOverlapQueue {
+		return nil, fmt.Errorf("Unknown schedule overlap '%s'", config.General.ScheduleOverlap)
+	}
+	if config.General.SkipUnchangedDirs && (config.General.DetectDrift || config.General.EnforceDestination) {
+		return nil, errors.New("Skipping unchanged directories cannot be used along with drift detection, which must see files modified in place")
+	}
+	if config.General.SkipUnchangedDirs && config.General.FullRescanIntervalMS < 1 {
+		return nil, errors.New("Full rescan interval must be positive when skipping unchanged directories")
+	}
+	if config.General.WatchMode == watchModeEvents && (config.General.FullRescanIntervalMS < 1 || config.General.EventDebounceMS < 1) {
+		return nil, errors.New("Full rescan interval and event debounce must be positive in events watch mode")
+	}
+
+	// a single source is mirrored into the destination directory itself
+	// (the configurations are prepared once, so preparing them again keeps them as they are)
+	config.General.prepared = true
+	configs := []Config{config}
+	if len(config.General.Sources) < 1 {
+		configs[0].General.SourceDirectory = normalizeDirectory(config.General.SourceDirectory)
+	} else if configs, err = expandSources(config); err != nil {
+		return nil, err
+	}
+
+	for _, sourceConfig := range configs {
+		if err := validateOverlap(sourceConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return configs, nil
+}
+
+// validateDestinationURL makes sure the destination URL can be used along with the rest of the configuration,
+// options which work on the local file system of the destination are not available for a remote destination
+func validateDestinationURL(general GeneralConfigurations) error {
+	destURL, err := parseDestinationURL(general.DestinationURL)
+	if err != nil {
+		return fmt.Errorf("Invalid destination URL; %w", err)
+	}
+
+	switch destURL.Scheme {
+	case destinationSchemeSftp:
+		if _, exists := destURL.User.Password(); !exists && len(general.SftpKeyFile) < 1 && len(general.SftpPassword) < 1 {
+			return errors.New("Destination URL requires a key file or a password")
+		}
+		if general.SftpMaxSessions < 1 {
+			return errors.New("SFTP sessions count must be positive")
+		}
+	case destinationSchemeS3:
+		if accessKey, secretKey := getS3Credentials(general); len(accessKey) < 1 || len(secretKey) < 1 {
+			return errors.New("Destination URL requires an access key and a secret key")
+		}
+		if endpoint, err := url.Parse(getS3Endpoint(general)); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) < 1 {
+			return fmt.Errorf("Invalid S3 endpoint '%s'", getS3Endpoint(general))
+		}
+		if len(general.S3Region) < 1 {
+			return errors.New("S3 region is not configured")
+		}
+		// every part but the last must be at least 5 MB
+		if general.S3MultipartThresholdMB < 5 {
+			return errors.New("S3 multipart threshold must be at least 5 MB")
+		}
+	}
+
+	if err := validateIndirectDestination(general, "a destination URL"); err != nil {
+		return err
+	}
+	if general.SymlinkMode == symlinkModeCopy {
+		return errors.New("Symlinks cannot be copied with a destination URL")
+	}
+	return nil
+}
+
+// validateNameMapping checks the settings which names can not be mapped with. the mapped names are stored through the file system of the
+// destination, so options which work on the destination files directly can not be used
+func validateNameMapping(general GeneralConfigurations) error {
+	if len(general.InvalidNameSubstitute) < 1 || isInvalidName(general.InvalidNameSubstitute) {
+		return fmt.Errorf("Invalid name substitute '%s' must be a valid name", general.InvalidNameSubstitute)
+	}
+	if general.SnapshotMode || general.SyncMode == syncModeBidirectional || general.WriteManifest {
+		return errors.New("Invalid names cannot be mapped with snapshot mode, bidirectional sync mode or a manifest")
+	}
+	if err := validateIndirectDestination(general, "mapped invalid names"); err != nil {
+		return err
+	}
+	if general.SymlinkMode == symlinkModeCopy {
+		return errors.New("Symlinks cannot be copied with mapped invalid names")
+	}
+	return nil
+}
+
+// validateIndirectDestination makes sure no option which works on the destination files directly, rather than through the file system of
+// the destination, is used along with the feature (e.g. a destination URL)
+func validateIndirectDestination(general GeneralConfigurations, feature string) error {
+	if len(general.BackupDirectory) > 0 {
+		return fmt.Errorf("Backup directory cannot be used with %s", feature)
+	}
+	if general.DeleteMode == deleteModeTrash {
+		return fmt.Errorf("Trash delete mode cannot be used with %s", feature)
+	}
+	if len(general.QuarantineDirectory) > 0 {
+		return fmt.Errorf("Quarantine directory cannot be used with %s", feature)
+	}
+	if general.PreserveOwnership || general.PreserveHardLinks {
+		return fmt.Errorf("Ownership and hard links cannot be preserved with %s", feature)
+	}
+	if canPreserveSecurity && (general.PreserveACLs || general.PreserveAttributes) {
+		return fmt.Errorf("ACLs and attributes cannot be preserved with %s", feature)
+	}
+	if general.ResumePartialCopies {
+		return fmt.Errorf("Partial copies cannot be resumed with %s", feature)
+	}
+	if general.CopyMode != copyModeCopy {
+		return fmt.Errorf("Copy mode '%s' cannot be used with %s", general.CopyMode, feature)
+	}
+	return nil
+}
+
+// ValidateJobs makes sure the jobs which run together do not get in the way of each other
+func ValidateJobs(configs []Config) error {
+	if err := validateJobNames(configs); err != nil {
+		return err
+	}
+	return validateHealthFiles(configs)
+}
+
+// validateJobNames makes sure no two jobs have the same name
+func validateJobNames(configs []Config) error {
+	names := make(map[string]bool)
+	for _, jobConfigs := range configs {
+		name := getJobName(jobConfigs)
+		if names[name] {
+			return fmt.Errorf("Job name '%s' is repeated, every job must have a unique name (set name in the config files)", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// validateHealthFiles makes sure no two jobs write the same health file, which would overwrite the health of each other
+func validateHealthFiles(configs []Config) error {
+	jobs := make(map[string]string)
+	for _, jobConfigs := range configs {
+		path := jobConfigs.General.HealthFile
+		if len(path) < 1 {
+			continue
+		}
+		if job, exists := jobs[path]; exists {
+			return fmt.Errorf("Health file '%s' is shared by jobs '%s' and '%s', every job must have its own health file", path, job, getJobName(jobConfigs))
+		}
+		jobs[path] = getJobName(jobConfigs)
+	}
+	return nil
+}
+
+// validateOverlap makes sure the source directory and the destination directories do not overlap (unless allowed), which would mirror the
+// output of the mirror into itself without bound. both are compared by their real paths, so symlinks to either are detected too
+func validateOverlap(configs Config) error {
+	// a remote destination can not overlap the local source
+	if configs.General.AllowOverlappingDirectories || len(configs.General.DestinationURL) > 0 {
+		return nil
+	}
+
+	srcDir := resolveExistingPath(configs.General.SourceDirectory)
+	for _, destConfigs := range getDestinationConfigs(configs) {
+		destDir := resolveExistingPath(destConfigs.General.DestinationDirectory)
+		if isSubPath(srcDir, destDir) || isSubPath(destDir, srcDir) {
+			return fmt.Errorf("Source directory '%s' and destination directory '%s' overlap (set allowOverlappingDirectories to allow it)",
+				configs.General.SourceDirectory, destConfigs.General.DestinationDirectory)
+		}
+	}
+	return nil
+}
+
+// warnOverlappingDestinations warns about local destination directories of different jobs (or of the same job) which overlap, since the
+// mirror of one deletes the files written by the other
+func warnOverlappingDestinations(configs []Config) {
+	type destination struct {
+		dir      string
+		realDir  string
+		jobIndex int
+	}
+	var destinations []destination
+	for i, jobConfigs := range configs {
+		if len(jobConfigs.General.DestinationURL) > 0 {
+			continue
+		}
+		for _, destConfigs := range getDestinationConfigs(jobConfigs) {
+			dir := destConfigs.General.DestinationDirectory
+			destinations = append(destinations, destination{dir: dir, realDir: resolveExistingPath(dir), jobIndex: i})
+		}
+	}
+
+	for i, dest := range destinations {
+		for _, otherDest := range destinations[i+1:] {
+			if isSubPath(dest.realDir, otherDest.realDir) || isSubPath(otherDest.realDir, dest.realDir) {
+				slog.Warn("Destination directories overlap, files mirrored into one can be deleted by the mirror of the other", "destination", dest.dir,
+					"job", getJobName(configs[dest.jobIndex]), "otherDestination", otherDest.dir, "otherJob", getJobName(configs[otherDest.jobIndex]))
+			}
+		}
+	}
+}
+
+// resolveExistingPath returns the real path of the path, evaluating the symlinks of its nearest existing parent directory if it does not exist
+// (yet). a path which can not be resolved at all is returned as it is
+func resolveExistingPath(path string) string {
+	var missingPath string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, missingPath)
+		}
+		if filepath.Dir(dir) == dir {
+			return path
+		}
+		missingPath = filepath.Join(filepath.Base(dir), missingPath)
+	}
+}
+
+// normalizeDirectory returns the absolute and clean form of the configured local directory (or file), so relative paths are computed
+// against the same form of it as the walked paths. on Windows, it is the extended-length form, so paths under it are not limited to 260
+// characters (and a UNC path keeps its share root as a volume)
+func normalizeDirectory(dir string) string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.Clean(dir)
+	}
+	return toExtendedLengthPath(absDir)
+}
+
+// normalizeSubdirectories converts the selected subdirectories into slash separated relative paths, which must be inside the source directory
+func normalizeSubdirectories(subdirectories []string) ([]string, error) {
+	normalized := make([]string, 0, len(subdirectories))
+	for _, subdirectory := range subdirectories {
+		cleanPath := path.Clean(filepath.ToSlash(subdirectory))
+		if filepath.IsAbs(subdirectory) || path.IsAbs(cleanPath) || cleanPath == "." || cleanPath == ".." || strings.HasPrefix(cleanPath, "../") {
+			return nil, fmt.Errorf("Included subdirectory '%s' must be a subpath of the source directory", subdirectory)
+		}
+		normalized = append(normalized, cleanPath)
+	}
+	return normalized, nil
+}
+
+// validateSnapshotMode checks the settings which snapshots can not be written with. snapshots hard link files into the previous snapshot, so
+// they are local and stored as they are
+func validateSnapshotMode(general GeneralConfigurations) error {
+	if len(general.DestinationURL) > 0 || general.CompressDestination != compressionNone || isEncrypted(general) {
+		return errors.New("Snapshot mode requires a local destination, without compression or encryption")
+	}
+	if len(general.BackupDirectory) > 0 || len(general.QuarantineDirectory) > 0 || general.DeleteMode == deleteModeTrash {
+		return errors.New("Backup directory, quarantine directory and trash delete mode cannot be used with snapshot mode, previous versions are kept by the snapshots")
+	}
+	if general.WatchMode == watchModeEvents {
+		return errors.New("Events watch mode cannot be used with snapshot mode, every snapshot is written by a full scan")
+	}
+	if general.CopyMode == copyModeHardlink || general.CopyMode == copyModeAuto {
+		return fmt.Errorf("Copy mode '%s' cannot be used with snapshot mode, hard links to source files would change with them", general.CopyMode)
+	}
+	if general.SnapshotRetention < 0 {
+		return errors.New("Snapshot retention must not be negative")
+	}
+	return nil
+}
+
+func expandSources(config Config) ([]Config, error) {
+	// create a container for the configuration of every source
+	configs := make([]Config, 0, len(config.General.Sources))
+
+	for _, source := range config.General.Sources {
+		if len(source.Directory) < 1 {
+			return nil, errors.New("Source directory is not configured")
+		}
+
+		// every source is mirrored into its own subfolder of the destination directory, named after the source directory unless configured
+		sourceDir := normalizeDirectory(source.Directory)
+		subpath := source.DestinationSubpath
+		if len(subpath) < 1 {
+			subpath = filepath.Base(sourceDir)
+		}
+		subpath = filepath.Clean(subpath)
+
+		// the subfolder must be located under the destination directory
+		if filepath.IsAbs(subpath) || !isSubPath(".", subpath) || subpath == "." || subpath == string(filepath.Separator) {
+			return nil, fmt.Errorf("Invalid destination subpath '%s' of source '%s'", subpath, sourceDir)
+		}
+
+		// make sure one source cannot delete files of another, which happens when their destination subtrees overlap
+		for _, otherConfig := range configs {
+			if isSubPath(otherConfig.General.DestinationSubpath, subpath) || isSubPath(subpath, otherConfig.General.DestinationSubpath) {
+				return nil, fmt.Errorf("Destination of source '%s' overlaps the destination of source '%s'", sourceDir, otherConfig.General.SourceDirectory)
+			}
+		}
+
+		sourceConfig := config
+		sourceConfig.General.Sources = nil
+		sourceConfig.General.SourceDirectory = sourceDir
+		sourceConfig.General.DestinationSubpath = subpath
+		// every source is a job of its own, named by its subfolder too
+		sourceConfig.General.Name = config.General.Name + "/" + filepath.ToSlash(subpath)
+		configs = append(configs, sourceConfig)
+	}
+
+	return configs, nil
+}
+
+// getDestinationConfigs returns a copy of the configuration for every destination directory, with the destination (and backup) directory of that destination set
+func getDestinationConfigs(configs Config) []Config {
+	destConfigs := make([]Config, 0, len(configs.General.DestinationDirectories))
+
+	for _, dir := range configs.General.DestinationDirectories {
+		destConfig := configs
+		destConfig.General.DestinationDirectory = filepath.Join(dir, configs.General.DestinationSubpath)
+
+		if len(configs.General.BackupDirectory) > 0 {
+			// keep backups of every destination apart, so they do not override each other
+			if len(configs.General.DestinationDirectories) > 1 {
+				destConfig.General.BackupDirectory = filepath.Join(destConfig.General.BackupDirectory, filepath.Base(dir))
+			}
+			// keep backups of every source apart too
+			destConfig.General.BackupDirectory = filepath.Join(destConfig.General.BackupDirectory, configs.General.DestinationSubpath)
+		}
+		// so are quarantined files
+		if len(configs.General.QuarantineDirectory) > 0 {
+			if len(configs.General.DestinationDirectories) > 1 {
+				destConfig.General.QuarantineDirectory = filepath.Join(destConfig.General.QuarantineDirectory, filepath.Base(dir))
+			}
+			destConfig.General.QuarantineDirectory = filepath.Join(destConfig.General.QuarantineDirectory, configs.General.DestinationSubpath)
+		}
+
+		destConfigs = append(destConfigs, destConfig)
+	}
+
+	return destConfigs
+}
+
+// getDestinationsDescription returns the destination directories, for logging
+func getDestinationsDescription(configs Config) string {
+	var dirs []string
+	for _, destConfig := range getDestinationConfigs(configs) {
+		dirs = append(dirs, getDestinationName(destConfig))
+	}
+
+	return strings.Join(dirs, "', '")
+}
+
+// getDestinationName returns the destination directory, prefixed by the remote host (or bucket) of a destination URL
+func getDestinationName(destConfigs Config) string {
+	if len(destConfigs.General.DestinationURL) < 1 {
+		return destConfigs.General.DestinationDirectory
+	}
+
+	destURL, _ := parseDestinationURL(destConfigs.General.DestinationURL)
+	if destURL.Scheme == destinationSchemeS3 {
+		return fmt.Sprintf("s3://%s%s", destURL.Host, filepath.ToSlash(destConfigs.General.DestinationDirectory))
+	}
+	return fmt.Sprintf("%s@%s:%s", destURL.User.Username(), destURL.Host, filepath.ToSlash(destConfigs.General.DestinationDirectory))
+}
+
+// getJobName returns the name of the job, or a name made of its source and destination directories if it has none (e.g. when not read from
+// a config file)
+func getJobName(configs Config) string {
+	if len(configs.General.Name) > 0 {
+		return configs.General.Name
+	}
+
+	var dirs []string
+	for _, destConfig := range getDestinationConfigs(configs) {
+		dirs = append(dirs, getDestinationName(destConfig))
+	}
+
+	return fmt.Sprintf("%s -> %s", configs.General.SourceDirectory, strings.Join(dirs, ", "))
+}
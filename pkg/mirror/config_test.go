@@ -0,0 +1,240 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// prepareConfig prepares the configuration of a single source, configured by the function on top of the default configuration
+func prepareConfig(t *testing.T, configure func(config *Config)) Config {
+	t.Helper()
+
+	config := DefaultConfig()
+	configure(&config)
+
+	configs, err := Prepare(config)
+	if err != nil {
+		t.Fatalf("Prepare() failed; %s", err)
+	}
+	if len(configs) != 1 {
+		t.Fatalf("Prepare() returned %d configurations, expected 1", len(configs))
+	}
+	return configs[0]
+}
+
+func TestPrepareTrimsTrailingSeparators(t *testing.T) {
+	root := t.TempDir()
+	source := filepath.Join(root, "source")
+	destination := filepath.Join(root, "backup")
+
+	tests := []struct {
+		name        string
+		source      string
+		destination string
+	}{
+		{name: "without trailing separators", source: source, destination: destination},
+		{name: "with trailing separators", source: source + string(filepath.Separator), destination: destination + string(filepath.Separator)},
+		{name: "with repeated trailing separators", source: source + "//", destination: destination + "//"},
+		{name: "with trailing slashes", source: source + "/", destination: destination + "/"},
+		{name: "with dot segments", source: filepath.Join(source, "sub", "..") + "/.", destination: destination + "/./"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := prepareConfig(t, func(config *Config) {
+				config.General.SourceDirectory = test.source
+				config.General.DestinationDirectory = test.destination
+			})
+
+			if expected := normalizeDirectory(source); config.General.SourceDirectory != expected {
+				t.Errorf("source directory is '%s', expected '%s'", config.General.SourceDirectory, expected)
+			}
+			if expected := []string{normalizeDirectory(destination)}; len(config.General.DestinationDirectories) != 1 || config.General.DestinationDirectories[0] != expected[0] {
+				t.Errorf("destination directories are %q, expected %q", config.General.DestinationDirectories, expected)
+			}
+		})
+	}
+}
+
+func TestSyncDestinationWithTrailingSeparator(t *testing.T) {
+	suffixes := map[string]string{"without separator": "", "with separator": string(filepath.Separator), "with slash": "/"}
+	for name, suffix := range suffixes {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			source := filepath.Join(root, "source")
+			destination := filepath.Join(root, "backup")
+			writeTestFile(t, filepath.Join(source, "sub", "file.txt"), "file")
+			if err := os.Mkdir(destination, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			mirror := newTestMirror(t, source+suffix, destination+suffix, nil)
+			mustSyncOnce(t, mirror)
+			// a second iteration finds the copies where the first one wrote them
+			if summary := mustSyncOnce(t, mirror); summary.FilesCopied != 0 {
+				t.Errorf("second iteration copied %d files, expected none", summary.FilesCopied)
+			}
+
+			if data, err := os.ReadFile(filepath.Join(destination, "sub", "file.txt")); err != nil || string(data) != "file" {
+				t.Errorf("destination file has '%s' (%v), expected 'file'", data, err)
+			}
+			// the copies are never written next to the destination directory (e.g. into 'backupsub')
+			entries, err := os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 {
+				t.Errorf("%d entries next to the destination directory, expected only the source and the destination", len(entries))
+			}
+		})
+	}
+}
+
+// writeTestFile creates the file (and its missing parent directories) with the contents, and an old modification time
+func writeTestFile(t testing.TB, path string, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeConfigFile writes the contents into the config file of the name in the directory, and returns its path
+func writeConfigFile(t *testing.T, dir string, name string, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFilesIndependently(t *testing.T) {
+	dir := t.TempDir()
+	first := writeConfigFile(t, dir, "first.yml", "general:\n  sourceDirectory: "+filepath.ToSlash(filepath.Join(dir, "a"))+"\n  destinationDirectory: "+
+		filepath.ToSlash(filepath.Join(dir, "a-backup"))+"\n  loopIntervalMS: 1000\n")
+	second := writeConfigFile(t, dir, "second.yml", "general:\n  sourceDirectory: "+filepath.ToSlash(filepath.Join(dir, "b"))+"\n  destinationDirectory: "+
+		filepath.ToSlash(filepath.Join(dir, "b-backup"))+"\n  maxConcurrentWorkers: 2\n")
+
+	configs, err := LoadConfigFiles([]string{first, second}, true)
+	if err != nil {
+		t.Fatalf("LoadConfigFiles() failed; %s", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("LoadConfigFiles() returned %d configurations, expected 2", len(configs))
+	}
+
+	tests := []struct {
+		config      Config
+		name        string
+		source      string
+		destination string
+		interval    int
+		workers     int
+	}{
+		{config: configs[0], name: "first", source: "a", destination: "a-backup", interval: 1000, workers: 100},
+		{config: configs[1], name: "second", source: "b", destination: "b-backup", interval: 60000, workers: 2},
+	}
+	for _, test := range tests {
+		general := test.config.General
+		if general.Name != test.name {
+			t.Errorf("name is '%s', expected '%s'", general.Name, test.name)
+		}
+		if expected := normalizeDirectory(filepath.Join(dir, test.source)); general.SourceDirectory != expected {
+			t.Errorf("source directory of '%s' is '%s', expected '%s'", test.name, general.SourceDirectory, expected)
+		}
+		if expected := normalizeDirectory(filepath.Join(dir, test.destination)); len(general.DestinationDirectories) != 1 || general.DestinationDirectories[0] != expected {
+			t.Errorf("destination directories of '%s' are %q, expected '%s'", test.name, general.DestinationDirectories, expected)
+		}
+		if general.LoopIntervalMS != test.interval || general.MaxConcurrentWorkers != test.workers {
+			t.Errorf("loop interval and workers of '%s' are %d and %d, expected %d and %d", test.name, general.LoopIntervalMS, general.MaxConcurrentWorkers,
+				test.interval, test.workers)
+		}
+	}
+}
+
+func TestLoadConfigFileOfWorkingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "local.config.yaml", "general:\n  sourceDirectory: source\n  destinationDirectory: backup\n")
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(workingDir)
+
+	// a name without a directory (whose directory is '.'), and with dots in it
+	for _, name := range []string{"local.config.yaml", "./local.config.yaml"} {
+		configs, err := LoadConfigFile(name, true)
+		if err != nil {
+			t.Fatalf("LoadConfigFile(%q) failed; %s", name, err)
+		}
+		if configs[0].General.Name != "local.config" {
+			t.Errorf("name of '%s' is '%s', expected 'local.config'", name, configs[0].General.Name)
+		}
+		if expected := normalizeDirectory(filepath.Join(dir, "source")); configs[0].General.SourceDirectory != expected {
+			t.Errorf("source directory of '%s' is '%s', expected '%s'", name, configs[0].General.SourceDirectory, expected)
+		}
+	}
+}
+
+func TestLoadConfigFileWithoutExtension(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, dir, "job.yml", "general:\n  sourceDirectory: "+filepath.ToSlash(filepath.Join(dir, "source"))+"\n  destinationDirectory: "+
+		filepath.ToSlash(filepath.Join(dir, "backup"))+"\n")
+
+	configs, err := LoadConfigFile(filepath.Join(dir, "job"), true)
+	if err != nil {
+		t.Fatalf("LoadConfigFile() failed; %s", err)
+	}
+	if configs[0].General.Name != "job" {
+		t.Errorf("name is '%s', expected 'job'", configs[0].General.Name)
+	}
+}
+
+func TestLoadConfigFileFormats(t *testing.T) {
+	expected, err := LoadConfigFile(filepath.Join("testdata", "job.yml"), true)
+	if err != nil {
+		t.Fatalf("LoadConfigFile() failed; %s", err)
+	}
+
+	general := expected[0].General
+	if !reflect.DeepEqual(general.ExcludePatterns, []string{"*.tmp", "cache/"}) || general.RetryCount != 3 {
+		t.Errorf("exclude patterns and retry count are %q and %d, expected the configured ones", general.ExcludePatterns, general.RetryCount)
+	}
+	// options which are not set have their defaults, whatever the format
+	if general.LoopIntervalMS != 60000 || general.MaxConcurrentWorkers != 100 {
+		t.Errorf("loop interval and workers are %d and %d, expected the defaults", general.LoopIntervalMS, general.MaxConcurrentWorkers)
+	}
+
+	for _, name := range []string{"job.yaml", "job.json", "job.toml"} {
+		configs, err := LoadConfigFile(filepath.Join("testdata", name), true)
+		if err != nil {
+			t.Errorf("LoadConfigFile(%q) failed; %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(configs, expected) {
+			t.Errorf("configuration of '%s' differs from the same configuration in YAML", name)
+		}
+	}
+}
+
+func TestLoadConfigFileUnknownFormat(t *testing.T) {
+	_, err := LoadConfigFile(filepath.Join("testdata", "job.ini"), false)
+	if err == nil || !strings.Contains(err.Error(), "supported extensions are .yml, .yaml, .json and .toml") {
+		t.Errorf("LoadConfigFile() returned %v, expected an error listing the supported extensions", err)
+	}
+}
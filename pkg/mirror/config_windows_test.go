@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"testing"
+)
+
+func TestNormalizeWindowsDirectory(t *testing.T) {
+	tests := []struct {
+		dir      string
+		expected string
+	}{
+		{dir: `C:\backup`, expected: `\\?\C:\backup`},
+		{dir: `C:\backup\`, expected: `\\?\C:\backup`},
+		{dir: `C:\backup\\`, expected: `\\?\C:\backup`},
+		{dir: `C:/backup/`, expected: `\\?\C:\backup`},
+		{dir: `C:\backup\sub\..\`, expected: `\\?\C:\backup`},
+		{dir: `D:\`, expected: `\\?\D:\`},
+	}
+
+	for _, test := range tests {
+		if normalized := normalizeDirectory(test.dir); normalized != test.expected {
+			t.Errorf("normalizeDirectory(%q) = %q, expected %q", test.dir, normalized, test.expected)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package mirror
+
+import (
+	"os"
+)
+
+// creation times can not be set on other platforms
+const defaultPreserveCreationTime = false
+
+// checkCreationTimeSupport logs that creation times are not preserved, if requested, so the same config file can be used on every platform
+func checkCreationTimeSupport(configs Config) {
+	if configs.General.PreserveCreationTime {
+		configs.General.logger.Warn("Creation times are preserved on Windows only, ignoring preserveCreationTime")
+	}
+}
+
+func preserveCreationTime(configs Config, srcFile os.FileInfo, path string) error {
+	// there is no way to set the creation time of a file
+	return nil
+}
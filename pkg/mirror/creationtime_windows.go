@@ -0,0 +1,51 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// creation times are preserved by default on windows, where they are kept by every file
+const defaultPreserveCreationTime = true
+
+// checkCreationTimeSupport does nothing, since creation times are preserved on windows
+func checkCreationTimeSupport(configs Config) {
+}
+
+// preserveCreationTime sets the creation time of the source file on the destination file, if requested
+func preserveCreationTime(configs Config, srcFile os.FileInfo, path string) error {
+	// nothing to do unless requested (a symlink keeps its own times, which are never followed)
+	if !configs.General.PreserveCreationTime || configs.General.DryRun || isSymlink(srcFile) {
+		return nil
+	}
+
+	// the creation time is part of the info of every file read on windows
+	data, ok := srcFile.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return nil
+	}
+
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	// backup semantics are required to open a directory
+	handle, err := windows.CreateFile(pathPtr, windows.FILE_WRITE_ATTRIBUTES, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil,
+		windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return &os.PathError{Op: "CreateFile", Path: path, Err: err}
+	}
+	defer windows.CloseHandle(handle)
+
+	// the access and modification times are left as they are
+	creationTime := windows.Filetime{LowDateTime: data.CreationTime.LowDateTime, HighDateTime: data.CreationTime.HighDateTime}
+	if err := windows.SetFileTime(handle, &creationTime, nil, nil); err != nil {
+		return &os.PathError{Op: "SetFileTime", Path: path, Err: err}
+	}
+	return nil
+}
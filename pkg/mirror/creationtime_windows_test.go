@@ -0,0 +1,79 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// openFileTimes opens the file (or directory) to write its times
+func openFileTimes(t *testing.T, path string, access uint32) windows.Handle {
+	t.Helper()
+
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, err := windows.CreateFile(pathPtr, access, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil,
+		windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handle
+}
+
+func getCreationTime(t *testing.T, path string) time.Time {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return time.Unix(0, info.Sys().(*syscall.Win32FileAttributeData).CreationTime.Nanoseconds())
+}
+
+func setCreationTime(t *testing.T, path string, creation time.Time) {
+	t.Helper()
+
+	handle := openFileTimes(t, path, windows.FILE_WRITE_ATTRIBUTES)
+	defer windows.CloseHandle(handle)
+
+	creationTime := windows.NsecToFiletime(creation.UnixNano())
+	if err := windows.SetFileTime(handle, &creationTime, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncPreservesCreationTime(t *testing.T) {
+	created := time.Date(2015, 6, 7, 8, 9, 10, 0, time.UTC)
+
+	for _, preserve := range []bool{true, false} {
+		source, destination := t.TempDir(), t.TempDir()
+		writeTestFile(t, filepath.Join(source, "dir", "a.txt"), "a")
+		setCreationTime(t, filepath.Join(source, "dir", "a.txt"), created)
+		setCreationTime(t, filepath.Join(source, "dir"), created)
+
+		mirror := newTestMirror(t, source, destination, func(config *Config) {
+			config.General.PreserveCreationTime = preserve
+		})
+		mustSyncOnce(t, mirror)
+
+		// the creation times of files and directories survive the round trip, unless they are not preserved
+		for _, path := range []string{filepath.Join("dir", "a.txt"), "dir"} {
+			creation := getCreationTime(t, filepath.Join(destination, path))
+			if preserve && !creation.Equal(created) {
+				t.Errorf("creation time of '%s' is %s, expected %s", path, creation, created)
+			}
+			if !preserve && creation.Equal(created) {
+				t.Errorf("creation time of '%s' was preserved, though not requested", path)
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Decrypt restores the files of an encrypted destination (or a single encrypted file) into the output path: encrypted files are decrypted
+// without their suffix, and any other file is copied as it is. every key the files may be encrypted with is given, by key files and
+// passphrases. it returns the number of decrypted files and the problems found, a file which fails to decrypt never stops the others. an
+// error is returned if the files can not be restored at all
+func Decrypt(srcPath string, dstPath string, keyFiles []string, passphrases []string) (int, []string, error) {
+	if len(keyFiles)+len(passphrases) < 1 {
+		return 0, nil, errors.New("No encryption key is given")
+	}
+	srcPath, dstPath = filepath.Clean(srcPath), filepath.Clean(dstPath)
+
+	// restoring into the encrypted directory itself would decrypt the restored files again
+	absSrcPath, srcErr := filepath.Abs(srcPath)
+	absDstPath, dstErr := filepath.Abs(dstPath)
+	if srcErr == nil && dstErr == nil && isSubPath(absSrcPath, absDstPath) {
+		return 0, nil, fmt.Errorf("Output path '%s' is inside the encrypted path '%s'", dstPath, srcPath)
+	}
+
+	// every key decrypts files the same way, whether current or previous
+	general := GeneralConfigurations{EncryptionPreviousKeyFiles: keyFiles, EncryptionPreviousPassphrases: passphrases}
+	if len(keyFiles) > 0 {
+		general.EncryptionKeyFile, general.EncryptionPreviousKeyFiles = keyFiles[0], keyFiles[1:]
+	} else {
+		general.EncryptionPassphrase, general.EncryptionPreviousPassphrases = passphrases[0], passphrases[1:]
+	}
+	keys, err := getEncryptionKeys(general)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Invalid encryption key; %w", err)
+	}
+
+	decrypted, problems := decryptPath(&encryption{keys: keys}, srcPath, dstPath)
+	return decrypted, problems, nil
+}
+
+// decryptPath restores the files under the encrypted path (or the file at it) into the output path, decrypting the encrypted ones.
+// it returns the number of decrypted files and the problems found, a file which fails to decrypt never stops the others
+func decryptPath(encoding *encryption, srcPath string, dstPath string) (int, []string) {
+	decrypted := 0
+	var problems []string
+
+	err := filepath.WalkDir(srcPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			problems = append(problems, err.Error())
+			return nil
+		}
+
+		relPath, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstPath, relPath)
+
+		info, err := entry.Info()
+		if err != nil {
+			problems = append(problems, err.Error())
+			return nil
+		}
+
+		switch {
+		case entry.IsDir():
+			err = os.MkdirAll(dst, info.Mode().Perm()|0700)
+		case entry.Type()&fs.ModeSymlink != 0:
+			var target string
+			if target, err = os.Readlink(path); err == nil {
+				err = os.Symlink(target, dst)
+			}
+		case entry.Type().IsRegular():
+			var encrypted bool
+			if encrypted, err = decryptFile(encoding, path, dst, info); encrypted && err == nil {
+				decrypted++
+			}
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", path, err))
+		}
+		return nil
+	})
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	return decrypted, problems
+}
+
+// decryptFile restores the file, decrypting it into the output path (without its suffix) if it is encrypted, any other file is copied as it
+// is. it reports whether the file was encrypted
+func decryptFile(encoding *encryption, path string, dst string, info os.FileInfo) (bool, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	modTime := info.ModTime()
+	var reader io.Reader = src
+	aead, metadata, encrypted, err := encoding.readHeader(src)
+	if err != nil {
+		return true, err
+	}
+	if encrypted && strings.HasSuffix(path, encryptionSuffix) {
+		// the modification time of the source file is restored from the header, since storage (e.g. a synced folder) may not keep it
+		_, modTime = decodeMetadata(metadata)
+		dst = strings.TrimSuffix(dst, encryptionSuffix)
+		reader = newDecryptingReader(src, aead)
+	} else {
+		encrypted = false
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	}
+
+	file, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return encrypted, err
+	}
+	if _, err = io.Copy(file, reader); err != nil {
+		// never leave contents which failed to decrypt behind
+		file.Close()
+		return encrypted, errors.Join(err, os.Remove(dst))
+	}
+	if err := file.Close(); err != nil {
+		return encrypted, err
+	}
+
+	return encrypted, os.Chtimes(dst, modTime, modTime)
+}
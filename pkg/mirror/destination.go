@@ -0,0 +1,375 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// readableFS is the part of a file system which reads files. the source directory is read through it (the local file system, unless the
+// job is given another one, e.g. an in-memory one to exercise the mirroring without touching the disk)
+type readableFS interface {
+	Stat(path string) (os.FileInfo, error)
+	Lstat(path string) (os.FileInfo, error)
+	// ReadDir lists the directory, sorted by name
+	ReadDir(path string) ([]fs.DirEntry, error)
+	Open(path string) (io.ReadCloser, error)
+}
+
+// destinationFS is the file system of the destination directories, either the local one or a remote one.
+// paths are always passed in their local form, a remote file system converts them as needed
+type destinationFS interface {
+	readableFS
+	// Connect makes sure the file system can be reached
+	Connect() error
+	// Create creates the file to write, given the info of its source file (nil if none). file systems which store files differently use it
+	// (e.g. the modification time is stored along with the contents by file systems which can not change it later, Chtimes sets it either way)
+	Create(path string, srcFile os.FileInfo) (destinationFile, error)
+	Remove(path string) error
+	RemoveAll(path string) error
+	MkdirAll(path string, perm os.FileMode) error
+	Chmod(path string, mode os.FileMode) error
+	Chtimes(path string, atime time.Time, mtime time.Time) error
+	// Rename moves the file, replacing any file at the new path
+	Rename(oldPath string, newPath string) error
+	// ModTimeGranularity returns the precision of the modification times kept by the file system
+	ModTimeGranularity() time.Duration
+	Close() error
+}
+
+// destinationFile is a file written into the destination
+type destinationFile interface {
+	io.Writer
+	io.Seeker
+	// Sync flushes the written contents to stable storage
+	Sync() error
+	Close() error
+}
+
+// abortableFile is a destination file whose contents are stored once it is closed (e.g. an object being uploaded), so a failed write
+// is aborted rather than closed
+type abortableFile interface {
+	Abort() error
+}
+
+// reopenableFS is a file system whose existing files can be opened to write into, keeping their contents (e.g. the local one), so an
+// interrupted copy can be resumed
+type reopenableFS interface {
+	OpenExisting(path string) (destinationFile, error)
+}
+
+// schemes of destination URLs
+const (
+	destinationSchemeSftp = "sftp"
+	destinationSchemeS3   = "s3"
+)
+
+// parseDestinationURL parses a destination URL, validating it by its scheme
+func parseDestinationURL(rawURL string) (*url.URL, error) {
+	destURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch destURL.Scheme {
+	case destinationSchemeSftp:
+		err = validateSftpURL(destURL)
+	case destinationSchemeS3:
+		err = validateS3URL(destURL)
+	default:
+		err = fmt.Errorf("unsupported scheme '%s'", destURL.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return destURL, nil
+}
+
+// newDestinationFS returns the file system of the destination directories, which is remote if a destination URL is configured
+// (and compresses and encrypts the files it stores, if configured)
+func newDestinationFS(configs Config) destinationFS {
+	fsys := newStorageFS(configs)
+	// files are compressed before they are encrypted, since encrypted contents do not compress
+	if isEncrypted(configs.General) {
+		fsys = newEncryptedFS(configs, fsys)
+	}
+	if configs.General.CompressDestination != compressionNone {
+		fsys = newCompressedFS(configs, fsys)
+	}
+	// names which are invalid on the destination are mapped outermost, so the names the encodings add suffixes to are valid already
+	if isNameMapped(configs.General) {
+		return newMappedFS(configs, fsys)
+	}
+
+	return fsys
+}
+
+// newStorageFS returns the file system the destination directories are stored in
+func newStorageFS(configs Config) destinationFS {
+	if len(configs.General.DestinationURL) < 1 {
+		return localFS{}
+	}
+
+	// the URL is validated when the configuration is read
+	destURL, _ := parseDestinationURL(configs.General.DestinationURL)
+	if destURL.Scheme == destinationSchemeS3 {
+		return newS3FS(configs)
+	}
+	return newSftpFS(configs)
+}
+
+// localFS is the local file system
+type localFS struct{}
+
+func (localFS) Connect() error {
+	return nil
+}
+
+func (localFS) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (localFS) Lstat(path string) (os.FileInfo, error) {
+	return os.Lstat(path)
+}
+
+func (localFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	return os.ReadDir(path)
+}
+
+func (localFS) Open(path string) (io.ReadCloser, error) {
+	file, err := openShared(path)
+	if err != nil {
+		// never return a nil file inside a non-nil interface
+		return nil, err
+	}
+	return file, nil
+}
+
+func (localFS) Create(path string, srcFile os.FileInfo) (destinationFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		// never return a nil file inside a non-nil interface
+		return nil, err
+	}
+	return file, nil
+}
+
+func (localFS) OpenExisting(path string) (destinationFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0666)
+	if err != nil {
+		// never return a nil file inside a non-nil interface
+		return nil, err
+	}
+	return file, nil
+}
+
+func (localFS) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (localFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (localFS) MkdirAll(path string, perm os.FileMode) error {
+	return mkdirAll(path, perm)
+}
+
+func (localFS) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(path, mode)
+}
+
+func (localFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
+func (localFS) Rename(oldPath string, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (localFS) ModTimeGranularity() time.Duration {
+	return 0
+}
+
+func (localFS) Close() error {
+	return nil
+}
+
+// remoteFileInfo is the info of a file of a remote file system
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (info remoteFileInfo) Name() string {
+	return info.name
+}
+
+func (info remoteFileInfo) Size() int64 {
+	return info.size
+}
+
+func (info remoteFileInfo) Mode() os.FileMode {
+	return info.mode
+}
+
+func (info remoteFileInfo) ModTime() time.Time {
+	return info.modTime
+}
+
+func (info remoteFileInfo) IsDir() bool {
+	return info.mode.IsDir()
+}
+
+func (info remoteFileInfo) Sys() any {
+	return nil
+}
+
+// readFSFile returns the contents of the file, read through the file system
+func readFSFile(fsys readableFS, path string) ([]byte, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// writeFSFile writes the contents into the file through the file system, replacing the file if it exists
+func writeFSFile(fsys destinationFS, path string, data []byte) error {
+	file, err := fsys.Create(path, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// getDestFiles gets the files of the destination directory (including subdirs or subfiles) through its file system, symlinks are never followed.
+// the internal paths of the mirror (e.g. a backup directory inside the destination) are neither walked nor added
+func getDestFiles(logger *slog.Logger, fsys destinationFS, destDir string, scope pathScope, internalPaths []string) map[string]os.FileInfo {
+	// create a container for files
+	files := make(map[string]os.FileInfo)
+
+	walkFS(fsys, destDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			addUnreadableFile(logger, destDir, path, getEntryInfo(entry), err, files)
+			return nil
+		}
+
+		// ignore root path dir
+		if destDir == path {
+			return nil
+		}
+
+		// paths out of scope are not walked at all, the same as in the source, and neither are the paths used by the mirror itself
+		relativePath := getRelativePath(destDir, path)
+		if !scope.contains(relativePath) || isInternalPath(internalPaths, relativePath) {
+			return skipWalkedPath(entry)
+		}
+
+		// add file to container
+		info, err := entry.Info()
+		if err != nil {
+			addUnreadableFile(logger, destDir, path, nil, err, files)
+			return nil
+		}
+		files[relativePath] = info
+
+		// a directory whose contents are out of scope is compared itself only
+		if info.IsDir() && !scope.isWalked(relativePath) {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	return files
+}
+
+// addDestPathFiles adds the path (and its subtree, if it is a directory) to the destination files, getting its current state through the file system
+func addDestPathFiles(logger *slog.Logger, fsys destinationFS, rootDir string, relativePath string, scope pathScope, internalPaths []string,
+	files map[string]os.FileInfo) {
+	// paths used by the mirror itself are left alone
+	if isInternalPath(internalPaths, relativePath) {
+		return
+	}
+
+	// get path info, if the path does not exist there is nothing to add
+	info, err := fsys.Lstat(filepath.Join(rootDir, relativePath))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("Skip", "path", filepath.Join(rootDir, relativePath), "reason", "unreadable", "error", err)
+			files[relativePath] = unreadableFile{}
+		}
+		return
+	}
+
+	// add path to container
+	files[relativePath] = info
+
+	// in case of a directory, its whole subtree (in scope) is needed too
+	if info.IsDir() && scope.isWalked(relativePath) {
+		for subPath, subInfo := range getDestFiles(logger, fsys, filepath.Join(rootDir, relativePath), scope.under(relativePath),
+			getInternalPathsUnder(internalPaths, relativePath)) {
+			files[filepath.Join(relativePath, subPath)] = subInfo
+		}
+	}
+}
+
+// walkFS walks the tree of the root through the file system, calling walkFn the same way filepath.WalkDir does: the info of an entry is
+// only read if walkFn asks for it, a directory is listed only once walkFn did not skip it, and a directory which can not be listed is
+// reported a second time, along with the error
+func walkFS(fsys readableFS, root string, walkFn fs.WalkDirFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+
+	return walkFSDir(fsys, root, fs.FileInfoToDirEntry(info), walkFn)
+}
+
+func walkFSDir(fsys readableFS, path string, entry fs.DirEntry, walkFn fs.WalkDirFunc) error {
+	if !entry.IsDir() {
+		return walkFn(path, entry, nil)
+	}
+
+	// a skipped directory is not listed at all (e.g. a backup directory inside the destination)
+	if err := walkFn(path, entry, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	// a directory which can not be listed is reported again, along with the error
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		if walkErr := walkFn(path, entry, err); walkErr != filepath.SkipDir {
+			return walkErr
+		}
+		return nil
+	}
+
+	for _, subEntry := range entries {
+		if err := walkFSDir(fsys, filepath.Join(path, subEntry.Name()), subEntry, walkFn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// a directory modified shortly before it was listed could be modified again within the same modification time (file systems keep times at
+// a granularity, e.g. 2 seconds on FAT), so its listing is not kept
+const dirListingSettleTime = 2 * time.Second
+
+// dirListingKey is a directory of a root directory (the source or a destination one), which tells apart the same path as seen through
+// different file systems
+type dirListingKey struct {
+	rootDir     string
+	relativeDir string
+}
+
+// dirListing is the listing of a directory, as of its modification time
+type dirListing struct {
+	modTime time.Time
+	entries []scannedEntry
+}
+
+// dirListings keeps the listings of the directories seen by the previous scan, so a directory whose modification time did not change since
+// is not listed again (adding, removing or renaming its entries modifies a directory, while modifying a file in place does not). since a
+// kept listing holds the infos its files had when it was listed, every listing is dropped once the full rescan interval passes, so files
+// modified in place are noticed by the next full scan. it is used by a single scan at a time
+type dirListings struct {
+	listings map[dirListingKey]dirListing
+	// directories seen by the current scan, the others are gone once it ended
+	used map[dirListingKey]bool
+
+	rescanInterval time.Duration
+	// start of the last scan which listed every directory
+	rescanned time.Time
+}
+
+// newDirListings returns the container of directory listings, or nil if unchanged directories are listed anyway
+func newDirListings(general GeneralConfigurations) *dirListings {
+	if !general.SkipUnchangedDirs {
+		return nil
+	}
+
+	return &dirListings{listings: make(map[dirListingKey]dirListing), used: make(map[dirListingKey]bool),
+		rescanInterval: time.Duration(general.FullRescanIntervalMS) * time.Millisecond}
+}
+
+// startScan starts a scan at the time, which lists every directory again (dropping the kept listings) once the full rescan interval passed
+// since the last one. it reports whether the scan is a full one
+func (listings *dirListings) startScan(now time.Time) bool {
+	if listings == nil {
+		return true
+	}
+
+	if now.Sub(listings.rescanned) < listings.rescanInterval {
+		return false
+	}
+
+	listings.listings = make(map[dirListingKey]dirListing)
+	listings.rescanned = now
+	return true
+}
+
+// get returns the kept listing of the directory, if its modification time did not change since it was listed. the info of its
+// subdirectories is read again, so they are compared (and recursed into) by their current modification time
+func (listings *dirListings) get(fsys readableFS, rootDir string, relativeDir string, dir os.FileInfo) ([]scannedEntry, bool) {
+	if listings == nil {
+		return nil, false
+	}
+
+	key := dirListingKey{rootDir: rootDir, relativeDir: relativeDir}
+	listings.used[key] = true
+
+	listing, exists := listings.listings[key]
+	if !exists || !listing.modTime.Equal(dir.ModTime()) {
+		return nil, false
+	}
+
+	for i, entry := range listing.entries {
+		if !entry.info.IsDir() {
+			continue
+		}
+
+		info, err := fsys.Lstat(filepath.Join(rootDir, relativeDir, entry.name))
+		if err != nil {
+			return nil, false
+		}
+		listing.entries[i].info = info
+	}
+
+	return listing.entries, true
+}
+
+// put keeps the listing of the directory, unless it was modified too shortly before it was listed (or its modification time is not kept,
+// e.g. by an S3 destination), or some of its entries could not be read
+func (listings *dirListings) put(rootDir string, relativeDir string, dir os.FileInfo, entries []scannedEntry, listed time.Time) {
+	if listings == nil {
+		return
+	}
+
+	key := dirListingKey{rootDir: rootDir, relativeDir: relativeDir}
+	delete(listings.listings, key)
+
+	if dir.ModTime().IsZero() || listed.Sub(dir.ModTime()) < dirListingSettleTime {
+		return
+	}
+	for _, entry := range entries {
+		if entry.info == nil {
+			return
+		}
+	}
+
+	listings.listings[key] = dirListing{modTime: dir.ModTime(), entries: entries}
+}
+
+// prune drops the listings of directories which the ended scan did not see, since they are gone (or no longer scanned)
+func (listings *dirListings) prune() {
+	if listings == nil {
+		return
+	}
+
+	for key := range listings.listings {
+		if !listings.used[key] {
+			delete(listings.listings, key)
+		}
+	}
+	listings.used = make(map[dirListingKey]bool)
+}
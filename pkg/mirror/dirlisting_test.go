@@ -0,0 +1,155 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirListingsFullRescan(t *testing.T) {
+	listings := newDirListings(GeneralConfigurations{SkipUnchangedDirs: true, FullRescanIntervalMS: int(time.Hour / time.Millisecond)})
+	fsys := newMemFS("/src")
+	dir := remoteFileInfo{name: "src", mode: os.ModeDir | 0755, modTime: modTime}
+	entries := []scannedEntry{{name: "a.txt", info: remoteFileInfo{name: "a.txt", modTime: modTime}}}
+
+	start := time.Now()
+	if !listings.startScan(start) {
+		t.Error("first scan is not a full scan")
+	}
+	if _, ok := listings.get(fsys, "/src", "", dir); ok {
+		t.Error("listing of an unseen directory is reused")
+	}
+	listings.put("/src", "", dir, entries, start)
+	listings.prune()
+
+	// the listing is kept until the interval passes
+	for _, elapsed := range []time.Duration{time.Minute, 59 * time.Minute} {
+		if listings.startScan(start.Add(elapsed)) {
+			t.Errorf("scan %s after the full scan is a full scan", elapsed)
+		}
+		if _, ok := listings.get(fsys, "/src", "", dir); !ok {
+			t.Errorf("listing is not reused %s after the full scan", elapsed)
+		}
+		listings.prune()
+	}
+
+	if !listings.startScan(start.Add(time.Hour)) {
+		t.Error("scan once the interval passed is not a full scan")
+	}
+	if _, ok := listings.get(fsys, "/src", "", dir); ok {
+		t.Error("listing is reused by a full scan")
+	}
+
+	// without skipping unchanged directories every scan is a full one
+	var disabled *dirListings
+	if !disabled.startScan(start) {
+		t.Error("scan without kept listings is not a full scan")
+	}
+}
+
+// setDirModTimes sets the modification time of the directory and of every directory under it
+func setDirModTimes(t testing.TB, root string, modTime time.Time) {
+	t.Helper()
+
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, modTime, modTime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncSkipsUnchangedDirsUntilFullRescan(t *testing.T) {
+	source, destination := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(source, "dir", "a.txt"), "old")
+	writeTestFile(t, filepath.Join(source, "dir", "b.txt"), "b")
+
+	mirror := newTestMirror(t, source, destination, func(config *Config) {
+		config.General.SkipUnchangedDirs = true
+		config.General.FullRescanIntervalMS = int(time.Hour / time.Millisecond)
+	})
+
+	// iterations of an opened job keep the listings, as the iterations of the scan loop do
+	configs, closeJob, err := openJob(mirror.configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeJob()
+
+	sync := func() int64 {
+		t.Helper()
+
+		stats := syncDirectories(context.Background(), configs)
+		if stats.filesFailed != 0 {
+			t.Fatalf("iteration failed %d files", stats.filesFailed)
+		}
+		return stats.filesCopied
+	}
+	sync()
+
+	// directories modified long ago are settled, so their listings are kept by the next scan
+	setDirModTimes(t, source, modTime)
+	setDirModTimes(t, destination, modTime)
+	sync()
+
+	// a file modified in place does not modify its directory, so the kept listing hides it until the next full scan
+	writeTestFile(t, filepath.Join(source, "dir", "a.txt"), "modified")
+	if err := os.Chtimes(filepath.Join(source, "dir", "a.txt"), modTime.Add(time.Hour), modTime.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if copied := sync(); copied != 0 {
+		t.Errorf("scan of kept listings copied %d files, expected none", copied)
+	}
+
+	configs.General.listings.rescanned = time.Now().Add(-time.Hour)
+	if copied := sync(); copied != 1 {
+		t.Errorf("full scan copied %d files, expected the modified file", copied)
+	}
+	if data, err := os.ReadFile(filepath.Join(destination, "dir", "a.txt")); err != nil || string(data) != "modified" {
+		t.Errorf("copy holds %q (%v), expected %q", data, err, "modified")
+	}
+}
+
+func TestPrepareRequiresFullRescanOfUnchangedDirs(t *testing.T) {
+	config := DefaultConfig()
+	config.General.SourceDirectory = t.TempDir()
+	config.General.DestinationDirectory = t.TempDir()
+	config.General.SkipUnchangedDirs = true
+	config.General.FullRescanIntervalMS = 0
+
+	if _, err := New(config); err == nil {
+		t.Error("New() of a job skipping unchanged directories without full rescans succeeded")
+	}
+}
+
+func BenchmarkScanUnchangedTree(b *testing.B) {
+	source, destination := b.TempDir(), b.TempDir()
+	writeBenchmarkTree(b, 200, 100, source, destination)
+	// directories modified long ago are settled, so their listings are kept
+	setDirModTimes(b, source, modTime)
+	setDirModTimes(b, destination, modTime)
+
+	for _, skip := range []bool{false, true} {
+		b.Run(fmt.Sprintf("skipUnchangedDirs=%t", skip), func(b *testing.B) {
+			configs := openBenchmarkJob(b, source, destination, func(config *Config) {
+				config.General.SkipUnchangedDirs = skip
+				config.General.FullRescanIntervalMS = int(time.Hour / time.Millisecond)
+			})
+			destConfigsList := getDestinationConfigs(configs)
+			// the first scan lists every directory
+			scanDifferences(configs, destConfigsList)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				scanDifferences(configs, destConfigsList)
+			}
+		})
+	}
+}
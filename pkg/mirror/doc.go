@@ -0,0 +1,51 @@
+// Package mirror makes sure destination directories fully mirror a source directory (files and folders), as the DirectoryMirror command
+// does. It can be embedded into other programs, rather than running the command.
+//
+// A job is configured by a Config, which is read from a config file (with the options described in the README) by LoadConfigFile, or
+// built from DefaultConfig. An invalid configuration is returned as an error, by LoadConfigFile and Prepare (and by New, which prepares
+// the configuration unless it was read from a file):
+//
+//	config := mirror.DefaultConfig()
+//	config.General.SourceDirectory = "/data"
+//	config.General.DestinationDirectory = "/backup/data"
+//	config.General.LoopIntervalMS = 10000
+//
+//	m, err := mirror.New(config)
+//	if err != nil {
+//		return err
+//	}
+//
+// Run mirrors until its context is cancelled, waiting for in-flight operations to finish before it returns:
+//
+//	ctx, cancel := context.WithCancel(context.Background())
+//	defer cancel()
+//
+//	go func() {
+//		if err := m.Run(ctx); err != nil {
+//			log.Printf("mirroring failed; %s", err)
+//		}
+//	}()
+//
+//	// the totals of all iterations so far
+//	stats := m.Stats()
+//	log.Printf("copied %d files (%d bytes)", stats.FilesCopied, stats.BytesCopied)
+//
+// SyncOnce runs a single iteration instead, and returns what it did:
+//
+//	summary, err := m.SyncOnce(ctx)
+//	if errors.Is(err, mirror.ErrSkipped) {
+//		// the source or the destination is unavailable, try again later
+//	} else if err != nil {
+//		log.Printf("%d files failed; %s", summary.FilesFailed, err)
+//	}
+//
+// A configuration of multiple sources is mirrored by a job for every source, each created from a configuration returned by Prepare. The
+// jobs of config files can be run the way the command does (with their metrics and status servers, and their config files reloaded when
+// they change) by a Supervisor:
+//
+//	supervisor, err := mirror.NewSupervisor([]string{"config1.yml", "config2.yml"}, nil)
+//	if err != nil {
+//		return err
+//	}
+//	return supervisor.Run(ctx)
+package mirror
@@ -0,0 +1,107 @@
+package mirror
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writtenFile is what the mirror last wrote into a destination path: the size and modification time of its source file
+type writtenFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// writtenFiles keeps the files written by the mirror (or found in sync with their source files) by their destination path, so a destination
+// file which changed since while its source file did not (it was modified or deleted out of band) is told apart as drift
+type writtenFiles struct {
+	mutex sync.Mutex
+	files map[string]writtenFile
+}
+
+// newWrittenFiles returns the container of written files, or nil if drift is not detected
+func newWrittenFiles(general GeneralConfigurations) *writtenFiles {
+	if !general.DetectDrift && !general.EnforceDestination {
+		return nil
+	}
+
+	return &writtenFiles{files: make(map[string]writtenFile)}
+}
+
+// record records the source file as written into the destination path
+func (written *writtenFiles) record(path string, srcFile os.FileInfo) {
+	if written == nil {
+		return
+	}
+
+	written.mutex.Lock()
+	defer written.mutex.Unlock()
+
+	written.files[path] = writtenFile{size: srcFile.Size(), modTime: srcFile.ModTime()}
+}
+
+// forget removes the destination path and anything written under it, once it was deleted by the mirror
+func (written *writtenFiles) forget(path string) {
+	if written == nil {
+		return
+	}
+
+	written.mutex.Lock()
+	defer written.mutex.Unlock()
+
+	delete(written.files, path)
+
+	prefix := path + string(os.PathSeparator)
+	for writtenPath := range written.files {
+		if strings.HasPrefix(writtenPath, prefix) {
+			delete(written.files, writtenPath)
+		}
+	}
+}
+
+// getDrift returns how the destination file (nil if it is missing) drifted from what the mirror last wrote into its path while its source
+// file did not change, or an empty string if it did not drift (or nothing was written into it)
+func (written *writtenFiles) getDrift(configs Config, path string, srcFile os.FileInfo, destFile os.FileInfo) string {
+	if written == nil {
+		return ""
+	}
+
+	written.mutex.Lock()
+	file, exists := written.files[path]
+	written.mutex.Unlock()
+
+	// a changed source file is mirrored as usual, whatever happened to the destination file
+	if !exists || srcFile.Size() != file.size || !srcFile.ModTime().Equal(file.modTime) {
+		return ""
+	}
+
+	if destFile == nil {
+		return "destination deleted"
+	}
+	if destFile.Size() != file.size || !isSameModTime(configs, file.modTime, destFile.ModTime()) {
+		return "destination modified"
+	}
+	return ""
+}
+
+// checkDrift warns about the destination file if it drifted from what the mirror last wrote into its path, and returns how it drifted, or an
+// empty string if it did not
+func checkDrift(configs Config, stats *iterationStats, path string, srcFile os.FileInfo, destFile os.FileInfo) string {
+	drift := configs.General.written.getDrift(configs, path, srcFile, destFile)
+	if len(drift) < 1 {
+		return ""
+	}
+
+	stats.addDrifted()
+
+	if destFile == nil {
+		configs.General.logger.Warn("Drift", "path", path, "reason", drift, "enforced", configs.General.EnforceDestination)
+	} else {
+		configs.General.logger.Warn("Drift", "path", path, "reason", drift, "enforced", configs.General.EnforceDestination, "sourceSize", srcFile.Size(),
+			"destinationSize", destFile.Size(), "sourceModTime", srcFile.ModTime(), "destinationModTime", destFile.ModTime())
+	}
+	emitDriftEvent(configs, path, drift)
+
+	return drift
+}
@@ -0,0 +1,70 @@
+package mirror
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncDetectsDrift(t *testing.T) {
+	mirror, fsys := newMemMirror(t, func(config *Config) {
+		config.General.DetectDrift = true
+	})
+	fsys.writeFile("/src/a.txt", "a", modTime)
+	fsys.writeFile("/src/b.txt", "b", modTime)
+	fsys.writeFile("/src/c.txt", "c", modTime)
+	// files in sync when the job starts are tracked as well
+	fsys.writeFile("/dst/a.txt", "a", modTime)
+	fsys.writeFile("/dst/b.txt", "b", modTime)
+	if summary := mustSyncOnce(t, mirror); summary.FilesCopied != 1 || summary.FilesDrifted != 0 {
+		t.Fatalf("first scan copied %d files and found %d drifted, expected 1 copied and none drifted", summary.FilesCopied, summary.FilesDrifted)
+	}
+
+	// a destination file modified out of band is reported, and restored since the comparison tells it apart
+	fsys.writeFile("/dst/a.txt", "edited", modTime.Add(time.Hour))
+	summary := mustSyncOnce(t, mirror)
+	if summary.FilesDrifted != 1 || summary.FilesCopied != 1 {
+		t.Errorf("scan of a modified file found %d drifted and copied %d files, expected 1 and 1", summary.FilesDrifted, summary.FilesCopied)
+	}
+	assertTree(t, fsys, memDestination, "a.txt=a", "b.txt=b", "c.txt=c")
+
+	// a destination file deleted out of band (including one copied by the mirror) is reported, and restored
+	fsys.Remove("/dst/b.txt")
+	fsys.Remove("/dst/c.txt")
+	summary = mustSyncOnce(t, mirror)
+	if summary.FilesDrifted != 2 || summary.FilesCopied != 2 {
+		t.Errorf("scan of deleted files found %d drifted and copied %d files, expected 2 and 2", summary.FilesDrifted, summary.FilesCopied)
+	}
+	assertTree(t, fsys, memDestination, "a.txt=a", "b.txt=b", "c.txt=c")
+
+	// a modified source file is mirrored as usual
+	fsys.writeFile("/src/a.txt", "new", modTime.Add(time.Hour))
+	if summary := mustSyncOnce(t, mirror); summary.FilesDrifted != 0 || summary.FilesCopied != 1 {
+		t.Errorf("scan of a modified source file found %d drifted and copied %d files, expected none drifted and 1 copied", summary.FilesDrifted,
+			summary.FilesCopied)
+	}
+}
+
+func TestSyncEnforcesDestination(t *testing.T) {
+	for _, enforce := range []bool{false, true} {
+		mirror, fsys := newMemMirror(t, func(config *Config) {
+			config.General.CompareMode = compareModeSize
+			config.General.DetectDrift = !enforce
+			config.General.EnforceDestination = enforce
+		})
+		fsys.writeFile("/src/a.txt", "a", modTime)
+		fsys.writeFile("/dst/a.txt", "a", modTime)
+		mustSyncOnce(t, mirror)
+
+		// an edit which kept the size is no change to the comparison, so it is kept unless the destination is enforced
+		fsys.writeFile("/dst/a.txt", "A", modTime.Add(time.Hour))
+		summary := mustSyncOnce(t, mirror)
+		if summary.FilesDrifted != 1 {
+			t.Errorf("enforce %t: scan found %d drifted files, expected 1", enforce, summary.FilesDrifted)
+		}
+		if enforce {
+			assertTree(t, fsys, memDestination, "a.txt=a")
+		} else {
+			assertTree(t, fsys, memDestination, "a.txt=A")
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package mirror
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileEncoding encodes the contents of the files stored by an encodedFS (e.g. compresses them)
+type fileEncoding interface {
+	// ready reports why the encoding can not be used, if it can not (e.g. its keys are missing)
+	ready() error
+	// isEncoded reports whether the file is stored encoded, by its source file
+	isEncoded(path string, srcFile os.FileInfo) bool
+	// readMetadata reads the size of the source file from the header of the encoded file. a file without the header (e.g. encoded by
+	// another tool) is not an encoded file of the encoding
+	readMetadata(reader io.Reader) (int64, bool, error)
+	// newReader decodes the contents of the encoded file
+	newReader(reader io.Reader) (io.ReadCloser, error)
+	// newWriter encodes the contents written into the file, after the header holding the metadata of the source file
+	newWriter(writer io.Writer, srcFile os.FileInfo) (io.WriteCloser, error)
+}
+
+// size of the metadata of the source file stored in the header of an encoded file: its size and modification time (in nanoseconds)
+const encodedMetadataSize = 16
+
+// encodeMetadata encodes the size and modification time of the source file
+func encodeMetadata(srcFile os.FileInfo) []byte {
+	metadata := make([]byte, encodedMetadataSize)
+	binary.LittleEndian.PutUint64(metadata, uint64(srcFile.Size()))
+	binary.LittleEndian.PutUint64(metadata[8:], uint64(srcFile.ModTime().UnixNano()))
+	return metadata
+}
+
+// decodeMetadata decodes the size and modification time of the source file
+func decodeMetadata(metadata []byte) (int64, time.Time) {
+	return int64(binary.LittleEndian.Uint64(metadata)), time.Unix(0, int64(binary.LittleEndian.Uint64(metadata[8:])))
+}
+
+// encodedFS stores files encoded in the destination file system, each under the path of its source file with the suffix of the encoding.
+// the size and modification time of the source file are stored in the header of the encoded file, so files are listed by the names and
+// sizes of their source files (their modification times are set as usual), and compared the same way as any other file. files the encoding
+// skips are stored as they are
+type encodedFS struct {
+	base     destinationFS
+	encoding fileEncoding
+	suffix   string
+}
+
+// readMetadata reads the size of the source file from the header of the encoded file
+func (fsys *encodedFS) readMetadata(path string) (int64, bool, error) {
+	file, err := fsys.base.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+
+	size, encoded, err := fsys.encoding.readMetadata(file)
+	if err != nil {
+		return 0, false, &fs.PathError{Op: "read", Path: path, Err: err}
+	}
+	return size, encoded, nil
+}
+
+// resolve returns the info of the file at the path, as it is listed, and whether it is stored encoded
+func (fsys *encodedFS) resolve(path string, stat func(string) (os.FileInfo, error)) (os.FileInfo, bool, error) {
+	info, err := fsys.base.Lstat(path + fsys.suffix)
+	if err == nil && info.Mode().IsRegular() {
+		size, encoded, err := fsys.readMetadata(path + fsys.suffix)
+		if err != nil {
+			return nil, false, err
+		}
+		if encoded {
+			return encodedFileInfo{FileInfo: info, name: filepath.Base(path), size: size}, true, nil
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, err
+	}
+
+	info, err = stat(path)
+	return info, false, err
+}
+
+// getStoredPath returns the path the file at the path is stored at (its own path, unless stored encoded)
+func (fsys *encodedFS) getStoredPath(path string) (string, error) {
+	_, encoded, err := fsys.resolve(path, fsys.base.Lstat)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	if encoded {
+		return path + fsys.suffix, nil
+	}
+	return path, nil
+}
+
+// removeOtherForm removes the file at the path in the form it is not going to be stored in (encoded or not), which is left over by a file
+// whose form changed (e.g. it grew beyond the minimal size of compression)
+func (fsys *encodedFS) removeOtherForm(path string, encoded bool) error {
+	otherPath := path + fsys.suffix
+	if encoded {
+		otherPath = path
+
+		// a directory is not a form of the file
+		if info, err := fsys.base.Lstat(otherPath); err != nil || info.IsDir() {
+			return nil
+		}
+	} else if _, isEncoded, err := fsys.readMetadata(otherPath); err != nil || !isEncoded {
+		// a file which is not an encoded file of the file system is not a form of the file
+		return nil
+	}
+
+	if err := fsys.base.Remove(otherPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (fsys *encodedFS) Connect() error {
+	if err := fsys.encoding.ready(); err != nil {
+		return err
+	}
+
+	return fsys.base.Connect()
+}
+
+func (fsys *encodedFS) Stat(path string) (os.FileInfo, error) {
+	info, _, err := fsys.resolve(path, fsys.base.Stat)
+	return info, err
+}
+
+func (fsys *encodedFS) Lstat(path string) (os.FileInfo, error) {
+	info, _, err := fsys.resolve(path, fsys.base.Lstat)
+	return info, err
+}
+
+func (fsys *encodedFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	entries, err := fsys.base.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// encoded files are listed by the names of their source files, replacing any file left over in the other form
+	indexes := make(map[string]int)
+	listed := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		encoded := false
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), fsys.suffix) {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			var size int64
+			if size, encoded, err = fsys.readMetadata(filepath.Join(path, entry.Name())); err != nil {
+				return nil, err
+			}
+			if encoded {
+				entry = fs.FileInfoToDirEntry(encodedFileInfo{FileInfo: info, name: strings.TrimSuffix(entry.Name(), fsys.suffix), size: size})
+			}
+		}
+
+		if index, exists := indexes[entry.Name()]; exists {
+			if encoded {
+				listed[index] = entry
+			}
+			continue
+		}
+		indexes[entry.Name()] = len(listed)
+		listed = append(listed, entry)
+	}
+
+	sort.Slice(listed, func(i, j int) bool { return listed[i].Name() < listed[j].Name() })
+	return listed, nil
+}
+
+func (fsys *encodedFS) Open(path string) (io.ReadCloser, error) {
+	_, encoded, err := fsys.resolve(path, fsys.base.Lstat)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if !encoded {
+		return fsys.base.Open(path)
+	}
+
+	// the contents are decoded while read
+	file, err := fsys.base.Open(path + fsys.suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := fsys.encoding.newReader(file)
+	if err != nil {
+		file.Close()
+		return nil, &fs.PathError{Op: "open", Path: path + fsys.suffix, Err: err}
+	}
+
+	return &decodingReader{ReadCloser: reader, file: file}, nil
+}
+
+func (fsys *encodedFS) Create(path string, srcFile os.FileInfo) (destinationFile, error) {
+	encoded := fsys.encoding.isEncoded(path, srcFile)
+	if err := fsys.removeOtherForm(path, encoded); err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return fsys.base.Create(path, srcFile)
+	}
+
+	file, err := fsys.base.Create(path+fsys.suffix, srcFile)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := &encodingFile{file: file}
+	if encoding.writer, err = fsys.encoding.newWriter(file, srcFile); err != nil {
+		encoding.Abort()
+		return nil, &fs.PathError{Op: "create", Path: path + fsys.suffix, Err: err}
+	}
+
+	return encoding, nil
+}
+
+func (fsys *encodedFS) Remove(path string) error {
+	storedPath, err := fsys.getStoredPath(path)
+	if err != nil {
+		return err
+	}
+
+	return fsys.base.Remove(storedPath)
+}
+
+func (fsys *encodedFS) RemoveAll(path string) error {
+	storedPath, err := fsys.getStoredPath(path)
+	if err != nil {
+		return err
+	}
+
+	return fsys.base.RemoveAll(storedPath)
+}
+
+func (fsys *encodedFS) MkdirAll(path string, perm os.FileMode) error {
+	return fsys.base.MkdirAll(path, perm)
+}
+
+// SyncDir flushes the entries of the directory, whose path is not encoded
+func (fsys *encodedFS) SyncDir(path string) error {
+	return syncDir(fsys.base, path)
+}
+
+func (fsys *encodedFS) Chmod(path string, mode os.FileMode) error {
+	storedPath, err := fsys.getStoredPath(path)
+	if err != nil {
+		return err
+	}
+
+	return fsys.base.Chmod(storedPath, mode)
+}
+
+func (fsys *encodedFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	storedPath, err := fsys.getStoredPath(path)
+	if err != nil {
+		return err
+	}
+
+	return fsys.base.Chtimes(storedPath, atime, mtime)
+}
+
+func (fsys *encodedFS) Rename(oldPath string, newPath string) error {
+	_, encoded, err := fsys.resolve(oldPath, fsys.base.Lstat)
+	if err != nil {
+		return err
+	}
+
+	if encoded {
+		err = fsys.base.Rename(oldPath+fsys.suffix, newPath+fsys.suffix)
+	} else {
+		err = fsys.base.Rename(oldPath, newPath)
+	}
+	if err != nil {
+		return err
+	}
+	return fsys.removeOtherForm(newPath, encoded)
+}
+
+func (fsys *encodedFS) ModTimeGranularity() time.Duration {
+	return fsys.base.ModTimeGranularity()
+}
+
+func (fsys *encodedFS) Close() error {
+	return fsys.base.Close()
+}
+
+// encodedFileInfo is the info of an encoded file, as listed by the name and size of its source file
+type encodedFileInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (info encodedFileInfo) Name() string {
+	return info.name
+}
+
+func (info encodedFileInfo) Size() int64 {
+	return info.size
+}
+
+// encodingFile encodes the contents written into the file
+type encodingFile struct {
+	file   destinationFile
+	writer io.WriteCloser
+	// the encoded stream was completed
+	finished bool
+	written  int64
+}
+
+func (file *encodingFile) Write(data []byte) (int, error) {
+	if file.finished {
+		return 0, os.ErrClosed
+	}
+
+	n, err := file.writer.Write(data)
+	file.written += int64(n)
+	return n, err
+}
+
+// Seek reports the current offset only, since the file is encoded sequentially
+func (file *encodingFile) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekCurrent {
+		return 0, errors.ErrUnsupported
+	}
+
+	return file.written, nil
+}
+
+// finish completes the encoded stream, after which nothing is written
+func (file *encodingFile) finish() error {
+	if file.finished {
+		return nil
+	}
+	file.finished = true
+
+	return file.writer.Close()
+}
+
+// Sync completes the encoded stream before it is flushed, since the contents are complete once synced
+func (file *encodingFile) Sync() error {
+	if err := file.finish(); err != nil {
+		return err
+	}
+
+	return file.file.Sync()
+}
+
+func (file *encodingFile) Close() error {
+	err := file.finish()
+	if closeErr := file.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (file *encodingFile) Abort() error {
+	file.finished = true
+
+	if abortable, ok := file.file.(abortableFile); ok {
+		return abortable.Abort()
+	}
+	return file.file.Close()
+}
+
+// decodingReader decodes the contents of a file while read
+type decodingReader struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (reader *decodingReader) Close() error {
+	reader.ReadCloser.Close()
+	return reader.file.Close()
+}
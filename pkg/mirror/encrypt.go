@@ -0,0 +1,367 @@
+package mirror
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// suffix of encrypted files
+const encryptionSuffix = ".enc"
+
+const (
+	// magic of the header of an encrypted file, which is followed by the id of its key, its salt, the metadata of the source file and
+	// the authentication tag of the header
+	encryptionMagic = "DMIRENC1"
+	// size of the id of a key, which tells the key a file was encrypted with
+	encryptionKeyIDSize = 8
+	// size of the random salt of a file, which its own key is derived with
+	encryptionSaltSize = 32
+	// size of the header of an encrypted file
+	encryptionHeaderSize = len(encryptionMagic) + encryptionKeyIDSize + encryptionSaltSize + encodedMetadataSize + encryptionTagSize
+	// size of the authentication tag of AES-GCM
+	encryptionTagSize = 16
+	// size of the chunks the contents are encrypted in, every one authenticated on its own
+	encryptionChunkSize = 64 * 1024
+	// minimal size of a key file
+	encryptionMinKeyFileSize = 32
+)
+
+// salt of keys derived from passphrases, which must never change since it is not stored along with the files
+const encryptionPassphraseSalt = "DirectoryMirror encryption passphrase"
+
+var (
+	errEncryptionUnknownKey     = errors.New("encrypted with an unknown key (is the encryption key wrong?)")
+	errEncryptionAuthentication = errors.New("authentication failed, the file is damaged or was tampered with")
+	errEncryptionNotEncrypted   = errors.New("not an encrypted file")
+)
+
+// encryptionKey is a key files are encrypted with
+type encryptionKey struct {
+	id  []byte
+	key []byte
+}
+
+// isEncrypted reports whether the files of the destination are encrypted
+func isEncrypted(general GeneralConfigurations) bool {
+	return len(general.EncryptionKeyFile) > 0 || len(general.EncryptionPassphrase) > 0
+}
+
+// getEncryptionKeys returns the keys of the configuration, starting with the one new files are encrypted with
+func getEncryptionKeys(general GeneralConfigurations) ([]encryptionKey, error) {
+	var keys []encryptionKey
+
+	addKeyFile := func(keyFile string) error {
+		key, err := readEncryptionKeyFile(keyFile)
+		if err == nil {
+			keys = append(keys, key)
+		}
+		return err
+	}
+	addPassphrase := func(passphrase string) error {
+		key, err := deriveEncryptionKey(passphrase)
+		if err == nil {
+			keys = append(keys, key)
+		}
+		return err
+	}
+
+	if len(general.EncryptionKeyFile) > 0 {
+		if err := addKeyFile(general.EncryptionKeyFile); err != nil {
+			return nil, err
+		}
+	} else if err := addPassphrase(general.EncryptionPassphrase); err != nil {
+		return nil, err
+	}
+
+	// files encrypted with a previous key are read and compared as usual, and encrypted with the current key once they change
+	for _, keyFile := range general.EncryptionPreviousKeyFiles {
+		if err := addKeyFile(keyFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, passphrase := range general.EncryptionPreviousPassphrases {
+		if err := addPassphrase(passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// readEncryptionKeyFile returns the key of a key file, derived from all of its contents
+func readEncryptionKeyFile(keyFile string) (encryptionKey, error) {
+	contents, err := os.ReadFile(keyFile)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	if len(contents) < encryptionMinKeyFileSize {
+		return encryptionKey{}, fmt.Errorf("key file '%s' is too short, at least %d bytes are required", keyFile, encryptionMinKeyFileSize)
+	}
+
+	key := sha256.Sum256(contents)
+	return newEncryptionKey(key[:]), nil
+}
+
+// deriveEncryptionKey returns the key of a passphrase
+func deriveEncryptionKey(passphrase string) (encryptionKey, error) {
+	if len(passphrase) < 1 {
+		return encryptionKey{}, errors.New("passphrase is empty")
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), []byte(encryptionPassphraseSalt), 1<<15, 8, 1, 32)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	return newEncryptionKey(key), nil
+}
+
+func newEncryptionKey(key []byte) encryptionKey {
+	return encryptionKey{id: getHMAC(key, "key id")[:encryptionKeyIDSize], key: key}
+}
+
+// getEncryptionNonce returns the nonce of a chunk, which tells the last chunk apart so a file truncated between chunks is detected
+func getEncryptionNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// the nonce of the header, which no chunk uses
+var encryptionHeaderNonce = bytes.Repeat([]byte{0xff}, 12)
+
+// encryption encrypts the files stored by an encodedFS with AES-GCM, every file with its own key derived from the configured key and its
+// salt. the header of a file is authenticated, so its metadata is trusted without decrypting the file
+type encryption struct {
+	// the first key encrypts new files, all of them decrypt files
+	keys []encryptionKey
+	// the keys could not be read
+	err error
+}
+
+// newEncryptedFS returns the file system storing files encrypted in the base file system
+func newEncryptedFS(configs Config, base destinationFS) *encodedFS {
+	encoding := &encryption{}
+	encoding.keys, encoding.err = getEncryptionKeys(configs.General)
+
+	return &encodedFS{base: base, encoding: encoding, suffix: encryptionSuffix}
+}
+
+func (encoding *encryption) ready() error {
+	if encoding.err != nil {
+		return fmt.Errorf("invalid encryption key; %w", encoding.err)
+	}
+	return nil
+}
+
+// isEncoded reports every file is encrypted, so the names of encrypted files are never ambiguous
+func (encoding *encryption) isEncoded(path string, srcFile os.FileInfo) bool {
+	return srcFile != nil && srcFile.Mode().IsRegular()
+}
+
+// readHeader reads the header of an encrypted file, returning the cipher of the file and the metadata of its source file
+func (encoding *encryption) readHeader(reader io.Reader) (cipher.AEAD, []byte, bool, error) {
+	header := make([]byte, encryptionHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, nil, false, nil
+	}
+	keyID := header[len(encryptionMagic) : len(encryptionMagic)+encryptionKeyIDSize]
+	salt := header[len(encryptionMagic)+encryptionKeyIDSize : len(encryptionMagic)+encryptionKeyIDSize+encryptionSaltSize]
+	metadata := header[len(header)-encryptionTagSize-encodedMetadataSize : len(header)-encryptionTagSize]
+
+	// a file encrypted with another key is never treated as a file which is not encrypted, so it is never replaced by mistake
+	for _, key := range encoding.keys {
+		if !bytes.Equal(key.id, keyID) {
+			continue
+		}
+
+		aead, err := newFileCipher(key, salt)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if _, err := aead.Open(nil, encryptionHeaderNonce, header[len(header)-encryptionTagSize:], header[:len(header)-encryptionTagSize]); err != nil {
+			return nil, nil, false, errEncryptionAuthentication
+		}
+		return aead, metadata, true, nil
+	}
+
+	return nil, nil, false, errEncryptionUnknownKey
+}
+
+// newFileCipher returns the cipher of a file, by its salt
+func newFileCipher(key encryptionKey, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(getHMAC(key.key, string(salt)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (encoding *encryption) readMetadata(reader io.Reader) (int64, bool, error) {
+	_, metadata, encrypted, err := encoding.readHeader(reader)
+	if !encrypted {
+		return 0, false, err
+	}
+
+	size, _ := decodeMetadata(metadata)
+	return size, true, nil
+}
+
+func (encoding *encryption) newReader(reader io.Reader) (io.ReadCloser, error) {
+	aead, _, encrypted, err := encoding.readHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return nil, errEncryptionNotEncrypted
+	}
+
+	return newDecryptingReader(reader, aead), nil
+}
+
+func (encoding *encryption) newWriter(writer io.Writer, srcFile os.FileInfo) (io.WriteCloser, error) {
+	key := encoding.keys[0]
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newFileCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, encryptionHeaderSize)
+	header = append(append(append(append(header, encryptionMagic...), key.id...), salt...), encodeMetadata(srcFile)...)
+	header = aead.Seal(header, encryptionHeaderNonce, nil, header)
+	if _, err := writer.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		writer: writer,
+		aead:   aead,
+		chunk:  make([]byte, 0, encryptionChunkSize),
+		sealed: make([]byte, 0, encryptionChunkSize+encryptionTagSize),
+	}, nil
+}
+
+// encryptingWriter encrypts the contents written into it in chunks. a full chunk is encrypted once more contents follow it, since the
+// last chunk (which may be empty) is encrypted as such
+type encryptingWriter struct {
+	writer  io.Writer
+	aead    cipher.AEAD
+	chunk   []byte
+	sealed  []byte
+	counter uint64
+}
+
+func (writer *encryptingWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		if len(writer.chunk) == encryptionChunkSize {
+			if err := writer.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := min(len(data), encryptionChunkSize-len(writer.chunk))
+		writer.chunk = append(writer.chunk, data[:n]...)
+		data = data[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// seal encrypts the chunk and writes it
+func (writer *encryptingWriter) seal(last bool) error {
+	writer.sealed = writer.aead.Seal(writer.sealed[:0], getEncryptionNonce(writer.counter, last), writer.chunk, nil)
+	writer.chunk = writer.chunk[:0]
+	writer.counter++
+
+	_, err := writer.writer.Write(writer.sealed)
+	return err
+}
+
+// Close encrypts the last chunk
+func (writer *encryptingWriter) Close() error {
+	return writer.seal(true)
+}
+
+// decryptingReader decrypts the contents of an encrypted file, making sure it is complete
+type decryptingReader struct {
+	reader *bufio.Reader
+	aead   cipher.AEAD
+	chunk  []byte
+	// decrypted contents which were not read yet
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+// newDecryptingReader returns the reader of the contents following the header of an encrypted file, given its cipher
+func newDecryptingReader(reader io.Reader, aead cipher.AEAD) *decryptingReader {
+	return &decryptingReader{
+		reader: bufio.NewReaderSize(reader, encryptionChunkSize+encryptionTagSize+1),
+		aead:   aead,
+		chunk:  make([]byte, encryptionChunkSize+encryptionTagSize),
+	}
+}
+
+func (reader *decryptingReader) Read(data []byte) (int, error) {
+	for len(reader.plain) < 1 {
+		if reader.done {
+			return 0, io.EOF
+		}
+		if err := reader.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(data, reader.plain)
+	reader.plain = reader.plain[n:]
+	return n, nil
+}
+
+// open reads the next chunk and decrypts it
+func (reader *decryptingReader) open() error {
+	n, err := io.ReadFull(reader.reader, reader.chunk)
+	last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !last {
+		return err
+	}
+	if !last {
+		// a full chunk is the last one if nothing follows it
+		if _, err := reader.reader.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := reader.aead.Open(reader.chunk[:0], getEncryptionNonce(reader.counter, last), reader.chunk[:n], nil)
+	if err != nil {
+		return errEncryptionAuthentication
+	}
+	reader.plain = plain
+	reader.counter++
+	reader.done = last
+	return nil
+}
+
+func (reader *decryptingReader) Close() error {
+	return nil
+}
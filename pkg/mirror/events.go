@@ -0,0 +1,230 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	watchModePoll   = "poll"
+	watchModeEvents = "events"
+)
+
+func runEventLoop(ctx context.Context, configs Config) error {
+	// create a file system watcher, to get notified on source directory changes
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		configs.General.totals.recordFailure(err)
+		return err
+	}
+	// make sure to release the watcher before end of context
+	defer watcher.Close()
+
+	// subscribe to the whole source tree before the initial scan, so changes during the scan are not missed
+	addWatchRecursive(configs.General.logger, watcher, configs.General.SourceDirectory, newPathScope(configs.General))
+
+	// count failed operations of all iterations
+	var failed int64
+
+	// create a container for paths with pending events, by the time of their last event
+	pendingPaths := make(map[string]time.Time)
+
+	// run an initial full scan, to mirror anything that changed while we were not watching
+	stats := syncDirectories(ctx, configs)
+	failed += stats.filesFailed
+	addDeferredPaths(pendingPaths, stats)
+
+	// get the time a burst of events should settle before the path is mirrored
+	debounceInterval := time.Duration(configs.General.EventDebounceMS) * time.Millisecond
+
+	// check pending paths periodically, so bursts of events are debounced into a single operation
+	debounceTicker := time.NewTicker(debounceInterval)
+	defer debounceTicker.Stop()
+
+	// periodically run a full scan, to catch any missed events
+	rescanTicker := time.NewTicker(time.Duration(configs.General.FullRescanIntervalMS) * time.Millisecond)
+	defer rescanTicker.Stop()
+
+	// run loop until termination is requested, to process events continuously
+	for {
+		// a paused job idles until resumed (events are not processed meanwhile), then scans right away since events could be missed
+		if waitWhilePaused(ctx, configs) {
+			pendingPaths = make(map[string]time.Time)
+
+			addWatchRecursive(configs.General.logger, watcher, configs.General.SourceDirectory, newPathScope(configs.General))
+			stats := syncDirectories(ctx, configs)
+			failed += stats.filesFailed
+			addDeferredPaths(pendingPaths, stats)
+		}
+		if ctx.Err() != nil {
+			return getJobError(ctx, failed)
+		}
+
+		_, pauseChanged := configs.General.control.state()
+
+		select {
+		case <-ctx.Done():
+			// termination requested
+			return getJobError(ctx, failed)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				// watcher has been closed
+				return getJobError(ctx, failed)
+			}
+
+			relativePath := getRelativePath(configs.General.SourceDirectory, filepath.Clean(event.Name))
+
+			// new directories must be watched too, so their contents changes are notified
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					addWatchRecursive(configs.General.logger, watcher, event.Name, newPathScope(configs.General).under(relativePath))
+				}
+			}
+
+			// record (or postpone) the path, it will be mirrored once its events settle
+			pendingPaths[relativePath] = time.Now()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				// watcher has been closed
+				return getJobError(ctx, failed)
+			}
+
+			// events might have been lost (for example, on queue overflow), so run a full scan to be safe
+			configs.General.logger.Warn("Watch error, running a full rescan", "error", err)
+
+			addWatchRecursive(configs.General.logger, watcher, configs.General.SourceDirectory, newPathScope(configs.General))
+			stats := syncDirectories(ctx, configs)
+			failed += stats.filesFailed
+			addDeferredPaths(pendingPaths, stats)
+		case <-debounceTicker.C:
+			// collect paths which had no events for at least the debounce interval
+			var settledPaths []string
+			for relativePath, lastEvent := range pendingPaths {
+				if time.Since(lastEvent) >= debounceInterval {
+					settledPaths = append(settledPaths, relativePath)
+					delete(pendingPaths, relativePath)
+				}
+			}
+
+			// mirror the settled paths
+			if len(settledPaths) > 0 {
+				stats := syncPaths(ctx, configs, settledPaths)
+				failed += stats.filesFailed
+				addDeferredPaths(pendingPaths, stats)
+			}
+		case update := <-configs.General.updates:
+			// the config file changed, so apply the new settings (the watched source is the same, otherwise the job is restarted)
+			configs = applyConfigUpdate(configs, update)
+
+			debounceInterval = time.Duration(configs.General.EventDebounceMS) * time.Millisecond
+			debounceTicker.Reset(debounceInterval)
+			rescanTicker.Reset(time.Duration(configs.General.FullRescanIntervalMS) * time.Millisecond)
+		case <-pauseChanged:
+			// paused, which is handled at the start of the loop
+		case <-rescanTicker.C:
+			// make sure no directory was left unwatched, then mirror any changes of the whole directory
+			addWatchRecursive(configs.General.logger, watcher, configs.General.SourceDirectory, newPathScope(configs.General))
+			stats := syncDirectories(ctx, configs)
+			failed += stats.filesFailed
+			addDeferredPaths(pendingPaths, stats)
+		}
+	}
+}
+
+// addDeferredPaths marks the paths left by an iteration for later (e.g. files still being written) as pending, so they are mirrored once they settle
+func addDeferredPaths(pendingPaths map[string]time.Time, stats *iterationStats) {
+	for _, relativePath := range stats.deferredPaths {
+		pendingPaths[relativePath] = time.Now()
+	}
+}
+
+func addWatchRecursive(logger *slog.Logger, watcher *fsnotify.Watcher, rootDir string, scope pathScope) {
+	// walk the directory tree and subscribe to every directory (events are not recursive)
+	filepath.WalkDir(rootDir, func(path string, entry fs.DirEntry, err error) error {
+		// ignore entries which could not be read (they could be removed in the meantime)
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+
+		// a directory whose contents are out of scope has no events of interest
+		if !scope.isWalked(getRelativePath(rootDir, path)) {
+			return filepath.SkipDir
+		}
+
+		if err := watcher.Add(path); err != nil {
+			logger.Warn("Watch error", "path", path, "error", err)
+		}
+
+		return nil
+	})
+}
+
+func syncPaths(ctx context.Context, configs Config, relativePaths []string) *iterationStats {
+	// ignore the root directories themselves
+	var targetPaths []string
+	for _, relativePath := range relativePaths {
+		if len(normalizeRelativePath(relativePath)) > 0 {
+			targetPaths = append(targetPaths, relativePath)
+		}
+	}
+
+	// a path whose name collides with another entry of its directory once stored is left alone
+	targetPaths = excludeCollidingPaths(configs, targetPaths)
+
+	// mirror differences of the targeted files, getting their current state in the source directory and in every destination directory
+	return syncFiles(ctx, configs, func(destConfigsList []Config) []*scannedTree {
+		return scanTrees(configs, destConfigsList, func() map[string]os.FileInfo {
+			srcFiles := make(map[string]os.FileInfo)
+			for _, relativePath := range targetPaths {
+				// get the current state of the path (a missing source path means it should be removed)
+				addPathFiles(configs.General.logger, configs.General.source, configs.General.SourceDirectory, relativePath, configs.General.SymlinkMode == symlinkModeFollow, newPathScope(configs.General), srcFiles)
+			}
+			return srcFiles
+		}, func(destConfigs Config) map[string]os.FileInfo {
+			destFiles := make(map[string]os.FileInfo)
+			internalPaths := getInternalPaths(destConfigs)
+			for _, relativePath := range targetPaths {
+				addDestPathFiles(configs.General.logger, destConfigs.General.destination, destConfigs.General.DestinationDirectory, relativePath, newPathScope(configs.General),
+					internalPaths, destFiles)
+			}
+			return destFiles
+		})
+	}, false)
+}
+
+func addPathFiles(logger *slog.Logger, fsys readableFS, rootDir string, relativePath string, followSymlinks bool, scope pathScope, files map[string]os.FileInfo) {
+	// get path info, if the path does not exist there is nothing to add
+	info, err := fsys.Lstat(filepath.Join(rootDir, relativePath))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("Skip", "path", filepath.Join(rootDir, relativePath), "reason", "unreadable", "error", err)
+			files[relativePath] = unreadableFile{}
+		}
+		return
+	}
+
+	// when following symlinks, the target info is used instead of the symlink info
+	if followSymlinks && isSymlink(info) {
+		if info, err = fsys.Stat(filepath.Join(rootDir, relativePath)); err != nil {
+			return
+		}
+	}
+
+	// add path to container
+	files[relativePath] = info
+
+	// in case of a directory, its whole subtree should be mirrored too (its contents could be created before it was watched)
+	// (if its contents could not be read, the directory is marked by the unreadable root of its subtree)
+	if info.IsDir() && scope.isWalked(relativePath) {
+		for subPath, subInfo := range getDirFiles(logger, fsys, filepath.Join(rootDir, relativePath), followSymlinks, scope.under(relativePath)) {
+			files[filepath.Join(relativePath, subPath)] = subInfo
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	eventOutputNDJSON = "ndjson"
+)
+
+const (
+	eventActionIterationStart = "iterationStart"
+	eventActionIterationEnd   = "iterationEnd"
+	eventActionWrite          = "write"
+	eventActionMkdir          = "mkdir"
+	eventActionChmod          = "chmod"
+	eventActionLink           = "link"
+	eventActionMove           = "move"
+	eventActionRemove         = "remove"
+	eventActionSkip           = "skip"
+	eventActionDrift          = "drift"
+	eventActionError          = "error"
+)
+
+// events of all jobs are written one at a time, so their lines never interleave (even when jobs share the output)
+var eventsMutex sync.Mutex
+
+// streamEvent is a single line of the event stream
+type streamEvent struct {
+	Time        time.Time        `json:"time"`
+	Job         string           `json:"job"`
+	Action      string           `json:"action"`
+	Destination string           `json:"destination,omitempty"`
+	Path        string           `json:"path,omitempty"`
+	Bytes       int64            `json:"bytes,omitempty"`
+	DurationMS  float64          `json:"durationMs,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	DryRun      bool             `json:"dryRun,omitempty"`
+	Counts      map[string]int64 `json:"counts,omitempty"`
+}
+
+// newEventStream returns the output of the events of a job (the event file, or the console if not set), or nil if events are not requested
+func newEventStream(configs Config) (io.Writer, error) {
+	if configs.General.EventOutput != eventOutputNDJSON {
+		return nil, nil
+	}
+	if len(configs.General.EventFile) < 1 {
+		return os.Stdout, nil
+	}
+
+	// jobs writing into the same file share it, as they do with log files (event files are not rotated)
+	eventFile, err := getLogFile(configs.General.EventFile, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening event file; %w", err)
+	}
+	return eventFile, nil
+}
+
+// emitEvent writes the event of an operation on a destination path, if events are requested
+func emitEvent(configs Config, action string, path string, bytes int64, duration time.Duration, err error) {
+	if configs.General.events == nil {
+		return
+	}
+
+	event := streamEvent{Action: action, Destination: configs.General.DestinationDirectory, Path: getRelativePath(configs.General.DestinationDirectory, path), Bytes: bytes}
+	event.DurationMS = float64(duration.Microseconds()) / 1000
+	if err != nil {
+		event.Error = err.Error()
+	}
+	writeEvent(configs, event)
+}
+
+// emitSkipEvent writes the event of a source file which is not mirrored (by its path relative to the source directory), if events are requested
+func emitSkipEvent(configs Config, relativePath string, bytes int64, reason string) {
+	if configs.General.events == nil {
+		return
+	}
+
+	writeEvent(configs, streamEvent{Action: eventActionSkip, Destination: configs.General.DestinationDirectory, Path: relativePath, Bytes: bytes, Reason: reason})
+}
+
+// emitDriftEvent writes the event of a destination file which drifted from what the mirror wrote, if events are requested
+func emitDriftEvent(configs Config, path string, reason string) {
+	if configs.General.events == nil {
+		return
+	}
+
+	writeEvent(configs, streamEvent{Action: eventActionDrift, Destination: configs.General.DestinationDirectory, Path: getRelativePath(configs.General.DestinationDirectory, path),
+		Reason: reason})
+}
+
+// emitIterationEvent writes the event of a started or ended iteration (along with its counts once ended), if events are requested
+func emitIterationEvent(configs Config, action string, stats *iterationStats, duration time.Duration) {
+	if configs.General.events == nil {
+		return
+	}
+
+	event := streamEvent{Action: action, DryRun: configs.General.DryRun}
+	event.DurationMS = float64(duration.Microseconds()) / 1000
+	if stats != nil {
+		event.Counts = stats.getCounts()
+	}
+	writeEvent(configs, event)
+}
+
+func writeEvent(configs Config, event streamEvent) {
+	event.Time = time.Now()
+	event.Job = getJobName(configs)
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		configs.General.logger.Warn("Event not written", "action", event.Action, "error", err)
+		return
+	}
+
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	// a lost event is not worth failing the operation for, the operation is logged anyway
+	if _, err := configs.General.events.Write(append(line, '\n')); err != nil {
+		configs.General.logger.Warn("Event not written", "action", event.Action, "error", err)
+	}
+}
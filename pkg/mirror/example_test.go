@@ -0,0 +1,200 @@
+package mirror_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"go/mirror_backup/pkg/mirror"
+)
+
+// newExampleDir creates a temporary directory holding the files, whose contents are their names
+func newExampleDir(files ...string) string {
+	dir, err := os.MkdirTemp("", "mirror-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Example() {
+	source := newExampleDir("a.txt", "docs/b.txt")
+	defer os.RemoveAll(source)
+	backup := newExampleDir()
+	defer os.RemoveAll(backup)
+
+	config := mirror.DefaultConfig()
+	config.General.SourceDirectory = source
+	config.General.DestinationDirectory = filepath.Join(backup, "data")
+	// only failures are logged (to the console)
+	config.General.LogLevel = "error"
+
+	m, err := mirror.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	summary, err := m.SyncOnce(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("copied %d files (%d bytes)\n", summary.FilesCopied, summary.BytesCopied)
+
+	contents, err := os.ReadFile(filepath.Join(backup, "data", "docs", "b.txt"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("docs/b.txt holds %q\n", contents)
+	// Output:
+	// copied 2 files (15 bytes)
+	// docs/b.txt holds "docs/b.txt"
+}
+
+func ExampleMirror_Run() {
+	source := newExampleDir("a.txt")
+	defer os.RemoveAll(source)
+	backup := newExampleDir()
+	defer os.RemoveAll(backup)
+
+	config := mirror.DefaultConfig()
+	config.General.SourceDirectory = source
+	config.General.DestinationDirectory = backup
+	config.General.LogLevel = "error"
+	// mirror once rather than until the context is cancelled
+	config.General.RunOnce = true
+
+	m, err := mirror.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := m.Run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	stats := m.Stats()
+	fmt.Printf("%d iteration copied %d files\n", stats.Iterations, stats.FilesCopied)
+	// Output:
+	// 1 iteration copied 1 files
+}
+
+func ExampleMirror_SyncOnce() {
+	source := newExampleDir("a.txt")
+	backup := newExampleDir()
+	defer os.RemoveAll(backup)
+
+	config := mirror.DefaultConfig()
+	config.General.SourceDirectory = source
+	config.General.DestinationDirectory = backup
+	config.General.LogLevel = "error"
+
+	m, err := mirror.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the source goes missing (e.g. an unmounted drive), so the iteration is skipped rather than emptying the destination
+	os.RemoveAll(source)
+
+	_, err = m.SyncOnce(context.Background())
+	if errors.Is(err, mirror.ErrSkipped) {
+		fmt.Println("skipped, trying again later")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// skipped, trying again later
+}
+
+func ExamplePrepare() {
+	photos := newExampleDir("a.jpg", "b.jpg")
+	defer os.RemoveAll(photos)
+	documents := newExampleDir("c.txt")
+	defer os.RemoveAll(documents)
+	backup := newExampleDir()
+	defer os.RemoveAll(backup)
+
+	// every source is mirrored into its own subdirectory of the destination
+	config := mirror.DefaultConfig()
+	config.General.Sources = []mirror.SourceConfigurations{
+		{Directory: photos, DestinationSubpath: "photos"},
+		{Directory: documents, DestinationSubpath: "documents"},
+	}
+	config.General.DestinationDirectory = backup
+	config.General.LogLevel = "error"
+
+	// a configuration is returned for every source, which is mirrored by a job of its own
+	configs, err := mirror.Prepare(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, jobConfig := range configs {
+		m, err := mirror.New(jobConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		summary, err := m.SyncOnce(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("copied %d files\n", summary.FilesCopied)
+	}
+
+	entries, err := os.ReadDir(backup)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, entry := range entries {
+		fmt.Println(entry.Name())
+	}
+	// Output:
+	// copied 2 files
+	// copied 1 files
+	// documents
+	// photos
+}
+
+func ExampleLoadConfigFile() {
+	source := newExampleDir("a.txt")
+	defer os.RemoveAll(source)
+	backup := newExampleDir()
+	defer os.RemoveAll(backup)
+
+	configFile := filepath.Join(backup, "config.yml")
+	contents := fmt.Sprintf("general:\n  sourceDirectory: %s\n  destinationDirectory: %s\n  logLevel: error\n",
+		filepath.ToSlash(source), filepath.ToSlash(filepath.Join(backup, "data")))
+	if err := os.WriteFile(configFile, []byte(contents), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	// a config file holds a configuration for every source, which are validated as they are read
+	configs, err := mirror.LoadConfigFile(configFile, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	m, err := mirror.New(configs[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	summary, err := m.SyncOnce(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("copied %d files\n", summary.FilesCopied)
+	// Output:
+	// copied 1 files
+}
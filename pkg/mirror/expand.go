@@ -0,0 +1,102 @@
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// maximal depth of variables whose values refer to other variables, beyond which the variables are considered recursive
+const maxVariableDepth = 10
+
+// windows style variable reference (e.g. %USERPROFILE%), which is translated into a ${NAME} reference
+var windowsVariablePattern = regexp.MustCompile(`%([A-Za-z_][A-Za-z0-9_()]*)%`)
+
+// expandConfigPaths expands environment variables and the home directory in every path valued option, before the paths are validated.
+// a new path valued option must be added here too
+func expandConfigPaths(general *GeneralConfigurations) error {
+	type pathOption struct {
+		name  string
+		value *string
+	}
+	options := []pathOption{
+		{"sourceDirectory", &general.SourceDirectory},
+		{"destinationDirectory", &general.DestinationDirectory},
+		{"sftpKeyFile", &general.SftpKeyFile},
+		{"sftpKnownHostsFile", &general.SftpKnownHostsFile},
+		{"encryptionKeyFile", &general.EncryptionKeyFile},
+		{"stateFile", &general.StateFile},
+		{"healthFile", &general.HealthFile},
+		{"backupDirectory", &general.BackupDirectory},
+		{"quarantineDirectory", &general.QuarantineDirectory},
+		{"logFile", &general.LogFile},
+		{"eventFile", &general.EventFile},
+	}
+	for i := range general.DestinationDirectories {
+		options = append(options, pathOption{fmt.Sprintf("destinationDirectories[%d]", i), &general.DestinationDirectories[i]})
+	}
+	for i := range general.Sources {
+		options = append(options, pathOption{fmt.Sprintf("sources[%d].directory", i), &general.Sources[i].Directory})
+	}
+	for i := range general.EncryptionPreviousKeyFiles {
+		options = append(options, pathOption{fmt.Sprintf("encryptionPreviousKeyFiles[%d]", i), &general.EncryptionPreviousKeyFiles[i]})
+	}
+
+	for _, option := range options {
+		expanded, err := expandPath(*option.value, general.KeepUnsetVariables)
+		if err != nil {
+			return fmt.Errorf("Invalid %s '%s'; %w", option.name, *option.value, err)
+		}
+		*option.value = expanded
+	}
+	return nil
+}
+
+// expandPath replaces the environment variables referenced by the path ($NAME or ${NAME}, and %NAME% on windows) with their values, and a
+// leading ~ with the home directory. a variable whose value refers to other variables is expanded too. an unset variable is an error,
+// unless it should be kept as it is
+func expandPath(value string, keepUnset bool) (string, error) {
+	var err error
+	expanded := expandVariables(value, keepUnset, 0, &err)
+	if err != nil {
+		return "", err
+	}
+
+	// the home directory is expanded once the variables are, since their values can start with it too
+	if expanded == "~" || strings.HasPrefix(expanded, "~/") || strings.HasPrefix(expanded, "~"+string(filepath.Separator)) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		expanded = home + expanded[1:]
+	}
+
+	return expanded, nil
+}
+
+func expandVariables(value string, keepUnset bool, depth int, err *error) string {
+	if runtime.GOOS == "windows" {
+		value = windowsVariablePattern.ReplaceAllString(value, "$${$1}")
+	}
+
+	return os.Expand(value, func(name string) string {
+		variable, exists := os.LookupEnv(name)
+		switch {
+		case *err != nil:
+			return ""
+		case !exists && keepUnset:
+			return "${" + name + "}"
+		case !exists:
+			*err = fmt.Errorf("environment variable '%s' is not set (set keepUnsetVariables to keep it as it is)", name)
+			return ""
+		case depth >= maxVariableDepth:
+			*err = fmt.Errorf("environment variable '%s' refers to itself", name)
+			return ""
+		}
+
+		return expandVariables(variable, keepUnset, depth+1, err)
+	})
+}
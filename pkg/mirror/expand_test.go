@@ -0,0 +1,110 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	t.Setenv("MIRROR_TEST_ROOT", "/backups")
+	// a variable whose value refers to other variables, through a few levels
+	t.Setenv("MIRROR_TEST_DOCS", "${MIRROR_TEST_ROOT}/docs")
+	t.Setenv("MIRROR_TEST_NESTED", "$MIRROR_TEST_DOCS/nested")
+	t.Setenv("MIRROR_TEST_HOME", "~/home")
+
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "/plain/path", expected: "/plain/path"},
+		{value: "${MIRROR_TEST_ROOT}/docs", expected: "/backups/docs"},
+		{value: "$MIRROR_TEST_ROOT/docs", expected: "/backups/docs"},
+		{value: "${MIRROR_TEST_DOCS}/2024", expected: "/backups/docs/2024"},
+		{value: "${MIRROR_TEST_NESTED}", expected: "/backups/docs/nested"},
+		{value: "~", expected: home},
+		{value: "~/Documents", expected: home + "/Documents"},
+		// the home directory is expanded once the variables are
+		{value: "$MIRROR_TEST_HOME", expected: home + "/home"},
+		// only a leading ~ is the home directory
+		{value: "/data/~/docs", expected: "/data/~/docs"},
+		{value: "~user/docs", expected: "~user/docs"},
+	}
+
+	for _, test := range tests {
+		expanded, err := expandPath(test.value, false)
+		if err != nil {
+			t.Errorf("expandPath(%q) failed; %s", test.value, err)
+		} else if expanded != test.expected {
+			t.Errorf("expandPath(%q) = %q, expected %q", test.value, expanded, test.expected)
+		}
+	}
+}
+
+func TestExpandPathUnsetVariable(t *testing.T) {
+	// the variable is restored once the test ends, if it was set
+	t.Setenv("MIRROR_TEST_UNSET", "")
+	os.Unsetenv("MIRROR_TEST_UNSET")
+	t.Setenv("MIRROR_TEST_REFERS_UNSET", "/data/${MIRROR_TEST_UNSET}")
+
+	for _, value := range []string{"${MIRROR_TEST_UNSET}/docs", "$MIRROR_TEST_REFERS_UNSET"} {
+		if _, err := expandPath(value, false); err == nil || !strings.Contains(err.Error(), "'MIRROR_TEST_UNSET' is not set") {
+			t.Errorf("expandPath(%q) returned %v, expected an error naming the unset variable", value, err)
+		}
+	}
+
+	// unless unset variables are kept as they are
+	if expanded, err := expandPath("$MIRROR_TEST_REFERS_UNSET", true); err != nil || expanded != "/data/${MIRROR_TEST_UNSET}" {
+		t.Errorf("expandPath() = %q (%v), expected the unset variable to be kept", expanded, err)
+	}
+}
+
+func TestExpandPathRecursiveVariable(t *testing.T) {
+	t.Setenv("MIRROR_TEST_LOOP", "/data/${MIRROR_TEST_LOOP}")
+
+	if _, err := expandPath("${MIRROR_TEST_LOOP}", false); err == nil || !strings.Contains(err.Error(), "refers to itself") {
+		t.Errorf("expandPath() returned %v, expected a recursive variable to be rejected", err)
+	}
+}
+
+func TestWindowsVariableTranslation(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: `%USERPROFILE%\Documents`, expected: `${USERPROFILE}\Documents`},
+		{value: `%BACKUP_ROOT%\%JOB%`, expected: `${BACKUP_ROOT}\${JOB}`},
+		{value: `%ProgramFiles(x86)%\app`, expected: `${ProgramFiles(x86)}\app`},
+		// a lone percent sign is not a variable
+		{value: `C:\100%\data`, expected: `C:\100%\data`},
+	}
+
+	for _, test := range tests {
+		if translated := windowsVariablePattern.ReplaceAllString(test.value, "$${$1}"); translated != test.expected {
+			t.Errorf("translation of %q is %q, expected %q", test.value, translated, test.expected)
+		}
+	}
+}
+
+func TestPrepareExpandsPaths(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("MIRROR_TEST_ROOT", filepath.ToSlash(root))
+	t.Setenv("MIRROR_TEST_BACKUPS", "${MIRROR_TEST_ROOT}/backups")
+
+	config := prepareConfig(t, func(config *Config) {
+		config.General.SourceDirectory = "${MIRROR_TEST_ROOT}/docs"
+		config.General.DestinationDirectory = "$MIRROR_TEST_BACKUPS/docs"
+	})
+
+	if expected := normalizeDirectory(filepath.Join(root, "docs")); config.General.SourceDirectory != expected {
+		t.Errorf("source directory is '%s', expected '%s'", config.General.SourceDirectory, expected)
+	}
+	if expected := normalizeDirectory(filepath.Join(root, "backups", "docs")); config.General.DestinationDirectories[0] != expected {
+		t.Errorf("destination directory is '%s', expected '%s'", config.General.DestinationDirectories[0], expected)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"testing"
+)
+
+func TestExpandWindowsVariables(t *testing.T) {
+	t.Setenv("MIRROR_TEST_ROOT", `D:\backups`)
+	t.Setenv("MIRROR_TEST_DOCS", `%MIRROR_TEST_ROOT%\docs`)
+
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: `%MIRROR_TEST_ROOT%\docs`, expected: `D:\backups\docs`},
+		// a variable whose value refers to another one in the windows style
+		{value: `%MIRROR_TEST_DOCS%\2024`, expected: `D:\backups\docs\2024`},
+		// both styles in one path
+		{value: `${MIRROR_TEST_ROOT}\%MIRROR_TEST_DOCS%`, expected: `D:\backups\D:\backups\docs`},
+	}
+
+	for _, test := range tests {
+		expanded, err := expandPath(test.value, false)
+		if err != nil {
+			t.Errorf("expandPath(%q) failed; %s", test.value, err)
+		} else if expanded != test.expected {
+			t.Errorf("expandPath(%q) = %q, expected %q", test.value, expanded, test.expected)
+		}
+	}
+}
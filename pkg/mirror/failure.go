@@ -0,0 +1,159 @@
+package mirror
+
+import (
+	"fmt"
+	"time"
+)
+
+// recordOperationFailure records a failed operation (after its retries) in the iteration counters, logs it, and fails the job once the
+// iteration had too many failures
+func recordOperationFailure(configs Config, stats *iterationStats, operation string, path string, err error) {
+	stats.addFailed(path, err)
+
+	logOperationError(configs.General.logger, operation, path, err)
+	emitEvent(configs, eventActionError, path, 0, 0, err)
+
+	if configs.General.MaxErrorsPerIteration < 1 {
+		return
+	}
+	if count := configs.General.control.addIterationError(); count == int64(configs.General.MaxErrorsPerIteration)+1 {
+		failJob(configs, fmt.Sprintf("%d operations failed in an iteration", count))
+	}
+}
+
+// recordIterationHealth counts the ended iterations in a row which had failed operations, and fails the job once there are too many of them
+// (an iteration without failures resets the count)
+func recordIterationHealth(configs Config, stats *iterationStats) {
+	failedIterations := configs.General.control.addIteration(stats.filesFailed > 0)
+
+	if configs.General.MaxConsecutiveFailedIterations > 0 && failedIterations >= configs.General.MaxConsecutiveFailedIterations {
+		failJob(configs, fmt.Sprintf("%d iterations in a row had failed operations", failedIterations))
+	}
+}
+
+// failJob stops the job from running further operations and iterations, until it is resumed or its cool-down ends
+func failJob(configs Config, reason string) {
+	if !configs.General.control.setFailed(true, reason) {
+		return
+	}
+
+	if configs.General.FailureCooldownSeconds > 0 {
+		configs.General.logger.Error("Job failed, stopped until resumed or until the cool-down ends", "reason", reason, "cooldown", time.Duration(configs.General.FailureCooldownSeconds)*time.Second)
+	} else {
+		configs.General.logger.Error("Job failed, stopped until resumed", "reason", reason)
+	}
+
+	notifyJobFailed(configs, reason)
+}
+
+// addIterationError counts a failed operation of the running iteration, and returns the count of them
+func (control *jobControl) addIterationError() int64 {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	control.iterationErrors++
+	return control.iterationErrors
+}
+
+// startIteration resets the count of failed operations and the skipped operations of the iteration
+func (control *jobControl) startIteration() {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	control.iterationErrors = 0
+	control.skipped = make(chan struct{})
+}
+
+// skipOperation records an operation of the running iteration was skipped, releasing the operations waiting for others
+func (control *jobControl) skipOperation() {
+	if control == nil {
+		return
+	}
+
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	select {
+	case <-control.skipped:
+	default:
+		close(control.skipped)
+	}
+}
+
+// operationSkipped returns a channel which is closed once an operation of the running iteration is skipped
+func (control *jobControl) operationSkipped() <-chan struct{} {
+	if control == nil {
+		return nil
+	}
+
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	return control.skipped
+}
+
+// addIteration counts an ended iteration, and returns the count of the iterations in a row which had failed operations
+func (control *jobControl) addIteration(failed bool) int {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if failed {
+		control.failedIterations++
+	} else {
+		control.failedIterations = 0
+	}
+	return control.failedIterations
+}
+
+// setFailed fails the job (for the reason), or lets a failed job try again, and reports whether its state changed
+func (control *jobControl) setFailed(failed bool, reason string) bool {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if control.failed == failed {
+		return false
+	}
+
+	if failed {
+		control.failed = true
+		control.failedAt = time.Now()
+		control.failedReason = reason
+	} else {
+		control.clearFailed()
+	}
+	control.notifyChanged()
+	return true
+}
+
+// clearFailed lets a failed job try again with fresh counts of failures, the mutex must be held
+func (control *jobControl) clearFailed() {
+	control.failed = false
+	control.failedAt = time.Time{}
+	control.failedReason = ""
+	control.iterationErrors = 0
+	control.failedIterations = 0
+}
+
+// isFailed reports whether the job failed, and the reason it did
+func (control *jobControl) isFailed() (bool, string) {
+	if control == nil {
+		return false, ""
+	}
+
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	return control.failed, control.failedReason
+}
+
+// getCooldown returns the time left until a failed job tries again, and reports whether the job is failed and a cool-down is set
+func (control *jobControl) getCooldown(configs Config) (time.Duration, bool) {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if !control.failed || configs.General.FailureCooldownSeconds < 1 {
+		return 0, false
+	}
+
+	return time.Until(control.failedAt.Add(time.Duration(configs.General.FailureCooldownSeconds) * time.Second)), true
+}
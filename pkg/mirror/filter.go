@@ -0,0 +1,410 @@
+package mirror
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// files are filtered by their age only once they are older than the limit by this margin, so a file is not dropped (and its copy removed)
+// just because the clock of the source or the destination runs slightly ahead
+const ageSafetyMargin = time.Hour
+
+// normalizeRelativePath converts a relative path (as computed by getDirFiles) into a slash separated path without a leading separator, so it can be matched against patterns on every platform
+func normalizeRelativePath(relativePath string) string {
+	return strings.Trim(filepath.ToSlash(relativePath), "/")
+}
+
+// getFilterReason returns the reason the relative path is ignored by the mirror (and the pattern which excludes it, if any), or an empty reason
+// if it is mirrored: it is excluded, ignored by an ignore file, or not included. exclude patterns always win over include patterns
+func getFilterReason(general GeneralConfigurations, relativePath string, isDir bool) (string, string) {
+	// paths out of the scope of the mirror are never seen, so they are filtered too (e.g. by targeted scans)
+	if !newPathScope(general).contains(relativePath) {
+		return "out of scope", ""
+	}
+
+	// excluded paths are always filtered
+	if pattern := getMatchingPattern(general.ExcludePatterns, relativePath); len(pattern) > 0 {
+		return "excluded", pattern
+	}
+
+	// so are the paths ignored by the ignore files of the source directory, if respected
+	if general.RespectMirrorIgnore {
+		if general.ExcludeMirrorIgnoreFiles && isMirrorIgnoreFile(relativePath) {
+			return "ignore file", ""
+		}
+		if match := general.ignores.match(relativePath, isDir); match.ignored {
+			return "ignored", match.file + ": " + match.rule
+		}
+	}
+
+	// and so are the paths whose names are invalid on the destination, if skipped
+	if general.InvalidNameHandling == invalidNameHandlingSkip && hasInvalidName(relativePath) {
+		return "invalid name", ""
+	}
+
+	// when include patterns are set, only matching paths are mirrored (parent directories of included files are still created when the files are written)
+	if len(general.IncludePatterns) > 0 && !matchesAnyPattern(general.IncludePatterns, relativePath) {
+		return "not included", ""
+	}
+
+	return "", ""
+}
+
+// getSizeFilterReason returns the reason the file is ignored by its size, or an empty reason if its size is within the limits
+func getSizeFilterReason(general GeneralConfigurations, file os.FileInfo) string {
+	if !isSizeFiltered(general, file) {
+		return ""
+	}
+	if general.MaxFileSizeMB > 0 && file.Size() > int64(general.MaxFileSizeMB)*1024*1024 {
+		return "too large"
+	}
+	return "too small"
+}
+
+// isSizeFiltered reports whether the file should be ignored by the mirror, because its size is out of the configured limits (directories are never filtered by size)
+func isSizeFiltered(general GeneralConfigurations, file os.FileInfo) bool {
+	if file.IsDir() {
+		return false
+	}
+
+	if general.MaxFileSizeMB > 0 && file.Size() > int64(general.MaxFileSizeMB)*1024*1024 {
+		return true
+	}
+
+	return general.MinFileSizeKB > 0 && file.Size() < int64(general.MinFileSizeKB)*1024
+}
+
+// getAgeFilterReason returns the reason the file is ignored by its age (by its modification time), or an empty reason if it is recent enough:
+// it is older than the destination retention (its copy would be removed right away), or older than the maximum source age. directories are
+// never filtered by age
+func getAgeFilterReason(general GeneralConfigurations, file os.FileInfo, now time.Time) string {
+	if file.IsDir() {
+		return ""
+	}
+
+	age := now.Sub(file.ModTime())
+	if general.DestinationRetentionDays > 0 && age > time.Duration(general.DestinationRetentionDays)*24*time.Hour+ageSafetyMargin {
+		return "expired"
+	}
+	if general.MaxSourceAgeDays > 0 && age > time.Duration(general.MaxSourceAgeDays)*24*time.Hour+ageSafetyMargin {
+		return "too old"
+	}
+	return ""
+}
+
+// isExpired reports whether the destination file is removed by its age: it is older than the destination retention, or older than the
+// maximum source age while expired files are pruned
+func isExpired(general GeneralConfigurations, file os.FileInfo, now time.Time) bool {
+	reason := getAgeFilterReason(general, file, now)
+	return reason == "expired" || (len(reason) > 0 && general.PruneExpired)
+}
+
+// pathScope limits the mirrored paths to a depth and to subdirectories of the root. paths out of scope are neither walked nor compared,
+// in the source and in the destinations alike, so their destination files are left alone
+type pathScope struct {
+	// count of path levels mirrored below the root, unlimited if 0
+	maxDepth int
+	// slash separated subpaths of the root which are mirrored, the whole root if empty
+	subdirectories []string
+	// slash separated relative path of the walked directory, which the checked paths are relative to
+	base string
+}
+
+func newPathScope(general GeneralConfigurations) pathScope {
+	return pathScope{maxDepth: general.MaxDepth, subdirectories: general.IncludeSubdirectories}
+}
+
+// isLimited reports whether any path can be out of scope
+func (scope pathScope) isLimited() bool {
+	return scope.maxDepth > 0 || len(scope.subdirectories) > 0
+}
+
+// under returns the scope of a walk of the subdirectory, whose paths are relative to it
+func (scope pathScope) under(relativeDir string) pathScope {
+	scope.base = scope.getPath(relativeDir)
+	return scope
+}
+
+// contains reports whether the path is mirrored: it is within the depth, and inside a selected subdirectory (or is a parent directory of one)
+func (scope pathScope) contains(relativePath string) bool {
+	rootPath := scope.getPath(relativePath)
+	if len(rootPath) < 1 {
+		return true
+	}
+	if scope.maxDepth > 0 && getPathDepth(rootPath) > scope.maxDepth {
+		return false
+	}
+	if len(scope.subdirectories) < 1 {
+		return true
+	}
+
+	for _, subdirectory := range scope.subdirectories {
+		if isSlashSubPath(subdirectory, rootPath) || isSlashSubPath(rootPath, subdirectory) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWalked reports whether any contents of the directory are in scope
+func (scope pathScope) isWalked(relativeDir string) bool {
+	if scope.maxDepth > 0 && getPathDepth(scope.getPath(relativeDir)) >= scope.maxDepth {
+		return false
+	}
+	return scope.contains(relativeDir)
+}
+
+// isComplete reports whether all contents of the directory are in scope. an incomplete directory must never be removed, which would remove
+// its contents out of scope
+func (scope pathScope) isComplete(relativeDir string) bool {
+	rootPath := scope.getPath(relativeDir)
+	if scope.maxDepth > 0 && getPathDepth(rootPath) >= scope.maxDepth {
+		return false
+	}
+	if len(scope.subdirectories) < 1 {
+		return true
+	}
+
+	for _, subdirectory := range scope.subdirectories {
+		if isSlashSubPath(subdirectory, rootPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// getPath returns the slash separated path of the relative path from the root
+func (scope pathScope) getPath(relativePath string) string {
+	return strings.Trim(path.Join(scope.base, normalizeRelativePath(relativePath)), "/")
+}
+
+// getPathDepth returns the count of levels of the slash separated path below the root
+func getPathDepth(rootPath string) int {
+	if len(rootPath) < 1 {
+		return 0
+	}
+	return strings.Count(rootPath, "/") + 1
+}
+
+// isSlashSubPath reports whether the slash separated path is the parent path itself, or is located under it
+func isSlashSubPath(parent string, rootPath string) bool {
+	return rootPath == parent || strings.HasPrefix(rootPath, parent+"/")
+}
+
+// skippedFiles holds the sizes of source files skipped by their size (or age), so a skipped file is logged once per appearance
+type skippedFiles struct {
+	mutex sync.Mutex
+	sizes map[string]int64
+}
+
+func newSkippedFiles() *skippedFiles {
+	return &skippedFiles{sizes: make(map[string]int64)}
+}
+
+// add records the skipped file, and reports whether it was not skipped before (or its size changed since)
+func (skipped *skippedFiles) add(relativePath string, size int64) bool {
+	skipped.mutex.Lock()
+	defer skipped.mutex.Unlock()
+
+	if lastSize, exists := skipped.sizes[relativePath]; exists && lastSize == size {
+		return false
+	}
+
+	skipped.sizes[relativePath] = size
+	return true
+}
+
+// retain forgets the skipped files which are not in the given paths
+func (skipped *skippedFiles) retain(relativePaths map[string]bool) {
+	skipped.mutex.Lock()
+	defer skipped.mutex.Unlock()
+
+	for relativePath := range skipped.sizes {
+		if !relativePaths[relativePath] {
+			delete(skipped.sizes, relativePath)
+		}
+	}
+}
+
+// matchesAnyPattern reports whether the relative path, or any of its parent directories, matches one of the provided glob patterns
+func matchesAnyPattern(patterns []string, relativePath string) bool {
+	return len(getMatchingPattern(patterns, relativePath)) > 0
+}
+
+// getMatchingPattern returns the first of the provided glob patterns which the relative path, or any of its parent directories, matches, or an
+// empty pattern if none does
+func getMatchingPattern(patterns []string, relativePath string) string {
+	// nothing to match against
+	if len(patterns) < 1 {
+		return ""
+	}
+
+	normalizedPath := normalizeRelativePath(relativePath)
+	if len(normalizedPath) < 1 {
+		return ""
+	}
+
+	// check the path itself and every parent directory of it, so excluding a directory excludes its contents too
+	for candidate := normalizedPath; candidate != "."; candidate = path.Dir(candidate) {
+		for _, pattern := range patterns {
+			if matchPattern(pattern, candidate) {
+				return pattern
+			}
+		}
+	}
+
+	return ""
+}
+
+// matchPattern reports whether the slash separated relative path matches the glob pattern.
+// patterns without a separator are matched against the base name only (so '*.tmp' matches in any directory),
+// otherwise the pattern is matched against the whole path, where a '**' segment matches zero or more directories
+func matchPattern(pattern, relativePath string) bool {
+	pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+	if len(pattern) < 1 {
+		return false
+	}
+
+	// pattern has no directory part, so match only the file name
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(relativePath))
+		return matched
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relativePath, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, expanding '**' segments recursively
+func matchSegments(patternSegments, pathSegments []string) bool {
+	for len(patternSegments) > 0 {
+		if patternSegments[0] == "**" {
+			// collapse consecutive '**' segments
+			for len(patternSegments) > 0 && patternSegments[0] == "**" {
+				patternSegments = patternSegments[1:]
+			}
+			// trailing '**' matches anything remaining
+			if len(patternSegments) < 1 {
+				return true
+			}
+			// try to match the rest of the pattern at every remaining position
+			for i := 0; i <= len(pathSegments); i++ {
+				if matchSegments(patternSegments, pathSegments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		// path ran out of segments before the pattern did
+		if len(pathSegments) < 1 {
+			return false
+		}
+
+		// match a single segment
+		if matched, err := path.Match(patternSegments[0], pathSegments[0]); err != nil || !matched {
+			return false
+		}
+
+		patternSegments = patternSegments[1:]
+		pathSegments = pathSegments[1:]
+	}
+
+	// pattern fully consumed, so it matches only if the path was fully consumed too
+	return len(pathSegments) < 1
+}
+
+// getInternalPaths returns the relative paths inside the destination directory which are used by the mirror itself (e.g. the backup directory)
+func getInternalPaths(configs Config) []string {
+	var internalPaths []string
+
+	// the backup and quarantine directories are internal only if they live under the destination directory
+	for _, dir := range []string{configs.General.BackupDirectory, configs.General.QuarantineDirectory} {
+		if len(dir) > 0 && isSubPath(configs.General.DestinationDirectory, dir) {
+			internalPaths = append(internalPaths, getRelativePath(configs.General.DestinationDirectory, dir))
+		}
+	}
+
+	// so are the state file and the health file (which are always local, so never inside a remote destination)
+	for _, path := range []string{configs.General.StateFile, configs.General.HealthFile} {
+		if len(path) > 0 && len(configs.General.DestinationURL) < 1 && isSubPath(configs.General.DestinationDirectory, path) {
+			internalPaths = append(internalPaths, getRelativePath(configs.General.DestinationDirectory, path))
+		}
+	}
+
+	// and so is the manifest
+	if configs.General.WriteManifest {
+		internalPaths = append(internalPaths, manifestFileName)
+	}
+
+	return internalPaths
+}
+
+// excludeInternalPaths removes the internal paths of the mirror (and their contents) from the destination files, so they are never deleted
+func excludeInternalPaths(configs Config, destFiles map[string]os.FileInfo) {
+	internalPaths := getInternalPaths(configs)
+	if len(internalPaths) < 1 {
+		return
+	}
+
+	for dstPath := range destFiles {
+		if isInternalPath(internalPaths, dstPath) {
+			delete(destFiles, dstPath)
+		}
+	}
+}
+
+// isInternalPath reports whether the relative path is one of the internal paths of the mirror, or inside one of them
+func isInternalPath(internalPaths []string, relativePath string) bool {
+	for _, internalPath := range internalPaths {
+		if isSubPath(internalPath, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// getInternalPathsUnder returns the internal paths of the mirror inside the relative directory, relative to that directory
+func getInternalPathsUnder(internalPaths []string, relativeDir string) []string {
+	var subPaths []string
+	for _, internalPath := range internalPaths {
+		if isSubPath(relativeDir, internalPath) {
+			subPaths = append(subPaths, getRelativePath(relativeDir, internalPath))
+		}
+	}
+	return subPaths
+}
+
+// dropEmptyDirs removes the source directories which are missing from the destination and hold no files (nor do their subdirectories) from
+// the source files, unless empty directories are mirrored. directories are then created as the parents of the files written into them
+func dropEmptyDirs(configs Config, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo, wg *sync.WaitGroup) {
+	if configs.General.MirrorEmptyDirectories {
+		return
+	}
+
+	// get the directories which hold files to write (a directory missing from the destination has all of its files among the source files)
+	filledDirs := make(map[string]bool)
+	for srcPath, srcFile := range srcFiles {
+		if srcFile.IsDir() && !isSymlink(srcFile) {
+			continue
+		}
+		for dir := filepath.Dir(srcPath); dir != "." && !filledDirs[dir]; dir = filepath.Dir(dir) {
+			filledDirs[dir] = true
+		}
+	}
+
+	for srcPath, srcFile := range srcFiles {
+		if _, exists := destFiles[srcPath]; exists || !srcFile.IsDir() || isSymlink(srcFile) || filledDirs[srcPath] {
+			continue
+		}
+
+		configs.General.logger.Debug("Skip", "path", filepath.Join(configs.General.SourceDirectory, srcPath), "reason", "empty directory")
+		delete(srcFiles, srcPath)
+
+		// since we remove record from container, count as -1 in WaitGroup counter
+		wg.Done()
+	}
+}
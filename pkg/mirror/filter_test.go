@@ -0,0 +1,117 @@
+package mirror
+
+import (
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		matched bool
+	}{
+		// patterns without a separator match the base name in any directory
+		{pattern: "*.tmp", path: "a.tmp", matched: true},
+		{pattern: "*.tmp", path: "dir/sub/a.tmp", matched: true},
+		{pattern: "*.tmp", path: "a.tmp.txt", matched: false},
+		{pattern: "cache", path: "dir/cache", matched: true},
+		// patterns with a separator match the whole path, and * does not match a separator
+		{pattern: "docs/*.pdf", path: "docs/manual.pdf", matched: true},
+		{pattern: "docs/*.pdf", path: "docs/old/manual.pdf", matched: false},
+		{pattern: "docs/*.pdf", path: "other/docs/manual.pdf", matched: false},
+		// leading and trailing separators are ignored
+		{pattern: "/docs/*.pdf", path: "docs/manual.pdf", matched: true},
+		{pattern: "build/", path: "build", matched: true},
+		// ** matches zero or more directories
+		{pattern: "**/cache", path: "cache", matched: true},
+		{pattern: "**/cache", path: "x/y/cache", matched: true},
+		{pattern: "a/**/z.txt", path: "a/z.txt", matched: true},
+		{pattern: "a/**/z.txt", path: "a/b/c/z.txt", matched: true},
+		{pattern: "a/**/z.txt", path: "b/a/z.txt", matched: false},
+		{pattern: "a/**", path: "a/b/c", matched: true},
+		{pattern: "a/**/**/z.txt", path: "a/b/z.txt", matched: true},
+		// a pattern is not matched by a part of the path
+		{pattern: "a/b", path: "a/b/c", matched: false},
+		{pattern: "a/b/c", path: "a/b", matched: false},
+		// empty and malformed patterns match nothing
+		{pattern: "", path: "a", matched: false},
+		{pattern: "/", path: "a", matched: false},
+		{pattern: "dir/[", path: "dir/[", matched: false},
+	}
+
+	for _, test := range tests {
+		if matched := matchPattern(test.pattern, test.path); matched != test.matched {
+			t.Errorf("matchPattern(%q, %q) = %t, expected %t", test.pattern, test.path, matched, test.matched)
+		}
+	}
+}
+
+func TestGetMatchingPattern(t *testing.T) {
+	patterns := []string{"*.log", "build/**/*.o", "vendor"}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "debug.log", expected: "*.log"},
+		{path: "build/x/y/main.o", expected: "build/**/*.o"},
+		// the contents of a matching directory match as well
+		{path: "vendor", expected: "vendor"},
+		{path: "vendor/lib/a.go", expected: "vendor"},
+		{path: "src/vendor/a.go", expected: "vendor"},
+		{path: "debug.log/a.txt", expected: "*.log"},
+		// relative paths are normalized first
+		{path: "/vendor/a.go/", expected: "vendor"},
+		{path: "build/main.c", expected: ""},
+		{path: "", expected: ""},
+	}
+
+	for _, test := range tests {
+		if pattern := getMatchingPattern(patterns, test.path); pattern != test.expected {
+			t.Errorf("getMatchingPattern(%q) = %q, expected %q", test.path, pattern, test.expected)
+		}
+	}
+
+	if pattern := getMatchingPattern(nil, "debug.log"); pattern != "" {
+		t.Errorf("getMatchingPattern without patterns = %q, expected none", pattern)
+	}
+}
+
+func TestGetFilterReason(t *testing.T) {
+	var general GeneralConfigurations
+	general.ExcludePatterns = []string{"*.tmp", "logs"}
+	general.IncludePatterns = []string{"src/**", "*.md"}
+	general.RespectMirrorIgnore = true
+	general.ignores = newTestIgnoreRules(map[string]string{
+		".": "*.bak\n" +
+			"!keep.bak\n" +
+			"out/\n",
+	})
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		reason  string
+		pattern string
+	}{
+		{path: "src/main.go"},
+		{path: "README.md"},
+		{path: "docs/guide.md"},
+		// exclude patterns win over include patterns
+		{path: "src/a.tmp", reason: "excluded", pattern: "*.tmp"},
+		{path: "src/logs/a.txt", reason: "excluded", pattern: "logs"},
+		// ignore files apply to included paths, along with their re-includes and directory only rules
+		{path: "src/a.bak", reason: "ignored", pattern: ".mirrorignore: *.bak"},
+		{path: "src/keep.bak"},
+		{path: "src/out", isDir: true, reason: "ignored", pattern: ".mirrorignore: out/"},
+		{path: "src/out"},
+		{path: "other.go", reason: "not included"},
+	}
+
+	for _, test := range tests {
+		reason, pattern := getFilterReason(general, test.path, test.isDir)
+		if reason != test.reason || pattern != test.pattern {
+			t.Errorf("getFilterReason(%q, %t) = %q, %q, expected %q, %q", test.path, test.isDir, reason, pattern, test.reason, test.pattern)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build darwin
+// +build darwin
+
+package mirror
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// isCoarseModTimeFS reports whether the path is on a FAT or exFAT file system, which keeps modification times in 2 second steps
+func isCoarseModTimeFS(path string) bool {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return false
+	}
+
+	name := unix.ByteSliceToString(stat.Fstypename[:])
+	return name == "msdos" || name == "exfat"
+}
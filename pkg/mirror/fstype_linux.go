@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package mirror
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// isCoarseModTimeFS reports whether the path is on a FAT or exFAT file system, which keeps modification times in 2 second steps
+func isCoarseModTimeFS(path string) bool {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return false
+	}
+
+	return stat.Type == unix.MSDOS_SUPER_MAGIC || stat.Type == unix.EXFAT_SUPER_MAGIC
+}
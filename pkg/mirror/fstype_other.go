@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package mirror
+
+// isCoarseModTimeFS reports whether the path is on a file system which keeps modification times in 2 second steps, which is detected on
+// linux, macOS and windows only
+func isCoarseModTimeFS(path string) bool {
+	return false
+}
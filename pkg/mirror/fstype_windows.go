@@ -0,0 +1,30 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// isCoarseModTimeFS reports whether the path is on a FAT or exFAT volume, which keeps modification times in 2 second steps
+func isCoarseModTimeFS(path string) bool {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+
+	// the file system is told by the root of the volume the path is on
+	volume := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(pathPtr, &volume[0], uint32(len(volume))); err != nil {
+		return false
+	}
+	name := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumeInformation(&volume[0], nil, 0, nil, nil, nil, &name[0], uint32(len(name))); err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(strings.ToUpper(windows.UTF16ToString(name)), "FAT") || strings.EqualFold(windows.UTF16ToString(name), "exFAT")
+}
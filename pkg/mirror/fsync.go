@@ -0,0 +1,28 @@
+package mirror
+
+import (
+	"os"
+)
+
+// dirSyncer is a file system which can flush the entries of a directory to stable storage (e.g. a file created or renamed into it)
+type dirSyncer interface {
+	SyncDir(path string) error
+}
+
+// isDurableCopy reports whether the copy of the source file is flushed to stable storage along with its directory entry, before it counts
+// as mirrored
+func isDurableCopy(configs Config, srcFile os.FileInfo) bool {
+	return configs.General.FsyncAfterCopy && srcFile.Size() >= int64(configs.General.FsyncThresholdKB)*1024
+}
+
+// syncDir flushes the entries of the directory to stable storage, on file systems which support it (it is a no-op on others)
+func syncDir(fsys destinationFS, path string) error {
+	if syncer, ok := fsys.(dirSyncer); ok {
+		return syncer.SyncDir(path)
+	}
+	return nil
+}
+
+func (localFS) SyncDir(path string) error {
+	return syncDirectory(path)
+}
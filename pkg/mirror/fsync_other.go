@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package mirror
+
+import (
+	"os"
+)
+
+// syncDirectory flushes the entries of the directory to stable storage
+func syncDirectory(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
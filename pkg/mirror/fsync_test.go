@@ -0,0 +1,46 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func BenchmarkFsyncAfterCopy(b *testing.B) {
+	// many small files and a few large ones, which are mirrored into an empty destination by every iteration. the temporary directory should
+	// be on a disk (see TMPDIR), since flushing a file of a memory-backed file system costs nothing
+	source, destination := b.TempDir(), b.TempDir()
+	writeBenchmarkTree(b, 20, 50, source)
+	if err := os.Mkdir(filepath.Join(source, "large"), 0755); err != nil {
+		b.Fatal(err)
+	}
+	writeBenchmarkFiles(b, filepath.Join(source, "large"), 4, 4*1024*1024)
+
+	durability := []struct {
+		name      string
+		configure func(config *Config)
+	}{
+		{name: "off"},
+		{name: "all", configure: func(config *Config) { config.General.FsyncAfterCopy = true }},
+		{name: "threshold", configure: func(config *Config) {
+			config.General.FsyncAfterCopy = true
+			config.General.FsyncThresholdKB = 1024
+		}},
+	}
+
+	for _, options := range durability {
+		b.Run(options.name, func(b *testing.B) {
+			mirror := newTestMirror(b, source, destination, options.configure)
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := os.RemoveAll(destination); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				mustSyncOnce(b, mirror)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+// syncDirectory does nothing, directories can not be flushed on windows (NTFS journals the changes of their entries)
+func syncDirectory(path string) error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// fileID identifies a file across its hard links
+type fileID struct {
+	device uint64
+	index  uint64
+}
+
+// getHardLinks returns the source files which are hard links of another source file, mapped to the relative path of that file (the first path of every group of links)
+func getHardLinks(configs Config, srcFiles map[string]os.FileInfo) map[string]string {
+	// nothing to do unless requested
+	if !configs.General.PreserveHardLinks {
+		return nil
+	}
+
+	// collect regular source files which have other hard links, by their identity
+	groups := make(map[fileID][]string)
+	for srcPath, srcFile := range srcFiles {
+		if !srcFile.Mode().IsRegular() {
+			continue
+		}
+
+		if id, ok := getFileID(filepath.Join(configs.General.SourceDirectory, srcPath), srcFile); ok {
+			groups[id] = append(groups[id], srcPath)
+		}
+	}
+
+	// the first path of every group is copied, all other paths are linked to it
+	links := make(map[string]string)
+	for _, paths := range groups {
+		if len(paths) < 2 {
+			continue
+		}
+
+		sort.Strings(paths)
+		for _, path := range paths[1:] {
+			links[path] = paths[0]
+		}
+	}
+
+	return links
+}
+
+// linkFile makes the destination file a hard link of the destination file of another source path, once that file was written (falling back to a copy when linking fails)
+func linkFile(ctx context.Context, configs Config, stats *iterationStats, srcPath string, srcFile os.FileInfo, targetPath string, targetDone chan struct{}, path string) error {
+	// wait for the target to be written first (it is scheduled ahead of its links, so it is already running), unless operations are skipped
+	// (the target may be one of them, and never signal its end)
+	select {
+	case <-targetDone:
+	case <-ctx.Done():
+		return nil
+	case <-configs.General.control.operationSkipped():
+		// the target may have ended anyway
+		select {
+		case <-targetDone:
+		default:
+			return nil
+		}
+	}
+
+	// the target must hold the current contents of the source file, otherwise (e.g. its copy failed or was deferred) the file is copied on its own
+	target, err := os.Lstat(targetPath)
+	if err != nil || !target.Mode().IsRegular() || target.Size() != srcFile.Size() || !target.ModTime().Equal(srcFile.ModTime()) {
+		return writeFile(ctx, configs, stats, srcPath, srcFile, path)
+	}
+
+	// check destination file, nothing to do if it is already a link of the target
+	file, err := os.Lstat(path)
+	if err == nil && os.SameFile(file, target) {
+		stats.addUnchanged()
+		configs.General.manifests.keep(configs, path, file)
+
+		configs.General.logger.Debug("Unchanged", "path", path)
+		return nil
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	overwrite := err == nil
+
+	// in dry run mode, only report the file would be linked
+	if configs.General.DryRun {
+		stats.addLinked()
+
+		configs.General.logger.Info("WOULD Link", "path", path, "target", targetPath)
+		return nil
+	}
+
+	// make sure destination directory exists
+	if err := validateDirExistance(configs, stats, srcPath, path); err != nil {
+		return err
+	}
+
+	// create the link under a temporary name, so the existing destination file is only replaced once the link exists
+	tempPath := getTempPath(path)
+	os.Remove(tempPath)
+	if err := os.Link(targetPath, tempPath); err != nil {
+		// the destination file system may not support hard links, so copy the file instead (warning once per iteration)
+		if stats.warnOnce(&stats.hardLinkWarned) {
+			configs.General.logger.Warn("Hard links can not be preserved, copying instead", "path", path, "error", err)
+		}
+
+		return writeFile(ctx, configs, stats, srcPath, srcFile, path)
+	}
+	// make sure the temporary link does not survive a failure
+	defer os.Remove(tempPath)
+
+	// replace the destination file with the link (backing up the existing file first)
+	if overwrite {
+		if err := backupFile(configs, path); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return err
+	}
+
+	stats.addLinked()
+	configs.General.manifests.record(configs, path, nil)
+
+	configs.General.logger.Info("Link", "path", path, "target", targetPath)
+	emitEvent(configs, eventActionLink, path, 0, 0, nil)
+	return nil
+}
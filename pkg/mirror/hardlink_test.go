@@ -0,0 +1,69 @@
+package mirror
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLinkFileReleasedBySkippedTarget(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	if err := os.WriteFile(srcPath, []byte("link"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srcFile, err := os.Stat(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var configs Config
+	configs.General.control = &jobControl{changed: make(chan struct{})}
+	configs.General.control.startIteration()
+
+	// the target is never written, since its operation is skipped (e.g. the job failed meanwhile)
+	targetDone := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- linkFile(context.Background(), configs, nil, srcPath, srcFile, filepath.Join(dir, "target"), targetDone, filepath.Join(dir, "link"))
+	}()
+
+	configs.General.control.skipOperation()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("linkFile() failed; %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("linkFile() still waits for a target whose operation was skipped")
+	}
+
+	if _, err := os.Lstat(filepath.Join(dir, "link")); !os.IsNotExist(err) {
+		t.Errorf("link of a skipped target was made (%v)", err)
+	}
+}
+
+func TestSkippedOperationsResetByIteration(t *testing.T) {
+	control := &jobControl{changed: make(chan struct{})}
+	control.startIteration()
+
+	control.skipOperation()
+	// skipping more operations of the same iteration is harmless
+	control.skipOperation()
+
+	select {
+	case <-control.operationSkipped():
+	default:
+		t.Fatal("operationSkipped() is not closed after an operation was skipped")
+	}
+
+	control.startIteration()
+	select {
+	case <-control.operationSkipped():
+		t.Fatal("operationSkipped() is closed at the start of a new iteration")
+	default:
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package mirror
+
+import (
+	"os"
+	"syscall"
+)
+
+// getFileID returns the identity of the file (its device and inode), or false if the file has no other hard links
+func getFileID(path string, info os.FileInfo) (fileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || uint64(stat.Nlink) < 2 {
+		return fileID{}, false
+	}
+
+	return fileID{device: uint64(stat.Dev), index: uint64(stat.Ino)}, true
+}
+
+// getDeviceID returns the identity of the device (file system) holding the file
+func getDeviceID(path string, info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(stat.Dev), true
+}
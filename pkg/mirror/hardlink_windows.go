@@ -0,0 +1,43 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"os"
+	"syscall"
+)
+
+// getFileID returns the identity of the file (its volume and file index), or false if the file has no other hard links
+func getFileID(path string, info os.FileInfo) (fileID, bool) {
+	// the file index is not part of the scanned info, so it is read from an open handle
+	f, err := os.Open(path)
+	if err != nil {
+		return fileID{}, false
+	}
+	defer f.Close()
+
+	var data syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &data); err != nil || data.NumberOfLinks < 2 {
+		return fileID{}, false
+	}
+
+	return fileID{device: uint64(data.VolumeSerialNumber), index: uint64(data.FileIndexHigh)<<32 | uint64(data.FileIndexLow)}, true
+}
+
+// getDeviceID returns the identity of the device (volume) holding the file
+func getDeviceID(path string, info os.FileInfo) (uint64, bool) {
+	// the volume is not part of the scanned info, so it is read from an open handle (directories can be opened too)
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	var data syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &data); err != nil {
+		return 0, false
+	}
+
+	return uint64(data.VolumeSerialNumber), true
+}
@@ -0,0 +1,45 @@
+package mirror
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// healthFileContents is the JSON contents of the health file, written at the end of every successful iteration
+type healthFileContents struct {
+	Time         time.Time        `json:"time"`
+	Job          string           `json:"job"`
+	Source       string           `json:"source"`
+	Destinations []string         `json:"destinations"`
+	DryRun       bool             `json:"dryRun,omitempty"`
+	Duration     string           `json:"duration"`
+	Summary      map[string]int64 `json:"summary"`
+}
+
+// writeHealthFile replaces the health file with the summary of the ended iteration, if it had no failed operations (so monitoring can tell
+// a stuck or failing job by the age of the file)
+func writeHealthFile(configs Config, stats *iterationStats) {
+	if len(configs.General.HealthFile) < 1 || stats.filesFailed > 0 {
+		return
+	}
+
+	contents := healthFileContents{
+		Time:     time.Now(),
+		Job:      getJobName(configs),
+		Source:   configs.General.SourceDirectory,
+		DryRun:   configs.General.DryRun,
+		Duration: (stats.scanDuration + stats.transferDuration).String(),
+		Summary:  stats.getCounts(),
+	}
+	for _, destConfigs := range getDestinationConfigs(configs) {
+		contents.Destinations = append(contents.Destinations, getDestinationName(destConfigs))
+	}
+
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(configs.General.HealthFile, data)
+	}
+	if err != nil {
+		logOperationError(configs.General.logger, "Write", configs.General.HealthFile, err)
+	}
+}
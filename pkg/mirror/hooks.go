@@ -0,0 +1,134 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hookPreSync  = "preSync"
+	hookPostSync = "postSync"
+)
+
+// runPreSyncHook runs the pre-sync command (if set) before an iteration, and reports whether the iteration should run
+func runPreSyncHook(ctx context.Context, configs Config) bool {
+	if len(configs.General.PreSyncCommand) < 1 {
+		return true
+	}
+
+	if err := runHook(ctx, configs, hookPreSync, configs.General.PreSyncCommand, nil); err != nil {
+		configs.General.logger.Warn("Skip iteration", "reason", "pre-sync command failed", "error", err)
+		return false
+	}
+	return true
+}
+
+// runPostSyncHook runs the post-sync command (if set) after an iteration, with the counts of the iteration in its environment.
+// a failure is logged only, since the iteration itself is complete
+func runPostSyncHook(ctx context.Context, configs Config, stats *iterationStats) {
+	if len(configs.General.PostSyncCommand) < 1 {
+		return
+	}
+
+	env := []string{
+		"DM_JOB=" + getJobName(configs),
+		"DM_SOURCE=" + configs.General.SourceDirectory,
+		fmt.Sprintf("DM_DRY_RUN=%t", configs.General.DryRun),
+		fmt.Sprintf("DM_FILES_SCANNED=%d", stats.filesScannedSource),
+		fmt.Sprintf("DM_FILES_COPIED=%d", stats.filesCopied),
+		fmt.Sprintf("DM_BYTES_COPIED=%d", stats.bytesCopied),
+		fmt.Sprintf("DM_FILES_LINKED=%d", stats.filesLinked+stats.filesCloned),
+		fmt.Sprintf("DM_FILES_MOVED=%d", stats.filesMoved),
+		fmt.Sprintf("DM_FILES_DELETED=%d", stats.filesDeleted),
+		fmt.Sprintf("DM_FILES_UNCHANGED=%d", stats.filesUnchanged),
+		fmt.Sprintf("DM_ERRORS=%d", stats.filesFailed),
+	}
+
+	if err := runHook(ctx, configs, hookPostSync, configs.General.PostSyncCommand, env); err != nil {
+		configs.General.logger.Error("Post-sync command failed", "error", err)
+	}
+}
+
+// runHook runs the command (without a shell) until it exits, the timeout passes or termination is requested, logging its output line by line
+func runHook(ctx context.Context, configs Config, hook string, command []string, env []string) error {
+	if configs.General.HookTimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(configs.General.HookTimeoutSeconds)*time.Second)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+
+	stdout := &hookOutput{logger: configs.General.logger, hook: hook, stream: "stdout"}
+	stderr := &hookOutput{logger: configs.General.logger, hook: hook, stream: "stderr"}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	configs.General.logger.Debug("Hook", "hook", hook, "command", strings.Join(command, " "))
+
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %v", time.Since(start).Round(time.Millisecond))
+	}
+	if err != nil {
+		return err
+	}
+
+	configs.General.logger.Debug("Hook finished", "hook", hook, "duration", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// hookOutput logs the output of a hook command line by line, as it is written
+type hookOutput struct {
+	mutex   sync.Mutex
+	logger  *slog.Logger
+	hook    string
+	stream  string
+	partial []byte
+}
+
+func (output *hookOutput) Write(p []byte) (int, error) {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	output.partial = append(output.partial, p...)
+	for {
+		i := bytes.IndexByte(output.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		output.log(output.partial[:i])
+		output.partial = output.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// flush logs the last line of the output, if it did not end with a new line
+func (output *hookOutput) flush() {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	if len(output.partial) > 0 {
+		output.log(output.partial)
+		output.partial = nil
+	}
+}
+
+func (output *hookOutput) log(line []byte) {
+	output.logger.Info("Hook output", "hook", output.hook, "stream", output.stream, "line", strings.TrimRight(string(line), "\r"))
+}
@@ -0,0 +1,230 @@
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// name of the lock file in a destination directory, which tells other instances the directory is mirrored into
+const lockFileName = ".directorymirror.lock"
+
+// a held lock file is refreshed periodically, so a lock file which was not refreshed for longer belongs to an instance which is gone (e.g.
+// one which ran on another host, whose process can not be checked)
+const (
+	lockRefreshInterval = time.Minute
+	lockStaleAge        = 10 * time.Minute
+)
+
+// lockFileContents is the JSON format of the lock file
+type lockFileContents struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Started  time.Time `json:"started"`
+}
+
+// heldLock is a lock file held by this process, which is shared by its jobs of the same destination directory (overlapping destinations of
+// jobs are warned about, rather than rejected)
+type heldLock struct {
+	jobs int
+	stop chan struct{}
+}
+
+// registry of the lock files held by this process, by their path
+var (
+	locksMutex sync.Mutex
+	heldLocks  = make(map[string]*heldLock)
+)
+
+// lockDestinations takes the lock of every local destination directory of the job, so other instances do not mirror into them at the same
+// time, and returns the function which releases them. a destination locked by another live instance is an error, unless the lock is broken
+// (with a warning)
+func lockDestinations(configs Config) (func(), error) {
+	// a remote destination (or one of a file system the job was given) is not locked, and a dry run does not touch the destination at all
+	if len(configs.General.DestinationURL) > 0 || configs.General.destination != nil || configs.General.DryRun {
+		return func() {}, nil
+	}
+
+	var paths []string
+	release := func() {
+		for _, path := range paths {
+			releaseLock(configs.General.logger, path)
+		}
+	}
+
+	for _, destDir := range configs.General.DestinationDirectories {
+		path := filepath.Join(destDir, lockFileName)
+		if err := acquireLock(configs.General.logger, path, configs.General.BreakLock); err != nil {
+			release()
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return release, nil
+}
+
+func acquireLock(logger *slog.Logger, path string, breakLock bool) error {
+	locksMutex.Lock()
+	defer locksMutex.Unlock()
+
+	// another job of this process holds the lock already
+	if held, exists := heldLocks[path]; exists {
+		held.jobs++
+		return nil
+	}
+
+	err := createLockFile(path)
+	if errors.Is(err, fs.ErrExist) {
+		owner, reason := getStaleLockReason(path)
+		if len(reason) > 0 {
+			logger.Warn("Removing stale lock", "path", path, "pid", owner.PID, "hostname", owner.Hostname, "reason", reason)
+		} else if breakLock {
+			logger.Warn("Breaking lock of another instance", "path", path, "pid", owner.PID, "hostname", owner.Hostname, "started", owner.Started)
+		} else {
+			return fmt.Errorf("Destination directory '%s' is locked by another instance (process %d on '%s', started %s), use --break-lock to override",
+				filepath.Dir(path), owner.PID, owner.Hostname, owner.Started.Format(time.RFC3339))
+		}
+
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		err = createLockFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	held := &heldLock{jobs: 1, stop: make(chan struct{})}
+	heldLocks[path] = held
+
+	// refresh the lock file while it is held, so other instances see it is not stale
+	go func() {
+		ticker := time.NewTicker(lockRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-held.stop:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				if err := os.Chtimes(path, now, now); err != nil {
+					logOperationError(logger, "Write", path, err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func releaseLock(logger *slog.Logger, path string) {
+	locksMutex.Lock()
+	defer locksMutex.Unlock()
+
+	held, exists := heldLocks[path]
+	if !exists {
+		return
+	}
+	if held.jobs--; held.jobs > 0 {
+		return
+	}
+
+	close(held.stop)
+	delete(heldLocks, path)
+
+	// a lock which was broken by another instance is theirs now
+	if owner, err := readLockFile(path); err != nil || !isOwnLock(owner) {
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logOperationError(logger, "Remove", path, err)
+	}
+}
+
+// createLockFile creates the lock file of this process, unless it exists already (creating the destination directory if missing)
+func createLockFile(path string) error {
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(lockFileContents{PID: os.Getpid(), Hostname: hostname, Started: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	if err := mkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+func readLockFile(path string) (lockFileContents, error) {
+	var owner lockFileContents
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return owner, err
+	}
+	err = json.Unmarshal(data, &owner)
+	return owner, err
+}
+
+// getStaleLockReason returns the owner of the existing lock file, and the reason it is stale (its process ended, or it was not refreshed
+// for long), or an empty reason if its owner is alive
+func getStaleLockReason(path string) (lockFileContents, string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		// removed meanwhile, so nothing holds it
+		return lockFileContents{}, "missing"
+	}
+
+	// a lock file which can not be read yet (e.g. is being written) is stale only once it is old
+	owner, err := readLockFile(path)
+	if age := time.Since(info.ModTime()); age > lockStaleAge {
+		return owner, fmt.Sprintf("not refreshed for %s", age.Round(time.Second))
+	}
+	if err != nil {
+		return owner, ""
+	}
+
+	// the process of an owner on this host is checked directly (a lock of this process which it does not hold is left over by a job)
+	if hostname, _ := os.Hostname(); owner.Hostname == hostname {
+		if isOwnLock(owner) {
+			return owner, "left over by this process"
+		}
+		if !isProcessAlive(owner.PID) {
+			return owner, "process ended"
+		}
+	}
+
+	return owner, ""
+}
+
+// isOwnLock reports whether the lock file was created by this process
+func isOwnLock(owner lockFileContents) bool {
+	hostname, _ := os.Hostname()
+	return owner.PID == os.Getpid() && owner.Hostname == hostname
+}
+
+// excludeLockFiles removes the lock file of the root directory from both containers, so the lock of a source which is itself a destination
+// of another instance is not mirrored, and the lock of the destination is not deleted
+func excludeLockFiles(srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) {
+	delete(srcFiles, lockFileName)
+	delete(destFiles, lockFileName)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package mirror
+
+import (
+	"os"
+)
+
+// openShared opens the file for reading, files are never locked against reading on other platforms
+func openShared(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+// isLockedFileError reports whether the error is caused by another process which locked the file, which never fails reading on other
+// platforms
+func isLockedFileError(err error) bool {
+	return false
+}
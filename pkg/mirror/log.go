@@ -0,0 +1,73 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// ParseLogLevel parses the name of a log level (debug/info/warn/error)
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+
+	return slog.LevelInfo, fmt.Errorf("unknown log level '%s'", name)
+}
+
+// logOperationError logs a failed operation, with the operation and path as fields
+func logOperationError(logger *slog.Logger, operation string, path string, err error) {
+	logger.Error("Operation failed", "operation", operation, "path", path, "error", err)
+}
+
+// isDebugEnabled reports whether debug lines are logged, so the reasons of decisions (which are logged for every file) are only worked out
+// when they are
+func isDebugEnabled(logger *slog.Logger) bool {
+	return logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// newLogger creates the logger of a job, every line is labeled with the job, since multiple jobs share the output
+func newLogger(configs Config) (*slog.Logger, error) {
+	// level is validated when the configuration is read
+	level, _ := ParseLogLevel(configs.General.LogLevel)
+	options := &slog.HandlerOptions{Level: level}
+
+	// log to the console, to a log file, or both
+	var writer io.Writer = os.Stdout
+	if len(configs.General.LogFile) > 0 {
+		logFile, err := getLogFile(configs.General.LogFile, configs.General.LogMaxSizeMB, configs.General.LogMaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("Error opening log file; %w", err)
+		}
+
+		if configs.General.LogConsole {
+			writer = io.MultiWriter(os.Stdout, logFile)
+		} else {
+			writer = logFile
+		}
+	}
+
+	var handler slog.Handler
+	if configs.General.LogFormat == logFormatJSON {
+		handler = slog.NewJSONHandler(writer, options)
+	} else {
+		handler = slog.NewTextHandler(writer, options)
+	}
+
+	return slog.New(handler).With("job", getJobName(configs)), nil
+}
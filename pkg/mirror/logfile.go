@@ -0,0 +1,97 @@
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a log file which is rotated once it exceeds its maximum size, it is safe for concurrent use
+type rotatingFile struct {
+	mutex sync.Mutex
+
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+// registry of open log files, by path, so jobs logging into the same file share it
+var (
+	logFilesMutex sync.Mutex
+	logFiles      = make(map[string]*rotatingFile)
+)
+
+// getLogFile returns the log file of the given path, opening it for append if it is not open yet
+func getLogFile(path string, maxSizeMB int, maxBackups int) (*rotatingFile, error) {
+	logFilesMutex.Lock()
+	defer logFilesMutex.Unlock()
+
+	if logFile, exists := logFiles[path]; exists {
+		return logFile, nil
+	}
+
+	logFile := &rotatingFile{path: path, maxSize: int64(maxSizeMB) * 1024 * 1024, maxBackups: maxBackups}
+	if err := logFile.open(); err != nil {
+		return nil, err
+	}
+
+	logFiles[path] = logFile
+	return logFile, nil
+}
+
+func (logFile *rotatingFile) open() error {
+	file, err := os.OpenFile(logFile.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	logFile.file = file
+	logFile.size = info.Size()
+	return nil
+}
+
+func (logFile *rotatingFile) Write(p []byte) (int, error) {
+	logFile.mutex.Lock()
+	defer logFile.mutex.Unlock()
+
+	// rotate before the file exceeds its maximum size (0 to disable)
+	if logFile.maxSize > 0 && logFile.size > 0 && logFile.size+int64(len(p)) > logFile.maxSize {
+		if err := logFile.rotate(); err != nil {
+			// keep logging into the current file, rather than losing the line
+			fmt.Fprintf(os.Stderr, "Error rotating log file '%s'; %s\n", logFile.path, err)
+		}
+	}
+
+	n, err := logFile.file.Write(p)
+	logFile.size += int64(n)
+
+	return n, err
+}
+
+func (logFile *rotatingFile) rotate() error {
+	if err := logFile.file.Close(); err != nil {
+		return err
+	}
+
+	// shift the backups (path.1 is the most recent), the oldest one is dropped
+	if logFile.maxBackups < 1 {
+		os.Remove(logFile.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", logFile.path, logFile.maxBackups))
+		for i := logFile.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", logFile.path, i), fmt.Sprintf("%s.%d", logFile.path, i+1))
+		}
+		os.Rename(logFile.path, logFile.path+".1")
+	}
+
+	return logFile.open()
+}
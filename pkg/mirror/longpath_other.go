@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package mirror
+
+import (
+	"os"
+)
+
+// toExtendedLengthPath returns the path as it is, paths are not limited in length on other platforms
+func toExtendedLengthPath(path string) string {
+	return path
+}
+
+// mkdirAll creates the directory along with any missing parents
+func mkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixes of extended-length paths, which are not limited to MAX_PATH (260 characters) and are passed to the file system as they are
+const (
+	extendedLengthPrefix    = `\\?\`
+	extendedLengthUNCPrefix = `\\?\UNC\`
+)
+
+// toExtendedLengthPath returns the extended-length form of the absolute path (`\\?\C:\dir` for a drive path, `\\?\UNC\server\share\dir` for
+// a UNC path), so deep trees under it can be mirrored. other paths (e.g. relative ones, or ones in that form already) are kept as they are
+func toExtendedLengthPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, extendedLengthPrefix) || strings.HasPrefix(path, `\\.\`):
+		return path
+	case strings.HasPrefix(path, `\\`):
+		return extendedLengthUNCPrefix + strings.TrimPrefix(filepath.Clean(path), `\\`)
+	case filepath.IsAbs(path):
+		return extendedLengthPrefix + filepath.Clean(path)
+	}
+	return path
+}
+
+// mkdirAll creates the directory along with any missing parents, like os.MkdirAll, yet the volume of the path (a drive or a share, whose
+// root is a directory of another path segment when it is a share) is never created
+func mkdirAll(path string, perm os.FileMode) error {
+	info, err := os.Stat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: path, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+
+	// the root of the volume is not created, it is unavailable
+	volume := filepath.VolumeName(path)
+	if len(strings.Trim(path[len(volume):], `\`)) < 1 {
+		return err
+	}
+
+	if err := mkdirAll(filepath.Dir(path), perm); err != nil {
+		return err
+	}
+	if err := os.Mkdir(path, perm); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestToExtendedLengthPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: `C:\data\src`, expected: `\\?\C:\data\src`},
+		{path: `C:\data\src\..\other\`, expected: `\\?\C:\data\other`},
+		{path: `\\fileserver\share\team`, expected: `\\?\UNC\fileserver\share\team`},
+		{path: `\\fileserver\share\team\`, expected: `\\?\UNC\fileserver\share\team`},
+		{path: `\\fileserver\share`, expected: `\\?\UNC\fileserver\share`},
+		// paths in the extended-length or device form already are kept as they are
+		{path: `\\?\C:\data\src`, expected: `\\?\C:\data\src`},
+		{path: `\\?\UNC\fileserver\share\team`, expected: `\\?\UNC\fileserver\share\team`},
+		{path: `\\.\PhysicalDrive0`, expected: `\\.\PhysicalDrive0`},
+		// so are relative paths
+		{path: `data\src`, expected: `data\src`},
+	}
+
+	for _, test := range tests {
+		if extended := toExtendedLengthPath(test.path); extended != test.expected {
+			t.Errorf("toExtendedLengthPath(%q) = %q, expected %q", test.path, extended, test.expected)
+		}
+	}
+}
+
+func TestGetRelativeUNCPath(t *testing.T) {
+	rootDir := normalizeDirectory(`\\fileserver\share\team`)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: `\\?\UNC\fileserver\share\team\docs\a.txt`, expected: `docs\a.txt`},
+		{path: `\\?\UNC\fileserver\share\team`, expected: ""},
+	}
+
+	for _, test := range tests {
+		if relativePath := getRelativePath(rootDir, test.path); relativePath != test.expected {
+			t.Errorf("getRelativePath(%q, %q) = %q, expected %q", rootDir, test.path, relativePath, test.expected)
+		}
+	}
+}
+
+func TestMkdirAllNeverCreatesShareRoot(t *testing.T) {
+	// the share of a server which does not exist can not be created, so its error is returned rather than an attempt to create it
+	err := mkdirAll(`\\?\UNC\mirror-test-missing-server\share\dir`, 0755)
+	if err == nil {
+		t.Fatal("mkdirAll() created a directory of a missing share")
+	}
+	if errors.Is(err, os.ErrExist) {
+		t.Errorf("mkdirAll() returned %v, expected the share to be unavailable", err)
+	}
+}
+
+// longTestPath returns a path under the root which is longer than MAX_PATH (260 characters)
+func longTestPath(root string) string {
+	segment := strings.Repeat("d", 50)
+
+	path := root
+	for len(path) <= 300 {
+		path = filepath.Join(path, segment)
+	}
+	return path
+}
+
+func TestMkdirAllLongPath(t *testing.T) {
+	dir := longTestPath(normalizeDirectory(t.TempDir()))
+
+	if err := mkdirAll(dir, 0755); err != nil {
+		t.Fatalf("mkdirAll() of a %d characters path failed; %s", len(dir), err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("directory of a %d characters path is missing (%v)", len(dir), err)
+	}
+	// creating it again is harmless
+	if err := mkdirAll(dir, 0755); err != nil {
+		t.Errorf("mkdirAll() of an existing directory failed; %s", err)
+	}
+}
+
+func TestSyncLongPaths(t *testing.T) {
+	root := normalizeDirectory(t.TempDir())
+	source := filepath.Join(root, "source")
+	destination := filepath.Join(root, "backup")
+
+	srcDir := longTestPath(source)
+	if err := mkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(srcDir, "file.txt"), "deep")
+	relativePath := getRelativePath(source, filepath.Join(srcDir, "file.txt"))
+
+	// the directories are configured in their usual form, which is longer than MAX_PATH below them
+	mirror := newTestMirror(t, strings.TrimPrefix(source, extendedLengthPrefix), strings.TrimPrefix(destination, extendedLengthPrefix), nil)
+	mustSyncOnce(t, mirror)
+
+	if data, err := os.ReadFile(filepath.Join(destination, relativePath)); err != nil || string(data) != "deep" {
+		t.Errorf("destination file of a %d characters path has '%s' (%v), expected 'deep'", len(destination)+len(relativePath), data, err)
+	}
+	if summary := mustSyncOnce(t, mirror); summary.FilesCopied != 0 {
+		t.Errorf("second iteration copied %d files, expected none", summary.FilesCopied)
+	}
+}
@@ -0,0 +1,362 @@
+package mirror
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// name of the manifest file in the root of a destination directory, which lists the hash, size and modification time of every mirrored file
+// (the name is kept whatever the hash algorithm, which the header names)
+const manifestFileName = "MANIFEST.sha256"
+
+// format of the first line of the manifest file, which describes the columns of the lines that follow (starting with the hash algorithm)
+const manifestHeaderFormat = "# %s size modTime path"
+
+// statuses of the mismatches found by verifying a manifest
+const (
+	ManifestStatusMissing  = "missing"
+	ManifestStatusModified = "modified"
+	ManifestStatusError    = "error"
+)
+
+// ManifestMismatch is a file of a destination directory which does not match its manifest
+type ManifestMismatch struct {
+	// relative path of the file, as listed in the manifest
+	Path   string
+	Status string
+	// how the file differs (or the error reading it)
+	Reason string
+}
+
+type manifestEntry struct {
+	hash    string
+	size    int64
+	modTime time.Time
+}
+
+// manifestWriter keeps the manifest of every destination directory of the job, which the operations report their results to. the manifests
+// are written once every iteration ended
+type manifestWriter struct {
+	mutex sync.Mutex
+	// hash algorithm of the entries
+	algorithm string
+	// entries of every destination directory, by the relative path of the file
+	entries map[string]map[string]manifestEntry
+	// entries which were seen by the current iteration, entries a full scan did not see are gone from the destination
+	used map[string]map[string]bool
+	// destination directories whose manifest changed since it was written
+	dirty map[string]bool
+}
+
+// loadManifests reads the manifests of the destination directories, or returns nil if manifests are not written. a manifest which can not
+// be read (or whose hash algorithm is not the configured one) is rebuilt, by hashing the files of its destination directory again
+func loadManifests(configs Config) *manifestWriter {
+	if !configs.General.WriteManifest {
+		return nil
+	}
+
+	manifests := &manifestWriter{
+		algorithm: configs.General.ManifestHashAlgorithm,
+		entries:   make(map[string]map[string]manifestEntry),
+		used:      make(map[string]map[string]bool),
+		dirty:     make(map[string]bool),
+	}
+
+	for _, destDir := range configs.General.DestinationDirectories {
+		path := filepath.Join(destDir, manifestFileName)
+
+		entries, algorithm, err := readManifestFile(path)
+		if err == nil && algorithm != manifests.algorithm {
+			configs.General.logger.Info("Manifest hash algorithm changed, hashing all files", "path", path, "algorithm", algorithm)
+			entries = make(map[string]manifestEntry)
+			manifests.dirty[destDir] = true
+		} else if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				configs.General.logger.Warn("Manifest can not be read, hashing all files", "path", path, "error", err)
+			}
+			entries = make(map[string]manifestEntry)
+		}
+
+		manifests.entries[destDir] = entries
+		manifests.used[destDir] = make(map[string]bool)
+	}
+
+	return manifests
+}
+
+// isListed reports whether the destination file is listed by the manifest as it is (so it needs no hashing), marking it as seen
+func (manifests *manifestWriter) isListed(configs Config, relativePath string, destFile os.FileInfo) bool {
+	if manifests == nil {
+		return true
+	}
+
+	manifests.mutex.Lock()
+	defer manifests.mutex.Unlock()
+
+	destDir := configs.General.DestinationDirectory
+	relativePath = normalizeRelativePath(relativePath)
+
+	entry, exists := manifests.entries[destDir][relativePath]
+	if !exists || entry.size != destFile.Size() || !entry.modTime.Equal(destFile.ModTime()) {
+		return false
+	}
+
+	manifests.used[destDir][relativePath] = true
+	return true
+}
+
+// keep lists the unchanged destination file, hashing it unless it is listed already
+func (manifests *manifestWriter) keep(configs Config, path string, destFile os.FileInfo) {
+	if manifests == nil || configs.General.DryRun || manifests.isListed(configs, getRelativePath(configs.General.DestinationDirectory, path), destFile) {
+		return
+	}
+
+	manifests.record(configs, path, nil)
+}
+
+// record lists the destination file as it was written, by the hash of its contents (nil to hash the written file). a file which can not be
+// listed is warned about, and listed by a later iteration (the file itself was mirrored, so it is not a failure)
+func (manifests *manifestWriter) record(configs Config, path string, contentsHash hash.Hash) {
+	if manifests == nil || configs.General.DryRun {
+		return
+	}
+
+	file, err := configs.General.destination.Lstat(path)
+	var sum []byte
+	if err == nil && contentsHash != nil {
+		sum = contentsHash.Sum(nil)
+	} else if err == nil {
+		sum, err = hashFile(configs.General.destination, path, manifests.algorithm)
+	}
+	if err != nil {
+		configs.General.logger.Warn("Manifest entry can not be recorded", "path", path, "error", err)
+		return
+	}
+
+	manifests.mutex.Lock()
+	defer manifests.mutex.Unlock()
+
+	destDir := configs.General.DestinationDirectory
+	relativePath := normalizeRelativePath(getRelativePath(destDir, path))
+
+	manifests.entries[destDir][relativePath] = manifestEntry{hash: hex.EncodeToString(sum), size: file.Size(), modTime: file.ModTime()}
+	manifests.used[destDir][relativePath] = true
+	manifests.dirty[destDir] = true
+}
+
+// rename lists the destination file which was moved by the entry of its old path, hashing it if the old path was not listed
+func (manifests *manifestWriter) rename(configs Config, oldPath string, path string) {
+	if manifests == nil || configs.General.DryRun {
+		return
+	}
+
+	destDir := configs.General.DestinationDirectory
+	oldRelativePath := normalizeRelativePath(getRelativePath(destDir, oldPath))
+
+	manifests.mutex.Lock()
+	entry, exists := manifests.entries[destDir][oldRelativePath]
+	manifests.mutex.Unlock()
+
+	manifests.forget(configs, oldPath)
+	if !exists {
+		manifests.record(configs, path, nil)
+		return
+	}
+
+	// a move keeps the contents, yet the permissions could have changed along with it (which changes no listed detail)
+	file, err := configs.General.destination.Lstat(path)
+	if err != nil {
+		configs.General.logger.Warn("Manifest entry can not be recorded", "path", path, "error", err)
+		return
+	}
+	entry.size, entry.modTime = file.Size(), file.ModTime()
+
+	manifests.mutex.Lock()
+	defer manifests.mutex.Unlock()
+
+	relativePath := normalizeRelativePath(getRelativePath(destDir, path))
+	manifests.entries[destDir][relativePath] = entry
+	manifests.used[destDir][relativePath] = true
+	manifests.dirty[destDir] = true
+}
+
+// forget removes the deleted destination path (and anything listed under it) from the manifest
+func (manifests *manifestWriter) forget(configs Config, path string) {
+	if manifests == nil || configs.General.DryRun {
+		return
+	}
+
+	manifests.mutex.Lock()
+	defer manifests.mutex.Unlock()
+
+	destDir := configs.General.DestinationDirectory
+	relativePath := normalizeRelativePath(getRelativePath(destDir, path))
+
+	for listedPath := range manifests.entries[destDir] {
+		if listedPath == relativePath || strings.HasPrefix(listedPath, relativePath+"/") {
+			delete(manifests.entries[destDir], listedPath)
+			manifests.dirty[destDir] = true
+		}
+	}
+}
+
+// save writes the manifest of the destination directory, if it changed. once a full scan ended, entries it did not see are dropped, since
+// their files are gone from the destination (or are no longer mirrored)
+func (manifests *manifestWriter) save(configs Config, fullScan bool) {
+	if manifests == nil || configs.General.DryRun {
+		return
+	}
+
+	manifests.mutex.Lock()
+	defer manifests.mutex.Unlock()
+
+	destDir := configs.General.DestinationDirectory
+	if fullScan {
+		for relativePath := range manifests.entries[destDir] {
+			if !manifests.used[destDir][relativePath] {
+				delete(manifests.entries[destDir], relativePath)
+				manifests.dirty[destDir] = true
+			}
+		}
+		manifests.used[destDir] = make(map[string]bool)
+	}
+
+	if !manifests.dirty[destDir] {
+		return
+	}
+
+	path := filepath.Join(destDir, manifestFileName)
+	if err := writeFileAtomic(path, formatManifest(manifests.entries[destDir], manifests.algorithm)); err != nil {
+		logOperationError(configs.General.logger, "Write", path, err)
+		return
+	}
+
+	manifests.dirty[destDir] = false
+}
+
+// formatManifest returns the contents of a manifest file listing the entries of the hash algorithm, sorted by path
+func formatManifest(entries map[string]manifestEntry, algorithm string) []byte {
+	paths := make([]string, 0, len(entries))
+	for relativePath := range entries {
+		paths = append(paths, relativePath)
+	}
+	sort.Strings(paths)
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, manifestHeaderFormat+"\n", algorithm)
+	for _, relativePath := range paths {
+		entry := entries[relativePath]
+		fmt.Fprintf(&builder, "%s %d %s %s\n", entry.hash, entry.size, entry.modTime.UTC().Format(time.RFC3339Nano), relativePath)
+	}
+
+	return []byte(builder.String())
+}
+
+// readManifestFile returns the entries listed by a manifest file by their relative path, and the hash algorithm its header names (a manifest
+// without a header holds SHA-256 hashes, as did every manifest before the algorithm was configurable)
+func readManifestFile(path string) (map[string]manifestEntry, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	entries := make(map[string]manifestEntry)
+	algorithm := hashAlgorithmSHA256
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if line == 1 && strings.HasPrefix(text, "#") {
+			if fields := strings.Fields(strings.TrimPrefix(text, "#")); len(fields) > 0 {
+				algorithm = fields[0]
+			}
+		}
+		if len(text) < 1 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// the path is the last column, so it may hold spaces
+		fields := strings.SplitN(text, " ", 4)
+		if len(fields) != 4 {
+			return nil, "", fmt.Errorf("line %d is malformed", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d has an invalid size; %w", line, err)
+		}
+		modTime, err := time.Parse(time.RFC3339Nano, fields[2])
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d has an invalid modification time; %w", line, err)
+		}
+
+		entries[fields[3]] = manifestEntry{hash: fields[0], size: size, modTime: modTime}
+	}
+
+	return entries, algorithm, scanner.Err()
+}
+
+// excludeManifestFile removes the manifest file of the root directory from the source files, so a manifest of the source never replaces
+// the manifest of the destination (the destination one is an internal path)
+func excludeManifestFile(configs Config, srcFiles map[string]os.FileInfo) {
+	if configs.General.WriteManifest {
+		delete(srcFiles, manifestFileName)
+	}
+}
+
+// VerifyManifest hashes the files listed by the manifest of the destination directory again, without touching anything, and returns the
+// number of verified files and the mismatches found sorted by path. an error is returned if the manifest can not be read
+func VerifyManifest(directory string) (int, []ManifestMismatch, error) {
+	entries, algorithm, err := readManifestFile(filepath.Join(directory, manifestFileName))
+	if err != nil {
+		return 0, nil, fmt.Errorf("Manifest of '%s' can not be read; %w", directory, err)
+	}
+	if !isHashAlgorithm(algorithm) {
+		return 0, nil, fmt.Errorf("Manifest of '%s' has an unknown hash algorithm '%s'", directory, algorithm)
+	}
+
+	var mismatches []ManifestMismatch
+	for relativePath, entry := range entries {
+		path := filepath.Join(directory, filepath.FromSlash(relativePath))
+
+		file, err := os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			mismatches = append(mismatches, ManifestMismatch{Path: relativePath, Status: ManifestStatusMissing})
+			continue
+		}
+		if err == nil && !file.Mode().IsRegular() {
+			err = errors.New("not a regular file")
+		}
+		var sum []byte
+		if err == nil {
+			sum, err = hashFile(localFS{}, path, algorithm)
+		}
+		if err != nil {
+			mismatches = append(mismatches, ManifestMismatch{Path: relativePath, Status: ManifestStatusError, Reason: err.Error()})
+			continue
+		}
+
+		if file.Size() != entry.size {
+			mismatches = append(mismatches, ManifestMismatch{Path: relativePath, Status: ManifestStatusModified, Reason: fmt.Sprintf("size %d differs from %d", file.Size(), entry.size)})
+		} else if hex.EncodeToString(sum) != entry.hash {
+			mismatches = append(mismatches, ManifestMismatch{Path: relativePath, Status: ManifestStatusModified, Reason: "contents differ"})
+		}
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Path < mismatches[j].Path
+	})
+
+	return len(entries), mismatches, nil
+}
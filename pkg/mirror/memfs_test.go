@@ -0,0 +1,477 @@
+package mirror
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memFS is an in-memory file system, which the source and the destinations of a job are read and written through in tests. failures of
+// its operations are injected by fail
+type memFS struct {
+	mutex sync.Mutex
+	nodes map[string]*memNode
+	// errors returned by operations, by the operation and the path
+	failures map[string]error
+}
+
+// memNode is a file or a directory of the in-memory file system
+type memNode struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// operations of the in-memory file system which failures are injected into. a read failure is returned by the reads of an opened file, and a
+// write failure by the writes of a created one
+const (
+	memOpStat    = "stat"
+	memOpReadDir = "readdir"
+	memOpOpen    = "open"
+	memOpRead    = "read"
+	memOpCreate  = "create"
+	memOpWrite   = "write"
+	memOpRemove  = "remove"
+	memOpMkdir   = "mkdir"
+	memOpChmod   = "chmod"
+	memOpChtimes = "chtimes"
+	memOpRename  = "rename"
+)
+
+func newMemFS(roots ...string) *memFS {
+	fsys := &memFS{nodes: make(map[string]*memNode), failures: make(map[string]error)}
+	for _, root := range roots {
+		if err := fsys.MkdirAll(root, 0755); err != nil {
+			panic(err)
+		}
+	}
+	return fsys
+}
+
+// fail makes the operation on the path return the error, until it is cleared by a nil error
+func (fsys *memFS) fail(operation string, path string, err error) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	key := operation + " " + filepath.Clean(path)
+	if err == nil {
+		delete(fsys.failures, key)
+	} else {
+		fsys.failures[key] = err
+	}
+}
+
+func (fsys *memFS) getFailure(operation string, path string) error {
+	if err := fsys.failures[operation+" "+path]; err != nil {
+		return &fs.PathError{Op: operation, Path: path, Err: err}
+	}
+	return nil
+}
+
+// writeFile creates the file (and its missing parent directories) with the contents and modification time
+func (fsys *memFS) writeFile(path string, data string, modTime time.Time) {
+	if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		panic(err)
+	}
+
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	fsys.nodes[filepath.Clean(path)] = &memNode{data: []byte(data), mode: 0644, modTime: modTime}
+}
+
+// readFile returns the contents of the file, or an error if it is missing or not a file
+func (fsys *memFS) readFile(path string) (string, error) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	node, exists := fsys.nodes[filepath.Clean(path)]
+	if !exists {
+		return "", &fs.PathError{Op: memOpOpen, Path: path, Err: fs.ErrNotExist}
+	}
+	if node.mode.IsDir() {
+		return "", fmt.Errorf("'%s' is a directory", path)
+	}
+	return string(node.data), nil
+}
+
+// tree returns the paths under the root (directories with a trailing slash) with the contents of files, sorted by path
+func (fsys *memFS) tree(root string) []string {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	root = filepath.Clean(root)
+
+	var paths []string
+	for path, node := range fsys.nodes {
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil || relativePath == "." || strings.HasPrefix(relativePath, "..") {
+			continue
+		}
+
+		relativePath = filepath.ToSlash(relativePath)
+		if node.mode.IsDir() {
+			paths = append(paths, relativePath+"/")
+		} else {
+			paths = append(paths, relativePath+"="+string(node.data))
+		}
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// setMode changes the mode of the path directly, regardless of injected failures
+func (fsys *memFS) setMode(path string, perm os.FileMode) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	node := fsys.nodes[filepath.Clean(path)]
+	node.mode = node.mode&os.ModeType | perm
+}
+
+func (fsys *memFS) Connect() error {
+	return nil
+}
+
+func (fsys *memFS) Stat(path string) (os.FileInfo, error) {
+	return fsys.Lstat(path)
+}
+
+func (fsys *memFS) Lstat(path string) (os.FileInfo, error) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	if err := fsys.getFailure(memOpStat, path); err != nil {
+		return nil, err
+	}
+
+	node, exists := fsys.nodes[path]
+	if !exists {
+		return nil, &fs.PathError{Op: memOpStat, Path: path, Err: fs.ErrNotExist}
+	}
+	return node.info(filepath.Base(path)), nil
+}
+
+func (fsys *memFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	if err := fsys.getFailure(memOpReadDir, path); err != nil {
+		return nil, err
+	}
+
+	node, exists := fsys.nodes[path]
+	if !exists {
+		return nil, &fs.PathError{Op: memOpReadDir, Path: path, Err: fs.ErrNotExist}
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: memOpReadDir, Path: path, Err: errors.New("not a directory")}
+	}
+	if node.mode.Perm()&0400 == 0 {
+		return nil, &fs.PathError{Op: memOpReadDir, Path: path, Err: fs.ErrPermission}
+	}
+
+	var entries []fs.DirEntry
+	for childPath, child := range fsys.nodes {
+		if childPath != path && filepath.Dir(childPath) == path {
+			entries = append(entries, memDirEntry{DirEntry: fs.FileInfoToDirEntry(child.info(filepath.Base(childPath))), fsys: fsys, path: childPath})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// memDirEntry is an entry of a listed directory, whose info is read once asked for (as os does), so it fails when reading it fails
+type memDirEntry struct {
+	fs.DirEntry
+	fsys *memFS
+	path string
+}
+
+func (entry memDirEntry) Info() (fs.FileInfo, error) {
+	return entry.fsys.Lstat(entry.path)
+}
+
+func (fsys *memFS) Open(path string) (io.ReadCloser, error) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	if err := fsys.getFailure(memOpOpen, path); err != nil {
+		return nil, err
+	}
+
+	node, exists := fsys.nodes[path]
+	if !exists {
+		return nil, &fs.PathError{Op: memOpOpen, Path: path, Err: fs.ErrNotExist}
+	}
+	if node.mode.Perm()&0400 == 0 {
+		return nil, &fs.PathError{Op: memOpOpen, Path: path, Err: fs.ErrPermission}
+	}
+
+	return &memReader{reader: bytes.NewReader(bytes.Clone(node.data)), err: fsys.getFailure(memOpRead, path)}, nil
+}
+
+func (fsys *memFS) Create(path string, srcFile os.FileInfo) (destinationFile, error) {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	if err := fsys.getFailure(memOpCreate, path); err != nil {
+		return nil, err
+	}
+	if err := fsys.checkWritableParent(memOpCreate, path); err != nil {
+		return nil, err
+	}
+	if node, exists := fsys.nodes[path]; exists && node.mode.IsDir() {
+		return nil, &fs.PathError{Op: memOpCreate, Path: path, Err: errors.New("is a directory")}
+	}
+
+	node := &memNode{mode: 0644, modTime: time.Now()}
+	fsys.nodes[path] = node
+
+	return &memWriter{fsys: fsys, node: node, err: fsys.getFailure(memOpWrite, path)}, nil
+}
+
+// checkWritableParent makes sure the parent directory of the path exists and can be written
+func (fsys *memFS) checkWritableParent(operation string, path string) error {
+	parent, exists := fsys.nodes[filepath.Dir(path)]
+	if !exists {
+		return &fs.PathError{Op: operation, Path: path, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &fs.PathError{Op: operation, Path: path, Err: errors.New("not a directory")}
+	}
+	if parent.mode.Perm()&0200 == 0 {
+		return &fs.PathError{Op: operation, Path: path, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (fsys *memFS) Remove(path string) error {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	if err := fsys.getFailure(memOpRemove, path); err != nil {
+		return err
+	}
+	if _, exists := fsys.nodes[path]; !exists {
+		return &fs.PathError{Op: memOpRemove, Path: path, Err: fs.ErrNotExist}
+	}
+	if err := fsys.checkWritableParent(memOpRemove, path); err != nil {
+		return err
+	}
+	for childPath := range fsys.nodes {
+		if filepath.Dir(childPath) == path && childPath != path {
+			return &fs.PathError{Op: memOpRemove, Path: path, Err: errors.New("directory not empty")}
+		}
+	}
+
+	delete(fsys.nodes, path)
+	return nil
+}
+
+func (fsys *memFS) RemoveAll(path string) error {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	if err := fsys.getFailure(memOpRemove, path); err != nil {
+		return err
+	}
+	if _, exists := fsys.nodes[path]; !exists {
+		return nil
+	}
+	if err := fsys.checkWritableParent(memOpRemove, path); err != nil {
+		return err
+	}
+
+	for childPath := range fsys.nodes {
+		if childPath == path || isSubPath(path, childPath) {
+			delete(fsys.nodes, childPath)
+		}
+	}
+	return nil
+}
+
+func (fsys *memFS) MkdirAll(path string, perm os.FileMode) error {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	if node, exists := fsys.nodes[path]; exists {
+		if !node.mode.IsDir() {
+			return &fs.PathError{Op: memOpMkdir, Path: path, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+	if err := fsys.getFailure(memOpMkdir, path); err != nil {
+		return err
+	}
+
+	// the parents are created first, the root of the file system exists always
+	if parent := filepath.Dir(path); parent != path {
+		fsys.mutex.Unlock()
+		err := fsys.MkdirAll(parent, perm)
+		fsys.mutex.Lock()
+		if err != nil {
+			return err
+		}
+		if err := fsys.checkWritableParent(memOpMkdir, path); err != nil {
+			return err
+		}
+	}
+
+	fsys.nodes[path] = &memNode{mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (fsys *memFS) Chmod(path string, mode os.FileMode) error {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	if err := fsys.getFailure(memOpChmod, path); err != nil {
+		return err
+	}
+
+	node, exists := fsys.nodes[path]
+	if !exists {
+		return &fs.PathError{Op: memOpChmod, Path: path, Err: fs.ErrNotExist}
+	}
+	node.mode = node.mode&os.ModeType | mode.Perm()
+	return nil
+}
+
+func (fsys *memFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	if err := fsys.getFailure(memOpChtimes, path); err != nil {
+		return err
+	}
+
+	node, exists := fsys.nodes[path]
+	if !exists {
+		return &fs.PathError{Op: memOpChtimes, Path: path, Err: fs.ErrNotExist}
+	}
+	node.modTime = mtime
+	return nil
+}
+
+func (fsys *memFS) Rename(oldPath string, newPath string) error {
+	fsys.mutex.Lock()
+	defer fsys.mutex.Unlock()
+
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	if err := fsys.getFailure(memOpRename, oldPath); err != nil {
+		return err
+	}
+	if _, exists := fsys.nodes[oldPath]; !exists {
+		return &fs.PathError{Op: memOpRename, Path: oldPath, Err: fs.ErrNotExist}
+	}
+	if err := fsys.checkWritableParent(memOpRename, newPath); err != nil {
+		return err
+	}
+
+	// a directory is moved along with its subtree
+	for path, node := range fsys.nodes {
+		if path == oldPath || isSubPath(oldPath, path) {
+			delete(fsys.nodes, path)
+			fsys.nodes[newPath+strings.TrimPrefix(path, oldPath)] = node
+		}
+	}
+	return nil
+}
+
+func (fsys *memFS) ModTimeGranularity() time.Duration {
+	return 0
+}
+
+func (fsys *memFS) Close() error {
+	return nil
+}
+
+func (node *memNode) info(name string) os.FileInfo {
+	return remoteFileInfo{name: name, size: int64(len(node.data)), mode: node.mode, modTime: node.modTime}
+}
+
+// memReader reads an opened file of the in-memory file system, failing with the injected error (if any) instead
+type memReader struct {
+	reader *bytes.Reader
+	err    error
+}
+
+func (reader *memReader) Read(p []byte) (int, error) {
+	if reader.err != nil {
+		return 0, reader.err
+	}
+	return reader.reader.Read(p)
+}
+
+func (reader *memReader) Close() error {
+	return nil
+}
+
+// memWriter writes a created file of the in-memory file system, failing with the injected error (if any) instead
+type memWriter struct {
+	fsys   *memFS
+	node   *memNode
+	offset int64
+	err    error
+}
+
+func (writer *memWriter) Write(p []byte) (int, error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+
+	writer.fsys.mutex.Lock()
+	defer writer.fsys.mutex.Unlock()
+
+	if end := writer.offset + int64(len(p)); end > int64(len(writer.node.data)) {
+		writer.node.data = append(writer.node.data, make([]byte, end-int64(len(writer.node.data)))...)
+	}
+	copy(writer.node.data[writer.offset:], p)
+	writer.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (writer *memWriter) Seek(offset int64, whence int) (int64, error) {
+	writer.fsys.mutex.Lock()
+	defer writer.fsys.mutex.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+		writer.offset = offset
+	case io.SeekCurrent:
+		writer.offset += offset
+	case io.SeekEnd:
+		writer.offset = int64(len(writer.node.data)) + offset
+	}
+	return writer.offset, nil
+}
+
+func (writer *memWriter) Sync() error {
+	return nil
+}
+
+func (writer *memWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,236 @@
+package mirror
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// jobMetrics holds the metrics of a single mirror job, which are updated concurrently by the operations
+type jobMetrics struct {
+	filesCopied   int64
+	bytesCopied   int64
+	bytesVerified int64
+	filesMoved    int64
+	filesDeleted  int64
+	errors        int64
+	queueDepth    int64
+
+	// float values, stored as bits so they can be updated atomically
+	lastIterationSeconds uint64
+	lastSuccessTimestamp uint64
+
+	// durations of the phases of all iterations, and of the last iteration
+	srcScanDuration      int64
+	destScanDuration     int64
+	planDuration         int64
+	transferDuration     int64
+	lastSrcScanDuration  int64
+	lastDestScanDuration int64
+	lastPlanDuration     int64
+	lastTransferDuration int64
+	// throughput and largest file copied of the last iteration
+	lastBytesPerSecond   int64
+	lastLargestFileBytes int64
+}
+
+// registry of metrics of all jobs, by job name
+var (
+	metricsMutex sync.Mutex
+	metricsJobs  = make(map[string]*jobMetrics)
+)
+
+// registerJobMetrics returns the metrics of the job, or nil if metrics are not enabled for it
+func registerJobMetrics(configs Config) *jobMetrics {
+	if len(configs.General.MetricsListenAddr) < 1 {
+		return nil
+	}
+
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	// jobs of the same name share their metrics
+	name := getJobName(configs)
+	if _, exists := metricsJobs[name]; !exists {
+		metricsJobs[name] = &jobMetrics{}
+	}
+
+	return metricsJobs[name]
+}
+
+// recordIteration adds the counters of an ended iteration
+func (metrics *jobMetrics) recordIteration(stats *iterationStats, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+
+	atomic.AddInt64(&metrics.filesCopied, stats.filesCopied)
+	atomic.AddInt64(&metrics.bytesCopied, stats.bytesCopied)
+	atomic.AddInt64(&metrics.bytesVerified, stats.bytesVerified)
+	atomic.AddInt64(&metrics.filesMoved, stats.filesMoved)
+	atomic.AddInt64(&metrics.filesDeleted, stats.filesDeleted)
+	atomic.AddInt64(&metrics.errors, stats.filesFailed)
+
+	atomic.AddInt64(&metrics.srcScanDuration, int64(stats.srcScanDuration))
+	atomic.AddInt64(&metrics.destScanDuration, int64(stats.destScanDuration))
+	atomic.AddInt64(&metrics.planDuration, int64(stats.planDuration))
+	atomic.AddInt64(&metrics.transferDuration, int64(stats.transferDuration))
+	atomic.StoreInt64(&metrics.lastSrcScanDuration, int64(stats.srcScanDuration))
+	atomic.StoreInt64(&metrics.lastDestScanDuration, int64(stats.destScanDuration))
+	atomic.StoreInt64(&metrics.lastPlanDuration, int64(stats.planDuration))
+	atomic.StoreInt64(&metrics.lastTransferDuration, int64(stats.transferDuration))
+	atomic.StoreInt64(&metrics.lastBytesPerSecond, stats.getBytesPerSecond())
+	atomic.StoreInt64(&metrics.lastLargestFileBytes, stats.largestFileBytes)
+
+	atomic.StoreUint64(&metrics.lastIterationSeconds, math.Float64bits(duration.Seconds()))
+	if stats.filesFailed < 1 {
+		atomic.StoreUint64(&metrics.lastSuccessTimestamp, math.Float64bits(float64(time.Now().UnixNano())/float64(time.Second)))
+	}
+}
+
+// addQueued updates the count of operations waiting to run or running
+func (metrics *jobMetrics) addQueued(count int64) {
+	if metrics == nil {
+		return
+	}
+
+	atomic.AddInt64(&metrics.queueDepth, count)
+}
+
+// startMetricsServers starts an HTTP server exposing /metrics for every distinct listen address of the configurations
+func startMetricsServers(configs []Config) error {
+	started := make(map[string]bool)
+
+	for _, config := range configs {
+		addr := config.General.MetricsListenAddr
+		if len(addr) < 1 || started[addr] {
+			continue
+		}
+		started[addr] = true
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("Error starting metrics server; %w", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", serveMetrics)
+
+		go func(addr string) {
+			if err := http.Serve(listener, mux); err != nil {
+				slog.Error("Metrics server failed", "address", addr, "error", err)
+			}
+		}(addr)
+
+		slog.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", listener.Addr()))
+	}
+	return nil
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsMutex.Lock()
+	names := make([]string, 0, len(metricsJobs))
+	for name := range metricsJobs {
+		names = append(names, name)
+	}
+	metricsMutex.Unlock()
+
+	// keep a stable order of jobs
+	sort.Strings(names)
+
+	// write the metrics in the Prometheus text exposition format
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	families := []struct {
+		name   string
+		kind   string
+		help   string
+		getter func(metrics *jobMetrics) string
+	}{
+		{"directorymirror_files_copied_total", "counter", "Files copied into the destination.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(atomic.LoadInt64(&metrics.filesCopied))
+		}},
+		{"directorymirror_bytes_copied_total", "counter", "Bytes copied into the destination.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(atomic.LoadInt64(&metrics.bytesCopied))
+		}},
+		{"directorymirror_bytes_verified_total", "counter", "Bytes read back from the destination to verify copies.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(atomic.LoadInt64(&metrics.bytesVerified))
+		}},
+		{"directorymirror_files_moved_total", "counter", "Files moved within the destination.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(atomic.LoadInt64(&metrics.filesMoved))
+		}},
+		{"directorymirror_files_deleted_total", "counter", "Files deleted from the destination.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(atomic.LoadInt64(&metrics.filesDeleted))
+		}},
+		{"directorymirror_errors_total", "counter", "Failed operations.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(atomic.LoadInt64(&metrics.errors))
+		}},
+		{"directorymirror_last_iteration_duration_seconds", "gauge", "Duration of the last iteration.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(math.Float64frombits(atomic.LoadUint64(&metrics.lastIterationSeconds)))
+		}},
+		{"directorymirror_last_success_timestamp_seconds", "gauge", "Time of the last iteration without failed operations, in seconds since epoch.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(math.Float64frombits(atomic.LoadUint64(&metrics.lastSuccessTimestamp)))
+		}},
+		{"directorymirror_queue_depth", "gauge", "Operations waiting to run or running.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(atomic.LoadInt64(&metrics.queueDepth))
+		}},
+		{"directorymirror_source_scan_seconds_total", "counter", "Time spent listing the source directory.", func(metrics *jobMetrics) string {
+			return formatSeconds(&metrics.srcScanDuration)
+		}},
+		{"directorymirror_destination_scan_seconds_total", "counter", "Time spent listing the destination directories.", func(metrics *jobMetrics) string {
+			return formatSeconds(&metrics.destScanDuration)
+		}},
+		{"directorymirror_plan_seconds_total", "counter", "Time spent planning the operations.", func(metrics *jobMetrics) string {
+			return formatSeconds(&metrics.planDuration)
+		}},
+		{"directorymirror_transfer_seconds_total", "counter", "Time spent running the operations.", func(metrics *jobMetrics) string {
+			return formatSeconds(&metrics.transferDuration)
+		}},
+		{"directorymirror_last_source_scan_duration_seconds", "gauge", "Time spent listing the source directory in the last iteration.", func(metrics *jobMetrics) string {
+			return formatSeconds(&metrics.lastSrcScanDuration)
+		}},
+		{"directorymirror_last_destination_scan_duration_seconds", "gauge", "Time spent listing the destination directories in the last iteration.", func(metrics *jobMetrics) string {
+			return formatSeconds(&metrics.lastDestScanDuration)
+		}},
+		{"directorymirror_last_plan_duration_seconds", "gauge", "Time spent planning the operations in the last iteration.", func(metrics *jobMetrics) string {
+			return formatSeconds(&metrics.lastPlanDuration)
+		}},
+		{"directorymirror_last_transfer_duration_seconds", "gauge", "Time spent running the operations in the last iteration.", func(metrics *jobMetrics) string {
+			return formatSeconds(&metrics.lastTransferDuration)
+		}},
+		{"directorymirror_last_transfer_bytes_per_second", "gauge", "Throughput of the copies of the last iteration.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(atomic.LoadInt64(&metrics.lastBytesPerSecond))
+		}},
+		{"directorymirror_last_largest_file_bytes", "gauge", "Size of the largest file copied by the last iteration.", func(metrics *jobMetrics) string {
+			return fmt.Sprint(atomic.LoadInt64(&metrics.lastLargestFileBytes))
+		}},
+	}
+
+	for _, family := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		for _, name := range names {
+			metricsMutex.Lock()
+			metrics := metricsJobs[name]
+			metricsMutex.Unlock()
+
+			fmt.Fprintf(w, "%s{mirror=\"%s\"} %s\n", family.name, escapeLabelValue(name), family.getter(metrics))
+		}
+	}
+}
+
+// formatSeconds formats the duration (in nanoseconds, stored so it can be updated atomically) in seconds
+func formatSeconds(duration *int64) string {
+	return fmt.Sprint(time.Duration(atomic.LoadInt64(duration)).Seconds())
+}
+
+// escapeLabelValue escapes a label value, as required by the text exposition format
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
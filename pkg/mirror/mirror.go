@@ -0,0 +1,169 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrSkipped is returned by SyncOnce when the iteration was skipped, since the source or a destination was unavailable, the source turned
+// out suspiciously empty, or the pre-sync command failed. the next iteration tries again
+var ErrSkipped = errors.New("Iteration skipped")
+
+// Mirror mirrors the source directory of a job into its destination directories. the metrics and status servers of the job are started by
+// a Supervisor only, the rest of its options apply as they do when the job runs from a config file
+type Mirror struct {
+	configs Config
+	// Run and SyncOnce share the files of the job, so only one of them runs at a time
+	running sync.Mutex
+}
+
+// Summary holds the counters of a single iteration, summed over all destinations (the source is scanned once for all of them)
+type Summary struct {
+	FilesScanned int64
+	FilesCopied  int64
+	BytesCopied  int64
+	// files hard linked or cloned rather than copied
+	FilesLinked      int64
+	FilesMoved       int64
+	FilesDeleted     int64
+	FilesUnchanged   int64
+	FilesFailed      int64
+	FilesDeferred    int64
+	FilesDrifted     int64
+	ScanDuration     time.Duration
+	TransferDuration time.Duration
+	// time spent listing the source directory and the destination directories during the scan, and planning the operations
+	SourceScanDuration      time.Duration
+	DestinationScanDuration time.Duration
+	PlanDuration            time.Duration
+	// throughput of the copies while the operations ran, and the largest file copied (an empty path if none was)
+	BytesPerSecond   int64
+	LargestFileBytes int64
+	LargestFilePath  string
+	// bytes the copies needed to write and the free space of the destination volumes before them (summed over the destinations), if the
+	// free space was checked (see onInsufficientSpace)
+	SpaceRequired int64
+	SpaceFree     int64
+}
+
+// New creates the mirror of the job, validating its configuration first (a configuration read by LoadConfigFile is validated already). a
+// configuration of multiple sources is rejected, since every source is mirrored by a job of its own: create a mirror for every
+// configuration returned by Prepare instead
+func New(config Config) (*Mirror, error) {
+	configs, err := Prepare(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) != 1 {
+		return nil, fmt.Errorf("Configuration has %d sources, a mirror must be created for every configuration returned by Prepare", len(configs))
+	}
+
+	mirror := &Mirror{configs: configs[0]}
+	// create the logger of the job, so its failure is logged the same way as its operations
+	if mirror.configs.General.logger == nil {
+		if mirror.configs.General.logger, err = newLogger(mirror.configs); err != nil {
+			return nil, err
+		}
+	}
+	// get the totals of the job, which are kept when a job of the same name is created again
+	mirror.configs.General.totals = registerJobTotals(mirror.configs)
+	// count the scans which found destination files missing across all runs of the job, so every SyncOnce counts another one
+	mirror.configs.General.missing = newMissingFiles()
+	// track the files written by all runs of the job, so drift is detected across SyncOnce calls
+	mirror.configs.General.written = newWrittenFiles(mirror.configs.General)
+
+	return mirror, nil
+}
+
+// setFileSystems makes the job read its source and write its destinations through the file systems, rather than the local (or remote) ones
+// of its configuration, e.g. in-memory ones which exercise the mirroring without touching the disk. the destination is not locked
+func (mirror *Mirror) setFileSystems(source readableFS, destination destinationFS) {
+	mirror.configs.General.source = source
+	mirror.configs.General.destination = destination
+}
+
+// Name returns the name of the job, which is configured (or the name of its config file), or made of its directories otherwise
+func (mirror *Mirror) Name() string {
+	return getJobName(mirror.configs)
+}
+
+// Run mirrors the source directory until the context is cancelled (or once, in run once mode), and returns once in-flight operations
+// finished (a copy in progress is interrupted). an error is returned if the job failed to start, or if any of its operations failed. a job
+// stopped by the context returns an error which wraps the error of the context, so errors.Is(err, context.Canceled) tells a shutdown from a
+// failure
+func (mirror *Mirror) Run(ctx context.Context) (err error) {
+	mirror.running.Lock()
+	defer mirror.running.Unlock()
+
+	// a job which fails unexpectedly must not take the caller down, so its panic is returned as its failure
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+			mirror.configs.General.totals.recordFailure(err)
+		}
+	}()
+
+	return runScanLoop(ctx, mirror.configs)
+}
+
+// SyncOnce runs a single iteration, regardless of the interval or schedule of the job, and returns its summary. ErrSkipped is returned if
+// the iteration was skipped, and an error counting the failed operations if any of them failed (along with the summary). an iteration
+// interrupted by the context returns an error wrapping the error of the context
+func (mirror *Mirror) SyncOnce(ctx context.Context) (summary Summary, err error) {
+	mirror.running.Lock()
+	defer mirror.running.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+			mirror.configs.General.totals.recordFailure(err)
+		}
+	}()
+
+	configs, closeJob, err := openJob(mirror.configs)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer closeJob()
+
+	stats := syncDirectories(ctx, configs)
+	if stats.skipped {
+		return Summary{}, ErrSkipped
+	}
+
+	return newSummary(stats), getJobError(ctx, stats.filesFailed)
+}
+
+// Stats returns a snapshot of the totals of all iterations of the job, which is safe to call while the job runs
+func (mirror *Mirror) Stats() Stats {
+	return mirror.configs.General.totals.getStats(mirror.Name())
+}
+
+func newSummary(stats *iterationStats) Summary {
+	return Summary{
+		FilesScanned:     stats.filesScannedSource,
+		FilesCopied:      stats.filesCopied,
+		BytesCopied:      stats.bytesCopied,
+		FilesLinked:      stats.filesLinked + stats.filesCloned,
+		FilesMoved:       stats.filesMoved,
+		FilesDeleted:     stats.filesDeleted,
+		FilesUnchanged:   stats.filesUnchanged,
+		FilesFailed:      stats.filesFailed,
+		FilesDeferred:    stats.filesDeferred,
+		FilesDrifted:     stats.filesDrifted,
+		ScanDuration:     stats.scanDuration,
+		TransferDuration: stats.transferDuration,
+
+		SourceScanDuration:      stats.srcScanDuration,
+		DestinationScanDuration: stats.destScanDuration,
+		PlanDuration:            stats.planDuration,
+		BytesPerSecond:          stats.getBytesPerSecond(),
+		LargestFileBytes:        stats.largestFileBytes,
+		LargestFilePath:         stats.largestFilePath,
+		SpaceRequired:           stats.spaceRequired,
+		SpaceFree:               stats.spaceFree,
+	}
+}
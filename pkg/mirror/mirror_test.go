@@ -0,0 +1,439 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memSource and memDestination are the directories of the jobs mirrored through the in-memory file system
+const (
+	memSource      = "/src"
+	memDestination = "/dst"
+)
+
+// newTestMirror returns a job mirroring the source into the destination directory, configured by the function (if any) on top of the
+// default configuration. the job logs nothing
+func newTestMirror(t testing.TB, source string, destination string, configure func(config *Config)) *Mirror {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.General.Name = t.Name()
+	config.General.SourceDirectory = source
+	config.General.DestinationDirectory = destination
+
+	if configure != nil {
+		configure(&config)
+	}
+
+	mirror, err := New(config)
+	if err != nil {
+		t.Fatalf("New() failed; %s", err)
+	}
+	mirror.configs.General.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return mirror
+}
+
+// newMemMirror returns a job mirroring the source into the destination directory of an in-memory file system, configured by the function
+// (if any) on top of the default configuration
+func newMemMirror(t testing.TB, configure func(config *Config)) (*Mirror, *memFS) {
+	t.Helper()
+
+	mirror := newTestMirror(t, memSource, memDestination, configure)
+	// the directories are not absolute on every platform (e.g. they lack a drive on Windows), so they are kept as configured
+	mirror.configs.General.SourceDirectory = memSource
+	mirror.configs.General.DestinationDirectories = []string{memDestination}
+
+	fsys := newMemFS(memSource, memDestination)
+	mirror.setFileSystems(fsys, fsys)
+
+	return mirror, fsys
+}
+
+// syncOnce runs a single iteration of the job, and returns its summary and error
+func syncOnce(t testing.TB, mirror *Mirror) (Summary, error) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return mirror.SyncOnce(ctx)
+}
+
+// mustSyncOnce runs a single iteration of the job, which must succeed
+func mustSyncOnce(t testing.TB, mirror *Mirror) Summary {
+	t.Helper()
+
+	summary, err := syncOnce(t, mirror)
+	if err != nil {
+		t.Fatalf("SyncOnce() failed; %s", err)
+	}
+	return summary
+}
+
+func assertTree(t testing.TB, fsys *memFS, root string, expected ...string) {
+	t.Helper()
+
+	if tree := fsys.tree(root); !reflect.DeepEqual(tree, expected) {
+		t.Errorf("tree of '%s' is %q, expected %q", root, tree, expected)
+	}
+}
+
+// modTime is an old modification time, so written files are never mistaken for files still being written
+var modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestSyncCreatesFiles(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/a.txt", "a", modTime)
+	fsys.writeFile("/src/b.txt", "bb", modTime)
+
+	summary := mustSyncOnce(t, mirror)
+
+	assertTree(t, fsys, memDestination, "a.txt=a", "b.txt=bb")
+	if summary.FilesCopied != 2 || summary.BytesCopied != 3 {
+		t.Errorf("copied %d files (%d bytes), expected 2 files (3 bytes)", summary.FilesCopied, summary.BytesCopied)
+	}
+
+	info, err := fsys.Stat("/dst/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("modification time of the copy is %s, expected %s", info.ModTime(), modTime)
+	}
+}
+
+func TestSyncCreatesNestedDirectories(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/one/two/three/deep.txt", "deep", modTime)
+	if err := fsys.MkdirAll("/src/empty/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	mustSyncOnce(t, mirror)
+
+	assertTree(t, fsys, memDestination, "empty/", "empty/dir/", "one/", "one/two/", "one/two/three/", "one/two/three/deep.txt=deep")
+}
+
+func TestSyncMirrorsEmptyDirectories(t *testing.T) {
+	for _, mirrorEmpty := range []bool{true, false} {
+		mirror, fsys := newMemMirror(t, func(config *Config) {
+			config.General.MirrorEmptyDirectories = mirrorEmpty
+		})
+		fsys.MkdirAll("/src/empty/nested", 0755)
+		fsys.writeFile("/src/full/dir/a.txt", "a", modTime)
+		mustSyncOnce(t, mirror)
+
+		// without empty directories, directories are created as the parents of files only
+		if mirrorEmpty {
+			assertTree(t, fsys, memDestination, "empty/", "empty/nested/", "full/", "full/dir/", "full/dir/a.txt=a")
+		} else {
+			assertTree(t, fsys, memDestination, "full/", "full/dir/", "full/dir/a.txt=a")
+		}
+
+		// a directory which gets a file is created along with it
+		fsys.writeFile("/src/empty/nested/b.txt", "b", modTime)
+		mustSyncOnce(t, mirror)
+		assertTree(t, fsys, memDestination, "empty/", "empty/nested/", "empty/nested/b.txt=b", "full/", "full/dir/", "full/dir/a.txt=a")
+
+		// a directory which is gone from the source is removed, even once emptied
+		fsys.RemoveAll("/src/full")
+		fsys.Remove("/src/empty/nested/b.txt")
+		mustSyncOnce(t, mirror)
+		assertTree(t, fsys, memDestination, "empty/", "empty/nested/")
+	}
+}
+
+func TestSyncPreservesDirTimes(t *testing.T) {
+	// by default directory times are left alone, so destinations which reject them do not fail every iteration
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/dir/a.txt", "a", modTime)
+	fsys.Chtimes("/src/dir", modTime, modTime)
+	fsys.fail(memOpChtimes, "/dst/dir", errors.ErrUnsupported)
+	if summary := mustSyncOnce(t, mirror); summary.FilesFailed != 0 {
+		t.Errorf("sync failed %d files, expected none", summary.FilesFailed)
+	}
+
+	mirror, fsys = newMemMirror(t, func(config *Config) {
+		config.General.PreserveDirTimes = true
+	})
+	fsys.writeFile("/src/dir/a.txt", "a", modTime)
+	fsys.Chtimes("/src/dir", modTime, modTime)
+	mustSyncOnce(t, mirror)
+	if info, err := fsys.Stat("/dst/dir"); err != nil {
+		t.Error(err)
+	} else if !info.ModTime().Equal(modTime) {
+		t.Errorf("destination directory has modification time %v, expected %v", info.ModTime(), modTime)
+	}
+}
+
+func TestSyncUpdatesChangedFiles(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/a.txt", "old", modTime)
+	mustSyncOnce(t, mirror)
+
+	fsys.writeFile("/src/a.txt", "new contents", modTime.Add(time.Hour))
+	summary := mustSyncOnce(t, mirror)
+
+	assertTree(t, fsys, memDestination, "a.txt=new contents")
+	if summary.FilesCopied != 1 {
+		t.Errorf("copied %d files, expected 1", summary.FilesCopied)
+	}
+}
+
+func TestSyncDeletesRemovedFiles(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/keep.txt", "keep", modTime)
+	fsys.writeFile("/src/gone/file.txt", "gone", modTime)
+	mustSyncOnce(t, mirror)
+
+	if err := fsys.RemoveAll("/src/gone"); err != nil {
+		t.Fatal(err)
+	}
+	summary := mustSyncOnce(t, mirror)
+
+	assertTree(t, fsys, memDestination, "keep.txt=keep")
+	if summary.FilesDeleted < 1 {
+		t.Errorf("deleted %d files, expected the removed directory to be deleted", summary.FilesDeleted)
+	}
+}
+
+func TestSyncDeletesNestedTreeConcurrently(t *testing.T) {
+	// without a limit of workers (every operation in its own goroutine), with a single one, and with several
+	for _, workers := range []int{0, 1, 8} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			mirror, fsys := newMemMirror(t, func(config *Config) {
+				config.General.MaxConcurrentWorkers = workers
+			})
+			fsys.writeFile("/src/keep.txt", "keep", modTime)
+			for _, dir := range []string{"/src/a", "/src/a/b", "/src/a/b/c"} {
+				for i := 0; i < 5; i++ {
+					fsys.writeFile(fmt.Sprintf("%s/file%d.txt", dir, i), "file", modTime)
+				}
+			}
+			mustSyncOnce(t, mirror)
+
+			if err := fsys.RemoveAll("/src/a"); err != nil {
+				t.Fatal(err)
+			}
+			summary := mustSyncOnce(t, mirror)
+
+			assertTree(t, fsys, memDestination, "keep.txt=keep")
+			if summary.FilesFailed != 0 {
+				t.Errorf("%d operations failed, expected none", summary.FilesFailed)
+			}
+		})
+	}
+}
+
+func TestSyncDeletesMissingFileAsDeleted(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/a.txt", "a", modTime)
+	mustSyncOnce(t, mirror)
+
+	// the destination file is removed by someone else before its deletion runs
+	if err := fsys.Remove("/src/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	fsys.fail(memOpRemove, "/dst/a.txt", fs.ErrNotExist)
+
+	summary := mustSyncOnce(t, mirror)
+
+	if summary.FilesFailed != 0 {
+		t.Errorf("%d operations failed, expected a missing file to be deleted already", summary.FilesFailed)
+	}
+}
+
+func TestSyncSkipsFilesWithUnchangedModTime(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/a.txt", "same", modTime)
+	mustSyncOnce(t, mirror)
+
+	// a destination file whose size and modification time match its source is not read, so its contents are kept as they are
+	fsys.writeFile("/dst/a.txt", "SAME", modTime)
+	summary := mustSyncOnce(t, mirror)
+
+	assertTree(t, fsys, memDestination, "a.txt=SAME")
+	if summary.FilesCopied != 0 || summary.FilesUnchanged != 1 {
+		t.Errorf("copied %d files and kept %d unchanged, expected 0 copied and 1 unchanged", summary.FilesCopied, summary.FilesUnchanged)
+	}
+}
+
+func TestSyncDefersRecentlyModifiedFiles(t *testing.T) {
+	mirror, fsys := newMemMirror(t, func(config *Config) {
+		config.General.MinFileAgeSeconds = 60
+	})
+	fsys.writeFile("/src/old.txt", "old", modTime)
+	fsys.writeFile("/src/recent.txt", "recent", time.Now())
+	// a file modified in the future (e.g. by a source with a skewed clock) is settled, otherwise it would never be mirrored
+	fsys.writeFile("/src/future.txt", "future", time.Now().Add(time.Hour))
+
+	summary := mustSyncOnce(t, mirror)
+	assertTree(t, fsys, memDestination, "future.txt=future", "old.txt=old")
+	if summary.FilesCopied != 2 || summary.FilesDeferred != 1 {
+		t.Errorf("copied %d files and deferred %d, expected 2 copied and 1 deferred", summary.FilesCopied, summary.FilesDeferred)
+	}
+
+	// once it settles the file is copied
+	fsys.writeFile("/src/recent.txt", "recent", time.Now().Add(-time.Minute))
+	mustSyncOnce(t, mirror)
+	assertTree(t, fsys, memDestination, "future.txt=future", "old.txt=old", "recent.txt=recent")
+}
+
+func TestSyncCopiesFutureFilesByDefault(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/future.txt", "future", time.Now().Add(time.Hour))
+
+	summary := mustSyncOnce(t, mirror)
+	assertTree(t, fsys, memDestination, "future.txt=future")
+	if summary.FilesCopied != 1 || summary.FilesDeferred != 0 {
+		t.Errorf("copied %d files and deferred %d, expected 1 copied and none deferred", summary.FilesCopied, summary.FilesDeferred)
+	}
+}
+
+func TestSyncReportsUnreadableSourceFiles(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/secret.txt", "secret", modTime)
+	fsys.writeFile("/src/public.txt", "public", modTime)
+	fsys.setMode("/src/secret.txt", 0200)
+
+	summary, err := syncOnce(t, mirror)
+
+	if err == nil || summary.FilesFailed != 1 {
+		t.Errorf("SyncOnce() returned %v with %d failed files, expected 1 failed file", err, summary.FilesFailed)
+	}
+	assertTree(t, fsys, memDestination, "public.txt=public")
+}
+
+func TestSyncKeepsDestinationOfUnlistableSourceDirectory(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/locked/file.txt", "file", modTime)
+	mustSyncOnce(t, mirror)
+
+	// a directory which can not be listed is left alone, rather than its mirrored contents being deleted
+	fsys.setMode("/src/locked", 0300)
+	mustSyncOnce(t, mirror)
+
+	assertTree(t, fsys, memDestination, "locked/", "locked/file.txt=file")
+}
+
+func TestSyncSkipsUnreadableSourceEntries(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/a.txt", "a", modTime)
+	fsys.writeFile("/src/locked/one/two/file.txt", "locked", modTime)
+	fsys.writeFile("/src/vanishing.txt", "vanishing", modTime)
+	fsys.writeFile("/src/tree/b.txt", "b", modTime)
+	mustSyncOnce(t, mirror)
+
+	// a directory which can not be listed, and a file which can not be read once listed (e.g. deleted meanwhile)
+	fsys.fail(memOpReadDir, "/src/locked/one", fs.ErrPermission)
+	fsys.fail(memOpStat, "/src/vanishing.txt", fs.ErrNotExist)
+	fsys.writeFile("/src/tree/c.txt", "c", modTime)
+
+	summary := mustSyncOnce(t, mirror)
+
+	// the rest of the tree is mirrored, and the destination counterparts of the unreadable entries are kept
+	assertTree(t, fsys, memDestination, "a.txt=a", "locked/", "locked/one/", "locked/one/two/", "locked/one/two/file.txt=locked", "tree/", "tree/b.txt=b",
+		"tree/c.txt=c", "vanishing.txt=vanishing")
+	if summary.FilesCopied != 1 || summary.FilesDeleted != 0 {
+		t.Errorf("copied %d and deleted %d files, expected 1 copied and none deleted", summary.FilesCopied, summary.FilesDeleted)
+	}
+}
+
+func TestSyncReportsWriteDeniedDestination(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/dir/a.txt", "a", modTime)
+	if err := fsys.MkdirAll("/dst/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a directory of another user, whose permissions can not be synchronized
+	fsys.setMode("/dst/dir", 0500)
+	fsys.fail(memOpChmod, "/dst/dir", fs.ErrPermission)
+
+	summary, err := syncOnce(t, mirror)
+
+	// both the permissions of the directory and the copy into it fail
+	if err == nil || summary.FilesFailed != 2 {
+		t.Errorf("SyncOnce() returned %v with %d failed files, expected 2 failed files", err, summary.FilesFailed)
+	}
+	assertTree(t, fsys, memDestination, "dir/")
+}
+
+func TestSyncInjectedErrors(t *testing.T) {
+	injected := errors.New("injected failure")
+
+	tests := []struct {
+		name      string
+		operation string
+		path      string
+		// contents of the destination after the failed iteration
+		expected []string
+	}{
+		{name: "open source", operation: memOpOpen, path: "/src/b.txt", expected: []string{"a.txt=a"}},
+		{name: "read source", operation: memOpRead, path: "/src/b.txt", expected: []string{"a.txt=a"}},
+		// files are written to a temporary path first, which is renamed to the destination file once complete
+		{name: "create destination", operation: memOpCreate, path: getTempPath("/dst/b.txt"), expected: []string{"a.txt=a"}},
+		{name: "write destination", operation: memOpWrite, path: getTempPath("/dst/b.txt"), expected: []string{"a.txt=a"}},
+		{name: "set modification time", operation: memOpChtimes, path: getTempPath("/dst/b.txt"), expected: []string{"a.txt=a"}},
+		{name: "rename destination", operation: memOpRename, path: getTempPath("/dst/b.txt"), expected: []string{"a.txt=a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mirror, fsys := newMemMirror(t, nil)
+			fsys.writeFile("/src/a.txt", "a", modTime)
+			fsys.writeFile("/src/b.txt", "b", modTime)
+			fsys.fail(test.operation, test.path, injected)
+
+			summary, err := syncOnce(t, mirror)
+
+			if err == nil || summary.FilesFailed != 1 || summary.FilesCopied != 1 {
+				t.Errorf("SyncOnce() returned %v with %d failed and %d copied files, expected 1 failed and 1 copied", err, summary.FilesFailed, summary.FilesCopied)
+			}
+			assertTree(t, fsys, memDestination, test.expected...)
+
+			// the failed file is copied by the next iteration, once the failure is gone
+			fsys.fail(test.operation, test.path, nil)
+			mustSyncOnce(t, mirror)
+			assertTree(t, fsys, memDestination, "a.txt=a", "b.txt=b")
+		})
+	}
+}
+
+func TestSyncInjectedDeleteError(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/a.txt", "a", modTime)
+	mustSyncOnce(t, mirror)
+
+	if err := fsys.Remove("/src/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	fsys.fail(memOpRemove, "/dst/a.txt", errors.New("injected failure"))
+
+	summary, err := syncOnce(t, mirror)
+
+	if err == nil || summary.FilesFailed != 1 {
+		t.Errorf("SyncOnce() returned %v with %d failed files, expected 1 failed file", err, summary.FilesFailed)
+	}
+	assertTree(t, fsys, memDestination, "a.txt=a")
+}
+
+func TestSyncSkipsUnavailableSource(t *testing.T) {
+	mirror, fsys := newMemMirror(t, nil)
+	fsys.writeFile("/src/a.txt", "a", modTime)
+	mustSyncOnce(t, mirror)
+
+	fsys.fail(memOpStat, memSource, fs.ErrNotExist)
+	if _, err := syncOnce(t, mirror); !errors.Is(err, ErrSkipped) {
+		t.Errorf("SyncOnce() returned %v, expected ErrSkipped", err)
+	}
+
+	assertTree(t, fsys, memDestination, "a.txt=a")
+}
@@ -0,0 +1,205 @@
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// name of the files of ignore rules, which apply to the contents of the source directory they are in
+const mirrorIgnoreFileName = ".mirrorignore"
+
+// ignoreRule is a single pattern of an ignore file, with gitignore semantics
+type ignoreRule struct {
+	// the line of the rule, as it is written in the file
+	text string
+	// slash separated segments of the pattern, matched against the path relative to the directory of the file
+	segments []string
+	// a negated rule includes the paths which a previous rule ignored
+	negate bool
+	// the rule matches directories only
+	dirOnly bool
+}
+
+// ignoreMatch is the decision of the rules about a path, along with the rule which decided it
+type ignoreMatch struct {
+	ignored bool
+	// relative path of the ignore file, and the rule in it
+	file string
+	rule string
+}
+
+// ignoreRules holds the ignore files of the source directory, which are read once per iteration (so changed files apply to the next one)
+type ignoreRules struct {
+	mutex  sync.Mutex
+	logger *slog.Logger
+	fsys   readableFS
+	srcDir string
+	// rules of every read directory by its slash separated relative path (nil if it has no ignore file), and the decisions about directories
+	files map[string][]ignoreRule
+	dirs  map[string]ignoreMatch
+}
+
+// newIgnoreRules returns the ignore rules of the source directory, or nil if ignore files are not respected
+func newIgnoreRules(configs Config) *ignoreRules {
+	if !configs.General.RespectMirrorIgnore {
+		return nil
+	}
+
+	return &ignoreRules{logger: configs.General.logger, fsys: configs.General.source, srcDir: configs.General.SourceDirectory, files: make(map[string][]ignoreRule), dirs: make(map[string]ignoreMatch)}
+}
+
+// reset forgets the read ignore files, so they are read again by the next iteration
+func (rules *ignoreRules) reset() {
+	if rules == nil {
+		return
+	}
+
+	rules.mutex.Lock()
+	defer rules.mutex.Unlock()
+
+	rules.files = make(map[string][]ignoreRule)
+	rules.dirs = make(map[string]ignoreMatch)
+}
+
+// match returns the decision about the relative path. as in git, a path inside an ignored directory is ignored, whatever the rules of the
+// path itself are
+func (rules *ignoreRules) match(relativePath string, isDir bool) ignoreMatch {
+	normalizedPath := normalizeRelativePath(relativePath)
+	if rules == nil || len(normalizedPath) < 1 {
+		return ignoreMatch{}
+	}
+
+	rules.mutex.Lock()
+	defer rules.mutex.Unlock()
+
+	// check the parent directories from the root down, their decisions are kept for the other paths in them
+	segments := strings.Split(normalizedPath, "/")
+	for i := 1; i < len(segments); i++ {
+		dir := strings.Join(segments[:i], "/")
+
+		decision, exists := rules.dirs[dir]
+		if !exists {
+			decision = rules.matchPath(dir, true)
+			rules.dirs[dir] = decision
+		}
+		if decision.ignored {
+			return decision
+		}
+	}
+
+	return rules.matchPath(normalizedPath, isDir)
+}
+
+// matchPath applies the rules of every ignore file above the path in order (from the root down, and from the top of every file down),
+// so the last matching rule decides. the mutex must be held
+func (rules *ignoreRules) matchPath(normalizedPath string, isDir bool) ignoreMatch {
+	var decision ignoreMatch
+
+	for dir := ""; ; {
+		relativePath := strings.TrimPrefix(strings.TrimPrefix(normalizedPath, dir), "/")
+
+		for _, rule := range rules.getFile(dir) {
+			if rule.matches(relativePath, isDir) {
+				decision = ignoreMatch{ignored: !rule.negate, file: path.Join(dir, mirrorIgnoreFileName), rule: rule.text}
+			}
+		}
+
+		// continue with the next directory down the path, up to the parent directory of the path
+		next := strings.IndexByte(relativePath, '/')
+		if next < 0 {
+			break
+		}
+		dir = path.Join(dir, relativePath[:next])
+	}
+
+	return decision
+}
+
+// getFile returns the rules of the ignore file of the directory, reading it if it was not read yet. the mutex must be held
+func (rules *ignoreRules) getFile(dir string) []ignoreRule {
+	if file, exists := rules.files[dir]; exists {
+		return file
+	}
+
+	var file []ignoreRule
+	data, err := readFSFile(rules.fsys, filepath.Join(rules.srcDir, filepath.FromSlash(dir), mirrorIgnoreFileName))
+	// a directory which is missing from the source (or is a file there) has no rules
+	if err == nil {
+		file = parseIgnoreFile(string(data))
+	} else if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+		rules.logger.Warn("Skip", "path", filepath.Join(rules.srcDir, filepath.FromSlash(dir), mirrorIgnoreFileName), "reason", "unreadable", "error", err)
+	}
+
+	rules.files[dir] = file
+	return file
+}
+
+// parseIgnoreFile parses the rules of an ignore file, in gitignore syntax: blank lines and lines starting with # are skipped, a leading !
+// negates the rule, a trailing / matches directories only, and a pattern with a / at its start or middle is anchored to the directory of the
+// file (otherwise it matches a name at any depth). a leading \ escapes a # or ! which starts a pattern
+func parseIgnoreFile(contents string) []ignoreRule {
+	var rules []ignoreRule
+
+	for _, line := range strings.Split(contents, "\n") {
+		text := strings.TrimRight(line, "\r")
+		// trailing spaces are ignored, unless escaped
+		pattern := strings.TrimRight(text, " ")
+		if strings.HasSuffix(pattern, "\\") && len(pattern) < len(text) {
+			pattern += " "
+		}
+		if len(pattern) < 1 || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		rule := ignoreRule{text: strings.TrimSpace(text)}
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, "\\#") || strings.HasPrefix(pattern, "\\!") {
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+
+		anchored := strings.Contains(pattern, "/")
+		pattern = strings.TrimLeft(pattern, "/")
+		if len(pattern) < 1 {
+			continue
+		}
+
+		rule.segments = strings.Split(pattern, "/")
+		if !anchored {
+			rule.segments = append([]string{"**"}, rule.segments...)
+		}
+		// a trailing /** matches everything inside the directory, but not the directory itself
+		if len(rule.segments) > 1 && rule.segments[len(rule.segments)-1] == "**" && rule.segments[len(rule.segments)-2] != "**" {
+			rule.segments = append(rule.segments[:len(rule.segments)-1], "*", "**")
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// matches reports whether the rule matches the slash separated path, relative to the directory of its file
+func (rule ignoreRule) matches(relativePath string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+
+	return matchSegments(rule.segments, strings.Split(relativePath, "/"))
+}
+
+// isMirrorIgnoreFile reports whether the relative path is an ignore file
+func isMirrorIgnoreFile(relativePath string) bool {
+	return path.Base(normalizeRelativePath(relativePath)) == mirrorIgnoreFileName
+}
@@ -0,0 +1,150 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// newTestIgnoreRules returns the ignore rules of the source directory of an in-memory file system, with the ignore files of the directories
+func newTestIgnoreRules(files map[string]string) *ignoreRules {
+	fsys := newMemFS(memSource)
+	for dir, contents := range files {
+		fsys.writeFile(memSource+"/"+dir+"/"+mirrorIgnoreFileName, contents, modTime)
+	}
+
+	var configs Config
+	configs.General.RespectMirrorIgnore = true
+	configs.General.SourceDirectory = memSource
+	configs.General.source = fsys
+	configs.General.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return newIgnoreRules(configs)
+}
+
+func TestIgnoreRules(t *testing.T) {
+	rules := newTestIgnoreRules(map[string]string{
+		".": "# comment\n" +
+			"\n" +
+			"*.log\n" +
+			"!keep.log\n" +
+			"build/\n" +
+			"/root-only.txt\n" +
+			"docs/*.pdf\n" +
+			"**/cache/**\n" +
+			"a/**/z.txt\n" +
+			"\\#literal\n" +
+			"\\!bang\n" +
+			"trailing\\ \n",
+		"sub": "*.tmp\n" +
+			"!important.log\n" +
+			"/anchored.txt\n",
+		"sub/deep": "!*.tmp\n",
+	})
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		// patterns without a slash match names at any depth
+		{path: "debug.log", ignored: true},
+		{path: "dir/debug.log", ignored: true},
+		{path: "dir/debug.txt", ignored: false},
+		// a negation re-includes a path a previous rule ignored
+		{path: "keep.log", ignored: false},
+		{path: "dir/keep.log", ignored: false},
+		// a trailing slash matches directories only, and everything inside them
+		{path: "build", isDir: true, ignored: true},
+		{path: "build", isDir: false, ignored: false},
+		{path: "src/build", isDir: true, ignored: true},
+		{path: "build/out.bin", ignored: true},
+		{path: "build/nested/keep.log", ignored: true},
+		// a leading slash anchors the pattern to the directory of its file
+		{path: "root-only.txt", ignored: true},
+		{path: "dir/root-only.txt", ignored: false},
+		// so does a slash in the middle, and * does not match a slash
+		{path: "docs/manual.pdf", ignored: true},
+		{path: "docs/old/manual.pdf", ignored: false},
+		{path: "other/docs/manual.pdf", ignored: false},
+		// ** matches any number of directories
+		{path: "cache/file", ignored: true},
+		{path: "x/y/cache/file", ignored: true},
+		{path: "x/cache", isDir: true, ignored: false},
+		{path: "a/z.txt", ignored: true},
+		{path: "a/b/c/z.txt", ignored: true},
+		{path: "b/a/z.txt", ignored: false},
+		// escaped characters
+		{path: "#literal", ignored: true},
+		{path: "!bang", ignored: true},
+		{path: "trailing ", ignored: true},
+		{path: "trailing", ignored: false},
+		// rules of a subdirectory apply to its contents, after the inherited ones
+		{path: "sub/file.tmp", ignored: true},
+		{path: "file.tmp", ignored: false},
+		{path: "sub/important.log", ignored: false},
+		{path: "sub/anchored.txt", ignored: true},
+		{path: "sub/x/anchored.txt", ignored: false},
+		{path: "sub/deep/file.tmp", ignored: false},
+		{path: "sub/deep/x/file.tmp", ignored: false},
+	}
+
+	for _, test := range tests {
+		if match := rules.match(test.path, test.isDir); match.ignored != test.ignored {
+			t.Errorf("match(%q, %v) ignored = %v, expected %v (decided by '%s' of '%s')", test.path, test.isDir, match.ignored, test.ignored, match.rule, match.file)
+		}
+	}
+}
+
+func TestIgnoreRulesInsideIgnoredDirectory(t *testing.T) {
+	// as in git, a file can not be re-included once its directory is ignored
+	rules := newTestIgnoreRules(map[string]string{".": "logs/\n!logs/keep.log\n"})
+
+	if match := rules.match("logs/keep.log", false); !match.ignored || match.rule != "logs/" || match.file != mirrorIgnoreFileName {
+		t.Errorf("match() = %+v, expected the file to be ignored by the rule of its directory", match)
+	}
+}
+
+func TestIgnoreRulesReadAgainAfterReset(t *testing.T) {
+	rules := newTestIgnoreRules(map[string]string{".": "*.log\n"})
+	if !rules.match("a.log", false).ignored {
+		t.Fatal("a.log is not ignored")
+	}
+
+	rules.fsys.(*memFS).writeFile(memSource+"/"+mirrorIgnoreFileName, "*.txt\n", modTime)
+	// the read ignore files apply until the next iteration
+	if !rules.match("a.log", false).ignored {
+		t.Error("changed ignore file applies within the same iteration")
+	}
+
+	rules.reset()
+	if rules.match("a.log", false).ignored || !rules.match("a.txt", false).ignored {
+		t.Error("changed ignore file does not apply once the rules were reset")
+	}
+}
+
+func TestSyncRespectsIgnoreFiles(t *testing.T) {
+	for _, excludeFiles := range []bool{false, true} {
+		t.Run(fmt.Sprintf("excluding ignore files %v", excludeFiles), func(t *testing.T) {
+			mirror, fsys := newMemMirror(t, func(config *Config) {
+				config.General.RespectMirrorIgnore = true
+				config.General.ExcludeMirrorIgnoreFiles = excludeFiles
+			})
+			fsys.writeFile("/src/.mirrorignore", "*.log\nbuild/\n", modTime)
+			fsys.writeFile("/src/a.txt", "a", modTime)
+			fsys.writeFile("/src/debug.log", "log", modTime)
+			fsys.writeFile("/src/build/out.bin", "bin", modTime)
+			// ignored destination files are not deleted either
+			fsys.writeFile("/dst/old.log", "old", modTime)
+
+			mustSyncOnce(t, mirror)
+
+			expected := []string{".mirrorignore=*.log\nbuild/\n", "a.txt=a", "old.log=old"}
+			if excludeFiles {
+				expected = expected[1:]
+			}
+			assertTree(t, fsys, memDestination, expected...)
+		})
+	}
+}
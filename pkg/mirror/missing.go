@@ -0,0 +1,61 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// missingFiles counts the consecutive scans which found destination files missing from the source, by their destination directory and
+// their relative path, so a file which is missing for a single scan (e.g. saved by an editor through a rename) is not deleted
+type missingFiles struct {
+	mutex  sync.Mutex
+	counts map[string]map[string]int
+}
+
+func newMissingFiles() *missingFiles {
+	return &missingFiles{counts: make(map[string]map[string]int)}
+}
+
+// keepRecent counts another scan for the files planned for deletion, and removes the files which were not missing for enough consecutive
+// scans from the deletions, returning how many were kept. files which are no longer missing are forgotten (a partial scan of targeted paths
+// only forgets the source files it found)
+func (missing *missingFiles) keepRecent(configs Config, srcFiles map[string]os.FileInfo, deletions map[string]os.FileInfo, fullScan bool) int {
+	// every missing file is deleted right away
+	if configs.General.DeleteAfterMissingIterations <= 1 {
+		return 0
+	}
+
+	missing.mutex.Lock()
+	defer missing.mutex.Unlock()
+
+	destDir := configs.General.DestinationDirectory
+	counts := make(map[string]int, len(deletions))
+	// a partial scan keeps counting the files which it did not find in the source
+	if !fullScan {
+		for relativePath, count := range missing.counts[destDir] {
+			if _, exists := srcFiles[relativePath]; !exists {
+				counts[relativePath] = count
+			}
+		}
+	}
+
+	kept := 0
+	for relativePath := range deletions {
+		count := missing.counts[destDir][relativePath] + 1
+		if count >= configs.General.DeleteAfterMissingIterations {
+			delete(counts, relativePath)
+			continue
+		}
+
+		counts[relativePath] = count
+		configs.General.logger.Debug("Keep", "path", filepath.Join(destDir, relativePath), "reason", "source missing", "missingScans", count,
+			"deleteAfterMissingIterations", configs.General.DeleteAfterMissingIterations)
+
+		delete(deletions, relativePath)
+		kept++
+	}
+
+	missing.counts[destDir] = counts
+	return kept
+}
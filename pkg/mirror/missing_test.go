@@ -0,0 +1,46 @@
+package mirror
+
+import (
+	"testing"
+)
+
+func TestSyncDeletesAfterMissingIterations(t *testing.T) {
+	mirror, fsys := newMemMirror(t, func(config *Config) {
+		config.General.DeleteAfterMissingIterations = 3
+	})
+	fsys.writeFile("/src/a.txt", "a", modTime)
+	fsys.writeFile("/src/b.txt", "b", modTime)
+	mustSyncOnce(t, mirror)
+
+	// the file is kept by the first scans which found it missing, and deleted by the last one
+	fsys.Remove("/src/a.txt")
+	for i := 1; i <= 3; i++ {
+		summary := mustSyncOnce(t, mirror)
+
+		if i < 3 {
+			assertTree(t, fsys, memDestination, "a.txt=a", "b.txt=b")
+			if summary.FilesDeleted != 0 {
+				t.Errorf("scan %d deleted %d files, expected none", i, summary.FilesDeleted)
+			}
+		} else {
+			assertTree(t, fsys, memDestination, "b.txt=b")
+			if summary.FilesDeleted != 1 {
+				t.Errorf("scan %d deleted %d files, expected 1", i, summary.FilesDeleted)
+			}
+		}
+	}
+
+	// a file which reappears is counted from the start once it goes missing again
+	fsys.Remove("/src/b.txt")
+	mustSyncOnce(t, mirror)
+	mustSyncOnce(t, mirror)
+	fsys.writeFile("/src/b.txt", "b", modTime)
+	mustSyncOnce(t, mirror)
+
+	fsys.Remove("/src/b.txt")
+	mustSyncOnce(t, mirror)
+	mustSyncOnce(t, mirror)
+	assertTree(t, fsys, memDestination, "b.txt=b")
+	mustSyncOnce(t, mirror)
+	assertTree(t, fsys, memDestination)
+}
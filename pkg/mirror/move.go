@@ -0,0 +1,143 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// movedFile is a destination file planned for deletion, which matches a new source file
+type movedFile struct {
+	path string
+	info os.FileInfo
+}
+
+// moveKey identifies candidates of a move, files are matched by size and modification time
+type moveKey struct {
+	size    int64
+	modTime int64
+}
+
+// getMoveKey returns the key of the file, whose modification time is truncated to the precision kept by the destination
+func getMoveKey(file os.FileInfo, granularity time.Duration) moveKey {
+	return moveKey{size: file.Size(), modTime: file.ModTime().Truncate(granularity).UnixNano()}
+}
+
+func detectMoves(configs Config, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo, wg *sync.WaitGroup) map[string]movedFile {
+	granularity := configs.General.destination.ModTimeGranularity()
+
+	// collect regular source files which do not exist in the destination, by their move key
+	newFiles := make(map[moveKey][]string)
+	for srcPath, srcFile := range srcFiles {
+		if _, exists := destFiles[srcPath]; !exists && srcFile.Mode().IsRegular() {
+			key := getMoveKey(srcFile, granularity)
+			newFiles[key] = append(newFiles[key], srcPath)
+		}
+	}
+
+	// nothing could have been moved
+	if len(newFiles) < 1 {
+		return nil
+	}
+
+	// collect regular destination files which do not exist in the source (planned for deletion), by their move key
+	removedFiles := make(map[moveKey][]string)
+	for dstPath, dstFile := range destFiles {
+		if _, exists := srcFiles[dstPath]; !exists && dstFile.Mode().IsRegular() {
+			key := getMoveKey(dstFile, granularity)
+			removedFiles[key] = append(removedFiles[key], dstPath)
+		}
+	}
+
+	// a file is considered moved only if the match is unambiguous, otherwise it is copied
+	moves := make(map[string]movedFile)
+	for key, srcPaths := range newFiles {
+		if dstPaths := removedFiles[key]; len(srcPaths) == 1 && len(dstPaths) == 1 {
+			moves[srcPaths[0]] = movedFile{path: dstPaths[0], info: destFiles[dstPaths[0]]}
+		}
+	}
+
+	for _, moved := range moves {
+		// the moved file is no longer planned for deletion
+		delete(destFiles, moved.path)
+
+		// since we remove record from container, count as -1 in WaitGroup counter
+		wg.Done()
+
+		// the directories of the moved file must not be removed before it is moved out of them, they are removed on the next iteration
+		for dir := filepath.Dir(moved.path); dir != "."; dir = filepath.Dir(dir) {
+			if _, exists := destFiles[dir]; exists {
+				delete(destFiles, dir)
+
+				// since we remove record from container, count as -1 in WaitGroup counter
+				wg.Done()
+			}
+		}
+	}
+
+	return moves
+}
+
+func moveDestFile(ctx context.Context, configs Config, stats *iterationStats, srcPath string, srcFile os.FileInfo, oldPath string, oldFile os.FileInfo, path string) error {
+	// in hash compare mode, make sure the contents match too before the file is moved
+	sameContents := true
+	if configs.General.CompareMode == compareModeHash {
+		srcHash, err := configs.General.hashes.getHash(configs.General.HashAlgorithm, configs.General.source, configs.General.SourceDirectory, srcPath, srcFile)
+		if err != nil {
+			return err
+		}
+		oldHash, err := configs.General.hashes.getHash(configs.General.HashAlgorithm, configs.General.destination, configs.General.DestinationDirectory, oldPath, oldFile)
+		if err != nil {
+			return err
+		}
+
+		sameContents = bytes.Equal(srcHash, oldHash)
+	}
+
+	if sameContents {
+		// in dry run mode, only report the file would be moved
+		if configs.General.DryRun {
+			stats.addMoved()
+
+			configs.General.logger.Info("WOULD Move", "path", oldPath, "target", path)
+			return nil
+		}
+
+		// make sure destination parent directory exists
+		if err := validateDirExistance(configs, stats, filepath.Dir(srcPath), filepath.Dir(path)); err != nil {
+			return err
+		}
+
+		// move the file, unless something was created at the destination path in the meantime (or the move is across volumes, so it fails)
+		if _, err := configs.General.destination.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+			if err := configs.General.destination.Rename(oldPath, path); err == nil {
+				// set same permission as source file, in case it changed along with the move (if requested)
+				if err := preservePermissions(configs, srcFile, path); err != nil {
+					return err
+				}
+
+				stats.addMoved()
+				configs.General.manifests.rename(configs, oldPath, path)
+
+				configs.General.logger.Info("Move", "path", oldPath, "target", path)
+				emitEvent(configs, eventActionMove, path, 0, 0, nil)
+				return nil
+			}
+		}
+	}
+
+	// the file could not be moved, so fall back to copying the source file and removing the old file
+	if err := writeFile(ctx, configs, stats, srcPath, srcFile, path); err != nil {
+		return err
+	}
+	if _, err := configs.General.destination.Lstat(oldPath); errors.Is(err, fs.ErrNotExist) {
+		// already removed (by a previous attempt)
+		return nil
+	}
+	return deleteFile(ctx, configs, stats, oldFile, oldPath, "Remove")
+}
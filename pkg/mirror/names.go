@@ -0,0 +1,325 @@
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	unicodeNormalizationNone = "none"
+	unicodeNormalizationNFC  = "nfc"
+	unicodeNormalizationNFD  = "nfd"
+)
+
+// isNameMatchingEnabled reports whether names which differ in their unicode normalization form (or in their case) are matched
+func isNameMatchingEnabled(general GeneralConfigurations) bool {
+	return general.UnicodeNormalization != unicodeNormalizationNone || general.CaseInsensitive
+}
+
+// getCanonicalName returns the form of the name (or relative path) which is compared: normalized to the configured unicode form, and in
+// lower case when compared case-insensitively
+func getCanonicalName(general GeneralConfigurations, name string) string {
+	switch general.UnicodeNormalization {
+	case unicodeNormalizationNFC:
+		name = norm.NFC.String(name)
+	case unicodeNormalizationNFD:
+		name = norm.NFD.String(name)
+	}
+	if general.CaseInsensitive {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// matchNames moves the destination entries whose names match the name of a source entry only in their canonical form (or once it is
+// mapped, see mapName) under the name of the source entry, so they are compared against it, rather than copied again and deleted (e.g. a
+// composed name on Linux which is decomposed by the file system of a macOS destination). names are never changed on disk, the destination
+// file system resolves the name of the source entry to the matched entry (which is what makes the forms differ in the first place)
+func matchNames(general GeneralConfigurations, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) {
+	if !isNameMatchingEnabled(general) && !isNameMapped(general) {
+		return
+	}
+
+	// source entries which have no destination entry of the same name, by the canonical form of the name they are stored under
+	unmatched := make(map[string]string)
+	for srcName := range srcFiles {
+		if _, exists := destFiles[srcName]; !exists {
+			unmatched[getCanonicalName(general, getStoredPath(general, srcName))] = srcName
+		}
+	}
+	if len(unmatched) < 1 {
+		return
+	}
+
+	for destName, destFile := range destFiles {
+		if _, exists := srcFiles[destName]; exists {
+			continue
+		}
+
+		canonicalName := getCanonicalName(general, destName)
+		if srcName, exists := unmatched[canonicalName]; exists {
+			delete(destFiles, destName)
+			destFiles[srcName] = destFile
+
+			// every source entry is matched by a single destination entry
+			delete(unmatched, canonicalName)
+		}
+	}
+}
+
+const (
+	invalidNameHandlingNone          = "none"
+	invalidNameHandlingSkip          = "skip"
+	invalidNameHandlingSanitize      = "sanitize"
+	invalidNameHandlingPercentEncode = "percentEncode"
+)
+
+// characters which are invalid in names on Windows file systems (NTFS, FAT, and SMB shares of them), as are control characters and trailing
+// dots and spaces
+const invalidNameCharacters = `<>:"/\|?*`
+
+// device names which are reserved on Windows, with any extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// isNameMapped reports whether names which are invalid on the destination are stored under mapped names
+func isNameMapped(general GeneralConfigurations) bool {
+	return general.InvalidNameHandling == invalidNameHandlingSanitize || general.InvalidNameHandling == invalidNameHandlingPercentEncode
+}
+
+// isInvalidName reports whether the name is invalid on Windows file systems
+func isInvalidName(name string) bool {
+	return mapName(GeneralConfigurations{InvalidNameHandling: invalidNameHandlingPercentEncode}, name) != name
+}
+
+// hasInvalidName reports whether any segment of the relative path is invalid on Windows file systems
+func hasInvalidName(relativePath string) bool {
+	for _, name := range strings.Split(relativePath, string(filepath.Separator)) {
+		if isInvalidName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// mapName returns the name a source entry is stored under in the destination: invalid characters (and trailing dots and spaces) are
+// replaced by the substitute, or percent encoded, and so is the first character of a reserved name (sanitized reserved names get the
+// substitute after them instead). a valid name is stored as it is, so the mapping of any stored name is the name itself
+func mapName(general GeneralConfigurations, name string) string {
+	escape := func(builder *strings.Builder, r rune) {
+		if general.InvalidNameHandling == invalidNameHandlingPercentEncode {
+			for _, b := range []byte(string(r)) {
+				fmt.Fprintf(builder, "%%%02X", b)
+			}
+		} else {
+			builder.WriteString(general.InvalidNameSubstitute)
+		}
+	}
+
+	trailing := len(strings.TrimRight(name, ". "))
+
+	var builder strings.Builder
+	for i, r := range name {
+		if r < 32 || strings.ContainsRune(invalidNameCharacters, r) || i >= trailing {
+			escape(&builder, r)
+		} else {
+			builder.WriteRune(r)
+		}
+	}
+	mapped := builder.String()
+
+	base, extension, found := strings.Cut(mapped, ".")
+	if !reservedNames[strings.ToUpper(base)] {
+		return mapped
+	}
+	if found {
+		extension = "." + extension
+	}
+
+	builder.Reset()
+	if general.InvalidNameHandling == invalidNameHandlingPercentEncode {
+		escape(&builder, rune(base[0]))
+		builder.WriteString(base[1:])
+	} else {
+		builder.WriteString(base)
+		builder.WriteString(general.InvalidNameSubstitute)
+	}
+	return builder.String() + extension
+}
+
+// getStoredPath returns the relative path a source path is stored under in the destination, every segment of it mapped
+func getStoredPath(general GeneralConfigurations, relativePath string) string {
+	if !isNameMapped(general) {
+		return relativePath
+	}
+
+	names := strings.Split(relativePath, string(filepath.Separator))
+	for i, name := range names {
+		names[i] = mapName(general, name)
+	}
+	return strings.Join(names, string(filepath.Separator))
+}
+
+// dropNameCollisions removes the source entries (by their paths relative to the directory) whose names are stored under the same name as
+// another entry of their directory, along with their contents, so a file never overwrites another in the destination. the entry whose name
+// is stored as it is wins, otherwise the first name does. the removed entries are warned about
+func dropNameCollisions(configs Config, relativeDir string, srcFiles map[string]os.FileInfo) {
+	if !isNameMapped(configs.General) {
+		return
+	}
+
+	// the entries of every stored path, by their names in order
+	paths := make([]string, 0, len(srcFiles))
+	for srcPath := range srcFiles {
+		paths = append(paths, srcPath)
+	}
+	sort.Strings(paths)
+	entries := make(map[string][]string)
+	for _, srcPath := range paths {
+		storedPath := filepath.Join(filepath.Dir(srcPath), mapName(configs.General, filepath.Base(srcPath)))
+		entries[storedPath] = append(entries[storedPath], srcPath)
+	}
+
+	var dropped []string
+	for storedPath, srcPaths := range entries {
+		if len(srcPaths) < 2 {
+			continue
+		}
+
+		winner := srcPaths[0]
+		for _, srcPath := range srcPaths {
+			if srcPath == storedPath {
+				winner = srcPath
+			}
+		}
+
+		for _, srcPath := range srcPaths {
+			if srcPath == winner {
+				continue
+			}
+
+			configs.General.logger.Warn("Skip", "path", filepath.Join(configs.General.SourceDirectory, relativeDir, srcPath), "reason", "name collision",
+				"storedAs", filepath.Join(relativeDir, storedPath), "collidesWith", filepath.Join(configs.General.SourceDirectory, relativeDir, winner))
+			dropped = append(dropped, srcPath)
+		}
+	}
+
+	for _, droppedPath := range dropped {
+		for srcPath := range srcFiles {
+			if srcPath == droppedPath || isSubPath(droppedPath, srcPath) {
+				delete(srcFiles, srcPath)
+			}
+		}
+	}
+}
+
+// excludeCollidingPaths returns the targeted paths, without the ones whose names collide with other entries of their source directories
+// once stored (the whole directories are listed, since the other entries may not be targeted)
+func excludeCollidingPaths(configs Config, relativePaths []string) []string {
+	if !isNameMapped(configs.General) {
+		return relativePaths
+	}
+
+	var kept []string
+	for _, relativePath := range relativePaths {
+		relativeDir, name := filepath.Dir(relativePath), filepath.Base(relativePath)
+		if relativeDir == "." {
+			relativeDir = ""
+		}
+
+		siblings := map[string]os.FileInfo{name: nil}
+		if entries, err := configs.General.source.ReadDir(filepath.Join(configs.General.SourceDirectory, relativeDir)); err == nil {
+			for _, entry := range entries {
+				siblings[entry.Name()] = nil
+			}
+		}
+
+		dropNameCollisions(configs, relativeDir, siblings)
+		if _, exists := siblings[name]; exists {
+			kept = append(kept, relativePath)
+		}
+	}
+	return kept
+}
+
+// mappedFS stores the entries of the destination directories under their mapped names, while they are passed under the names of their
+// source entries. listings return the stored names, which the scan pairs with the source names
+type mappedFS struct {
+	destinationFS
+	general GeneralConfigurations
+}
+
+func newMappedFS(configs Config, base destinationFS) *mappedFS {
+	return &mappedFS{destinationFS: base, general: configs.General}
+}
+
+// getStoredPath returns the path the entry is stored under, only the part of it inside a destination directory is mapped
+func (fsys *mappedFS) getStoredPath(path string) string {
+	for _, destDir := range fsys.general.DestinationDirectories {
+		if isSubPath(destDir, path) {
+			if relativePath := getRelativePath(destDir, path); len(relativePath) > 0 {
+				return filepath.Join(destDir, getStoredPath(fsys.general, relativePath))
+			}
+		}
+	}
+	return path
+}
+
+func (fsys *mappedFS) Stat(path string) (os.FileInfo, error) {
+	return fsys.destinationFS.Stat(fsys.getStoredPath(path))
+}
+
+func (fsys *mappedFS) Lstat(path string) (os.FileInfo, error) {
+	return fsys.destinationFS.Lstat(fsys.getStoredPath(path))
+}
+
+func (fsys *mappedFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	return fsys.destinationFS.ReadDir(fsys.getStoredPath(path))
+}
+
+func (fsys *mappedFS) Open(path string) (io.ReadCloser, error) {
+	return fsys.destinationFS.Open(fsys.getStoredPath(path))
+}
+
+func (fsys *mappedFS) Create(path string, srcFile os.FileInfo) (destinationFile, error) {
+	return fsys.destinationFS.Create(fsys.getStoredPath(path), srcFile)
+}
+
+func (fsys *mappedFS) Remove(path string) error {
+	return fsys.destinationFS.Remove(fsys.getStoredPath(path))
+}
+
+func (fsys *mappedFS) RemoveAll(path string) error {
+	return fsys.destinationFS.RemoveAll(fsys.getStoredPath(path))
+}
+
+func (fsys *mappedFS) MkdirAll(path string, perm os.FileMode) error {
+	return fsys.destinationFS.MkdirAll(fsys.getStoredPath(path), perm)
+}
+
+func (fsys *mappedFS) Chmod(path string, mode os.FileMode) error {
+	return fsys.destinationFS.Chmod(fsys.getStoredPath(path), mode)
+}
+
+func (fsys *mappedFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return fsys.destinationFS.Chtimes(fsys.getStoredPath(path), atime, mtime)
+}
+
+func (fsys *mappedFS) SyncDir(path string) error {
+	return syncDir(fsys.destinationFS, fsys.getStoredPath(path))
+}
+
+func (fsys *mappedFS) Rename(oldPath string, newPath string) error {
+	return fsys.destinationFS.Rename(fsys.getStoredPath(oldPath), fsys.getStoredPath(newPath))
+}
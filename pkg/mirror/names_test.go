@@ -0,0 +1,261 @@
+package mirror
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// names in their composed (NFC) and decomposed (NFD) forms, which look the same
+const (
+	cafeNFC     = "café.txt"
+	cafeNFD     = "cafe\u0301.txt"
+	angstromNFC = "Ångström"
+	angstromNFD = "A\u030angstro\u0308m"
+	hangulNFC   = "한글.txt"
+	hangulNFD   = "\u1112\u1161\u11ab\u1100\u1173\u11af.txt"
+)
+
+func TestGetCanonicalName(t *testing.T) {
+	tests := []struct {
+		normalization   string
+		caseInsensitive bool
+		name            string
+		expected        string
+	}{
+		{normalization: unicodeNormalizationNone, name: cafeNFD, expected: cafeNFD},
+		{normalization: unicodeNormalizationNFC, name: cafeNFD, expected: cafeNFC},
+		{normalization: unicodeNormalizationNFC, name: cafeNFC, expected: cafeNFC},
+		{normalization: unicodeNormalizationNFD, name: cafeNFC, expected: cafeNFD},
+		{normalization: unicodeNormalizationNFC, name: angstromNFD + "/" + hangulNFD, expected: angstromNFC + "/" + hangulNFC},
+		{normalization: unicodeNormalizationNFD, name: angstromNFC + "/" + hangulNFC, expected: angstromNFD + "/" + hangulNFD},
+		// combining marks in a non-canonical order are reordered
+		{normalization: unicodeNormalizationNFC, name: "e\u0307\u0323", expected: "\u1eb9\u0307"},
+		{normalization: unicodeNormalizationNone, caseInsensitive: true, name: "Docs/README.md", expected: "docs/readme.md"},
+		{normalization: unicodeNormalizationNFC, caseInsensitive: true, name: "CAFÉ.TXT", expected: "café.txt"},
+	}
+
+	for _, test := range tests {
+		general := GeneralConfigurations{UnicodeNormalization: test.normalization, CaseInsensitive: test.caseInsensitive}
+		if canonical := getCanonicalName(general, test.name); canonical != test.expected {
+			t.Errorf("getCanonicalName(%q) in %s form (case-insensitive %v) = %q, expected %q", test.name, test.normalization, test.caseInsensitive, canonical,
+				test.expected)
+		}
+	}
+}
+
+func TestMatchNames(t *testing.T) {
+	fsys := newMemFS("/dir")
+	fsys.writeFile("/dir/file", "file", modTime)
+	info, _ := fsys.Stat("/dir/file")
+
+	tests := []struct {
+		name            string
+		normalization   string
+		caseInsensitive bool
+		srcNames        []string
+		destNames       []string
+		expected        []string
+	}{
+		{name: "no matching", normalization: unicodeNormalizationNone, srcNames: []string{cafeNFC}, destNames: []string{cafeNFD},
+			expected: []string{cafeNFD}},
+		{name: "decomposed destination", normalization: unicodeNormalizationNFC, srcNames: []string{cafeNFC, angstromNFC, angstromNFC + "/" + hangulNFC},
+			destNames: []string{cafeNFD, angstromNFD, angstromNFD + "/" + hangulNFD}, expected: []string{cafeNFC, angstromNFC, angstromNFC + "/" + hangulNFC}},
+		{name: "composed destination", normalization: unicodeNormalizationNFD, srcNames: []string{cafeNFD}, destNames: []string{cafeNFC},
+			expected: []string{cafeNFD}},
+		{name: "case-insensitive destination", normalization: unicodeNormalizationNone, caseInsensitive: true, srcNames: []string{"README.md"},
+			destNames: []string{"readme.md"}, expected: []string{"README.md"}},
+		// an entry of the source name itself is compared as it is, so the other form is not matched
+		{name: "both forms in the destination", normalization: unicodeNormalizationNFC, srcNames: []string{cafeNFC}, destNames: []string{cafeNFC, cafeNFD},
+			expected: []string{cafeNFC, cafeNFD}},
+		// a destination entry matches a single source entry
+		{name: "both forms in the source", normalization: unicodeNormalizationNFC, srcNames: []string{"x/" + cafeNFC, "y/" + cafeNFC}, destNames: []string{"x/" + cafeNFD},
+			expected: []string{"x/" + cafeNFC}},
+		{name: "unrelated names", normalization: unicodeNormalizationNFC, caseInsensitive: true, srcNames: []string{"a.txt"}, destNames: []string{"b.txt"},
+			expected: []string{"b.txt"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srcFiles := make(map[string]os.FileInfo)
+			for _, name := range test.srcNames {
+				srcFiles[name] = info
+			}
+			destFiles := make(map[string]os.FileInfo)
+			for _, name := range test.destNames {
+				destFiles[name] = info
+			}
+
+			matchNames(GeneralConfigurations{UnicodeNormalization: test.normalization, CaseInsensitive: test.caseInsensitive}, srcFiles, destFiles)
+
+			if len(destFiles) != len(test.expected) {
+				t.Errorf("destination entries are %q, expected %q", getSortedKeys(destFiles), test.expected)
+			}
+			for _, name := range test.expected {
+				if _, exists := destFiles[name]; !exists {
+					t.Errorf("destination entries are %q, expected %q", getSortedKeys(destFiles), test.expected)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSyncMatchesNormalizedNames(t *testing.T) {
+	tests := []struct {
+		name            string
+		normalization   string
+		caseInsensitive bool
+		srcName         string
+		destName        string
+	}{
+		{name: "composed source", normalization: unicodeNormalizationNFC, srcName: cafeNFC, destName: cafeNFD},
+		{name: "decomposed source", normalization: unicodeNormalizationNFD, srcName: hangulNFD, destName: hangulNFC},
+		{name: "nested", normalization: unicodeNormalizationNFC, srcName: angstromNFC + "/" + cafeNFC, destName: angstromNFD + "/" + cafeNFD},
+		{name: "case", normalization: unicodeNormalizationNone, caseInsensitive: true, srcName: "Docs/README.md", destName: "docs/readme.md"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mirror, fsys := newMemMirror(t, func(config *Config) {
+				config.General.UnicodeNormalization = test.normalization
+				config.General.CaseInsensitive = test.caseInsensitive
+			})
+			// the destination resolves names the way it compares them, like the file system of a macOS (or a Windows) destination
+			general := GeneralConfigurations{UnicodeNormalization: test.normalization, CaseInsensitive: test.caseInsensitive}
+			mirror.setFileSystems(fsys, newFoldingFS(fsys, memDestination, func(name string) string {
+				return getCanonicalName(general, name)
+			}))
+
+			fsys.writeFile("/src/"+test.srcName, "same", modTime)
+			// the destination file system keeps the names in the other form
+			fsys.writeFile("/dst/"+test.destName, "same", modTime)
+			expected := fsys.tree(memDestination)
+
+			// the names which differ in their form only are neither copied again nor deleted, by any iteration
+			for i := 0; i < 2; i++ {
+				if summary := mustSyncOnce(t, mirror); summary.FilesCopied != 0 || summary.FilesDeleted != 0 {
+					t.Errorf("iteration %d copied %d and deleted %d files, expected none", i, summary.FilesCopied, summary.FilesDeleted)
+				}
+			}
+			assertTree(t, fsys, memDestination, expected...)
+		})
+	}
+}
+
+func TestSyncUpdatesNormalizedNames(t *testing.T) {
+	mirror, fsys := newMemMirror(t, func(config *Config) {
+		config.General.UnicodeNormalization = unicodeNormalizationNFC
+	})
+	general := GeneralConfigurations{UnicodeNormalization: unicodeNormalizationNFC}
+	mirror.setFileSystems(fsys, newFoldingFS(fsys, memDestination, func(name string) string {
+		return getCanonicalName(general, name)
+	}))
+	fsys.writeFile("/src/"+cafeNFC, "new", modTime.Add(time.Hour))
+	fsys.writeFile("/dst/"+cafeNFD, "old", modTime)
+
+	summary := mustSyncOnce(t, mirror)
+
+	// the destination entry is replaced under its own name, rather than kept along with a copy of the other form
+	assertTree(t, fsys, memDestination, cafeNFD+"=new")
+	if summary.FilesCopied != 1 || summary.FilesDeleted != 0 {
+		t.Errorf("copied %d and deleted %d files, expected 1 copied and none deleted", summary.FilesCopied, summary.FilesDeleted)
+	}
+}
+
+// getSortedKeys returns the paths of the entries, sorted
+func getSortedKeys(files map[string]os.FileInfo) []string {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// foldingFS resolves the names under its root to the existing entries whose folded names match, the way file systems which normalize
+// names (or ignore their case) do, while new entries keep the names they are created with
+type foldingFS struct {
+	destinationFS
+	root string
+	fold func(name string) string
+}
+
+func newFoldingFS(base destinationFS, root string, fold func(name string) string) *foldingFS {
+	return &foldingFS{destinationFS: base, root: filepath.Clean(root), fold: fold}
+}
+
+// resolve returns the path of the existing entry the path refers to, every missing name of it is kept as it is
+func (fsys *foldingFS) resolve(path string) string {
+	relativePath, err := filepath.Rel(fsys.root, filepath.Clean(path))
+	if err != nil || relativePath == "." || strings.HasPrefix(relativePath, "..") {
+		return path
+	}
+
+	resolved := fsys.root
+	for _, name := range strings.Split(relativePath, string(filepath.Separator)) {
+		match := name
+		if entries, err := fsys.destinationFS.ReadDir(resolved); err == nil {
+			for _, entry := range entries {
+				if entry.Name() == name {
+					match = name
+					break
+				}
+				if fsys.fold(entry.Name()) == fsys.fold(name) {
+					match = entry.Name()
+				}
+			}
+		}
+		resolved = filepath.Join(resolved, match)
+	}
+	return resolved
+}
+
+func (fsys *foldingFS) Stat(path string) (os.FileInfo, error) {
+	return fsys.destinationFS.Stat(fsys.resolve(path))
+}
+
+func (fsys *foldingFS) Lstat(path string) (os.FileInfo, error) {
+	return fsys.destinationFS.Lstat(fsys.resolve(path))
+}
+
+func (fsys *foldingFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	return fsys.destinationFS.ReadDir(fsys.resolve(path))
+}
+
+func (fsys *foldingFS) Open(path string) (io.ReadCloser, error) {
+	return fsys.destinationFS.Open(fsys.resolve(path))
+}
+
+func (fsys *foldingFS) Create(path string, srcFile os.FileInfo) (destinationFile, error) {
+	return fsys.destinationFS.Create(fsys.resolve(path), srcFile)
+}
+
+func (fsys *foldingFS) Remove(path string) error {
+	return fsys.destinationFS.Remove(fsys.resolve(path))
+}
+
+func (fsys *foldingFS) RemoveAll(path string) error {
+	return fsys.destinationFS.RemoveAll(fsys.resolve(path))
+}
+
+func (fsys *foldingFS) MkdirAll(path string, perm os.FileMode) error {
+	return fsys.destinationFS.MkdirAll(fsys.resolve(path), perm)
+}
+
+func (fsys *foldingFS) Chmod(path string, mode os.FileMode) error {
+	return fsys.destinationFS.Chmod(fsys.resolve(path), mode)
+}
+
+func (fsys *foldingFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return fsys.destinationFS.Chtimes(fsys.resolve(path), atime, mtime)
+}
+
+func (fsys *foldingFS) Rename(oldPath string, newPath string) error {
+	return fsys.destinationFS.Rename(fsys.resolve(oldPath), fsys.resolve(newPath))
+}
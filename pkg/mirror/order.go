@@ -0,0 +1,90 @@
+package mirror
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	copyOrderNone             = "none"
+	copyOrderSmallestFirst    = "smallestFirst"
+	copyOrderLargestFirst     = "largestFirst"
+	copyOrderPriorityPatterns = "priorityPatterns"
+)
+
+const (
+	deletePhaseInterleaved = "interleaved"
+	deletePhaseAfterCopies = "afterCopies"
+)
+
+// getCopyOrder returns the relative paths of the source files in the order their operations are scheduled (the operations run in their
+// order, as workers free up): directories first, since they are created right away, and then files by the copy order. files of the same
+// rank are ordered by their path. without a copy order, the paths are in no particular order
+func getCopyOrder(configs Config, srcFiles map[string]os.FileInfo) []string {
+	paths := make([]string, 0, len(srcFiles))
+	for srcPath := range srcFiles {
+		paths = append(paths, srcPath)
+	}
+
+	if configs.General.CopyOrder == copyOrderNone {
+		return paths
+	}
+
+	// get the rank of every file, a lower rank is scheduled first
+	ranks := make(map[string]int64, len(paths))
+	for _, srcPath := range paths {
+		switch configs.General.CopyOrder {
+		case copyOrderSmallestFirst:
+			ranks[srcPath] = srcFiles[srcPath].Size()
+		case copyOrderLargestFirst:
+			ranks[srcPath] = -srcFiles[srcPath].Size()
+		case copyOrderPriorityPatterns:
+			if !matchesAnyPattern(configs.General.PriorityPatterns, srcPath) {
+				ranks[srcPath] = 1
+			}
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		first, second := srcFiles[paths[i]], srcFiles[paths[j]]
+		if first.IsDir() != second.IsDir() {
+			return first.IsDir()
+		}
+		if ranks[paths[i]] != ranks[paths[j]] {
+			return ranks[paths[i]] < ranks[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+
+	return paths
+}
+
+// runDeletions runs the deletions of every destination, once all other operations ended (none are left when they were interleaved with the
+// other operations). the deletions of a destination whose operations failed too often are skipped, since the failures may mean the source
+// is not what it seems (e.g. a share which went away during the iteration)
+func runDeletions(ctx context.Context, configs Config, destConfigsList []Config, destStats []*iterationStats, deleteFuncs [][]func(), wg *sync.WaitGroup) {
+	var jobFuncs []func()
+	for i, deletions := range deleteFuncs {
+		threshold := configs.General.DeletePhaseFailureThreshold
+		if threshold > 0 && len(deletions) > 0 && destStats[i].filesFailed >= int64(threshold) {
+			destConfigsList[i].General.logger.Warn("Skipping deletions, too many operations failed", "destination", destConfigsList[i].General.DestinationDirectory,
+				"failed", destStats[i].filesFailed, "deletePhaseFailureThreshold", threshold)
+			destStats[i].deletionsSkipped += int64(len(deletions))
+
+			// since the operations are dropped, count them as -1 each in WaitGroup counter
+			wg.Add(-len(deletions))
+			continue
+		}
+
+		jobFuncs = append(jobFuncs, deletions...)
+	}
+
+	if len(jobFuncs) < 1 {
+		return
+	}
+
+	configs.General.status.setPhase(statusPhaseDeleting)
+	runJobs(ctx, configs, jobFuncs, wg)
+}
@@ -0,0 +1,102 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const (
+	overwritePolicyAlways  = "always"
+	overwritePolicyIfNewer = "ifNewer"
+	overwritePolicyNever   = "never"
+)
+
+// suffix of the copies of conflicting destination files, which are kept when the source file replaces them, followed by the date
+const conflictSuffix = ".conflict-"
+
+// names of conflict copies, the date is followed by a counter when a file conflicted more than once on the same day
+var conflictNamePattern = regexp.MustCompile(`\.conflict-[0-9]{8}(-[0-9]+)?$`)
+
+// getOverwriteConflict returns the conflict the overwrite policy sees in replacing the changed destination file (e.g. it is newer than the
+// source file, so it was most likely edited in place), or an empty string if it can be replaced
+func getOverwriteConflict(configs Config, srcFile os.FileInfo, destFile os.FileInfo) string {
+	switch configs.General.OverwritePolicy {
+	case overwritePolicyIfNewer:
+		if srcFile.ModTime().After(destFile.ModTime()) {
+			return ""
+		}
+		if destFile.ModTime().After(srcFile.ModTime()) {
+			return "destination newer"
+		}
+		return "destination differs"
+	case overwritePolicyNever:
+		return "destination exists"
+	}
+
+	return ""
+}
+
+// resolveConflict warns about the conflict, and reports whether the source file replaces the destination file anyway, which is the case when
+// conflict copies are kept (the destination file is renamed to its conflict copy first). otherwise the destination file is left alone
+func resolveConflict(configs Config, srcPath string, srcFile os.FileInfo, path string, destFile os.FileInfo, conflict string) (bool, error) {
+	configs.General.logger.Warn("Conflict", "path", path, "reason", conflict, "sourceModTime", srcFile.ModTime(), "destinationModTime", destFile.ModTime())
+
+	if !configs.General.ConflictBackup {
+		emitSkipEvent(configs, getRelativePath(configs.General.SourceDirectory, srcPath), srcFile.Size(), conflict)
+		return false, nil
+	}
+
+	conflictPath, err := getConflictPath(configs.General.destination, path)
+	if err != nil {
+		return false, err
+	}
+
+	// in dry run mode, only report the destination file would be kept
+	if configs.General.DryRun {
+		configs.General.logger.Info("WOULD Move", "path", path, "target", conflictPath)
+		return true, nil
+	}
+
+	if err := configs.General.destination.Rename(path, conflictPath); err != nil {
+		return false, err
+	}
+
+	configs.General.logger.Info("Move", "path", path, "target", conflictPath)
+	emitEvent(configs, eventActionMove, conflictPath, 0, 0, nil)
+	return true, nil
+}
+
+// getConflictPath returns a free path for the conflict copy of the destination file, named by the current date
+func getConflictPath(fsys destinationFS, path string) (string, error) {
+	date := time.Now().Format("20060102")
+	conflictPath := path + conflictSuffix + date
+
+	for i := 2; ; i++ {
+		_, err := fsys.Lstat(conflictPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return conflictPath, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		conflictPath = fmt.Sprintf("%s%s%s-%d", path, conflictSuffix, date, i)
+	}
+}
+
+// excludeConflictFiles removes the conflict copies from the destination files, so they are never deleted (unless the source has files of the
+// same names, which are mirrored as any other file)
+func excludeConflictFiles(srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) {
+	for dstPath, dstFile := range destFiles {
+		if _, exists := srcFiles[dstPath]; exists || dstFile.IsDir() || !conflictNamePattern.MatchString(filepath.Base(dstPath)) {
+			continue
+		}
+
+		delete(destFiles, dstPath)
+	}
+}
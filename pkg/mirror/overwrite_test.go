@@ -0,0 +1,115 @@
+package mirror
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetOverwriteConflict(t *testing.T) {
+	fsys := newMemFS("/dir")
+	fsys.writeFile("/dir/old", "old", modTime)
+	fsys.writeFile("/dir/new", "new", modTime.Add(time.Hour))
+	fsys.writeFile("/dir/same", "same time", modTime)
+
+	files := make(map[string]os.FileInfo)
+	for _, name := range []string{"old", "new", "same"} {
+		files[name], _ = fsys.Stat("/dir/" + name)
+	}
+
+	tests := []struct {
+		policy   string
+		srcFile  string
+		destFile string
+		conflict string
+	}{
+		{policy: overwritePolicyAlways, srcFile: "old", destFile: "new", conflict: ""},
+		{policy: overwritePolicyIfNewer, srcFile: "new", destFile: "old", conflict: ""},
+		{policy: overwritePolicyIfNewer, srcFile: "old", destFile: "new", conflict: "destination newer"},
+		{policy: overwritePolicyIfNewer, srcFile: "old", destFile: "same", conflict: "destination differs"},
+		{policy: overwritePolicyNever, srcFile: "new", destFile: "old", conflict: "destination exists"},
+	}
+
+	for _, test := range tests {
+		var configs Config
+		configs.General.OverwritePolicy = test.policy
+
+		if conflict := getOverwriteConflict(configs, files[test.srcFile], files[test.destFile]); conflict != test.conflict {
+			t.Errorf("%s policy with the %s source and the %s destination is in conflict %q, expected %q", test.policy, test.srcFile, test.destFile,
+				conflict, test.conflict)
+		}
+	}
+}
+
+func TestSyncOverwritePolicies(t *testing.T) {
+	tests := []struct {
+		policy   string
+		backup   bool
+		expected []string
+	}{
+		// the destination file edited in place is replaced, and so is the older one
+		{policy: overwritePolicyAlways, expected: []string{"edited.txt=source", "missing.txt=missing", "older.txt=source"}},
+		// only the older destination file is replaced
+		{policy: overwritePolicyIfNewer, expected: []string{"edited.txt=edited", "missing.txt=missing", "older.txt=source"}},
+		// only the missing file is copied
+		{policy: overwritePolicyNever, expected: []string{"edited.txt=edited", "missing.txt=missing", "older.txt=older"}},
+		// conflicting files are kept aside, and replaced
+		{policy: overwritePolicyIfNewer, backup: true, expected: []string{"edited.txt" + conflictSuffix + "DATE=edited", "edited.txt=source", "missing.txt=missing",
+			"older.txt=source"}},
+		{policy: overwritePolicyNever, backup: true, expected: []string{"edited.txt" + conflictSuffix + "DATE=edited", "edited.txt=source", "missing.txt=missing",
+			"older.txt" + conflictSuffix + "DATE=older", "older.txt=source"}},
+	}
+
+	for _, test := range tests {
+		name := test.policy
+		if test.backup {
+			name += " with conflict backup"
+		}
+		t.Run(name, func(t *testing.T) {
+			mirror, fsys := newMemMirror(t, func(config *Config) {
+				config.General.OverwritePolicy = test.policy
+				config.General.ConflictBackup = test.backup
+			})
+			fsys.writeFile("/src/edited.txt", "source", modTime)
+			fsys.writeFile("/src/older.txt", "source", modTime)
+			fsys.writeFile("/src/missing.txt", "missing", modTime)
+			fsys.writeFile("/dst/edited.txt", "edited", modTime.Add(time.Hour))
+			fsys.writeFile("/dst/older.txt", "older", modTime.Add(-time.Hour))
+
+			mustSyncOnce(t, mirror)
+			// the conflict copies are kept by the next iteration too
+			mustSyncOnce(t, mirror)
+
+			assertTree(t, fsys, memDestination, replaceConflictDates(test.expected)...)
+		})
+	}
+}
+
+func TestConflictPathOfRepeatedConflicts(t *testing.T) {
+	fsys := newMemFS("/dst")
+	fsys.writeFile("/dst/a.txt", "a", modTime)
+
+	date := time.Now().Format("20060102")
+	for _, expected := range []string{"/dst/a.txt.conflict-" + date, "/dst/a.txt.conflict-" + date + "-2", "/dst/a.txt.conflict-" + date + "-3"} {
+		conflictPath, err := getConflictPath(fsys, "/dst/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conflictPath != expected {
+			t.Errorf("conflict path is '%s', expected '%s'", conflictPath, expected)
+		}
+		fsys.writeFile(conflictPath, "conflict", modTime)
+	}
+}
+
+// replaceConflictDates replaces the DATE placeholder of the paths with the current date, which conflict copies are named by
+func replaceConflictDates(paths []string) []string {
+	date := time.Now().Format("20060102")
+
+	replaced := make([]string, len(paths))
+	for i, path := range paths {
+		replaced[i] = strings.Replace(path, "DATE", date, 1)
+	}
+	return replaced
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+func preserveOwnership(configs Config, stats *iterationStats, srcFile os.FileInfo, path string) error {
+	// nothing to do unless requested
+	if !configs.General.PreserveOwnership || configs.General.DryRun {
+		return nil
+	}
+
+	// get the owner of the source file
+	srcStat, ok := srcFile.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	// set same owner as source file (a symlink itself is changed, never its target)
+	var err error
+	if isSymlink(srcFile) {
+		err = os.Lchown(path, int(srcStat.Uid), int(srcStat.Gid))
+	} else {
+		err = os.Chown(path, int(srcStat.Uid), int(srcStat.Gid))
+	}
+
+	// lack of privilege is expected when not running as root, so only warn (once per iteration) instead of failing every file
+	if errors.Is(err, fs.ErrPermission) {
+		if stats.warnOnce(&stats.ownershipWarned) {
+			configs.General.logger.Warn("Ownership can not be preserved", "error", err)
+		}
+		return nil
+	}
+
+	return err
+}
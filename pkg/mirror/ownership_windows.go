@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"os"
+)
+
+func preserveOwnership(configs Config, stats *iterationStats, srcFile os.FileInfo, path string) error {
+	// ownership is not represented by uid/gid on windows, so there is nothing to preserve
+	return nil
+}
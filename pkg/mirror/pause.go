@@ -0,0 +1,171 @@
+package mirror
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobControl holds the paused state of a single mirror job, which can be changed while the job runs
+type jobControl struct {
+	mutex  sync.Mutex
+	paused bool
+	// a failed job (which had too many failures) idles like a paused one, until resumed or until its cool-down ends
+	failed       bool
+	failedAt     time.Time
+	failedReason string
+	// failures counted towards the limits, of the running iteration and of the iterations in a row which had any
+	iterationErrors  int64
+	failedIterations int
+	// closed (and replaced) whenever the paused state changes, to wake up the job
+	changed chan struct{}
+	// closed once an operation of the running iteration is skipped, to release the operations waiting for it (replaced by every iteration)
+	skipped chan struct{}
+}
+
+// registry of controls of all jobs, by job name
+var (
+	controlMutex sync.Mutex
+	controlJobs  = make(map[string]*jobControl)
+)
+
+// registerJobControl returns the control of the job, which is kept when the job is restarted
+func registerJobControl(configs Config) *jobControl {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+
+	// jobs of the same name share their control
+	name := getJobName(configs)
+	if _, exists := controlJobs[name]; !exists {
+		controlJobs[name] = &jobControl{changed: make(chan struct{}), skipped: make(chan struct{})}
+	}
+
+	return controlJobs[name]
+}
+
+// state returns whether the job is paused (or failed), and a channel which is closed once that changes
+func (control *jobControl) state() (paused bool, changed <-chan struct{}) {
+	if control == nil {
+		return false, nil
+	}
+
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	return control.paused || control.failed, control.changed
+}
+
+// isPaused reports whether the job was paused on request (a failed job is reported by isFailed)
+func (control *jobControl) isPaused() bool {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	return control.paused
+}
+
+// setPaused pauses or resumes the job, resuming a failed job lets it try again
+func (control *jobControl) setPaused(paused bool) {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if control.paused == paused && (paused || !control.failed) {
+		return
+	}
+
+	control.paused = paused
+	if !paused {
+		control.clearFailed()
+	}
+	control.notifyChanged()
+}
+
+// notifyChanged wakes up the job, the mutex must be held
+func (control *jobControl) notifyChanged() {
+	close(control.changed)
+	control.changed = make(chan struct{})
+}
+
+// setAllPaused pauses or resumes all jobs
+func setAllPaused(paused bool) {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+
+	for _, control := range controlJobs {
+		control.setPaused(paused)
+	}
+}
+
+// waitWhilePaused blocks while the job is paused, and reports whether it was paused (false when termination is requested in the meantime)
+func waitWhilePaused(ctx context.Context, configs Config) bool {
+	paused, changed := configs.General.control.state()
+	if !paused {
+		return false
+	}
+
+	// a failed job was logged as such already
+	if configs.General.control.isPaused() {
+		configs.General.logger.Info("Paused")
+	}
+
+	for paused {
+		// a failed job tries again once its cool-down ends, if set
+		var cooldownEnded <-chan time.Time
+		if remaining, cooling := configs.General.control.getCooldown(configs); cooling {
+			cooldownEnded = time.After(remaining)
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		case <-cooldownEnded:
+			configs.General.logger.Info("Cool-down ended, retrying")
+			configs.General.control.setFailed(false, "")
+		}
+
+		paused, changed = configs.General.control.state()
+	}
+
+	configs.General.logger.Info("Resumed")
+	return true
+}
+
+// serveJobControl pauses or resumes a job, by POST /jobs/<job>/pause or /jobs/<job>/resume
+func serveJobControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requested, action := path.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"))
+	if action != "pause" && action != "resume" {
+		http.NotFound(w, r)
+		return
+	}
+
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+
+	for name, control := range controlJobs {
+		if !isJobNamed(name, requested) {
+			continue
+		}
+
+		control.setPaused(action == "pause")
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+// isJobNamed reports whether the job name matches the name requested in a URL path
+// (job names made of directories contain separators, and the path is cleaned before it gets here, so compare cleaned names)
+func isJobNamed(name string, requested string) bool {
+	return path.Clean("/"+name) == path.Clean("/"+requested)
+}
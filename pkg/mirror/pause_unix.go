@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package mirror
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handlePauseSignals pauses all jobs on SIGUSR1, and resumes them on SIGUSR2, until the context is cancelled
+func handlePauseSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				setAllPaused(sig == syscall.SIGUSR1)
+			}
+		}
+	}()
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import "context"
+
+// handlePauseSignals does nothing, since there are no user signals on windows (jobs can still be paused by the status server)
+func handlePauseSignals(ctx context.Context) {
+}
@@ -0,0 +1,73 @@
+package mirror
+
+import (
+	"sync"
+)
+
+// workerPool runs the operations of a job on a fixed count of workers, which are kept across iterations
+type workerPool struct {
+	mutex sync.Mutex
+	jobs  chan func()
+	// stop signal of every running worker
+	stops   []chan struct{}
+	workers sync.WaitGroup
+}
+
+// newWorkerPool starts a pool of the given count of workers (none when the count of concurrent workers is unlimited)
+func newWorkerPool(size int) *workerPool {
+	pool := &workerPool{jobs: make(chan func())}
+	pool.resize(size)
+
+	return pool
+}
+
+// resize starts or stops workers to match the given count, a stopped worker finishes its running operation first
+func (pool *workerPool) resize(size int) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for len(pool.stops) < size {
+		stop := make(chan struct{})
+		pool.stops = append(pool.stops, stop)
+
+		pool.workers.Add(1)
+		go pool.work(stop)
+	}
+
+	for len(pool.stops) > size && len(pool.stops) > 0 {
+		close(pool.stops[len(pool.stops)-1])
+		pool.stops = pool.stops[:len(pool.stops)-1]
+	}
+}
+
+func (pool *workerPool) work(stop chan struct{}) {
+	defer pool.workers.Done()
+
+	// pool operations until stopped, or until the pool is closed
+	for {
+		select {
+		case <-stop:
+			return
+		case job, ok := <-pool.jobs:
+			if !ok {
+				return
+			}
+			job()
+		}
+	}
+}
+
+// schedule queues the operations, blocking until every one of them was taken by a worker. every operation takes its place within the total
+// workers limit of all jobs (if any) first, in the order of the operations (so a link never holds a place its target waits for)
+func (pool *workerPool) schedule(totalWorkers *workerLimit, jobFuncs []func()) {
+	for _, jobFunc := range jobFuncs {
+		totalWorkers.acquire()
+		pool.jobs <- jobFunc
+	}
+}
+
+// close stops the workers once they finish their running operations, and waits for them
+func (pool *workerPool) close() {
+	close(pool.jobs)
+	pool.workers.Wait()
+}
@@ -0,0 +1,107 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runPoolIteration schedules the count of operations on the pool as runJobs does, and waits for them to end
+func runPoolIteration(pool *workerPool, count int, ran *atomic.Int64) {
+	var done sync.WaitGroup
+	done.Add(count)
+
+	jobFuncs := make([]func(), count)
+	for i := range jobFuncs {
+		jobFuncs[i] = func() {
+			defer done.Done()
+
+			ran.Add(1)
+		}
+	}
+
+	pool.schedule(nil, jobFuncs)
+	done.Wait()
+}
+
+func TestWorkerPoolIterations(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	pool := newWorkerPool(4)
+
+	// many small iterations back to back, with the workers resized meanwhile (as a reload does)
+	var ran atomic.Int64
+	for i := 0; i < 500; i++ {
+		switch i {
+		case 100:
+			pool.resize(8)
+		case 300:
+			pool.resize(1)
+		}
+		runPoolIteration(pool, 10, &ran)
+	}
+
+	if count := ran.Load(); count != 5000 {
+		t.Errorf("%d operations ran, expected 5000", count)
+	}
+
+	// closing the pool stops all of its workers
+	pool.close()
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > goroutines && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := runtime.NumGoroutine(); count > goroutines {
+		t.Errorf("%d goroutines are left once the pool is closed, expected %d", count, goroutines)
+	}
+}
+
+func TestWorkerPoolCloseFinishesRunningOperations(t *testing.T) {
+	pool := newWorkerPool(2)
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	go pool.schedule(nil, []func(){func() {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		finished.Store(true)
+	}})
+
+	<-started
+	pool.close()
+
+	if !finished.Load() {
+		t.Error("pool was closed before its running operation finished")
+	}
+}
+
+func TestJobIterationsShareWorkers(t *testing.T) {
+	mirror, fsys := newMemMirror(t, func(config *Config) {
+		config.General.MaxConcurrentWorkers = 4
+	})
+
+	// iterations of an opened job run on the same workers, as the iterations of the scan loop do
+	configs, closeJob, err := openJob(mirror.configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workers := configs.General.workers
+
+	for i := 0; i < 50; i++ {
+		fsys.writeFile("/src/file.txt", fmt.Sprint(i), modTime.Add(time.Duration(i)*time.Second))
+		fsys.writeFile(fmt.Sprintf("/src/dir/%d.txt", i), fmt.Sprint(i), modTime)
+
+		stats := syncDirectories(context.Background(), configs)
+		if stats.filesCopied != 2 || stats.filesFailed != 0 {
+			t.Fatalf("iteration %d copied %d files (%d failed), expected 2 copied", i, stats.filesCopied, stats.filesFailed)
+		}
+		if configs.General.workers != workers {
+			t.Fatalf("iteration %d replaced the workers of the job", i)
+		}
+	}
+
+	closeJob()
+}
@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package mirror
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocateFile allocates the blocks of the file up to the size (extending it), so the file system can allocate them contiguously. a file
+// system which does not support it is left to allocate the blocks as they are written
+func preallocateFile(file *os.File, size int64) error {
+	err := unix.Fallocate(int(file.Fd()), 0, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "fallocate", Path: file.Name(), Err: err}
+	}
+	return nil
+}
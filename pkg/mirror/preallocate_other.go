@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package mirror
+
+import (
+	"os"
+)
+
+// preallocateFile does nothing, files are preallocated on linux and windows only (the file system allocates the blocks as they are written)
+func preallocateFile(file *os.File, size int64) error {
+	return nil
+}
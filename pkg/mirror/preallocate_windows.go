@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"os"
+)
+
+// preallocateFile extends the file to the size (SetEndOfFile), so the file system allocates its clusters up front
+func preallocateFile(file *os.File, size int64) error {
+	return file.Truncate(size)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package mirror
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isProcessAlive reports whether a process of the id runs on this host (a process of another user counts as well)
+func isProcessAlive(pid int) bool {
+	if pid < 1 {
+		return false
+	}
+
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// exit code of a process which did not exit yet
+const stillActive = 259
+
+// isProcessAlive reports whether a process of the id runs on this host (a process of another user counts as well)
+func isProcessAlive(pid int) bool {
+	if pid < 1 {
+		return false
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer windows.CloseHandle(handle)
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(handle, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
@@ -0,0 +1,136 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// interval between progress lines of a large file copy
+const progressInterval = 5 * time.Second
+
+// progressReader counts the bytes read from the underlying reader, the count may be read concurrently
+type progressReader struct {
+	reader io.Reader
+	copied int64
+}
+
+func (progress *progressReader) Read(p []byte) (int, error) {
+	n, err := progress.reader.Read(p)
+	atomic.AddInt64(&progress.copied, int64(n))
+
+	return n, err
+}
+
+// reportProgress logs the progress of the copy of the file periodically, until the returned function is called, which logs the final throughput of a finished copy
+func reportProgress(logger *slog.Logger, path string, total int64, progress *progressReader) func(finished bool) {
+	start := time.Now()
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				copied := atomic.LoadInt64(&progress.copied)
+				elapsed := time.Since(start)
+				bytesPerSecond := int64(float64(copied) / elapsed.Seconds())
+
+				// estimate the remaining time by the average throughput so far
+				var eta time.Duration
+				if bytesPerSecond > 0 {
+					eta = time.Duration(float64(total-copied) / float64(bytesPerSecond) * float64(time.Second)).Round(time.Second)
+				}
+
+				logger.Info("Progress", "path", path, "percent", copied*100/total, "bytes", copied, "totalBytes", total, "bytesPerSecond", bytesPerSecond, "eta", eta)
+			}
+		}
+	}()
+
+	return func(finished bool) {
+		close(done)
+
+		if !finished {
+			return
+		}
+
+		elapsed := time.Since(start)
+		copied := atomic.LoadInt64(&progress.copied)
+		logger.Info("Copy finished", "path", path, "bytes", copied, "duration", elapsed.Round(time.Millisecond), "bytesPerSecond", int64(float64(copied)/elapsed.Seconds()))
+	}
+}
+
+// errOperationTimeout is the cause of a copy which was abandoned, since it transferred no bytes for longer than the operation timeout
+var errOperationTimeout = errors.New("Operation timed out")
+
+// idleTransfer counts the bytes read by a copy with an idle timeout, until its transfer ends (flushing and verifying the written file, which
+// follow it, are not timed out)
+type idleTransfer struct {
+	progressReader
+	ended chan struct{}
+}
+
+// copyFileWithIdleTimeout copies the file as copyFile does, and abandons the copy once it transferred no bytes for the idle timeout (e.g. it
+// hangs on an unresponsive network share). the copy runs aside, so a copy stuck inside a single read or write (which the context can not
+// interrupt) frees its worker right away, and fails at its next chunk once the stuck call returns, closing its files itself
+func copyFileWithIdleTimeout(ctx context.Context, src string, dst string, options copyOptions) error {
+	timeout := options.idleTimeout
+	transfer := &idleTransfer{ended: make(chan struct{})}
+	options.idleTimeout = 0
+	options.transfer = transfer
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	copied := make(chan error, 1)
+	go func() {
+		copied <- copyFile(ctx, src, dst, options)
+	}()
+
+	// check the progress a few times within the timeout, so a stall is detected soon after the timeout
+	ticker := time.NewTicker(min(timeout/4, time.Second))
+	defer ticker.Stop()
+
+	var count int64
+	lastProgress := time.Now()
+	for {
+		select {
+		case err := <-copied:
+			return err
+		case <-transfer.ended:
+			return <-copied
+		case now := <-ticker.C:
+			if current := atomic.LoadInt64(&transfer.copied); current != count {
+				count, lastProgress = current, now
+				continue
+			}
+
+			idle := now.Sub(lastProgress)
+			if idle < timeout {
+				continue
+			}
+
+			err := fmt.Errorf("%w, no bytes were transferred for %s", errOperationTimeout, idle.Round(time.Second))
+			cancel(err)
+
+			// remove the partial destination file right away, rather than once the stuck call returns (which may never happen), unless it is
+			// kept to resume the copy
+			if !options.keepPartial {
+				fsys := options.destination
+				if fsys == nil {
+					fsys = localFS{}
+				}
+				fsys.Remove(dst)
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// quarantineFile moves the destination file (or directory) into the quarantine directory instead of removing it, and returns the path it
+// was moved to: its relative path, followed by the time it was quarantined (numbered if a path was quarantined within the same second), so
+// files removed at different times do not collide
+func quarantineFile(configs Config, path string) (string, error) {
+	now := time.Now()
+	basePath := filepath.Join(configs.General.QuarantineDirectory, getRelativePath(configs.General.DestinationDirectory, path)) + "." + now.Format("20060102-150405")
+
+	quarantinePath := basePath
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(quarantinePath); errors.Is(err, fs.ErrNotExist) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		quarantinePath = fmt.Sprintf("%s-%d", basePath, i)
+	}
+
+	// make sure quarantine parent directory exists
+	if err := mkdirAll(filepath.Dir(quarantinePath), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	// move the file into the quarantine directory (copying it across volumes)
+	if err := moveFile(path, quarantinePath); err != nil {
+		return "", err
+	}
+
+	// stamp the quarantined file with the quarantine time, so retention is counted from the moment it was removed
+	stampTree(quarantinePath, now)
+
+	return quarantinePath, nil
+}
+
+// pruneQuarantine purges the quarantined files which were kept for longer than the retention
+func pruneQuarantine(configs Config) {
+	// nothing to do unless requested
+	if len(configs.General.QuarantineDirectory) < 1 || configs.General.QuarantineRetentionHours < 1 || configs.General.DryRun {
+		return
+	}
+
+	pruneDirectory(configs, configs.General.QuarantineDirectory, time.Now().Add(-time.Duration(configs.General.QuarantineRetentionHours)*time.Hour), "Purge")
+}
@@ -0,0 +1,37 @@
+//go:build linux
+// +build linux
+
+package mirror
+
+import (
+	"os"
+	"syscall"
+)
+
+// ioctl request which clones all the extents of a file into another file (FICLONE)
+const ficlone = 0x40049409
+
+// reflinkFile creates the destination file as a copy-on-write clone of the source file, sharing its data blocks (btrfs, XFS)
+func reflinkFile(src string, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, destination.Fd(), ficlone, source.Fd()); errno != 0 {
+		// remove the empty destination file, so it never survives the failure
+		destination.Close()
+		os.Remove(dst)
+
+		return errno
+	}
+
+	return destination.Close()
+}
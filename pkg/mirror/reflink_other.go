@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package mirror
+
+import (
+	"errors"
+)
+
+// reflinkFile creates the destination file as a copy-on-write clone of the source file, which is only supported on linux
+func reflinkFile(src string, dst string) error {
+	return errors.New("reflinks are not supported on this platform")
+}
@@ -0,0 +1,190 @@
+package mirror
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// interval between checks of config files for changes
+const configPollInterval = 2 * time.Second
+
+// runningJob is a mirror job started from a config file
+type runningJob struct {
+	configs Config
+	cancel  context.CancelFunc
+	done    chan struct{}
+	// settings to apply in place at the next iteration boundary (only the latest is kept)
+	updates chan Config
+}
+
+// jobGroup runs the jobs of a single config file, so they can be updated or restarted when the file changes
+type jobGroup struct {
+	mutex sync.Mutex
+	jobs  []*runningJob
+
+	// counts the running jobs of all groups, so termination waits for them
+	wg *sync.WaitGroup
+
+	// starts a job of the given configuration, and closes the done channel once it ends
+	startJob func(ctx context.Context, configs Config, done chan struct{})
+}
+
+func (group *jobGroup) start(ctx context.Context, configs []Config) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	for _, config := range configs {
+		jobCtx, cancel := context.WithCancel(ctx)
+		job := &runningJob{configs: config, cancel: cancel, done: make(chan struct{}), updates: make(chan Config, 1)}
+
+		config.General.updates = job.updates
+		group.startJob(jobCtx, config, job.done)
+
+		group.jobs = append(group.jobs, job)
+	}
+}
+
+// reload applies the new configurations of the file, in place when possible, otherwise by restarting the jobs
+func (group *jobGroup) reload(ctx context.Context, configs []Config) {
+	group.mutex.Lock()
+
+	// check whether every job can be updated in place
+	restart := len(configs) != len(group.jobs)
+	for i := 0; !restart && i < len(configs); i++ {
+		restart = requiresRestart(group.jobs[i].configs, configs[i])
+	}
+
+	if !restart {
+		for i, job := range group.jobs {
+			// replace a pending update which was not applied yet
+			select {
+			case <-job.updates:
+			default:
+			}
+			job.updates <- configs[i]
+			job.configs = configs[i]
+		}
+
+		group.mutex.Unlock()
+		return
+	}
+
+	// hold the count of running jobs while restarting, so it does not drop to zero in between
+	group.wg.Add(1)
+	defer group.wg.Done()
+
+	// stop the jobs, letting in-flight operations finish, then start the jobs of the new configurations
+	jobs := group.jobs
+	group.jobs = nil
+	group.mutex.Unlock()
+
+	for _, job := range jobs {
+		job.cancel()
+		<-job.done
+	}
+
+	// termination could have been requested in the meantime
+	if ctx.Err() != nil {
+		return
+	}
+
+	slog.Info("Configuration changed, restarting jobs")
+	group.start(ctx, configs)
+}
+
+// requiresRestart reports whether the new configuration of a job can not be applied to the running job in place
+func requiresRestart(old Config, new Config) bool {
+	return old.General.Name != new.General.Name ||
+		old.General.SourceDirectory != new.General.SourceDirectory ||
+		!reflect.DeepEqual(old.General.DestinationDirectories, new.General.DestinationDirectories) ||
+		old.General.DestinationSubpath != new.General.DestinationSubpath ||
+		old.General.DestinationURL != new.General.DestinationURL ||
+		old.General.SftpKeyFile != new.General.SftpKeyFile ||
+		old.General.SftpKeyPassphrase != new.General.SftpKeyPassphrase ||
+		old.General.SftpPassword != new.General.SftpPassword ||
+		old.General.SftpKnownHostsFile != new.General.SftpKnownHostsFile ||
+		old.General.SftpMaxSessions != new.General.SftpMaxSessions ||
+		old.General.S3Endpoint != new.General.S3Endpoint ||
+		old.General.S3Region != new.General.S3Region ||
+		old.General.S3AccessKey != new.General.S3AccessKey ||
+		old.General.S3SecretKey != new.General.S3SecretKey ||
+		old.General.S3PathStyle != new.General.S3PathStyle ||
+		old.General.S3MultipartThresholdMB != new.General.S3MultipartThresholdMB ||
+		old.General.CompressDestination != new.General.CompressDestination ||
+		old.General.CompressMinSizeKB != new.General.CompressMinSizeKB ||
+		!reflect.DeepEqual(old.General.CompressSkipExtensions, new.General.CompressSkipExtensions) ||
+		old.General.EncryptionKeyFile != new.General.EncryptionKeyFile ||
+		old.General.EncryptionPassphrase != new.General.EncryptionPassphrase ||
+		!reflect.DeepEqual(old.General.EncryptionPreviousKeyFiles, new.General.EncryptionPreviousKeyFiles) ||
+		!reflect.DeepEqual(old.General.EncryptionPreviousPassphrases, new.General.EncryptionPreviousPassphrases) ||
+		old.General.WatchMode != new.General.WatchMode ||
+		old.General.RunOnce != new.General.RunOnce
+}
+
+// applyConfigUpdate returns the configuration of the job with the update applied, keeping the channel of updates. an update whose state can
+// not be created (e.g. its log file can not be opened) is logged, and the running settings are kept
+func applyConfigUpdate(configs Config, update Config) Config {
+	update.General.updates = configs.General.updates
+	// the workers are kept, only their count follows the new settings
+	update.General.workers = configs.General.workers
+	// and so is the limit of all jobs of the supervisor
+	update.General.totalWorkers = configs.General.totalWorkers
+	// so are the file systems, along with the connections of the destination
+	update.General.source = configs.General.source
+	update.General.destination = configs.General.destination
+	// and so are the counts of scans which found destination files missing
+	update.General.missing = configs.General.missing
+	// and so are the written files, while drift is still detected
+	update.General.written = configs.General.written
+
+	// state of the job is recreated, since its settings may have changed
+	update, err := initJobState(update)
+	if err != nil {
+		configs.General.logger.Error("Invalid configuration, keeping the running settings", "error", err)
+		return configs
+	}
+	update.General.workers.resize(update.General.MaxConcurrentWorkers)
+
+	update.General.logger.Info("Configuration reloaded")
+	return update
+}
+
+// watchConfigFile polls the config file for changes until the context is cancelled, and calls onChange with the configurations of a changed file
+// (an invalid configuration is logged, and the running settings are kept)
+func watchConfigFile(ctx context.Context, name string, onChange func(configs []Config)) {
+	path := resolveConfigPath(name)
+
+	var lastModTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(lastModTime) {
+			continue
+		}
+		lastModTime = info.ModTime()
+
+		configs, err := LoadConfigFile(name, false)
+		if err != nil {
+			slog.Error("Invalid configuration, keeping the running settings", "file", path, "error", err)
+			continue
+		}
+
+		onChange(configs)
+	}
+}
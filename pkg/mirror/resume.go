@@ -0,0 +1,120 @@
+package mirror
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// suffix of partial files, which keep the contents of an interrupted copy so it can be resumed, and of their sidecar files
+const (
+	partialFileSuffix    = ".partial"
+	partialSidecarSuffix = ".partial.json"
+)
+
+// size of the tail of a partial file which is copied again when resuming, since its last writes may not have reached the disk
+const resumeOverlap = 1024 * 1024
+
+// minimal size of files copied through a partial file, smaller files are quicker to copy again than to track
+const resumeMinFileSize = 16 * 1024 * 1024
+
+// partialSidecar records the source file a partial file was copied from, so a changed source restarts the copy
+type partialSidecar struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+func getPartialPath(path string) string {
+	// use a hidden name in the same directory, so the final rename does not cross file systems
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+partialFileSuffix)
+}
+
+func getSidecarPath(partialPath string) string {
+	return strings.TrimSuffix(partialPath, partialFileSuffix) + partialSidecarSuffix
+}
+
+// getPartialFinalPath returns the relative path of the file a partial (or sidecar) file is written for, or an empty string if the path is not a partial file
+func getPartialFinalPath(relativePath string) string {
+	name := filepath.Base(relativePath)
+	if !strings.HasPrefix(name, ".") {
+		return ""
+	}
+
+	for _, suffix := range []string{partialSidecarSuffix, partialFileSuffix} {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix)+1 {
+			return filepath.Join(filepath.Dir(relativePath), strings.TrimSuffix(name[1:], suffix))
+		}
+	}
+
+	return ""
+}
+
+// getResumeOffset returns the offset to resume the copy of the source file into the partial file from,
+// or 0 to start over (in which case the sidecar is written for the new partial file)
+func getResumeOffset(fsys destinationFS, partialPath string, srcFile os.FileInfo) (int64, error) {
+	// resume only if the partial file was written from the same source file (trusting its size and modification time)
+	if data, err := readFSFile(fsys, getSidecarPath(partialPath)); err == nil {
+		var sidecar partialSidecar
+		if json.Unmarshal(data, &sidecar) == nil && sidecar.Size == srcFile.Size() && sidecar.ModTime.Equal(srcFile.ModTime()) {
+			if partialFile, err := fsys.Stat(partialPath); err == nil && partialFile.Size() <= srcFile.Size() {
+				// copy the tail of the partial file again, in case it was not completely written
+				offset := partialFile.Size() - resumeOverlap
+				if offset > 0 {
+					return offset, nil
+				}
+			}
+		}
+	}
+
+	// start over, recording the source of the new partial file
+	data, err := json.Marshal(partialSidecar{Size: srcFile.Size(), ModTime: srcFile.ModTime()})
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, writeFSFile(fsys, getSidecarPath(partialPath), data)
+}
+
+// excludePartialFiles removes partial files (and their sidecars) from both containers, so they are neither mirrored nor deleted while their copy can be resumed.
+// partial files whose source file is gone are removed
+func excludePartialFiles(configs Config, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) {
+	// nothing to do unless requested (otherwise partial files left over by an earlier run are mirrored as any other file)
+	if !configs.General.ResumePartialCopies {
+		return
+	}
+
+	// partial files are never mirrored
+	for srcPath, srcFile := range srcFiles {
+		if !srcFile.IsDir() && len(getPartialFinalPath(srcPath)) > 0 {
+			delete(srcFiles, srcPath)
+		}
+	}
+
+	for dstPath, dstFile := range destFiles {
+		finalPath := getPartialFinalPath(dstPath)
+		if dstFile.IsDir() || len(finalPath) < 1 {
+			continue
+		}
+
+		delete(destFiles, dstPath)
+
+		// keep the partial file as long as its source file is copied through it, so its copy can be resumed
+		if srcFile, exists := srcFiles[finalPath]; exists && !srcFile.IsDir() && srcFile.Size() >= resumeMinFileSize {
+			continue
+		}
+
+		// in dry run mode, the destination must not be touched
+		if configs.General.DryRun {
+			continue
+		}
+
+		path := filepath.Join(configs.General.DestinationDirectory, dstPath)
+		if err := configs.General.destination.Remove(path); err != nil {
+			logOperationError(configs.General.logger, "Remove", path, err)
+		} else {
+			configs.General.logger.Info("Remove", "path", path)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// retryOperation runs the operation, and retries it on failure up to the configured retry count with an exponential backoff delay.
+// the error of the last attempt is returned
+func retryOperation(ctx context.Context, configs Config, action string, path string, operation func() error) (err error) {
+	// the operation is in-flight until its last attempt ends
+	done := configs.General.status.startOperation(action, path)
+	defer func() {
+		done(err)
+	}()
+
+	// delay before the first retry, doubled after every failed retry
+	delay := time.Duration(configs.General.RetryDelayMS) * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		err = operation()
+		// an operation interrupted by termination is not a failure, it runs again on the next start
+		if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+			configs.General.logger.Info("Interrupted", "operation", action, "path", path)
+			return nil
+		}
+		// stop on success, or when out of retries
+		if err == nil || attempt > configs.General.RetryCount {
+			return err
+		}
+
+		configs.General.logger.Warn("Retry", "attempt", attempt, "retries", configs.General.RetryCount, "operation", action, "path", path, "error", err)
+
+		// wait before the next attempt, unless termination is requested in the meantime
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
@@ -0,0 +1,428 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// count of objects whose metadata is requested concurrently while listing a directory
+const s3StatConcurrency = 16
+
+// validateS3URL validates a destination URL of the form s3://bucket/prefix
+func validateS3URL(destURL *url.URL) error {
+	if len(destURL.Host) < 1 {
+		return errors.New("bucket is missing")
+	}
+	if len(strings.Trim(destURL.Path, "/")) < 1 {
+		return errors.New("prefix is missing")
+	}
+
+	return nil
+}
+
+// getS3Endpoint returns the configured endpoint, or the AWS endpoint of the region
+func getS3Endpoint(general GeneralConfigurations) string {
+	if len(general.S3Endpoint) > 0 {
+		return general.S3Endpoint
+	}
+
+	return fmt.Sprintf("https://s3.%s.amazonaws.com", general.S3Region)
+}
+
+// getS3Credentials returns the configured keys, or the keys of the standard AWS environment variables
+func getS3Credentials(general GeneralConfigurations) (string, string) {
+	if len(general.S3AccessKey) > 0 || len(general.S3SecretKey) > 0 {
+		return general.S3AccessKey, general.S3SecretKey
+	}
+
+	return os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+}
+
+// s3FS is the file system of a prefix of an S3 bucket, where every file is an object keyed by its path. directories exist through the objects
+// stored under them, an empty one is kept by a marker (an empty object keyed by its path and a slash, as other tools create). directories
+// keep no times, and no object keeps permissions
+type s3FS struct {
+	client *s3Client
+	// key prefix of the destination directory, which is listed to check the bucket can be reached
+	prefix string
+	// objects larger than the threshold are uploaded in parts of its size
+	multipartThreshold int
+}
+
+func newS3FS(configs Config) *s3FS {
+	// the URL and the endpoint are validated when the configuration is read
+	destURL, _ := parseDestinationURL(configs.General.DestinationURL)
+	endpoint, _ := url.Parse(getS3Endpoint(configs.General))
+	accessKey, secretKey := getS3Credentials(configs.General)
+
+	// keep a connection of every worker open between operations, since uploads are made by the workers
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = max(configs.General.MaxConcurrentWorkers, s3StatConcurrency)
+
+	return &s3FS{
+		client: &s3Client{
+			endpoint:  endpoint,
+			pathStyle: configs.General.S3PathStyle,
+			bucket:    destURL.Host,
+			region:    configs.General.S3Region,
+			accessKey: accessKey,
+			secretKey: secretKey,
+			client:    &http.Client{Transport: transport},
+		},
+		prefix:             getObjectKey(destURL.Path),
+		multipartThreshold: configs.General.S3MultipartThresholdMB * 1024 * 1024,
+	}
+}
+
+// getObjectKey converts the local form of a path into the key of its object
+func getObjectKey(path string) string {
+	return strings.Trim(filepath.ToSlash(path), "/")
+}
+
+// getDirectoryPrefix returns the prefix of the keys of the objects under the directory
+func getDirectoryPrefix(key string) string {
+	if len(key) < 1 {
+		return ""
+	}
+
+	return key + "/"
+}
+
+func getS3DirInfo(key string) remoteFileInfo {
+	return remoteFileInfo{name: path.Base("/" + key), mode: os.ModeDir | 0755}
+}
+
+func (fsys *s3FS) Connect() error {
+	_, err := fsys.client.listObjects(getDirectoryPrefix(fsys.prefix), "/", 1, "")
+	return err
+}
+
+func (fsys *s3FS) Stat(path string) (os.FileInfo, error) {
+	return fsys.Lstat(path)
+}
+
+func (fsys *s3FS) Lstat(path string) (os.FileInfo, error) {
+	key := getObjectKey(path)
+	// the root of the bucket always exists
+	if len(key) < 1 {
+		return getS3DirInfo(key), nil
+	}
+
+	info, err := fsys.client.headObject(key)
+	if err == nil {
+		return info, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// without an object of its own, the path is a directory if any object is stored under it
+	result, err := fsys.client.listObjects(getDirectoryPrefix(key), "", 1, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Contents) < 1 {
+		return nil, &fs.PathError{Op: "lstat", Path: key, Err: fs.ErrNotExist}
+	}
+	return getS3DirInfo(key), nil
+}
+
+func (fsys *s3FS) ReadDir(path string) ([]fs.DirEntry, error) {
+	prefix := getDirectoryPrefix(getObjectKey(path))
+
+	var infos []remoteFileInfo
+	// indexes of the infos of objects, whose modification times are in their metadata
+	var objects []int
+	continuationToken := ""
+	for {
+		result, err := fsys.client.listObjects(prefix, "/", s3MaxKeys, continuationToken)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, commonPrefix := range result.CommonPrefixes {
+			// an empty name is made by consecutive slashes, which no path of the source has
+			if name := strings.TrimSuffix(strings.TrimPrefix(commonPrefix.Prefix, prefix), "/"); len(name) > 0 {
+				infos = append(infos, getS3DirInfo(name))
+			}
+		}
+		for _, object := range result.Contents {
+			// a key ending with a slash is the marker of a directory
+			if name := strings.TrimPrefix(object.Key, prefix); len(name) > 0 && !strings.HasSuffix(name, "/") {
+				objects = append(objects, len(infos))
+				infos = append(infos, remoteFileInfo{name: name, size: object.Size, mode: 0644, modTime: object.LastModified})
+			}
+		}
+
+		if !result.IsTruncated || len(result.NextContinuationToken) < 1 {
+			break
+		}
+		continuationToken = result.NextContinuationToken
+	}
+
+	if err := fsys.statListedObjects(prefix, infos, objects); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].name < infos[j].name })
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		// an object removed since it was listed has no info
+		if len(info.name) > 0 {
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	return entries, nil
+}
+
+// statListedObjects replaces the infos of the listed objects with their metadata, since a listing holds the times the objects were uploaded
+// rather than the stored modification times
+func (fsys *s3FS) statListedObjects(prefix string, infos []remoteFileInfo, objects []int) error {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+
+	indexes := make(chan int)
+	for i := 0; i < min(s3StatConcurrency, len(objects)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range indexes {
+				info, err := fsys.client.headObject(prefix + infos[index].name)
+				if err == nil {
+					info.name = infos[index].name
+				} else if !errors.Is(err, fs.ErrNotExist) {
+					mutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mutex.Unlock()
+				}
+
+				// every goroutine writes different elements
+				infos[index] = info
+			}
+		}()
+	}
+
+	for _, index := range objects {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	return firstErr
+}
+
+func (fsys *s3FS) Open(path string) (io.ReadCloser, error) {
+	return fsys.client.getObject(getObjectKey(path))
+}
+
+func (fsys *s3FS) Create(path string, srcFile os.FileInfo) (destinationFile, error) {
+	// the modification time is stored along with the contents
+	var modTime time.Time
+	if srcFile != nil {
+		modTime = srcFile.ModTime()
+	}
+
+	return &s3File{client: fsys.client, key: getObjectKey(path), modTime: modTime, partSize: fsys.multipartThreshold}, nil
+}
+
+func (fsys *s3FS) Remove(path string) error {
+	key := getObjectKey(path)
+	// a request without a key is about the bucket itself, which is never removed
+	if len(key) < 1 {
+		return nil
+	}
+
+	// a directory has no object to remove
+	return fsys.client.deleteObject(key)
+}
+
+func (fsys *s3FS) RemoveAll(path string) error {
+	key := getObjectKey(path)
+
+	// every object under the directory is removed, a page at a time
+	for {
+		result, err := fsys.client.listObjects(getDirectoryPrefix(key), "", s3MaxKeys, "")
+		if err != nil {
+			return err
+		}
+		for _, object := range result.Contents {
+			if err := fsys.client.deleteObject(object.Key); err != nil {
+				return err
+			}
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+	}
+
+	return fsys.Remove(path)
+}
+
+func (fsys *s3FS) MkdirAll(path string, perm os.FileMode) error {
+	key := getObjectKey(path)
+	if len(key) < 1 {
+		return nil
+	}
+
+	// the directory is kept (even while empty) by a marker, the parent directories exist through it
+	return fsys.client.putObject(getDirectoryPrefix(key), time.Time{}, nil)
+}
+
+func (fsys *s3FS) Chmod(path string, mode os.FileMode) error {
+	// objects keep no permissions
+	return nil
+}
+
+func (fsys *s3FS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	key := getObjectKey(path)
+
+	info, err := fsys.client.headObject(key)
+	if err != nil {
+		// directories keep no times
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	// the time is usually stored along with the contents already, otherwise the object is copied onto itself with the new metadata
+	if info.modTime.Equal(mtime) {
+		return nil
+	}
+	return fsys.client.copyObject(key, key, mtime)
+}
+
+func (fsys *s3FS) Rename(oldPath string, newPath string) error {
+	oldKey := getObjectKey(oldPath)
+	if err := fsys.client.copyObject(oldKey, getObjectKey(newPath), time.Time{}); err != nil {
+		return err
+	}
+
+	return fsys.client.deleteObject(oldKey)
+}
+
+func (fsys *s3FS) ModTimeGranularity() time.Duration {
+	// modification times are stored in nanoseconds
+	return 0
+}
+
+func (fsys *s3FS) Close() error {
+	fsys.client.client.CloseIdleConnections()
+	return nil
+}
+
+// s3File is an object being uploaded, which is stored once it is closed. its contents are buffered until they exceed a part,
+// after which they are uploaded a part at a time (so at most a part of every upload is held in memory)
+type s3File struct {
+	client   *s3Client
+	key      string
+	modTime  time.Time
+	partSize int
+
+	buffer  []byte
+	written int64
+	// id of the multipart upload, once started
+	uploadID string
+	parts    []s3CompletedPart
+	closed   bool
+}
+
+func (file *s3File) Write(data []byte) (int, error) {
+	if file.closed {
+		return 0, os.ErrClosed
+	}
+
+	file.buffer = append(file.buffer, data...)
+	for len(file.buffer) > file.partSize {
+		if err := file.uploadPart(file.buffer[:file.partSize]); err != nil {
+			return 0, err
+		}
+		file.buffer = file.buffer[:copy(file.buffer, file.buffer[file.partSize:])]
+	}
+
+	file.written += int64(len(data))
+	return len(data), nil
+}
+
+func (file *s3File) uploadPart(data []byte) error {
+	if len(file.uploadID) < 1 {
+		uploadID, err := file.client.createMultipartUpload(file.key, file.modTime)
+		if err != nil {
+			return err
+		}
+		file.uploadID = uploadID
+	}
+
+	etag, err := file.client.uploadPart(file.key, file.uploadID, len(file.parts)+1, data)
+	if err != nil {
+		return err
+	}
+	file.parts = append(file.parts, s3CompletedPart{PartNumber: len(file.parts) + 1, ETag: etag})
+	return nil
+}
+
+// Seek reports the current offset only, since an object is written sequentially
+func (file *s3File) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekCurrent {
+		return 0, &fs.PathError{Op: "seek", Path: file.key, Err: errors.ErrUnsupported}
+	}
+
+	return file.written, nil
+}
+
+func (file *s3File) Sync() error {
+	// an object is stored once its upload completes
+	return nil
+}
+
+func (file *s3File) Close() error {
+	if file.closed {
+		return os.ErrClosed
+	}
+	file.closed = true
+	defer func() { file.buffer = nil }()
+
+	if len(file.uploadID) < 1 {
+		return file.client.putObject(file.key, file.modTime, file.buffer)
+	}
+
+	// the rest of the contents make the last part
+	err := file.uploadPart(file.buffer)
+	if err == nil {
+		err = file.client.completeMultipartUpload(file.key, file.uploadID, file.parts)
+	}
+	if err != nil {
+		file.client.abortMultipartUpload(file.key, file.uploadID)
+	}
+	return err
+}
+
+// Abort discards the upload, so the object is not stored
+func (file *s3File) Abort() error {
+	if file.closed {
+		return nil
+	}
+	file.closed = true
+	file.buffer = nil
+
+	if len(file.uploadID) < 1 {
+		return nil
+	}
+	return file.client.abortMultipartUpload(file.key, file.uploadID)
+}
@@ -0,0 +1,380 @@
+package mirror
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// signature scheme of the requests (AWS signature version 4)
+	s3SigningAlgorithm = "AWS4-HMAC-SHA256"
+	s3SigningService   = "s3"
+	s3DateFormat       = "20060102T150405Z"
+	// header of the object metadata holding the modification time of the source file, in the format rclone uses
+	// (seconds since the epoch, with a fraction of nanoseconds)
+	s3ModTimeHeader = "X-Amz-Meta-Mtime"
+	// largest count of keys listed by a single request
+	s3MaxKeys = 1000
+	// largest size of an error response read from the server
+	s3MaxErrorSize = 64 * 1024
+)
+
+// s3Client sends the requests of the S3 API about the objects of a bucket
+type s3Client struct {
+	endpoint *url.URL
+	// the bucket is addressed by the path of the requests rather than by the host name
+	pathStyle bool
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// s3Error is the error returned by the server
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// request sends a request about the object of the key (or about the bucket, if the key is empty), signing it along with its body.
+// a response of a failed request is returned as an error
+func (client *s3Client) request(op string, method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	errPath := getS3ErrorPath(key, query)
+
+	requestURL := *client.endpoint
+	requestPath := "/" + key
+	if client.pathStyle {
+		requestPath = "/" + client.bucket + "/" + key
+	} else {
+		requestURL.Host = client.bucket + "." + requestURL.Host
+	}
+	requestURL.Path = strings.TrimSuffix(requestURL.Path, "/") + requestPath
+	requestURL.RawPath = s3Escape(requestURL.Path, false)
+	requestURL.RawQuery = getS3CanonicalQuery(query)
+
+	request, err := http.NewRequest(method, requestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: errPath, Err: err}
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	client.sign(request, body, time.Now())
+
+	response, err := client.client.Do(request)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: errPath, Err: err}
+	}
+
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		return nil, readS3Error(op, errPath, response)
+	}
+	return response, nil
+}
+
+// requestXML sends a request, and decodes its XML response into the result (if any)
+func (client *s3Client) requestXML(op string, method string, key string, query url.Values, header http.Header, body []byte, result any) error {
+	response, err := client.request(op, method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: getS3ErrorPath(key, query), Err: err}
+	}
+
+	// a copy or a completion could fail after the response started, in which case the error is the body of a successful response
+	var errorResult s3Error
+	if xml.Unmarshal(data, &errorResult) == nil && len(errorResult.Code) > 0 {
+		return &fs.PathError{Op: op, Path: getS3ErrorPath(key, query), Err: fmt.Errorf("s3: %s (%s)", errorResult.Message, errorResult.Code)}
+	}
+
+	if result != nil {
+		if err := xml.Unmarshal(data, result); err != nil {
+			return &fs.PathError{Op: op, Path: getS3ErrorPath(key, query), Err: err}
+		}
+	}
+	return nil
+}
+
+// getS3ErrorPath returns the path errors of a request are reported by, which is the key (or the listed prefix, for a request about the bucket)
+func getS3ErrorPath(key string, query url.Values) string {
+	if len(key) < 1 {
+		return query.Get("prefix")
+	}
+
+	return key
+}
+
+func readS3Error(op string, path string, response *http.Response) error {
+	var result s3Error
+	data, _ := io.ReadAll(io.LimitReader(response.Body, s3MaxErrorSize))
+	xml.Unmarshal(data, &result)
+
+	var err error
+	switch {
+	// a missing bucket is a configuration error rather than a missing file
+	case response.StatusCode == http.StatusNotFound && result.Code != "NoSuchBucket":
+		err = fs.ErrNotExist
+	case response.StatusCode == http.StatusForbidden:
+		err = fs.ErrPermission
+	case len(result.Code) > 0:
+		err = fmt.Errorf("s3: %s (%s)", result.Message, result.Code)
+	default:
+		err = fmt.Errorf("s3: %s", response.Status)
+	}
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
+
+// sign adds the signature of the request, which covers its host, its x-amz headers and the hash of its body
+func (client *s3Client) sign(request *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	date := now.UTC().Format(s3DateFormat)
+	request.Header.Set("X-Amz-Date", date)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	// the signed headers, sorted by their lowercase names
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-md5" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := strings.Join([]string{date[:8], client.region, s3SigningService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{s3SigningAlgorithm, date, scope, hex.EncodeToString(canonicalRequestHash[:])}, "\n")
+
+	// the signing key is derived from the secret key, for the day, region and service of the request
+	key := []byte("AWS4" + client.secretKey)
+	for _, part := range []string{date[:8], client.region, s3SigningService, "aws4_request"} {
+		key = getHMAC(key, part)
+	}
+	signature := hex.EncodeToString(getHMAC(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3SigningAlgorithm, client.accessKey, scope, signedHeaders, signature))
+}
+
+func getHMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape encodes every byte except the unreserved characters (and slashes, unless requested), as the signature requires
+func s3Escape(s string, encodeSlash bool) string {
+	var escaped strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+// getS3CanonicalQuery encodes the query sorted by its keys, which is both sent and signed
+func getS3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// formatS3ModTime formats the modification time for the object metadata
+func formatS3ModTime(modTime time.Time) string {
+	return fmt.Sprintf("%d.%09d", modTime.Unix(), modTime.Nanosecond())
+}
+
+// parseS3ModTime parses the modification time of the object metadata
+func parseS3ModTime(value string) (time.Time, error) {
+	seconds, fraction, _ := strings.Cut(value, ".")
+	sec, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nsec int64
+	if len(fraction) > 0 {
+		// the fraction could have any count of digits, so it is scaled into nanoseconds
+		fraction = (fraction + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(fraction, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// headObject returns the info of the object, its modification time is the stored one (or the time it was uploaded, if none is stored)
+func (client *s3Client) headObject(key string) (remoteFileInfo, error) {
+	response, err := client.request("stat", http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return remoteFileInfo{}, err
+	}
+	response.Body.Close()
+
+	info := remoteFileInfo{name: key[strings.LastIndex(key, "/")+1:], size: response.ContentLength, mode: 0644}
+	if modTime, err := parseS3ModTime(response.Header.Get(s3ModTimeHeader)); err == nil {
+		info.modTime = modTime
+	} else {
+		info.modTime, _ = http.ParseTime(response.Header.Get("Last-Modified"))
+	}
+	return info, nil
+}
+
+// listObjects lists a single page of the objects whose keys start with the prefix, grouping the keys by the delimiter (if any)
+func (client *s3Client) listObjects(prefix string, delimiter string, maxKeys int, continuationToken string) (*s3ListResult, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "max-keys": {strconv.Itoa(maxKeys)}}
+	if len(delimiter) > 0 {
+		query.Set("delimiter", delimiter)
+	}
+	if len(continuationToken) > 0 {
+		query.Set("continuation-token", continuationToken)
+	}
+
+	var result s3ListResult
+	if err := client.requestXML("readdir", http.MethodGet, "", query, nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (client *s3Client) getObject(key string) (io.ReadCloser, error) {
+	response, err := client.request("open", http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// putObject stores the object, along with its modification time (unless zero)
+func (client *s3Client) putObject(key string, modTime time.Time, body []byte) error {
+	header := http.Header{}
+	if !modTime.IsZero() {
+		header.Set(s3ModTimeHeader, formatS3ModTime(modTime))
+	}
+	return client.requestXML("write", http.MethodPut, key, nil, header, body, nil)
+}
+
+// copyObject copies the object along with its metadata, unless a modification time replaces it
+func (client *s3Client) copyObject(srcKey string, destKey string, modTime time.Time) error {
+	header := http.Header{"X-Amz-Copy-Source": {s3Escape("/"+client.bucket+"/"+srcKey, false)}}
+	if !modTime.IsZero() {
+		header.Set("X-Amz-Metadata-Directive", "REPLACE")
+		header.Set(s3ModTimeHeader, formatS3ModTime(modTime))
+	}
+	return client.requestXML("copy", http.MethodPut, destKey, nil, header, nil, nil)
+}
+
+func (client *s3Client) deleteObject(key string) error {
+	return client.requestXML("remove", http.MethodDelete, key, nil, nil, nil, nil)
+}
+
+func (client *s3Client) createMultipartUpload(key string, modTime time.Time) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	header := http.Header{s3ModTimeHeader: {formatS3ModTime(modTime)}}
+	if err := client.requestXML("write", http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, &result); err != nil {
+		return "", err
+	}
+	if len(result.UploadID) < 1 {
+		return "", &fs.PathError{Op: "write", Path: key, Err: errors.New("s3: upload id is missing")}
+	}
+	return result.UploadID, nil
+}
+
+func (client *s3Client) uploadPart(key string, uploadID string, partNumber int, body []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	response, err := client.request("write", http.MethodPut, key, query, nil, body)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	return response.Header.Get("ETag"), nil
+}
+
+func (client *s3Client) completeMultipartUpload(key string, uploadID string, parts []s3CompletedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	return client.requestXML("write", http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body, nil)
+}
+
+func (client *s3Client) abortMultipartUpload(key string, uploadID string) error {
+	return client.requestXML("write", http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, nil)
+}
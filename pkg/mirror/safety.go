@@ -0,0 +1,95 @@
+package mirror
+
+// isDeletionAllowed reports whether the planned deletions are within the configured safety thresholds, relative to the count of destination files
+func isDeletionAllowed(configs Config, plannedDeletes int, destTotal int) bool {
+	// nothing to delete, or thresholds explicitly overridden
+	if plannedDeletes < 1 || configs.General.ForceDelete {
+		return true
+	}
+
+	if configs.General.MaxDeleteCount > 0 && plannedDeletes > configs.General.MaxDeleteCount {
+		configs.General.logger.Warn("Skipping deletions, planned deletions exceed maxDeleteCount (use --force-delete to override)", "planned", plannedDeletes, "maxDeleteCount", configs.General.MaxDeleteCount)
+		return false
+	}
+
+	if configs.General.MaxDeletePercent > 0 && destTotal > 0 && plannedDeletes*100 > configs.General.MaxDeletePercent*destTotal {
+		configs.General.logger.Warn("Skipping deletions, planned deletions exceed maxDeletePercent of the destination files (use --force-delete to override)", "planned", plannedDeletes, "destinationFiles", destTotal, "maxDeletePercent", configs.General.MaxDeletePercent)
+		return false
+	}
+
+	return true
+}
+
+// isSourceAvailable reports whether the source directory exists and can be listed. an unavailable source (e.g. an unmounted drive) would look as if all its files were deleted
+func isSourceAvailable(configs Config) bool {
+	if err := checkReadableDir(configs.General.source, configs.General.SourceDirectory); err != nil {
+		configs.General.logger.Warn("Skipping iteration, source directory is unavailable", "path", configs.General.SourceDirectory, "error", err)
+		return false
+	}
+
+	return true
+}
+
+// isDestinationAvailable reports whether the destination can be reached. every operation against an unreachable remote destination would fail
+func isDestinationAvailable(configs Config) bool {
+	if err := configs.General.destination.Connect(); err != nil {
+		configs.General.logger.Warn("Skipping iteration, destination is unreachable", "destination", getDestinationsDescription(configs), "error", err)
+		return false
+	}
+
+	return true
+}
+
+// isEmptySourceSuspicious reports whether a full scan found no source files while a destination has at least the configured count of files,
+// which more likely means the source is not mounted (at an existing mount point) than that everything was deleted
+func isEmptySourceSuspicious(configs Config, destConfigsList []Config, trees []*scannedTree) bool {
+	// guard disabled, or explicitly overridden
+	if configs.General.EmptySourceGuard < 1 || configs.General.ForceDelete {
+		return false
+	}
+
+	for _, tree := range trees {
+		if tree.srcScanned > 0 {
+			return false
+		}
+	}
+
+	// in bidirectional mode, the destination files are copied into an empty source, unless they were in sync (which deletes them)
+	if configs.General.SyncMode == syncModeBidirectional && len(configs.General.hashes.getSynced(configs.General.SourceDirectory, destConfigsList[0].General.DestinationDirectory)) < 1 {
+		return false
+	}
+
+	for i, tree := range trees {
+		// in snapshot mode, the destination is the latest snapshot rather than the new (empty) one
+		destFiles := tree.destScanned
+		if latest := destConfigsList[i].General.snapshotLatest; len(latest) > 0 {
+			destFiles = max(destFiles, countSnapshotFiles(latest, int64(configs.General.EmptySourceGuard)))
+		}
+
+		if destFiles >= int64(configs.General.EmptySourceGuard) {
+			configs.General.logger.Warn("Skipping iteration, source directory is empty while the destination is not (use --force-delete to override)", "path", configs.General.SourceDirectory, "destinationFiles", destFiles, "emptySourceGuard", configs.General.EmptySourceGuard)
+			return true
+		}
+	}
+
+	return false
+}
+
+// isEmptyDestinationSuspicious reports whether a bidirectional full scan found no destination files while at least the configured count of
+// files were in sync after the previous iteration, which more likely means the destination is not mounted than that everything was deleted from it
+func isEmptyDestinationSuspicious(configs Config, destConfigsList []Config, trees []*scannedTree) bool {
+	// guard disabled, or explicitly overridden
+	if configs.General.SyncMode != syncModeBidirectional || configs.General.EmptySourceGuard < 1 || configs.General.ForceDelete {
+		return false
+	}
+
+	for i, tree := range trees {
+		synced := configs.General.hashes.getSynced(configs.General.SourceDirectory, destConfigsList[i].General.DestinationDirectory)
+		if tree.destScanned < 1 && tree.srcScanned > 0 && len(synced) >= configs.General.EmptySourceGuard {
+			configs.General.logger.Warn("Skipping iteration, destination directory is empty while files were in sync (use --force-delete to override)", "path", destConfigsList[i].General.DestinationDirectory, "syncedFiles", len(synced), "emptySourceGuard", configs.General.EmptySourceGuard)
+			return true
+		}
+	}
+
+	return false
+}
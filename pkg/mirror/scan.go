@@ -0,0 +1,458 @@
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// scannedTree holds the scanned files of the source directory and of a destination directory, either complete or only the differences between them
+type scannedTree struct {
+	srcFiles  map[string]os.FileInfo
+	destFiles map[string]os.FileInfo
+
+	// count of scanned entries (the containers may hold only part of them)
+	srcScanned  int64
+	destScanned int64
+	// destination entries left out of the containers since they match the source, and how many of them are unchanged files
+	destMatched    int64
+	filesUnchanged int64
+
+	scanDuration time.Duration
+	// time spent listing the source directory and the destination directory (the rest of the scan compares them)
+	srcScanDuration  time.Duration
+	destScanDuration time.Duration
+}
+
+// scanTrees gets the complete files of the source directory (a single scan serves all destinations) and of every destination directory
+func scanTrees(configs Config, destConfigsList []Config, getSrcFiles func() map[string]os.FileInfo, getDestFiles func(destConfigs Config) map[string]os.FileInfo) []*scannedTree {
+	// get files in source directory
+	start := time.Now()
+	srcFiles := getSrcFiles()
+	srcScanDuration := time.Since(start)
+	// names which collide once stored are mirrored once
+	dropNameCollisions(configs, "", srcFiles)
+	configs.General.logger.Debug("Scan", "path", configs.General.SourceDirectory, "files", len(srcFiles), "duration", srcScanDuration)
+
+	trees := make([]*scannedTree, len(destConfigsList))
+	for i, destConfigs := range destConfigsList {
+		// every destination is planned independently, so it gets its own copy of the source files container
+		destSrcFiles := make(map[string]os.FileInfo, len(srcFiles))
+		for srcPath, srcFile := range srcFiles {
+			destSrcFiles[srcPath] = srcFile
+		}
+
+		// get files in destination directory
+		scanStart := time.Now()
+		destFiles := getDestFiles(destConfigs)
+		destScanDuration := time.Since(scanStart)
+		// paths which differ only in their form (or are stored under mapped paths) are compared as the same path, by the path of the source
+		matchNames(configs.General, destSrcFiles, destFiles)
+		configs.General.logger.Debug("Scan", "path", destConfigs.General.DestinationDirectory, "files", len(destFiles), "duration", destScanDuration)
+
+		trees[i] = &scannedTree{
+			srcFiles:         destSrcFiles,
+			destFiles:        destFiles,
+			srcScanned:       int64(len(srcFiles)),
+			destScanned:      int64(len(destFiles)),
+			scanDuration:     srcScanDuration + destScanDuration,
+			srcScanDuration:  srcScanDuration,
+			destScanDuration: destScanDuration,
+		}
+	}
+
+	return trees
+}
+
+// treeScan compares the source directory against every destination directory while walking them together, directory by directory,
+// so only the differences (rather than the complete trees) are kept in memory
+type treeScan struct {
+	configs    Config
+	dests      []destScan
+	srcScanned int64
+	// time spent listing the source directories
+	srcScanDuration time.Duration
+	// paths out of scope are not walked, on either side
+	scope pathScope
+	// whether unchanged files (which are left out of the trees) are logged
+	debug bool
+	// count of directories whose listing of the previous scan was reused, since they were not modified
+	listingsReused int64
+}
+
+type destScan struct {
+	configs Config
+	tree    *scannedTree
+	// paths used by the mirror itself inside the destination directory, which are never scanned
+	internalPaths []string
+}
+
+// scannedEntry is an entry of a listed directory, whose info is nil if it could not be read
+type scannedEntry struct {
+	name string
+	info os.FileInfo
+}
+
+// scanDifferences gets the files which differ between the source directory and every destination directory.
+// entries which match (unchanged files, and directories whose contents are unchanged) are only counted
+func scanDifferences(configs Config, destConfigsList []Config) []*scannedTree {
+	start := time.Now()
+
+	scan := &treeScan{configs: configs, scope: newPathScope(configs.General), debug: isDebugEnabled(configs.General.logger)}
+	// every directory is listed again from time to time, to notice files modified in place
+	fullScan := configs.General.listings.startScan(start)
+	trees := make([]*scannedTree, len(destConfigsList))
+	for i, destConfigs := range destConfigsList {
+		trees[i] = &scannedTree{srcFiles: make(map[string]os.FileInfo), destFiles: make(map[string]os.FileInfo)}
+		scan.dests = append(scan.dests, destScan{configs: destConfigs, tree: trees[i], internalPaths: getInternalPaths(destConfigs)})
+	}
+
+	// a missing root directory is an empty tree (e.g. a destination directory which was not created yet), otherwise it is listed
+	srcRoot := scan.getRootInfo(configs.General.source, configs.General.SourceDirectory)
+	destRoots := make([]os.FileInfo, len(scan.dests))
+	for i, dest := range scan.dests {
+		destRoots[i] = scan.getRootInfo(dest.configs.General.destination, dest.configs.General.DestinationDirectory)
+	}
+	scan.mergeDir("", srcRoot, destRoots, allDests(len(scan.dests)))
+	// listings of directories which are gone are dropped
+	configs.General.listings.prune()
+
+	duration := time.Since(start)
+	for _, tree := range trees {
+		tree.srcScanned = scan.srcScanned
+		tree.scanDuration = duration
+		tree.srcScanDuration = scan.srcScanDuration
+	}
+	for _, dest := range scan.dests {
+		configs.General.logger.Debug("Scan", "path", dest.configs.General.DestinationDirectory, "files", dest.tree.destScanned, "sourceFiles", scan.srcScanned,
+			"differences", len(dest.tree.srcFiles)+len(dest.tree.destFiles), "reusedListings", scan.listingsReused, "fullScan", fullScan, "duration", duration)
+	}
+
+	return trees
+}
+
+func allDests(count int) []bool {
+	dests := make([]bool, count)
+	for i := range dests {
+		dests[i] = true
+	}
+	return dests
+}
+
+// getRootInfo returns the info of a root directory, or nil if it does not exist.
+// a root which could not be read is returned as a directory of unknown info, so its listing fails and marks it unreadable
+func (scan *treeScan) getRootInfo(fsys readableFS, rootDir string) os.FileInfo {
+	info, err := fsys.Lstat(rootDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil || !info.IsDir() {
+		return unreadableFile{info: info}
+	}
+	return info
+}
+
+// mergeDir compares the listings of the directory in the source and in the participating destinations, and recurses into their subdirectories.
+// a nil directory info stands for a directory which does not exist (which is listed as empty). it returns whether anything differs in every destination
+func (scan *treeScan) mergeDir(relativeDir string, srcDir os.FileInfo, destDirs []os.FileInfo, participating []bool) []bool {
+	changed := make([]bool, len(scan.dests))
+
+	// list the source directory, an unreadable one is mirrored itself (if it is not the root) while its contents are left alone in every destination
+	listStart := time.Now()
+	srcEntries, err := scan.readDir(scan.configs.General.source, scan.configs.General.SourceDirectory, relativeDir, srcDir)
+	scan.srcScanDuration += time.Since(listStart)
+	if err != nil {
+		for i, dest := range scan.dests {
+			if !participating[i] {
+				continue
+			}
+
+			dest.tree.srcFiles[relativeDir] = unreadableFile{info: getKnownInfo(srcDir)}
+			if destDirs[i] != nil && len(relativeDir) > 0 {
+				dest.tree.destFiles[relativeDir] = getKnownInfo(destDirs[i])
+			}
+			changed[i] = true
+		}
+		return changed
+	}
+
+	// list every participating destination directory, an unreadable one is mirrored itself while its contents are unknown (so every source entry is a difference)
+	destEntries := make([]map[string]os.FileInfo, len(scan.dests))
+	for i, dest := range scan.dests {
+		if !participating[i] {
+			continue
+		}
+
+		listStart := time.Now()
+		entries, err := scan.readDir(dest.configs.General.destination, dest.configs.General.DestinationDirectory, relativeDir, destDirs[i])
+		dest.tree.destScanDuration += time.Since(listStart)
+		if err != nil {
+			dest.tree.destFiles[relativeDir] = unreadableFile{info: getKnownInfo(destDirs[i])}
+			changed[i] = true
+		}
+
+		destEntries[i] = make(map[string]os.FileInfo, len(entries))
+		for _, entry := range entries {
+			relativePath := filepath.Join(relativeDir, entry.name)
+
+			// paths used by the mirror itself (and paths out of scope) are left alone
+			if dest.isInternalPath(relativePath) || !scan.scope.contains(relativePath) {
+				continue
+			}
+			dest.tree.destScanned++
+
+			if entry.info == nil {
+				// an entry which could not be read is left alone, and its source entry is compared as if it were missing
+				dest.tree.destFiles[relativePath] = unreadableFile{}
+				changed[i] = true
+				continue
+			}
+			destEntries[i][entry.name] = entry.info
+		}
+	}
+
+	srcInfos := make(map[string]os.FileInfo, len(srcEntries))
+	for _, entry := range srcEntries {
+		srcInfos[entry.name] = entry.info
+	}
+	// names which collide once stored are mirrored once, and names which differ only in their form (or are stored under mapped names) are
+	// compared as the same entry, by the name of the source entry
+	dropNameCollisions(scan.configs, relativeDir, srcInfos)
+	for i := range destEntries {
+		if participating[i] {
+			matchNames(scan.configs.General, srcInfos, destEntries[i])
+		}
+	}
+
+	// get the sorted names of entries in any of the directories
+	names := make(map[string]bool)
+	for name := range srcInfos {
+		names[name] = true
+	}
+	for _, entries := range destEntries {
+		for name := range entries {
+			names[name] = true
+		}
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	for _, name := range sortedNames {
+		relativePath := filepath.Join(relativeDir, name)
+		if !scan.scope.contains(relativePath) {
+			continue
+		}
+		srcFile, srcExists := srcInfos[name]
+		if srcExists {
+			scan.srcScanned++
+		}
+
+		// a source entry which could not be read is left alone in every destination
+		if srcExists && srcFile == nil {
+			for i, dest := range scan.dests {
+				if participating[i] {
+					dest.tree.srcFiles[relativePath] = unreadableFile{}
+					changed[i] = true
+				}
+			}
+			continue
+		}
+
+		srcIsDir := srcExists && srcFile.IsDir()
+		// a directory whose contents are out of scope is compared itself only
+		walked := scan.scope.isWalked(relativePath)
+
+		// get the destinations which recurse into the entry, as a directory on either side
+		subDirs := make([]os.FileInfo, len(scan.dests))
+		subParticipating := make([]bool, len(scan.dests))
+		recurse := false
+
+		for i, dest := range scan.dests {
+			if !participating[i] {
+				continue
+			}
+
+			destFile, destExists := destEntries[i][name]
+			destIsDir := destExists && destFile.IsDir()
+
+			// matching directories are compared once their contents are, or by their own modification time if their contents are not walked
+			if srcIsDir && destIsDir && !walked {
+				if isSameDirModTime(dest.configs, srcFile.ModTime(), destFile.ModTime()) && isSamePermissions(dest.configs, srcFile, destFile) {
+					dest.tree.destMatched++
+				} else {
+					dest.tree.srcFiles[relativePath] = srcFile
+					dest.tree.destFiles[relativePath] = destFile
+					changed[i] = true
+				}
+				continue
+			}
+			if srcIsDir && destIsDir {
+				subDirs[i] = destFile
+				subParticipating[i] = true
+				recurse = true
+				continue
+			}
+
+			// matching files are only counted
+			if srcExists && destExists && scan.isUnchanged(dest.configs, relativePath, srcFile, destFile) {
+				dest.tree.destMatched++
+				dest.tree.filesUnchanged++
+				// the file is in sync, so it is tracked from now on (e.g. a file which was in sync when the job started)
+				dest.configs.General.written.record(filepath.Join(dest.configs.General.DestinationDirectory, relativePath), srcFile)
+
+				if scan.debug {
+					dest.configs.General.logger.Debug("Unchanged", "path", filepath.Join(dest.configs.General.DestinationDirectory, relativePath), "reason", getUnchangedReason(dest.configs))
+				}
+				continue
+			}
+
+			if srcExists {
+				dest.tree.srcFiles[relativePath] = srcFile
+			}
+			if destExists {
+				dest.tree.destFiles[relativePath] = destFile
+			}
+			changed[i] = true
+
+			// the whole subtree of a directory on either side differs
+			if (srcIsDir || destIsDir) && walked {
+				if destIsDir {
+					subDirs[i] = destFile
+				}
+				subParticipating[i] = true
+				recurse = true
+			}
+		}
+
+		if !recurse {
+			continue
+		}
+
+		var subSrcDir os.FileInfo
+		if srcIsDir {
+			subSrcDir = srcFile
+		}
+		subChanged := scan.mergeDir(relativePath, subSrcDir, subDirs, subParticipating)
+
+		for i, dest := range scan.dests {
+			if !subParticipating[i] || !srcIsDir || subDirs[i] == nil {
+				changed[i] = changed[i] || subChanged[i]
+				continue
+			}
+
+			// matching directories differ if anything in them does (their modification time is synced once their contents are written), or if their own modification time
+			// or permissions do
+			if subChanged[i] || !isSameDirModTime(dest.configs, srcFile.ModTime(), subDirs[i].ModTime()) || !isSamePermissions(dest.configs, srcFile, subDirs[i]) {
+				dest.tree.srcFiles[relativePath] = srcFile
+				dest.tree.destFiles[relativePath] = subDirs[i]
+				changed[i] = true
+			} else {
+				dest.tree.destMatched++
+			}
+		}
+	}
+
+	return changed
+}
+
+// readDir lists the directory through the file system of its root, sorted by name. a missing directory is listed as empty
+func (scan *treeScan) readDir(fsys readableFS, rootDir string, relativeDir string, dir os.FileInfo) ([]scannedEntry, error) {
+	// nothing to list
+	if dir == nil {
+		return nil, nil
+	}
+
+	// a directory which was not modified since the previous scan listed it has the same entries
+	if listed, ok := scan.configs.General.listings.get(fsys, rootDir, relativeDir, dir); ok {
+		scan.listingsReused++
+		return listed, nil
+	}
+
+	listStart := time.Now()
+	path := filepath.Join(rootDir, relativeDir)
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		scan.configs.General.logger.Warn("Skip", "path", path, "reason", "unreadable", "error", err)
+		return nil, err
+	}
+
+	listed := make([]scannedEntry, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// the entry could be removed in the meantime, so it is marked as unreadable (a later scan gets its current state)
+			scan.configs.General.logger.Warn("Skip", "path", filepath.Join(path, entry.Name()), "reason", "unreadable", "error", err)
+			info = nil
+		}
+
+		listed = append(listed, scannedEntry{name: entry.Name(), info: info})
+	}
+	scan.configs.General.listings.put(rootDir, relativeDir, dir, listed, listStart)
+
+	return listed, nil
+}
+
+// isUnchanged reports whether the destination file matches the source file, as far as can be told from their info alone
+func (scan *treeScan) isUnchanged(destConfigs Config, relativePath string, srcFile os.FileInfo, destFile os.FileInfo) bool {
+	// only regular files are compared here, anything else is left to the operations
+	if !srcFile.Mode().IsRegular() || !destFile.Mode().IsRegular() {
+		return false
+	}
+
+	// files skipped by their size must be seen by the filter, so they are logged once and their destination files are left alone (or expire,
+	// for files skipped by their age)
+	if isSizeFiltered(scan.configs.General, srcFile) || len(getAgeFilterReason(scan.configs.General, srcFile, time.Now())) > 0 {
+		return false
+	}
+
+	// a file whose permissions changed alone must be seen to update them
+	if !isSamePermissions(destConfigs, srcFile, destFile) {
+		return false
+	}
+
+	// a file which drifted from what the mirror wrote must be seen to report it
+	if len(destConfigs.General.written.getDrift(destConfigs, filepath.Join(destConfigs.General.DestinationDirectory, relativePath), srcFile, destFile)) > 0 {
+		return false
+	}
+
+	// a file which is not listed by the manifest as it is must be seen to hash it
+	if !destConfigs.General.manifests.isListed(destConfigs, relativePath, destFile) {
+		return false
+	}
+
+	// hard links of the source file must be seen to link them in the destination
+	if scan.configs.General.PreserveHardLinks {
+		if _, ok := getFileID(filepath.Join(scan.configs.General.SourceDirectory, relativePath), srcFile); ok {
+			return false
+		}
+	}
+
+	switch scan.configs.General.CompareMode {
+	case compareModeSize:
+		return destFile.Size() == srcFile.Size()
+	case compareModeHash:
+		// the contents must be read to compare them
+		return false
+	default:
+		// times matched within a tolerance are confirmed by the sizes
+		return isSameModTime(destConfigs, srcFile.ModTime(), destFile.ModTime()) && (destConfigs.General.mtimeTolerance <= 0 || destFile.Size() == srcFile.Size())
+	}
+}
+
+func (dest destScan) isInternalPath(relativePath string) bool {
+	return isInternalPath(dest.internalPaths, relativePath)
+}
+
+// getKnownInfo returns the info of an entry, unwrapping the info of an unreadable root
+func getKnownInfo(info os.FileInfo) os.FileInfo {
+	if unreadable, ok := info.(unreadableFile); ok {
+		return unreadable.info
+	}
+	return info
+}
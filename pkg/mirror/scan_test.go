@@ -0,0 +1,82 @@
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeBenchmarkTree writes the same tree of directories holding small files into every root directory, so they are in sync
+func writeBenchmarkTree(b *testing.B, dirs int, files int, roots ...string) {
+	b.Helper()
+
+	for i := 0; i < dirs; i++ {
+		for j := 0; j < files; j++ {
+			relativePath := filepath.Join(fmt.Sprintf("dir%03d", i/10), fmt.Sprintf("dir%03d", i), fmt.Sprintf("file%04d.txt", j))
+			for _, root := range roots {
+				writeTestFile(b, filepath.Join(root, relativePath), relativePath)
+			}
+		}
+	}
+}
+
+// openBenchmarkJob opens a job mirroring the source into the destination directory, which is closed once the benchmark ends
+func openBenchmarkJob(b *testing.B, source string, destination string, configure func(config *Config)) Config {
+	b.Helper()
+
+	configs, closeJob, err := openJob(newTestMirror(b, source, destination, configure).configs)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(closeJob)
+
+	return configs
+}
+
+func BenchmarkScanMemory(b *testing.B) {
+	source, destination := b.TempDir(), b.TempDir()
+	writeBenchmarkTree(b, 200, 100, source, destination)
+
+	configs := openBenchmarkJob(b, source, destination, nil)
+	destConfigsList := getDestinationConfigs(configs)
+
+	// the complete scan holds every path of both trees, while the merged scan holds only their differences (none, since they are in sync)
+	scans := []struct {
+		name string
+		scan func() []*scannedTree
+	}{
+		{name: "complete", scan: func() []*scannedTree {
+			return scanTrees(configs, destConfigsList, func() map[string]os.FileInfo {
+				return getDirFiles(configs.General.logger, configs.General.source, configs.General.SourceDirectory, false, pathScope{})
+			}, func(destConfigs Config) map[string]os.FileInfo {
+				return getDestFiles(configs.General.logger, destConfigs.General.destination, destConfigs.General.DestinationDirectory, pathScope{}, nil)
+			})
+		}},
+		{name: "merged", scan: func() []*scannedTree {
+			return scanDifferences(configs, destConfigsList)
+		}},
+	}
+
+	for _, scan := range scans {
+		b.Run(scan.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				scan.scan()
+			}
+			b.StopTimer()
+
+			// the memory held by the scanned trees, which is kept until the iteration ends
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			trees := scan.scan()
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			runtime.KeepAlive(trees)
+
+			b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)), "retained-B")
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package mirror
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	scheduleOverlapSkip  = "skip"
+	scheduleOverlapQueue = "queue"
+)
+
+// shorthands of common cron expressions
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule holds the matching values of every field of a cron expression, as bit sets
+type cronSchedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+
+	// when both day of month and day of week are restricted, a time matching either of them matches (as in cron)
+	daysRestricted     bool
+	weekdaysRestricted bool
+}
+
+// cronField describes the allowed values of a field of a cron expression
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var (
+	cronMinute  = cronField{name: "minute", min: 0, max: 59}
+	cronHour    = cronField{name: "hour", min: 0, max: 23}
+	cronDay     = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth   = cronField{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	cronWeekday = cronField{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// parseCronSchedule parses a cron expression of 5 fields (minute, hour, day of month, month and day of week), e.g. '0 2 * * *', or a shorthand such as '@daily'
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	if descriptor, exists := cronDescriptors[strings.ToLower(expression)]; exists {
+		expression = descriptor
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule '%s', expected 5 fields (minute, hour, day of month, month and day of week)", expression)
+	}
+
+	schedule := &cronSchedule{}
+	var err error
+
+	if schedule.minutes, err = parseCronField(fields[0], cronMinute); err != nil {
+		return nil, err
+	}
+	if schedule.hours, err = parseCronField(fields[1], cronHour); err != nil {
+		return nil, err
+	}
+	if schedule.days, err = parseCronField(fields[2], cronDay); err != nil {
+		return nil, err
+	}
+	if schedule.months, err = parseCronField(fields[3], cronMonth); err != nil {
+		return nil, err
+	}
+	if schedule.weekdays, err = parseCronField(fields[4], cronWeekday); err != nil {
+		return nil, err
+	}
+
+	// sunday is either 0 or 7
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+
+	schedule.daysRestricted = fields[2] != "*"
+	schedule.weekdaysRestricted = fields[4] != "*"
+
+	// make sure the schedule ever matches (e.g. '0 0 30 2 *' does not)
+	if schedule.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule '%s' never matches", expression)
+	}
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (e.g. '1-5') and steps (e.g. '*/15' or '0-30/10') into a bit set of the matching values
+func parseCronField(value string, field cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(value, ",") {
+		// get the step, if any
+		rangePart := part
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s field '%s'", field.name, value)
+			}
+			rangePart = part[:i]
+		}
+
+		// get the range of values
+		start, end := field.min, field.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+
+			var err error
+			if start, err = parseCronValue(bounds[0], field); err != nil {
+				return 0, fmt.Errorf("invalid %s field '%s'", field.name, value)
+			}
+			end = start
+			if len(bounds) > 1 {
+				if end, err = parseCronValue(bounds[1], field); err != nil || end < start {
+					return 0, fmt.Errorf("invalid %s field '%s'", field.name, value)
+				}
+			} else if step > 1 {
+				// a single value with a step (e.g. '5/10') ranges up to the maximum
+				end = field.max
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(value string, field cronField) (int, error) {
+	for i, name := range field.names {
+		if strings.EqualFold(value, name) {
+			// names of months start from 1, names of days of week from 0
+			return field.min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil || v < field.min || v > field.max {
+		return 0, fmt.Errorf("invalid value '%s'", value)
+	}
+
+	return v, nil
+}
+
+// next returns the first time after the given time which matches the schedule (zero time if none does within a few years)
+func (schedule *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		// skip to the start of the next month, day or hour as long as it does not match, instead of checking every minute
+		if schedule.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !schedule.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if schedule.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if schedule.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (schedule *cronSchedule) matchesDay(t time.Time) bool {
+	dayMatches := schedule.days&(1<<uint(t.Day())) != 0
+	weekdayMatches := schedule.weekdays&(1<<uint(t.Weekday())) != 0
+
+	if schedule.daysRestricted && schedule.weekdaysRestricted {
+		return dayMatches || weekdayMatches
+	}
+	return dayMatches && weekdayMatches
+}
+
+// getScheduledInterval returns the time to wait for the next scheduled run, once an iteration which started at the given time ended
+func getScheduledInterval(configs Config, iterationStart time.Time) time.Duration {
+	now := time.Now()
+	next := configs.General.schedule.next(iterationStart)
+
+	// a scheduled run fired while the iteration was still running
+	if next.Before(now) {
+		if configs.General.ScheduleOverlap == scheduleOverlapQueue {
+			configs.General.logger.Info("Scheduled run queued", "scheduled", next)
+			next = now
+		} else {
+			configs.General.logger.Warn("Scheduled run skipped", "scheduled", next)
+			next = configs.General.schedule.next(now)
+		}
+	}
+
+	configs.General.status.setNextRun(next)
+	configs.General.logger.Info("Next scheduled run", "time", next)
+
+	return next.Sub(now)
+}
+
+// getAdaptiveInterval returns the time to wait for the next scan in adaptive interval mode, given the previous interval (zero before the
+// first one) and whether the iteration which just ended had changes: the minimum interval after changes, or the previous interval doubled
+// (up to the maximum) after none
+func getAdaptiveInterval(configs Config, previous time.Duration, changed bool) time.Duration {
+	minInterval := time.Duration(configs.General.AdaptiveInterval.MinIntervalMS) * time.Millisecond
+	maxInterval := time.Duration(configs.General.AdaptiveInterval.MaxIntervalMS) * time.Millisecond
+
+	interval := minInterval
+	if !changed && previous > 0 {
+		// the range could have changed since (by a config file update)
+		interval = max(min(previous*2, maxInterval), minInterval)
+	}
+	// only a changed interval is logged, so a job idling at the maximum logs nothing
+	if interval != previous {
+		configs.General.logger.Info("Scan interval", "intervalMS", interval.Milliseconds(), "changed", changed)
+	}
+
+	return interval
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package mirror
+
+import (
+	"os"
+)
+
+// ACLs and attributes are preserved on windows only
+const canPreserveSecurity = false
+
+// checkSecuritySupport logs that ACLs and attributes are not preserved, if requested, so the same config file can be used on every platform
+func checkSecuritySupport(configs Config) {
+	if configs.General.PreserveACLs || configs.General.PreserveAttributes {
+		configs.General.logger.Warn("ACLs and attributes are preserved on Windows only, ignoring preserveACLs and preserveAttributes")
+	}
+}
+
+func preserveSecurity(configs Config, stats *iterationStats, srcPath string, srcFile os.FileInfo, path string) error {
+	// there is nothing beyond permissions and ownership to preserve
+	return nil
+}
@@ -0,0 +1,147 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// ACLs and attributes are preserved on windows
+const canPreserveSecurity = true
+
+// attributes which can be set on a file, others (e.g. compressed or encrypted) are a matter of its storage
+const settableFileAttributes = windows.FILE_ATTRIBUTE_READONLY | windows.FILE_ATTRIBUTE_HIDDEN | windows.FILE_ATTRIBUTE_SYSTEM | windows.FILE_ATTRIBUTE_ARCHIVE |
+	windows.FILE_ATTRIBUTE_NOT_CONTENT_INDEXED | windows.FILE_ATTRIBUTE_TEMPORARY | windows.FILE_ATTRIBUTE_OFFLINE
+
+// checkSecuritySupport does nothing, since ACLs and attributes are preserved on windows
+func checkSecuritySupport(configs Config) {
+}
+
+// preserveSecurity sets the security descriptor (owner, group and DACL) and the attributes of the source file on the destination file, if requested
+func preserveSecurity(configs Config, stats *iterationStats, srcPath string, srcFile os.FileInfo, path string) error {
+	// nothing to do unless requested (a symlink keeps its own security, which is never followed)
+	if (!configs.General.PreserveACLs && !configs.General.PreserveAttributes) || configs.General.DryRun || isSymlink(srcFile) {
+		return nil
+	}
+
+	if configs.General.PreserveACLs {
+		// setting another owner requires the restore privilege, which is expected to be missing when not running elevated,
+		// so the DACL alone is preserved then
+		err := copySecurityDescriptor(srcPath, path, true)
+		if isPrivilegeError(err) {
+			if atomic.CompareAndSwapInt32(configs.General.securityWarned, 0, 1) {
+				configs.General.logger.Warn("Owners of ACLs can not be preserved, run elevated to preserve them", "path", path, "error", err)
+			}
+			err = copySecurityDescriptor(srcPath, path, false)
+		}
+		if isPrivilegeError(err) {
+			if atomic.CompareAndSwapInt32(configs.General.securityWarned, 1, 2) {
+				configs.General.logger.Warn("ACLs can not be preserved", "path", path, "error", err)
+			}
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if configs.General.PreserveAttributes {
+		if err := copyFileAttributes(srcPath, srcFile, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copySecurityDescriptor sets the DACL of the source file (and its owner and group, if requested) on the destination file
+func copySecurityDescriptor(srcPath string, path string, withOwner bool) error {
+	// the SACL is left out, since reading it requires the security privilege
+	sd, err := windows.GetNamedSecurityInfo(srcPath, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return &os.PathError{Op: "GetNamedSecurityInfo", Path: srcPath, Err: err}
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return err
+	}
+	group, _, err := sd.Group()
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		return err
+	}
+
+	// a protected DACL is set as it is, otherwise entries are inherited from the destination parent (which mirrors the source parent)
+	info := windows.SECURITY_INFORMATION(windows.DACL_SECURITY_INFORMATION)
+	if withOwner {
+		info |= windows.OWNER_SECURITY_INFORMATION | windows.GROUP_SECURITY_INFORMATION
+	} else {
+		owner, group = nil, nil
+	}
+	if control&windows.SE_DACL_PROTECTED != 0 {
+		info |= windows.PROTECTED_DACL_SECURITY_INFORMATION
+	} else {
+		info |= windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+	}
+
+	if err := windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, info, owner, group, dacl, nil); err != nil {
+		return &os.PathError{Op: "SetNamedSecurityInfo", Path: path, Err: err}
+	}
+	return nil
+}
+
+func copyFileAttributes(srcPath string, srcFile os.FileInfo, path string) error {
+	// get the attributes of the source file from its info, or read them if missing
+	var srcAttributes uint32
+	if data, ok := srcFile.Sys().(*syscall.Win32FileAttributeData); ok {
+		srcAttributes = data.FileAttributes
+	} else {
+		srcPathPtr, err := windows.UTF16PtrFromString(srcPath)
+		if err != nil {
+			return err
+		}
+		if srcAttributes, err = windows.GetFileAttributes(srcPathPtr); err != nil {
+			return &os.PathError{Op: "GetFileAttributes", Path: srcPath, Err: err}
+		}
+	}
+
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attributes, err := windows.GetFileAttributes(pathPtr)
+	if err != nil {
+		return &os.PathError{Op: "GetFileAttributes", Path: path, Err: err}
+	}
+	if attributes&settableFileAttributes == srcAttributes&settableFileAttributes {
+		return nil
+	}
+
+	// the other attributes are never changed by setting them, and no attribute at all is set as normal
+	newAttributes := srcAttributes & settableFileAttributes
+	if newAttributes == 0 {
+		newAttributes = windows.FILE_ATTRIBUTE_NORMAL
+	}
+	if err := windows.SetFileAttributes(pathPtr, newAttributes); err != nil {
+		return &os.PathError{Op: "SetFileAttributes", Path: path, Err: err}
+	}
+	return nil
+}
+
+// isPrivilegeError reports whether the error is caused by missing privileges
+func isPrivilegeError(err error) bool {
+	return errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD) || errors.Is(err, windows.ERROR_INVALID_OWNER) || errors.Is(err, windows.ERROR_ACCESS_DENIED)
+}
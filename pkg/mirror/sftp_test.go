@@ -0,0 +1,851 @@
+package mirror
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpTestUser and sftpTestPassword are the credentials the test server accepts
+const (
+	sftpTestUser     = "mirror"
+	sftpTestPassword = "secret"
+)
+
+// sftpTestServer is an SFTP server over SSH, which serves a local directory as the root of the remote host
+type sftpTestServer struct {
+	root           string
+	address        string
+	knownHostsFile string
+	// whether the posix rename extension is offered
+	posixRename bool
+
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+// newSftpTestServer starts a server on a local port, which is stopped once the test ends
+func newSftpTestServer(t *testing.T, posixRename bool) *sftpTestServer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if conn.User() != sftpTestUser || string(password) != sftpTestPassword {
+			return nil, errors.New("invalid credentials")
+		}
+		return nil, nil
+	}}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &sftpTestServer{root: t.TempDir(), address: listener.Addr().String(), posixRename: posixRename}
+	server.knownHostsFile = writeConfigFile(t, t.TempDir(), "known_hosts", knownhosts.Line([]string{server.address}, signer.PublicKey())+"\n")
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.conns = append(server.conns, conn)
+			server.mutex.Unlock()
+
+			go server.serveConn(conn, config)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		server.dropConns()
+	})
+
+	return server
+}
+
+// dropConns closes every connection to the server, as if the network dropped them
+func (server *sftpTestServer) dropConns() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.conns = nil
+}
+
+func (server *sftpTestServer) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for request := range channelRequests {
+				isSftp := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+				request.Reply(isSftp, nil)
+				if isSftp {
+					go server.serveSftp(channel)
+				}
+			}
+		}()
+	}
+}
+
+// getLocalPath returns the path of the local file which the remote path refers to
+func (server *sftpTestServer) getLocalPath(remotePath string) string {
+	return filepath.Join(server.root, filepath.FromSlash(remotePath))
+}
+
+// serveSftp serves the requests of a session one at a time, in the order they arrive
+func (server *sftpTestServer) serveSftp(channel ssh.Channel) {
+	defer channel.Close()
+
+	files := make(map[string]*os.File)
+	dirs := make(map[string][]os.FileInfo)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	nextHandle := 0
+	newHandle := func() string {
+		nextHandle++
+		return fmt.Sprint(nextHandle)
+	}
+
+	reply := func(packetType byte, payload sftpBuffer) bool {
+		packet := make(sftpBuffer, 0, 5+len(payload))
+		packet.uint32(uint32(1 + len(payload)))
+		packet = append(packet, packetType)
+		packet = append(packet, payload...)
+		_, err := channel.Write(packet)
+		return err == nil
+	}
+
+	for {
+		packetType, data, err := readSftpPacket(channel)
+		if err != nil {
+			return
+		}
+		request := sftpReader{data: data}
+
+		if packetType == sftpPacketInit {
+			var payload sftpBuffer
+			payload.uint32(3)
+			if server.posixRename {
+				payload.string(sftpExtensionPosixRename)
+				payload.string("1")
+			}
+			payload.string(sftpExtensionFsync)
+			payload.string("1")
+			if !reply(sftpPacketVersion, payload) {
+				return
+			}
+			continue
+		}
+
+		var response sftpBuffer
+		response.uint32(request.uint32())
+		status := func(err error) (byte, sftpBuffer) {
+			response.uint32(getSftpTestStatus(err))
+			if err != nil {
+				response.string(err.Error())
+			} else {
+				response.string("")
+			}
+			// language tag
+			response.string("")
+			return sftpPacketStatus, response
+		}
+
+		var responseType byte
+		switch packetType {
+		case sftpPacketOpen:
+			remotePath, flags := request.string(), request.uint32()
+			request.attrs()
+
+			openFlags := os.O_RDONLY
+			if flags&sftpOpenWrite != 0 {
+				openFlags = os.O_WRONLY
+			}
+			if flags&sftpOpenCreate != 0 {
+				openFlags |= os.O_CREATE
+			}
+			if flags&sftpOpenTruncate != 0 {
+				openFlags |= os.O_TRUNC
+			}
+			file, err := os.OpenFile(server.getLocalPath(remotePath), openFlags, 0644)
+			if err != nil {
+				responseType, response = status(err)
+				break
+			}
+			handle := newHandle()
+			files[handle] = file
+			response.string(handle)
+			responseType = sftpPacketHandle
+		case sftpPacketOpendir:
+			entries, err := os.ReadDir(server.getLocalPath(request.string()))
+			if err != nil {
+				responseType, response = status(err)
+				break
+			}
+			// the listing holds the entries of the directory itself, as the listings of real servers do
+			infos := []os.FileInfo{remoteFileInfo{name: ".", mode: os.ModeDir}, remoteFileInfo{name: "..", mode: os.ModeDir}}
+			for _, entry := range entries {
+				if info, err := entry.Info(); err == nil {
+					infos = append(infos, info)
+				}
+			}
+			handle := newHandle()
+			dirs[handle] = infos
+			response.string(handle)
+			responseType = sftpPacketHandle
+		case sftpPacketReaddir:
+			handle := request.string()
+			infos := dirs[handle]
+			if len(infos) < 1 {
+				responseType, response = status(io.EOF)
+				break
+			}
+			// the entries are listed a few at a time
+			count := min(len(infos), 50)
+			dirs[handle] = infos[count:]
+			response.uint32(uint32(count))
+			for _, info := range infos[:count] {
+				response.string(info.Name())
+				response.string("-rw-r--r-- 1 mirror mirror " + info.Name())
+				response.attrs(getSftpTestAttrs(info))
+			}
+			responseType = sftpPacketName
+		case sftpPacketClose:
+			handle := request.string()
+			var err error
+			if file, exists := files[handle]; exists {
+				err = file.Close()
+			}
+			delete(files, handle)
+			delete(dirs, handle)
+			responseType, response = status(err)
+		case sftpPacketRead:
+			file, offset, length := files[request.string()], request.uint64(), request.uint32()
+			data := make([]byte, length)
+			n, err := file.ReadAt(data, int64(offset))
+			if n < 1 {
+				responseType, response = status(err)
+				break
+			}
+			response.bytes(data[:n])
+			responseType = sftpPacketData
+		case sftpPacketWrite:
+			file, offset, data := files[request.string()], request.uint64(), request.bytes()
+			_, err := file.WriteAt(data, int64(offset))
+			responseType, response = status(err)
+		case sftpPacketStat, sftpPacketLstat:
+			localPath := server.getLocalPath(request.string())
+			var info os.FileInfo
+			if packetType == sftpPacketStat {
+				info, err = os.Stat(localPath)
+			} else {
+				info, err = os.Lstat(localPath)
+			}
+			if err != nil {
+				responseType, response = status(err)
+				break
+			}
+			response.attrs(getSftpTestAttrs(info))
+			responseType = sftpPacketAttrs
+		case sftpPacketSetstat:
+			localPath, attrs := server.getLocalPath(request.string()), request.attrs()
+			var err error
+			if attrs.flags&sftpAttrPermissions != 0 {
+				err = os.Chmod(localPath, os.FileMode(attrs.permissions&0777))
+			}
+			if attrs.flags&sftpAttrTimes != 0 && err == nil {
+				err = os.Chtimes(localPath, time.Unix(int64(attrs.atime), 0), time.Unix(int64(attrs.mtime), 0))
+			}
+			responseType, response = status(err)
+		case sftpPacketRemove:
+			localPath := server.getLocalPath(request.string())
+			// a directory is removed by its own request
+			if info, err := os.Lstat(localPath); err == nil && info.IsDir() {
+				responseType, response = status(errors.New("is a directory"))
+				break
+			}
+			responseType, response = status(os.Remove(localPath))
+		case sftpPacketMkdir:
+			localPath, attrs := server.getLocalPath(request.string()), request.attrs()
+			responseType, response = status(os.Mkdir(localPath, os.FileMode(attrs.permissions&0777)))
+		case sftpPacketRmdir:
+			responseType, response = status(removeEmptyDir(server.getLocalPath(request.string())))
+		case sftpPacketRename:
+			oldPath, newPath := server.getLocalPath(request.string()), server.getLocalPath(request.string())
+			// a plain rename never replaces an existing file
+			if _, err := os.Lstat(newPath); err == nil {
+				responseType, response = status(fs.ErrExist)
+				break
+			}
+			responseType, response = status(os.Rename(oldPath, newPath))
+		case sftpPacketExtended:
+			switch extension := request.string(); {
+			case extension == sftpExtensionPosixRename && server.posixRename:
+				oldPath, newPath := server.getLocalPath(request.string()), server.getLocalPath(request.string())
+				responseType, response = status(os.Rename(oldPath, newPath))
+			case extension == sftpExtensionFsync:
+				responseType, response = status(files[request.string()].Sync())
+			default:
+				responseType, response = status(errors.ErrUnsupported)
+			}
+		default:
+			responseType, response = status(errors.ErrUnsupported)
+		}
+
+		if !reply(responseType, response) {
+			return
+		}
+	}
+}
+
+// removeEmptyDir removes the directory, which must be empty
+func removeEmptyDir(path string) error {
+	if info, err := os.Lstat(path); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return &fs.PathError{Op: "rmdir", Path: path, Err: errors.New("not a directory")}
+	}
+	return os.Remove(path)
+}
+
+// getSftpTestStatus returns the status code of the error
+func getSftpTestStatus(err error) uint32 {
+	switch {
+	case err == nil:
+		return sftpStatusOK
+	case err == io.EOF:
+		return sftpStatusEOF
+	case errors.Is(err, fs.ErrNotExist):
+		return sftpStatusNoSuchFile
+	case errors.Is(err, fs.ErrPermission):
+		return sftpStatusPermissionDenied
+	}
+	// generic failure
+	return 4
+}
+
+// getSftpTestAttrs returns the attributes of the file as a server sends them, with its owner
+func getSftpTestAttrs(info os.FileInfo) sftpAttrs {
+	permissions := uint32(info.Mode().Perm())
+	switch {
+	case info.IsDir():
+		permissions |= 0040000
+	case info.Mode()&os.ModeSymlink != 0:
+		permissions |= 0120000
+	default:
+		permissions |= 0100000
+	}
+
+	return sftpAttrs{
+		flags:       sftpAttrSize | sftpAttrPermissions | sftpAttrTimes,
+		size:        uint64(info.Size()),
+		permissions: permissions,
+		atime:       uint32(info.ModTime().Unix()),
+		mtime:       uint32(info.ModTime().Unix()),
+	}
+}
+
+// newSftpTestFS returns the file system of the server, reached by its URL
+func newSftpTestFS(t testing.TB, server *sftpTestServer) *sftpFS {
+	t.Helper()
+
+	fsys := newSftpFS(Config{General: GeneralConfigurations{
+		DestinationURL:     fmt.Sprintf("sftp://%s@%s/backup", sftpTestUser, server.address),
+		SftpPassword:       sftpTestPassword,
+		SftpKnownHostsFile: server.knownHostsFile,
+		SftpMaxSessions:    2,
+	}})
+	t.Cleanup(func() {
+		fsys.Close()
+	})
+
+	return fsys
+}
+
+// nopWriteCloser is a writer which has nothing to close
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestSftpBufferRoundTrip(t *testing.T) {
+	full := sftpAttrs{flags: sftpAttrSize | sftpAttrPermissions | sftpAttrTimes, size: 1 << 40, permissions: 0100644, atime: 1600000000, mtime: 1700000000}
+
+	var buffer sftpBuffer
+	buffer.uint32(0xdeadbeef)
+	buffer.uint64(0x0102030405060708)
+	buffer.string("")
+	buffer.string("/backup/é.txt")
+	buffer.bytes([]byte{0, 1, 2, 255})
+	buffer.attrs(full)
+	buffer.attrs(sftpAttrs{})
+
+	// values are big endian, strings are prefixed by their length
+	if prefix := []byte{0xde, 0xad, 0xbe, 0xef, 1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0, 0, 0, 0, 14}; !bytes.HasPrefix(buffer, prefix) {
+		t.Errorf("buffer starts with %x, expected %x", buffer[:len(prefix)], prefix)
+	}
+
+	reader := sftpReader{data: buffer}
+	if value := reader.uint32(); value != 0xdeadbeef {
+		t.Errorf("uint32() = %x, expected deadbeef", value)
+	}
+	if value := reader.uint64(); value != 0x0102030405060708 {
+		t.Errorf("uint64() = %x, expected 0102030405060708", value)
+	}
+	if value := reader.string(); value != "" {
+		t.Errorf("string() = %q, expected an empty string", value)
+	}
+	if value := reader.string(); value != "/backup/é.txt" {
+		t.Errorf("string() = %q, expected %q", value, "/backup/é.txt")
+	}
+	if value := reader.bytes(); !bytes.Equal(value, []byte{0, 1, 2, 255}) {
+		t.Errorf("bytes() = %x, expected 000102ff", value)
+	}
+	if attrs := reader.attrs(); attrs != full {
+		t.Errorf("attrs() = %+v, expected %+v", attrs, full)
+	}
+	if attrs := reader.attrs(); attrs != (sftpAttrs{}) {
+		t.Errorf("attrs() = %+v, expected no attributes", attrs)
+	}
+	if reader.err != nil || len(reader.data) > 0 {
+		t.Errorf("reader ended with %v and %d bytes left, expected no error and no bytes left", reader.err, len(reader.data))
+	}
+}
+
+func TestSftpReaderSkipsAttributes(t *testing.T) {
+	// the owner and the extended attributes sent by servers are skipped
+	var buffer sftpBuffer
+	buffer.uint32(sftpAttrSize | sftpAttrUIDGID | sftpAttrPermissions | sftpAttrTimes | sftpAttrExtended)
+	buffer.uint64(42)
+	buffer.uint32(1000)
+	buffer.uint32(1000)
+	buffer.uint32(0040755)
+	buffer.uint32(1)
+	buffer.uint32(2)
+	buffer.uint32(2)
+	for _, value := range []string{"user.a", "1", "user.b", "2"} {
+		buffer.string(value)
+	}
+	buffer.string("next")
+
+	reader := sftpReader{data: buffer}
+	attrs := reader.attrs()
+	if attrs.size != 42 || attrs.permissions != 0040755 || attrs.atime != 1 || attrs.mtime != 2 {
+		t.Errorf("attrs() = %+v, expected a size of 42, permissions 0040755 and times 1 and 2", attrs)
+	}
+	if next := reader.string(); reader.err != nil || next != "next" {
+		t.Errorf("value after the attributes is %q (%v), expected %q", next, reader.err, "next")
+	}
+
+	info := attrs.fileInfo("dir")
+	if info.Name() != "dir" || !info.IsDir() || info.Mode().Perm() != 0755 || info.Size() != 42 || !info.ModTime().Equal(time.Unix(2, 0)) {
+		t.Errorf("fileInfo() = %s %s %d %s, expected dir drwxr-xr-x 42 %s", info.Name(), info.Mode(), info.Size(), info.ModTime(), time.Unix(2, 0))
+	}
+}
+
+func TestSftpReaderMalformed(t *testing.T) {
+	var buffer sftpBuffer
+	buffer.string("name")
+	buffer.uint64(7)
+	buffer.attrs(sftpAttrs{flags: sftpAttrSize | sftpAttrTimes, size: 1, atime: 2, mtime: 3})
+
+	// every truncation of the payload is reported, and every value read after it is zero
+	for length := 0; length < len(buffer); length++ {
+		reader := sftpReader{data: buffer[:length]}
+		reader.string()
+		reader.uint64()
+		reader.attrs()
+
+		if !errors.Is(reader.err, errSftpBadPacket) {
+			t.Errorf("reading %d of %d bytes returned %v, expected %v", length, len(buffer), reader.err, errSftpBadPacket)
+		}
+		if value := reader.uint32(); value != 0 {
+			t.Errorf("uint32() after an error = %d, expected 0", value)
+		}
+	}
+
+	// a length larger than the payload
+	reader := sftpReader{data: []byte{0xff, 0xff, 0xff, 0xff, 'a'}}
+	if value := reader.string(); value != "" || !errors.Is(reader.err, errSftpBadPacket) {
+		t.Errorf("string() = %q (%v), expected %v", value, reader.err, errSftpBadPacket)
+	}
+}
+
+func TestReadSftpPacket(t *testing.T) {
+	var output bytes.Buffer
+	client := &sftpClient{input: nopWriteCloser{&output}}
+
+	payload := bytes.Repeat([]byte{7}, 1000)
+	if err := client.writePacket(sftpPacketWrite, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.writePacket(sftpPacketClose, nil); err != nil {
+		t.Fatal(err)
+	}
+	if header := output.Bytes()[:5]; !bytes.Equal(header, []byte{0, 0, 0x03, 0xe9, sftpPacketWrite}) {
+		t.Errorf("packet header is %x, expected 000003e9%02x", header, sftpPacketWrite)
+	}
+
+	packetType, data, err := readSftpPacket(&output)
+	if err != nil || packetType != sftpPacketWrite || !bytes.Equal(data, payload) {
+		t.Errorf("readSftpPacket() = %d with %d bytes (%v), expected %d with %d bytes", packetType, len(data), err, sftpPacketWrite, len(payload))
+	}
+	packetType, data, err = readSftpPacket(&output)
+	if err != nil || packetType != sftpPacketClose || len(data) > 0 {
+		t.Errorf("readSftpPacket() = %d with %d bytes (%v), expected %d with no bytes", packetType, len(data), err, sftpPacketClose)
+	}
+	if _, _, err := readSftpPacket(&output); err != io.EOF {
+		t.Errorf("readSftpPacket() at the end returned %v, expected %v", err, io.EOF)
+	}
+
+	tests := []struct {
+		name     string
+		packet   []byte
+		expected error
+	}{
+		{name: "empty packet", packet: []byte{0, 0, 0, 0}, expected: errSftpBadPacket},
+		{name: "oversized packet", packet: []byte{0x7f, 0, 0, 0, sftpPacketData}, expected: errSftpBadPacket},
+		{name: "truncated header", packet: []byte{0, 0}, expected: io.ErrUnexpectedEOF},
+		{name: "truncated packet", packet: []byte{0, 0, 0, 9, sftpPacketData, 0}, expected: io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		if _, _, err := readSftpPacket(bytes.NewReader(test.packet)); !errors.Is(err, test.expected) {
+			t.Errorf("readSftpPacket() of %s returned %v, expected %v", test.name, err, test.expected)
+		}
+	}
+}
+
+func TestGetSftpFileMode(t *testing.T) {
+	tests := []struct {
+		permissions uint32
+		expected    os.FileMode
+	}{
+		{permissions: 0100644, expected: 0644},
+		{permissions: 0040755, expected: os.ModeDir | 0755},
+		{permissions: 0120777, expected: os.ModeSymlink | 0777},
+		{permissions: 0010600, expected: os.ModeNamedPipe | 0600},
+		{permissions: 0140700, expected: os.ModeSocket | 0700},
+		{permissions: 0020620, expected: os.ModeDevice | os.ModeCharDevice | 0620},
+		{permissions: 0060660, expected: os.ModeDevice | 0660},
+		{permissions: 0104755, expected: os.ModeSetuid | 0755},
+		{permissions: 0042775, expected: os.ModeDir | os.ModeSetgid | 0775},
+		{permissions: 0041777, expected: os.ModeDir | os.ModeSticky | 0777},
+	}
+
+	for _, test := range tests {
+		if mode := getSftpFileMode(test.permissions); mode != test.expected {
+			t.Errorf("getSftpFileMode(%o) = %s, expected %s", test.permissions, mode, test.expected)
+		}
+	}
+}
+
+func TestSftpStatusError(t *testing.T) {
+	statusResponse := func(code uint32, message string) sftpResponse {
+		var data sftpBuffer
+		data.uint32(code)
+		data.string(message)
+		data.string("")
+		return sftpResponse{packetType: sftpPacketStatus, data: data}
+	}
+	connErr := errors.New("connection lost")
+
+	tests := []struct {
+		name     string
+		response sftpResponse
+		expected error
+		message  string
+	}{
+		{name: "ok", response: statusResponse(sftpStatusOK, "")},
+		{name: "end of file", response: statusResponse(sftpStatusEOF, "EOF"), expected: io.EOF},
+		{name: "missing file", response: statusResponse(sftpStatusNoSuchFile, "No such file"), expected: fs.ErrNotExist},
+		{name: "permission denied", response: statusResponse(sftpStatusPermissionDenied, "Permission denied"), expected: fs.ErrPermission},
+		{name: "failure", response: statusResponse(4, "Failure"), message: "sftp: Failure"},
+		{name: "failure without a message", response: statusResponse(8, ""), message: "sftp: status 8"},
+		{name: "unexpected packet", response: sftpResponse{packetType: sftpPacketHandle}, expected: errSftpBadPacket},
+		{name: "truncated status", response: sftpResponse{packetType: sftpPacketStatus, data: []byte{0, 0}}, expected: errSftpBadPacket},
+		{name: "lost connection", response: sftpResponse{err: connErr}, expected: connErr},
+	}
+
+	for _, test := range tests {
+		err := test.response.statusError("op", "/backup/file")
+		switch {
+		case test.expected == nil && len(test.message) < 1:
+			if err != nil {
+				t.Errorf("statusError() of %s returned %v, expected no error", test.name, err)
+			}
+		case test.expected == io.EOF:
+			// the end of a file or a listing is returned as it is, as readers expect
+			if err != io.EOF {
+				t.Errorf("statusError() of %s returned %v, expected %v", test.name, err, io.EOF)
+			}
+		case len(test.message) > 0:
+			var pathErr *fs.PathError
+			if !errors.As(err, &pathErr) || pathErr.Err.Error() != test.message {
+				t.Errorf("statusError() of %s returned %v, expected %q", test.name, err, test.message)
+			}
+		default:
+			var pathErr *fs.PathError
+			if !errors.Is(err, test.expected) || !errors.As(err, &pathErr) || pathErr.Op != "op" || pathErr.Path != "/backup/file" {
+				t.Errorf("statusError() of %s returned %v, expected a path error of %v", test.name, err, test.expected)
+			}
+		}
+	}
+}
+
+func TestSftpFSRoundTrip(t *testing.T) {
+	for _, posixRename := range []bool{true, false} {
+		t.Run(fmt.Sprintf("posix rename %v", posixRename), func(t *testing.T) {
+			server := newSftpTestServer(t, posixRename)
+			fsys := newSftpTestFS(t, server)
+
+			if err := fsys.Connect(); err != nil {
+				t.Fatalf("Connect() failed; %s", err)
+			}
+			if err := fsys.MkdirAll("/backup/one/two", 0755); err != nil {
+				t.Fatalf("MkdirAll() failed; %s", err)
+			}
+			// existing directories are kept
+			if err := fsys.MkdirAll("/backup/one", 0755); err != nil {
+				t.Fatalf("MkdirAll() of an existing directory failed; %s", err)
+			}
+
+			// large enough to keep many writes in flight
+			data := make([]byte, sftpChunkSize*sftpMaxWritesInFlight*2+123)
+			for i := range data {
+				data[i] = byte(i % 251)
+			}
+			file, err := fsys.Create("/backup/one/two/.file.dmtmp", nil)
+			if err != nil {
+				t.Fatalf("Create() failed; %s", err)
+			}
+			if n, err := file.Write(data); err != nil || n != len(data) {
+				t.Fatalf("Write() wrote %d of %d bytes; %v", n, len(data), err)
+			}
+			if err := file.Sync(); err != nil {
+				t.Fatalf("Sync() failed; %s", err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatalf("Close() failed; %s", err)
+			}
+			if err := file.Close(); !errors.Is(err, fs.ErrClosed) {
+				t.Errorf("second Close() returned %v, expected %v", err, fs.ErrClosed)
+			}
+
+			// the new file replaces an existing one, either way the server renames files
+			if err := os.WriteFile(server.getLocalPath("/backup/one/two/file"), []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := fsys.Rename("/backup/one/two/.file.dmtmp", "/backup/one/two/file"); err != nil {
+				t.Fatalf("Rename() failed; %s", err)
+			}
+			if _, err := fsys.Stat("/backup/one/two/.file.dmtmp"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat() of the renamed file returned %v, expected %v", err, fs.ErrNotExist)
+			}
+
+			if err := fsys.Chmod("/backup/one/two/file", 0600); err != nil {
+				t.Fatalf("Chmod() failed; %s", err)
+			}
+			if err := fsys.Chtimes("/backup/one/two/file", modTime, modTime); err != nil {
+				t.Fatalf("Chtimes() failed; %s", err)
+			}
+			info, err := fsys.Stat("/backup/one/two/file")
+			if err != nil {
+				t.Fatalf("Stat() failed; %s", err)
+			}
+			if info.Size() != int64(len(data)) || !info.ModTime().Equal(modTime) || info.IsDir() {
+				t.Errorf("Stat() = %d bytes modified at %s, expected %d bytes modified at %s", info.Size(), info.ModTime(), len(data), modTime)
+			}
+			if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+				t.Errorf("Stat() mode is %s, expected %s", info.Mode(), os.FileMode(0600))
+			}
+
+			reader, err := fsys.Open("/backup/one/two/file")
+			if err != nil {
+				t.Fatalf("Open() failed; %s", err)
+			}
+			read, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil || !bytes.Equal(read, data) {
+				t.Errorf("read %d bytes (%v), expected the %d written bytes", len(read), err, len(data))
+			}
+
+			entries, err := fsys.ReadDir("/backup/one")
+			if err != nil || len(entries) != 1 || entries[0].Name() != "two" || !entries[0].IsDir() {
+				t.Errorf("ReadDir() = %v (%v), expected the directory 'two'", entries, err)
+			}
+
+			if err := fsys.Remove("/backup/one/two"); err == nil {
+				t.Error("Remove() of a directory which is not empty succeeded")
+			}
+			if err := fsys.Remove("/backup/one/two/file"); err != nil {
+				t.Errorf("Remove() of a file failed; %s", err)
+			}
+			if err := fsys.Remove("/backup/one/two"); err != nil {
+				t.Errorf("Remove() of an empty directory failed; %s", err)
+			}
+			if _, err := fsys.Lstat("/backup/one/two"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Lstat() of the removed directory returned %v, expected %v", err, fs.ErrNotExist)
+			}
+		})
+	}
+}
+
+func TestSftpFSReadDirAndRemoveAll(t *testing.T) {
+	server := newSftpTestServer(t, true)
+	fsys := newSftpTestFS(t, server)
+
+	// more entries than a single response of the server holds
+	var expected []string
+	for i := 0; i < 120; i++ {
+		name := fmt.Sprintf("file%03d", i)
+		expected = append(expected, name)
+		writeTestFile(t, server.getLocalPath("/backup/dir/"+name), name)
+	}
+	writeTestFile(t, server.getLocalPath("/backup/dir/sub/deep/file"), "deep")
+	expected = append(expected, "sub")
+	sort.Strings(expected)
+
+	entries, err := fsys.ReadDir("/backup/dir")
+	if err != nil {
+		t.Fatalf("ReadDir() failed; %s", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("ReadDir() = %q, expected %q", names, expected)
+	}
+
+	if _, err := fsys.ReadDir("/backup/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDir() of a missing directory returned %v, expected %v", err, fs.ErrNotExist)
+	}
+
+	if err := fsys.RemoveAll("/backup/dir"); err != nil {
+		t.Fatalf("RemoveAll() failed; %s", err)
+	}
+	if _, err := os.Stat(server.getLocalPath("/backup/dir")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("removed directory is still there (%v)", err)
+	}
+	if err := fsys.RemoveAll("/backup/dir"); err != nil {
+		t.Errorf("RemoveAll() of a missing directory failed; %s", err)
+	}
+}
+
+func TestSftpFSReconnects(t *testing.T) {
+	server := newSftpTestServer(t, true)
+	fsys := newSftpTestFS(t, server)
+	writeTestFile(t, server.getLocalPath("/backup/file"), "file")
+
+	if _, err := fsys.Stat("/backup/file"); err != nil {
+		t.Fatalf("Stat() failed; %s", err)
+	}
+
+	server.dropConns()
+
+	// the lost connection may fail the operations in flight, but later ones connect again
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := fsys.Stat("/backup/file")
+		if err == nil {
+			break
+		}
+		if !strings.Contains(err.Error(), "connection lost") || time.Now().After(deadline) {
+			t.Fatalf("Stat() after the connection dropped failed; %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSftpFSRejectsUnknownHost(t *testing.T) {
+	server := newSftpTestServer(t, true)
+	// the key of another host
+	other := newSftpTestServer(t, true)
+	server.knownHostsFile = other.knownHostsFile
+
+	var keyErr *knownhosts.KeyError
+	if err := newSftpTestFS(t, server).Connect(); !errors.As(err, &keyErr) {
+		t.Errorf("Connect() to a host of an unknown key returned %v, expected a host key error", err)
+	}
+}
+
+func TestSyncToSftpDestination(t *testing.T) {
+	server := newSftpTestServer(t, true)
+	source := t.TempDir()
+	writeTestFile(t, filepath.Join(source, "a.txt"), "a")
+	writeTestFile(t, filepath.Join(source, "dir", "b.txt"), strings.Repeat("b", sftpChunkSize*3))
+
+	mirror := newTestMirror(t, source, "", func(config *Config) {
+		config.General.DestinationURL = fmt.Sprintf("sftp://%s@%s/backup", sftpTestUser, server.address)
+		config.General.SftpPassword = sftpTestPassword
+		config.General.SftpKnownHostsFile = server.knownHostsFile
+	})
+
+	summary := mustSyncOnce(t, mirror)
+	if summary.FilesCopied != 2 {
+		t.Errorf("copied %d files, expected 2", summary.FilesCopied)
+	}
+	for _, name := range []string{"a.txt", filepath.Join("dir", "b.txt")} {
+		srcData, _ := os.ReadFile(filepath.Join(source, name))
+		destData, err := os.ReadFile(server.getLocalPath("/backup/" + filepath.ToSlash(name)))
+		if err != nil || !bytes.Equal(destData, srcData) {
+			t.Errorf("copy of '%s' holds %d bytes (%v), expected %d bytes", name, len(destData), err, len(srcData))
+		}
+	}
+
+	// a removed source file is removed from the remote host, unchanged ones are not copied again
+	if err := os.Remove(filepath.Join(source, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	summary = mustSyncOnce(t, mirror)
+	if summary.FilesCopied != 0 || summary.FilesDeleted != 1 {
+		t.Errorf("copied %d and deleted %d files, expected none copied and 1 deleted", summary.FilesCopied, summary.FilesDeleted)
+	}
+	if _, err := os.Stat(server.getLocalPath("/backup/a.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("removed source file is still on the remote host (%v)", err)
+	}
+}
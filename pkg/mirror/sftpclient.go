@@ -0,0 +1,731 @@
+package mirror
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// packet types of the SFTP protocol (version 3)
+const (
+	sftpPacketInit          = 1
+	sftpPacketVersion       = 2
+	sftpPacketOpen          = 3
+	sftpPacketClose         = 4
+	sftpPacketRead          = 5
+	sftpPacketWrite         = 6
+	sftpPacketLstat         = 7
+	sftpPacketSetstat       = 9
+	sftpPacketOpendir       = 11
+	sftpPacketReaddir       = 12
+	sftpPacketRemove        = 13
+	sftpPacketMkdir         = 14
+	sftpPacketRmdir         = 15
+	sftpPacketStat          = 17
+	sftpPacketRename        = 18
+	sftpPacketStatus        = 101
+	sftpPacketHandle        = 102
+	sftpPacketData          = 103
+	sftpPacketName          = 104
+	sftpPacketAttrs         = 105
+	sftpPacketExtended      = 200
+	sftpPacketExtendedReply = 201
+)
+
+// status codes of the SFTP protocol
+const (
+	sftpStatusOK               = 0
+	sftpStatusEOF              = 1
+	sftpStatusNoSuchFile       = 2
+	sftpStatusPermissionDenied = 3
+)
+
+// flags of opened files
+const (
+	sftpOpenRead     = 0x01
+	sftpOpenWrite    = 0x02
+	sftpOpenCreate   = 0x08
+	sftpOpenTruncate = 0x10
+)
+
+// flags of the attributes present in a packet
+const (
+	sftpAttrSize        = 0x00000001
+	sftpAttrUIDGID      = 0x00000002
+	sftpAttrPermissions = 0x00000004
+	sftpAttrTimes       = 0x00000008
+	sftpAttrExtended    = 0x80000000
+)
+
+// extensions of OpenSSH servers, which are used when available
+const (
+	sftpExtensionPosixRename = "posix-rename@openssh.com"
+	sftpExtensionFsync       = "fsync@openssh.com"
+)
+
+const (
+	// size of the data of a single read or write request, which every server accepts
+	sftpChunkSize = 32 * 1024
+	// count of write requests of a file in flight, so a write does not wait for the round trip of every request
+	sftpMaxWritesInFlight = 64
+	// largest packet accepted from the server
+	sftpMaxPacketSize = 4 * 1024 * 1024
+)
+
+var errSftpBadPacket = errors.New("sftp: malformed packet")
+
+// sftpClient runs SFTP requests over a single SSH connection. requests may be sent concurrently, their responses are matched by id
+type sftpClient struct {
+	conn  *ssh.Client
+	input io.WriteCloser
+	// packets are written whole, one at a time
+	writeMutex sync.Mutex
+
+	mutex   sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan sftpResponse
+	// error which ended the connection, after which every request fails
+	err error
+
+	// extensions supported by the server, by their name
+	extensions map[string]string
+}
+
+// sftpResponse is the response to a request, or the error which ended the connection before it arrived
+type sftpResponse struct {
+	packetType byte
+	// payload following the request id
+	data []byte
+	err  error
+}
+
+// newSftpClient starts the SFTP subsystem on the SSH connection, which is owned by the client from now on
+func newSftpClient(conn *ssh.Client) (*sftpClient, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	input, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	output, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, err
+	}
+
+	client := &sftpClient{conn: conn, input: input, pending: make(map[uint32]chan sftpResponse), extensions: make(map[string]string)}
+
+	// negotiate the protocol version
+	var init sftpBuffer
+	init.uint32(3)
+	if err := client.writePacket(sftpPacketInit, init); err != nil {
+		return nil, err
+	}
+	packetType, data, err := readSftpPacket(output)
+	if err != nil {
+		return nil, err
+	}
+	if packetType != sftpPacketVersion {
+		return nil, errSftpBadPacket
+	}
+
+	reader := sftpReader{data: data}
+	if version := reader.uint32(); reader.err == nil && version != 3 {
+		return nil, fmt.Errorf("sftp: unsupported protocol version %v", version)
+	}
+	for reader.err == nil && len(reader.data) > 0 {
+		name := reader.string()
+		client.extensions[name] = reader.string()
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	// dispatch the responses as they arrive
+	go client.receive(output)
+
+	return client, nil
+}
+
+func (client *sftpClient) receive(output io.Reader) {
+	for {
+		packetType, data, err := readSftpPacket(output)
+		if err == nil && len(data) < 4 {
+			err = errSftpBadPacket
+		}
+		if err != nil {
+			client.fail(err)
+			return
+		}
+
+		id := binary.BigEndian.Uint32(data)
+
+		client.mutex.Lock()
+		response, exists := client.pending[id]
+		delete(client.pending, id)
+		client.mutex.Unlock()
+
+		if exists {
+			response <- sftpResponse{packetType: packetType, data: data[4:]}
+		}
+	}
+}
+
+// fail ends the connection, failing every request which waits for its response
+func (client *sftpClient) fail(err error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.err == nil {
+		client.err = fmt.Errorf("sftp connection lost; %w", err)
+	}
+	for id, response := range client.pending {
+		response <- sftpResponse{err: client.err}
+		delete(client.pending, id)
+	}
+}
+
+// failed returns the error which ended the connection, or nil while it is usable
+func (client *sftpClient) failed() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.err
+}
+
+func (client *sftpClient) close() {
+	client.fail(errors.New("closed"))
+	client.input.Close()
+	client.conn.Close()
+}
+
+// send sends the request, and returns the channel its response is delivered to
+func (client *sftpClient) send(packetType byte, payload sftpBuffer) chan sftpResponse {
+	response := make(chan sftpResponse, 1)
+
+	client.mutex.Lock()
+	if client.err != nil {
+		response <- sftpResponse{err: client.err}
+		client.mutex.Unlock()
+		return response
+	}
+	id := client.nextID
+	client.nextID++
+	client.pending[id] = response
+	client.mutex.Unlock()
+
+	var packet sftpBuffer
+	packet.uint32(id)
+	packet = append(packet, payload...)
+	if err := client.writePacket(packetType, packet); err != nil {
+		client.fail(err)
+	}
+
+	return response
+}
+
+func (client *sftpClient) request(packetType byte, payload sftpBuffer) sftpResponse {
+	return <-client.send(packetType, payload)
+}
+
+func (client *sftpClient) writePacket(packetType byte, payload []byte) error {
+	packet := make(sftpBuffer, 0, 5+len(payload))
+	packet.uint32(uint32(1 + len(payload)))
+	packet = append(packet, packetType)
+	packet = append(packet, payload...)
+
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+
+	_, err := client.input.Write(packet)
+	return err
+}
+
+func readSftpPacket(reader io.Reader) (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length < 1 || length > sftpMaxPacketSize {
+		return 0, nil, errSftpBadPacket
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(reader, packet); err != nil {
+		return 0, nil, err
+	}
+
+	return packet[0], packet[1:], nil
+}
+
+// statusError returns the error of a status response, or nil if the request succeeded
+func (response sftpResponse) statusError(op string, path string) error {
+	if response.err != nil {
+		return &fs.PathError{Op: op, Path: path, Err: response.err}
+	}
+	if response.packetType != sftpPacketStatus {
+		return &fs.PathError{Op: op, Path: path, Err: errSftpBadPacket}
+	}
+
+	reader := sftpReader{data: response.data}
+	code := reader.uint32()
+	message := reader.string()
+	if reader.err != nil {
+		return &fs.PathError{Op: op, Path: path, Err: reader.err}
+	}
+
+	var err error
+	switch code {
+	case sftpStatusOK:
+		return nil
+	case sftpStatusEOF:
+		return io.EOF
+	case sftpStatusNoSuchFile:
+		err = fs.ErrNotExist
+	case sftpStatusPermissionDenied:
+		err = fs.ErrPermission
+	default:
+		if len(message) < 1 {
+			message = fmt.Sprintf("status %v", code)
+		}
+		err = fmt.Errorf("sftp: %s", message)
+	}
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
+
+func (client *sftpClient) stat(packetType byte, op string, remotePath string) (os.FileInfo, error) {
+	var payload sftpBuffer
+	payload.string(remotePath)
+
+	response := client.request(packetType, payload)
+	if response.err != nil || response.packetType != sftpPacketAttrs {
+		return nil, response.statusError(op, remotePath)
+	}
+
+	reader := sftpReader{data: response.data}
+	attrs := reader.attrs()
+	if reader.err != nil {
+		return nil, &fs.PathError{Op: op, Path: remotePath, Err: reader.err}
+	}
+	return attrs.fileInfo(path.Base(remotePath)), nil
+}
+
+func (client *sftpClient) setstat(op string, remotePath string, attrs sftpAttrs) error {
+	var payload sftpBuffer
+	payload.string(remotePath)
+	payload.attrs(attrs)
+
+	return client.request(sftpPacketSetstat, payload).statusError(op, remotePath)
+}
+
+func (client *sftpClient) open(remotePath string, flags uint32) (*sftpFile, error) {
+	var payload sftpBuffer
+	payload.string(remotePath)
+	payload.uint32(flags)
+	payload.attrs(sftpAttrs{})
+
+	handle, err := client.getHandle(sftpPacketOpen, "open", remotePath, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{client: client, path: remotePath, handle: handle}, nil
+}
+
+func (client *sftpClient) getHandle(packetType byte, op string, remotePath string, payload sftpBuffer) (string, error) {
+	response := client.request(packetType, payload)
+	if response.err != nil || response.packetType != sftpPacketHandle {
+		return "", response.statusError(op, remotePath)
+	}
+
+	reader := sftpReader{data: response.data}
+	handle := reader.string()
+	if reader.err != nil {
+		return "", &fs.PathError{Op: op, Path: remotePath, Err: reader.err}
+	}
+	return handle, nil
+}
+
+func (client *sftpClient) closeHandle(op string, remotePath string, handle string) error {
+	var payload sftpBuffer
+	payload.string(handle)
+
+	return client.request(sftpPacketClose, payload).statusError(op, remotePath)
+}
+
+func (client *sftpClient) readDir(remotePath string) ([]fs.DirEntry, error) {
+	var payload sftpBuffer
+	payload.string(remotePath)
+
+	handle, err := client.getHandle(sftpPacketOpendir, "readdir", remotePath, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer client.closeHandle("readdir", remotePath, handle)
+
+	var entries []fs.DirEntry
+	for {
+		var payload sftpBuffer
+		payload.string(handle)
+
+		// every response holds some of the entries, until the end of the listing is reported
+		response := client.request(sftpPacketReaddir, payload)
+		if response.err != nil || response.packetType != sftpPacketName {
+			if err := response.statusError("readdir", remotePath); err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+
+		reader := sftpReader{data: response.data}
+		count := reader.uint32()
+		for i := uint32(0); i < count && reader.err == nil; i++ {
+			name := reader.string()
+			// long name, which is meant for display only
+			reader.string()
+			attrs := reader.attrs()
+
+			if name != "." && name != ".." {
+				entries = append(entries, fs.FileInfoToDirEntry(attrs.fileInfo(name)))
+			}
+		}
+		if reader.err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: remotePath, Err: reader.err}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (client *sftpClient) pathRequest(packetType byte, op string, remotePath string) error {
+	var payload sftpBuffer
+	payload.string(remotePath)
+
+	return client.request(packetType, payload).statusError(op, remotePath)
+}
+
+func (client *sftpClient) mkdir(remotePath string, perm os.FileMode) error {
+	var payload sftpBuffer
+	payload.string(remotePath)
+	payload.attrs(sftpAttrs{flags: sftpAttrPermissions, permissions: uint32(perm.Perm())})
+
+	return client.request(sftpPacketMkdir, payload).statusError("mkdir", remotePath)
+}
+
+func (client *sftpClient) rename(oldPath string, newPath string) error {
+	var payload sftpBuffer
+
+	// a plain rename fails if the new path exists, unlike a posix rename which replaces it
+	if _, exists := client.extensions[sftpExtensionPosixRename]; exists {
+		payload.string(sftpExtensionPosixRename)
+		payload.string(oldPath)
+		payload.string(newPath)
+
+		return client.request(sftpPacketExtended, payload).statusError("rename", oldPath)
+	}
+
+	payload.string(oldPath)
+	payload.string(newPath)
+
+	return client.request(sftpPacketRename, payload).statusError("rename", oldPath)
+}
+
+// sftpFile is a remote file, which is either read or written sequentially
+type sftpFile struct {
+	client *sftpClient
+	path   string
+	handle string
+	offset int64
+	// responses of the writes in flight, oldest first
+	writes []chan sftpResponse
+	closed bool
+}
+
+func (file *sftpFile) Read(p []byte) (int, error) {
+	if len(p) > sftpChunkSize {
+		p = p[:sftpChunkSize]
+	}
+
+	var payload sftpBuffer
+	payload.string(file.handle)
+	payload.uint64(uint64(file.offset))
+	payload.uint32(uint32(len(p)))
+
+	response := file.client.request(sftpPacketRead, payload)
+	if response.err != nil || response.packetType != sftpPacketData {
+		return 0, response.statusError("read", file.path)
+	}
+
+	reader := sftpReader{data: response.data}
+	data := reader.bytes()
+	if reader.err != nil || len(data) > len(p) {
+		return 0, &fs.PathError{Op: "read", Path: file.path, Err: errSftpBadPacket}
+	}
+
+	n := copy(p, data)
+	file.offset += int64(n)
+	return n, nil
+}
+
+func (file *sftpFile) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > sftpChunkSize {
+			chunk = chunk[:sftpChunkSize]
+		}
+
+		var payload sftpBuffer
+		payload.string(file.handle)
+		payload.uint64(uint64(file.offset))
+		payload.bytes(chunk)
+
+		file.writes = append(file.writes, file.client.send(sftpPacketWrite, payload))
+		file.offset += int64(len(chunk))
+		written += len(chunk)
+		p = p[len(chunk):]
+
+		// wait for the oldest write once too many are in flight
+		if len(file.writes) >= sftpMaxWritesInFlight {
+			response := <-file.writes[0]
+			file.writes = file.writes[1:]
+
+			if err := response.statusError("write", file.path); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// flush waits for the writes in flight, and returns the first error of them
+func (file *sftpFile) flush() error {
+	var err error
+	for _, write := range file.writes {
+		if writeErr := (<-write).statusError("write", file.path); err == nil {
+			err = writeErr
+		}
+	}
+	file.writes = nil
+
+	return err
+}
+
+func (file *sftpFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += file.offset
+	default:
+		return file.offset, &fs.PathError{Op: "seek", Path: file.path, Err: errors.ErrUnsupported}
+	}
+	if offset < 0 {
+		return file.offset, &fs.PathError{Op: "seek", Path: file.path, Err: fs.ErrInvalid}
+	}
+
+	file.offset = offset
+	return offset, nil
+}
+
+func (file *sftpFile) Sync() error {
+	if err := file.flush(); err != nil {
+		return err
+	}
+
+	// servers without the extension can not be asked to flush the contents
+	if _, exists := file.client.extensions[sftpExtensionFsync]; !exists {
+		return nil
+	}
+
+	var payload sftpBuffer
+	payload.string(sftpExtensionFsync)
+	payload.string(file.handle)
+
+	return file.client.request(sftpPacketExtended, payload).statusError("sync", file.path)
+}
+
+func (file *sftpFile) Close() error {
+	if file.closed {
+		return &fs.PathError{Op: "close", Path: file.path, Err: fs.ErrClosed}
+	}
+	file.closed = true
+
+	err := file.flush()
+	if closeErr := file.client.closeHandle("close", file.path, file.handle); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sftpAttrs holds the attributes of a file, as sent over the protocol
+type sftpAttrs struct {
+	flags       uint32
+	size        uint64
+	permissions uint32
+	atime       uint32
+	mtime       uint32
+}
+
+func (attrs sftpAttrs) fileInfo(name string) os.FileInfo {
+	return remoteFileInfo{
+		name:    name,
+		size:    int64(attrs.size),
+		mode:    getSftpFileMode(attrs.permissions),
+		modTime: time.Unix(int64(attrs.mtime), 0),
+	}
+}
+
+// getSftpFileMode converts the permissions of a file (which include its type, as in a unix stat) into its mode
+func getSftpFileMode(permissions uint32) os.FileMode {
+	mode := os.FileMode(permissions & 0777)
+
+	switch permissions & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0010000:
+		mode |= os.ModeNamedPipe
+	case 0140000:
+		mode |= os.ModeSocket
+	case 0020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		mode |= os.ModeDevice
+	}
+
+	if permissions&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if permissions&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if permissions&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+// sftpBuffer builds the payload of a packet
+type sftpBuffer []byte
+
+func (buffer *sftpBuffer) uint32(value uint32) {
+	*buffer = binary.BigEndian.AppendUint32(*buffer, value)
+}
+
+func (buffer *sftpBuffer) uint64(value uint64) {
+	*buffer = binary.BigEndian.AppendUint64(*buffer, value)
+}
+
+func (buffer *sftpBuffer) string(value string) {
+	buffer.uint32(uint32(len(value)))
+	*buffer = append(*buffer, value...)
+}
+
+func (buffer *sftpBuffer) bytes(value []byte) {
+	buffer.uint32(uint32(len(value)))
+	*buffer = append(*buffer, value...)
+}
+
+func (buffer *sftpBuffer) attrs(attrs sftpAttrs) {
+	buffer.uint32(attrs.flags)
+	if attrs.flags&sftpAttrSize != 0 {
+		buffer.uint64(attrs.size)
+	}
+	if attrs.flags&sftpAttrPermissions != 0 {
+		buffer.uint32(attrs.permissions)
+	}
+	if attrs.flags&sftpAttrTimes != 0 {
+		buffer.uint32(attrs.atime)
+		buffer.uint32(attrs.mtime)
+	}
+}
+
+// sftpReader parses the payload of a packet, once it runs out of data every further value is zero and the error is set
+type sftpReader struct {
+	data []byte
+	err  error
+}
+
+func (reader *sftpReader) take(count int) []byte {
+	if reader.err != nil || len(reader.data) < count {
+		reader.err = errSftpBadPacket
+		return nil
+	}
+
+	value := reader.data[:count]
+	reader.data = reader.data[count:]
+	return value
+}
+
+func (reader *sftpReader) uint32() uint32 {
+	if value := reader.take(4); value != nil {
+		return binary.BigEndian.Uint32(value)
+	}
+	return 0
+}
+
+func (reader *sftpReader) uint64() uint64 {
+	if value := reader.take(8); value != nil {
+		return binary.BigEndian.Uint64(value)
+	}
+	return 0
+}
+
+func (reader *sftpReader) bytes() []byte {
+	length := reader.uint32()
+	if length > uint32(len(reader.data)) {
+		reader.err = errSftpBadPacket
+		return nil
+	}
+	return reader.take(int(length))
+}
+
+func (reader *sftpReader) string() string {
+	return string(reader.bytes())
+}
+
+func (reader *sftpReader) attrs() sftpAttrs {
+	attrs := sftpAttrs{flags: reader.uint32()}
+	if attrs.flags&sftpAttrSize != 0 {
+		attrs.size = reader.uint64()
+	}
+	if attrs.flags&sftpAttrUIDGID != 0 {
+		reader.uint32()
+		reader.uint32()
+	}
+	if attrs.flags&sftpAttrPermissions != 0 {
+		attrs.permissions = reader.uint32()
+	}
+	if attrs.flags&sftpAttrTimes != 0 {
+		attrs.atime = reader.uint32()
+		attrs.mtime = reader.uint32()
+	}
+	if attrs.flags&sftpAttrExtended != 0 {
+		count := reader.uint32()
+		for i := uint32(0); i < count && reader.err == nil; i++ {
+			reader.string()
+			reader.string()
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,195 @@
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// layout of the names of snapshot directories, by the start time of their iteration
+	snapshotLayout = "2006-01-02_1504"
+	// layout of the name of a snapshot whose minute is taken by another snapshot
+	snapshotLayoutSeconds = "2006-01-02_150405"
+	// directory of the snapshot being written, which becomes a snapshot once complete (or is continued by the next iteration)
+	snapshotPartialName = ".snapshot.partial"
+)
+
+// prepareSnapshots sets the destination directory of every destination to its partial snapshot, which files unchanged since the latest
+// snapshot of the destination are linked from
+func prepareSnapshots(destConfigsList []Config) []Config {
+	for i, destConfigs := range destConfigsList {
+		root := destConfigs.General.DestinationDirectory
+
+		snapshots, err := listSnapshots(root)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			destConfigs.General.logger.Warn("Snapshots unreadable, linking nothing", "path", root, "error", err)
+		}
+
+		destConfigsList[i].General.snapshotRoot = root
+		destConfigsList[i].General.snapshotLatest = ""
+		if len(snapshots) > 0 {
+			destConfigsList[i].General.snapshotLatest = filepath.Join(root, snapshots[len(snapshots)-1])
+		}
+		destConfigsList[i].General.DestinationDirectory = filepath.Join(root, snapshotPartialName)
+	}
+
+	return destConfigsList
+}
+
+// listSnapshots returns the names of the snapshots in the directory, oldest first
+func listSnapshots(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := time.ParseInLocation(snapshotLayout, entry.Name(), time.Local); err != nil {
+			if _, err := time.ParseInLocation(snapshotLayoutSeconds, entry.Name(), time.Local); err != nil {
+				continue
+			}
+		}
+		snapshots = append(snapshots, entry.Name())
+	}
+
+	// the layouts sort by time, even mixed
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// linkFromSnapshot creates the destination file as a hard link of the same file in the latest snapshot if it is unchanged since
+// (including its permissions, which linked files share), and reports whether it did (otherwise the file must be copied)
+func linkFromSnapshot(configs Config, stats *iterationStats, srcPath string, srcFile os.FileInfo, path string, overwrite bool) (bool, error) {
+	if len(configs.General.snapshotLatest) < 1 || !srcFile.Mode().IsRegular() {
+		return false, nil
+	}
+
+	// compare against the file of the latest snapshot, as if it were the destination file
+	previousConfigs := configs
+	previousConfigs.General.DestinationDirectory = configs.General.snapshotLatest
+	previousPath := filepath.Join(configs.General.snapshotLatest, getRelativePath(configs.General.DestinationDirectory, path))
+
+	previous, err := os.Lstat(previousPath)
+	if err != nil || !previous.Mode().IsRegular() || previous.Mode().Perm() != srcFile.Mode().Perm() {
+		return false, nil
+	}
+	if reason, err := getChangeReason(previousConfigs, srcPath, srcFile, previousPath, previous); err != nil || len(reason) > 0 {
+		return false, err
+	}
+
+	// in dry run mode, only count the file would be linked
+	if configs.General.DryRun {
+		stats.addLinked()
+		return true, nil
+	}
+
+	// a file of a continued snapshot is replaced by the link
+	if overwrite {
+		if err := os.Remove(path); err != nil {
+			return false, err
+		}
+	}
+	if err := os.Link(previousPath, path); err != nil {
+		// e.g. the maximal count of links to the file was reached
+		if stats.warnOnce(&stats.snapshotWarned) {
+			configs.General.logger.Warn("Hard links can not be created, copying instead", "path", path, "error", err)
+		}
+		return false, nil
+	}
+
+	// unchanged files are the bulk of every snapshot, so they are not logged by default
+	stats.addLinked()
+	configs.General.logger.Debug("Link", "path", path, "target", previousPath)
+	return true, nil
+}
+
+// completeSnapshot turns the partial snapshot of the destination into a snapshot named by the start time of the iteration, and removes the
+// oldest snapshots beyond the retention. a partial snapshot whose operations failed is kept, and continued by the next iteration
+func completeSnapshot(configs Config, stats *iterationStats, start time.Time) {
+	if configs.General.DryRun {
+		return
+	}
+
+	partialPath := configs.General.DestinationDirectory
+	if stats.filesFailed > 0 {
+		configs.General.logger.Warn("Snapshot incomplete, continuing it in the next iteration", "path", partialPath, "failed", stats.filesFailed)
+		return
+	}
+
+	// snapshots are ordered by their names, so a snapshot taken in the minute of the latest one is named by the second
+	name := start.Format(snapshotLayout)
+	if latest := filepath.Base(configs.General.snapshotLatest); len(configs.General.snapshotLatest) > 0 && name <= latest {
+		name = start.Format(snapshotLayoutSeconds)
+
+		// a snapshot was taken in this second already, so the partial snapshot is completed by the next iteration
+		if name <= latest {
+			configs.General.logger.Warn("Snapshot taken in this second already, completing it in the next iteration", "path", partialPath, "latest", configs.General.snapshotLatest)
+			return
+		}
+	}
+	path := filepath.Join(configs.General.snapshotRoot, name)
+
+	// an empty source leaves nothing behind, yet its snapshot is empty rather than missing
+	if err := mkdirAll(partialPath, os.ModePerm); err != nil {
+		logOperationError(configs.General.logger, "Snapshot", path, err)
+		return
+	}
+	if err := os.Rename(partialPath, path); err != nil {
+		logOperationError(configs.General.logger, "Snapshot", path, err)
+		return
+	}
+	configs.General.logger.Info("Snapshot", "path", path)
+
+	pruneSnapshots(configs)
+}
+
+// pruneSnapshots removes the oldest snapshots beyond the retention
+func pruneSnapshots(configs Config) {
+	// nothing to do unless limited
+	if configs.General.SnapshotRetention < 1 {
+		return
+	}
+
+	snapshots, err := listSnapshots(configs.General.snapshotRoot)
+	if err != nil {
+		logOperationError(configs.General.logger, "Remove", configs.General.snapshotRoot, err)
+		return
+	}
+
+	for len(snapshots) > configs.General.SnapshotRetention {
+		path := filepath.Join(configs.General.snapshotRoot, snapshots[0])
+		snapshots = snapshots[1:]
+
+		if err := os.RemoveAll(path); err != nil {
+			logOperationError(configs.General.logger, "Remove", path, err)
+		} else {
+			configs.General.logger.Info("Remove", "path", path)
+		}
+	}
+}
+
+// countSnapshotFiles counts the entries of the snapshot, up to the limit
+func countSnapshotFiles(path string, limit int64) int64 {
+	var count int64
+	filepath.WalkDir(path, func(walkPath string, entry fs.DirEntry, err error) error {
+		if err != nil || walkPath == path {
+			return nil
+		}
+
+		count++
+		if count >= limit {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+
+	return count
+}
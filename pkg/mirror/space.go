@@ -0,0 +1,137 @@
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	insufficientSpaceNone    = "none"
+	insufficientSpaceSkip    = "skip"
+	insufficientSpacePartial = "partial"
+)
+
+// plannedCopy is a source file which the iteration copies, and its size
+type plannedCopy struct {
+	path string
+	size int64
+}
+
+// checkFreeSpace compares the bytes which the planned copies of the destination write (new files and changed files, as far as can be told
+// from their info) against the free space of its volume. copies which do not fit are left for a later iteration: all of them, or only those
+// which do not fit once the smallest files are copied first. moved and linked files take no space, and are never left out
+func checkFreeSpace(configs Config, stats *iterationStats, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo, moves map[string]movedFile,
+	links map[string]string, wg *sync.WaitGroup) {
+	if configs.General.OnInsufficientSpace == insufficientSpaceNone {
+		return
+	}
+
+	var copies []plannedCopy
+	var required int64
+	for srcPath, srcFile := range srcFiles {
+		if _, moved := moves[srcPath]; moved || !srcFile.Mode().IsRegular() {
+			continue
+		}
+		if _, linked := links[srcPath]; linked {
+			continue
+		}
+		if destFile, exists := destFiles[srcPath]; exists && destFile.Mode().IsRegular() && destFile.Size() == srcFile.Size() &&
+			isSameModTime(configs, srcFile.ModTime(), destFile.ModTime()) {
+			continue
+		}
+
+		copies = append(copies, plannedCopy{path: srcPath, size: srcFile.Size()})
+		required += srcFile.Size()
+	}
+
+	free, err := getFreeSpace(getExistingDir(configs.General.DestinationDirectory))
+	if err != nil {
+		configs.General.logger.Warn("Free space can not be checked", "destination", configs.General.DestinationDirectory, "error", err)
+		return
+	}
+	stats.spaceChecked = true
+	stats.spaceRequired = required
+	stats.spaceFree = free
+
+	if required <= free {
+		return
+	}
+
+	// the smallest files are copied first, as many as fit (none are copied when skipping)
+	sort.Slice(copies, func(i, j int) bool {
+		if copies[i].size != copies[j].size {
+			return copies[i].size < copies[j].size
+		}
+		return copies[i].path < copies[j].path
+	})
+	var fitting int64
+	left := copies
+	if configs.General.OnInsufficientSpace == insufficientSpacePartial {
+		for len(left) > 0 && fitting+left[0].size <= free {
+			fitting += left[0].size
+			left = left[1:]
+		}
+	}
+
+	configs.General.logger.Warn("Skipping copies, insufficient free space in the destination", "destination", configs.General.DestinationDirectory, "requiredBytes", required,
+		"freeBytes", free, "onInsufficientSpace", configs.General.OnInsufficientSpace, "skipped", len(left), "skippedBytes", required-fitting)
+	notifyInsufficientSpace(configs, required, free, len(left))
+
+	// the skipped files are removed from both containers, so their destination files are not deleted either
+	for _, skipped := range left {
+		delete(srcFiles, skipped.path)
+		wg.Done()
+		if _, exists := destFiles[skipped.path]; exists {
+			delete(destFiles, skipped.path)
+			wg.Done()
+		}
+
+		stats.addDeferred(skipped.path)
+		configs.General.logger.Debug("Skip", "path", filepath.Join(configs.General.SourceDirectory, skipped.path), "reason", "insufficient space")
+		emitSkipEvent(configs, skipped.path, skipped.size, "insufficient space")
+	}
+}
+
+// getSpaceDetails returns the log attributes of the free space check of an iteration, if the free space was checked
+func getSpaceDetails(stats *iterationStats) []any {
+	if !stats.spaceChecked {
+		return nil
+	}
+
+	return []any{"requiredBytes", stats.spaceRequired, "freeBytes", stats.spaceFree}
+}
+
+// getExistingDir returns the directory, or its nearest parent which exists (e.g. for a destination directory which was not created yet)
+func getExistingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// isOutOfSpace reports whether a copy of the iteration failed since the destination ran out of space, so the copies which follow are left
+// for a later iteration rather than failing one by one
+func isOutOfSpace(stats *iterationStats) bool {
+	return atomic.LoadInt32(&stats.outOfSpace) != 0
+}
+
+// recordOutOfSpace marks the destination as out of space for the rest of the iteration, if the error of a copy tells so
+func recordOutOfSpace(configs Config, stats *iterationStats, path string, err error) {
+	if !isNoSpaceError(err) || !stats.warnOnce(&stats.outOfSpace) {
+		return
+	}
+
+	configs.General.logger.Warn("Skipping remaining copies, destination is out of space", "destination", configs.General.DestinationDirectory, "path", path, "error", err)
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package mirror
+
+import (
+	"errors"
+	"syscall"
+)
+
+// getFreeSpace returns the bytes available on the volume of the directory, which is read on linux, macOS and windows only
+func getFreeSpace(dir string) (int64, error) {
+	return 0, errors.New("free space is not supported on this platform")
+}
+
+// isNoSpaceError reports whether the error tells the volume is full
+func isNoSpaceError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
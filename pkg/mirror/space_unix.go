@@ -0,0 +1,26 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package mirror
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// getFreeSpace returns the bytes available to this process on the volume of the directory
+func getFreeSpace(dir string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// isNoSpaceError reports whether the error tells the volume is full
+func isNoSpaceError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,30 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// getFreeSpace returns the bytes available to this process (as limited by its quota) on the volume of the directory
+func getFreeSpace(dir string) (int64, error) {
+	dirPtr, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(dirPtr, &available, &total, &free); err != nil {
+		return 0, err
+	}
+
+	return int64(available), nil
+}
+
+// isNoSpaceError reports whether the error tells the volume is full
+func isNoSpaceError(err error) bool {
+	return errors.Is(err, windows.ERROR_DISK_FULL) || errors.Is(err, windows.ERROR_HANDLE_DISK_FULL)
+}
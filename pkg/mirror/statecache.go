@@ -0,0 +1,239 @@
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+// version of the format of the state file, a state file of another version is discarded
+const stateFileVersion = 1
+
+// stateFileContents is the JSON format of the state file
+type stateFileContents struct {
+	Version       int    `json:"version"`
+	CompareMode   string `json:"compareMode"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+	// hashes of files by their root directory (the source or a destination directory), and by their path relative to it
+	Roots map[string]map[string]stateEntry `json:"roots"`
+	// paths in sync after the last bidirectional iteration
+	Synced *syncedState `json:"synced,omitempty"`
+}
+
+// stateEntry is the hash of a file, which is valid as long as its size and modification time are unchanged
+type stateEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	Hash    []byte `json:"hash"`
+}
+
+// hashCache holds the hashes of files between iterations (and runs), so unchanged files are not read again to compare them
+type hashCache struct {
+	mutex         sync.Mutex
+	path          string
+	compareMode   string
+	hashAlgorithm string
+	roots         map[string]map[string]stateEntry
+	// paths of the entries used since the last save, by their root directory (entries which were not used by a full scan belong to removed files)
+	used map[string]map[string]bool
+	// paths in sync after the last bidirectional iteration, if any
+	synced *syncedState
+	// whether entries changed since the last save
+	dirty bool
+}
+
+// loadHashCache reads the state file of the job, or returns nil if the state file is not enabled.
+// a missing, corrupt or outdated state file results in an empty cache, so every file is hashed again
+func loadHashCache(configs Config) *hashCache {
+	if len(configs.General.StateFile) < 1 {
+		return nil
+	}
+
+	cache := &hashCache{
+		path:          configs.General.StateFile,
+		compareMode:   configs.General.CompareMode,
+		hashAlgorithm: configs.General.HashAlgorithm,
+		roots:         make(map[string]map[string]stateEntry),
+		used:          make(map[string]map[string]bool),
+	}
+
+	data, err := os.ReadFile(cache.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			configs.General.logger.Warn("State file can not be read, hashing all files", "path", cache.path, "error", err)
+		}
+		return cache
+	}
+
+	var contents stateFileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		configs.General.logger.Warn("State file is corrupt, hashing all files", "path", cache.path, "error", err)
+		return cache
+	}
+
+	if contents.Version != stateFileVersion {
+		configs.General.logger.Info("State file is outdated, hashing all files", "path", cache.path)
+		return cache
+	}
+
+	// the paths in sync do not depend on how files are compared, so they are kept when the cached hashes are not
+	cache.synced = contents.Synced
+
+	// the cached hashes are only valid for the same compare mode and hash algorithm (a state file written before the hash algorithm was
+	// recorded holds SHA-256 hashes)
+	if len(contents.HashAlgorithm) < 1 {
+		contents.HashAlgorithm = hashAlgorithmSHA256
+	}
+	if contents.CompareMode != cache.compareMode || contents.HashAlgorithm != cache.hashAlgorithm {
+		configs.General.logger.Info("State file hashes are outdated, hashing all files", "path", cache.path)
+		return cache
+	}
+
+	if contents.Roots != nil {
+		cache.roots = contents.Roots
+	}
+	return cache
+}
+
+// getHash returns the hash of the file by the hash algorithm, which is read from the cache if the file did not change since it was hashed
+// (the file is read through the file system of its root directory)
+func (cache *hashCache) getHash(algorithm string, fsys readableFS, rootDir string, path string, file os.FileInfo) ([]byte, error) {
+	if cache == nil {
+		return hashFile(fsys, path, algorithm)
+	}
+
+	relativePath := getRelativePath(rootDir, path)
+
+	cache.mutex.Lock()
+	entry, exists := cache.roots[rootDir][relativePath]
+	cache.markUsed(rootDir, relativePath)
+	cache.mutex.Unlock()
+
+	if exists && entry.Size == file.Size() && entry.ModTime == file.ModTime().UnixNano() {
+		return entry.Hash, nil
+	}
+
+	// get the current info before reading the file, so a change while it is hashed invalidates the entry
+	current, err := fsys.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashFile(fsys, path, cache.hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.roots[rootDir] == nil {
+		cache.roots[rootDir] = make(map[string]stateEntry)
+	}
+	cache.roots[rootDir][relativePath] = stateEntry{Size: current.Size(), ModTime: current.ModTime().UnixNano(), Hash: hash}
+	cache.dirty = true
+
+	return hash, nil
+}
+
+func (cache *hashCache) markUsed(rootDir string, relativePath string) {
+	if cache.used[rootDir] == nil {
+		cache.used[rootDir] = make(map[string]bool)
+	}
+	cache.used[rootDir][relativePath] = true
+}
+
+// save writes the cache into the state file, if it changed. once a full scan ended, entries it did not use are dropped
+func (cache *hashCache) save(logger *slog.Logger, fullScan bool) {
+	if cache == nil {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if fullScan {
+		for rootDir, entries := range cache.roots {
+			for relativePath := range entries {
+				if !cache.used[rootDir][relativePath] {
+					delete(entries, relativePath)
+					cache.dirty = true
+				}
+			}
+			if len(entries) < 1 {
+				delete(cache.roots, rootDir)
+			}
+		}
+		cache.used = make(map[string]map[string]bool)
+	}
+
+	if !cache.dirty {
+		return
+	}
+
+	data, err := json.Marshal(stateFileContents{Version: stateFileVersion, CompareMode: cache.compareMode, HashAlgorithm: cache.hashAlgorithm, Roots: cache.roots, Synced: cache.synced})
+	if err == nil {
+		err = writeFileAtomic(cache.path, data)
+	}
+	if err != nil {
+		logOperationError(logger, "Write", cache.path, err)
+		return
+	}
+
+	cache.dirty = false
+}
+
+// getSynced returns the paths which were in sync between the directories after the last bidirectional iteration, or nil if they were never
+// synchronized (e.g. the state file belongs to other directories)
+func (cache *hashCache) getSynced(srcDir string, destDir string) map[string]syncedEntry {
+	if cache == nil {
+		return nil
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.synced == nil || cache.synced.SourceDirectory != srcDir || cache.synced.DestinationDirectory != destDir {
+		return nil
+	}
+	return cache.synced.Paths
+}
+
+// setSynced keeps the paths which are in sync between the directories, which are written into the state file by the next save
+func (cache *hashCache) setSynced(srcDir string, destDir string, paths map[string]syncedEntry) {
+	if cache == nil {
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	synced := &syncedState{SourceDirectory: srcDir, DestinationDirectory: destDir, Paths: paths}
+	if !reflect.DeepEqual(cache.synced, synced) {
+		cache.synced = synced
+		cache.dirty = true
+	}
+}
+
+// writeFileAtomic writes the data into a temporary file which then replaces the file, so a crash never leaves a partial file
+func writeFileAtomic(path string, data []byte) error {
+	if err := mkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tempPath := getTempPath(path)
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package mirror
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// iterationStats holds counters of a single mirror iteration, which are updated concurrently by the operations
+type iterationStats struct {
+	filesScannedSource int64
+	filesScannedDest   int64
+	filesCopied        int64
+	bytesCopied        int64
+	bytesVerified      int64
+	filesLinked        int64
+	filesCloned        int64
+	filesDeleted       int64
+	filesMoved         int64
+	filesUnchanged     int64
+	filesFailed        int64
+	filesDeferred      int64
+	filesDrifted       int64
+	deletionsSkipped   int64
+
+	// destination entries which the scan left out of the planned operations since they match the source (counted for the deletion safety threshold)
+	destMatched int64
+
+	// wall-clock duration of scanning the directories, and of running the operations
+	scanDuration     time.Duration
+	transferDuration time.Duration
+	// time spent listing the source directory and the destination directory (the source is listed once for all destinations), and planning
+	// the operations once scanned
+	srcScanDuration  time.Duration
+	destScanDuration time.Duration
+	planDuration     time.Duration
+
+	// paths of deleted and failed files (up to the webhook paths limit), for notifications
+	pathsMutex   sync.Mutex
+	deletedPaths []string
+	failedPaths  []string
+	// relative paths of files left for a later iteration, since they were not settled yet
+	deferredPaths []string
+	// error of the last failed operation
+	lastError error
+	// size and path of the largest file copied
+	largestFileBytes int64
+	largestFilePath  string
+	// the iteration was skipped (e.g. since the source was unavailable), so nothing was done
+	skipped bool
+	// bytes the planned copies write and the free space of the destination volume, if it was checked
+	spaceChecked  bool
+	spaceRequired int64
+	spaceFree     int64
+	// set once a copy ran out of space, the copies which follow are left for a later iteration
+	outOfSpace int32
+
+	// flags of warnings which should be logged once per iteration
+	ownershipWarned int32
+	hardLinkWarned  int32
+	cloneWarned     int32
+	snapshotWarned  int32
+}
+
+func (stats *iterationStats) addCopied(path string, bytes int64) {
+	atomic.AddInt64(&stats.filesCopied, 1)
+	atomic.AddInt64(&stats.bytesCopied, bytes)
+
+	stats.pathsMutex.Lock()
+	defer stats.pathsMutex.Unlock()
+
+	if bytes > stats.largestFileBytes {
+		stats.largestFileBytes = bytes
+		stats.largestFilePath = path
+	}
+}
+
+func (stats *iterationStats) addVerified(bytes int64) {
+	atomic.AddInt64(&stats.bytesVerified, bytes)
+}
+
+func (stats *iterationStats) addLinked() {
+	atomic.AddInt64(&stats.filesLinked, 1)
+}
+
+func (stats *iterationStats) addCloned() {
+	atomic.AddInt64(&stats.filesCloned, 1)
+}
+
+func (stats *iterationStats) addDeleted(path string) {
+	atomic.AddInt64(&stats.filesDeleted, 1)
+
+	stats.addPath(&stats.deletedPaths, path)
+}
+
+func (stats *iterationStats) addMoved() {
+	atomic.AddInt64(&stats.filesMoved, 1)
+}
+
+func (stats *iterationStats) addUnchanged() {
+	atomic.AddInt64(&stats.filesUnchanged, 1)
+}
+
+func (stats *iterationStats) addFailed(path string, err error) {
+	atomic.AddInt64(&stats.filesFailed, 1)
+
+	stats.addPath(&stats.failedPaths, path)
+
+	stats.pathsMutex.Lock()
+	stats.lastError = fmt.Errorf("%s: %w", path, err)
+	stats.pathsMutex.Unlock()
+}
+
+func (stats *iterationStats) addDeferred(relativePath string) {
+	atomic.AddInt64(&stats.filesDeferred, 1)
+
+	stats.pathsMutex.Lock()
+	defer stats.pathsMutex.Unlock()
+
+	stats.deferredPaths = append(stats.deferredPaths, relativePath)
+}
+
+func (stats *iterationStats) addDrifted() {
+	atomic.AddInt64(&stats.filesDrifted, 1)
+}
+
+// addPath records the path in the list, up to the webhook paths limit
+func (stats *iterationStats) addPath(paths *[]string, path string) {
+	stats.pathsMutex.Lock()
+	defer stats.pathsMutex.Unlock()
+
+	if len(*paths) < webhookMaxPaths {
+		*paths = append(*paths, path)
+	}
+}
+
+// add adds the counters of another iteration, once its operations ended
+func (stats *iterationStats) add(other *iterationStats) {
+	stats.filesCopied += other.filesCopied
+	stats.bytesCopied += other.bytesCopied
+	stats.bytesVerified += other.bytesVerified
+	stats.filesLinked += other.filesLinked
+	stats.filesCloned += other.filesCloned
+	stats.filesDeleted += other.filesDeleted
+	stats.filesMoved += other.filesMoved
+	stats.filesUnchanged += other.filesUnchanged
+	stats.filesDeferred += other.filesDeferred
+	stats.filesDrifted += other.filesDrifted
+	stats.deferredPaths = append(stats.deferredPaths, other.deferredPaths...)
+	stats.filesScannedDest += other.filesScannedDest
+	stats.filesFailed += other.filesFailed
+	stats.destScanDuration += other.destScanDuration
+	stats.planDuration += other.planDuration
+	if other.spaceChecked {
+		stats.spaceChecked = true
+		stats.spaceRequired += other.spaceRequired
+		stats.spaceFree += other.spaceFree
+	}
+	if other.lastError != nil {
+		stats.lastError = other.lastError
+	}
+	if other.largestFileBytes > stats.largestFileBytes {
+		stats.largestFileBytes = other.largestFileBytes
+		stats.largestFilePath = other.largestFilePath
+	}
+}
+
+// getBytesPerSecond returns the throughput of the copies of an ended iteration, over the duration of running its operations
+func (stats *iterationStats) getBytesPerSecond() int64 {
+	if stats.transferDuration <= 0 {
+		return 0
+	}
+
+	return int64(float64(stats.bytesCopied) / stats.transferDuration.Seconds())
+}
+
+// hasChanges reports whether anything happened in the iteration
+func (stats *iterationStats) hasChanges() bool {
+	return stats.filesCopied > 0 || stats.filesLinked > 0 || stats.filesCloned > 0 || stats.filesMoved > 0 || stats.filesDeleted > 0 || stats.filesFailed > 0
+}
+
+// getCounts returns the counters of an ended iteration by name, as reported outside of the logs
+func (stats *iterationStats) getCounts() map[string]int64 {
+	return map[string]int64{
+		"scannedSource":      stats.filesScannedSource,
+		"scannedDestination": stats.filesScannedDest,
+		"copied":             stats.filesCopied,
+		"copiedBytes":        stats.bytesCopied,
+		"linked":             stats.filesLinked,
+		"cloned":             stats.filesCloned,
+		"moved":              stats.filesMoved,
+		"deleted":            stats.filesDeleted,
+		"deletionsSkipped":   stats.deletionsSkipped,
+		"unchanged":          stats.filesUnchanged,
+		"failed":             stats.filesFailed,
+		"deferred":           stats.filesDeferred,
+		"drifted":            stats.filesDrifted,
+	}
+}
+
+// warnOnce reports whether the warning flag was set by this call, so the warning is logged only once per iteration
+func (stats *iterationStats) warnOnce(flag *int32) bool {
+	return atomic.CompareAndSwapInt32(flag, 0, 1)
+}
@@ -0,0 +1,392 @@
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statusPhaseIdle     = "idle"
+	statusPhaseScanning = "scanning"
+	statusPhaseCopying  = "copying"
+	statusPhaseDeleting = "deleting"
+	// a failed job is reported in this phase until it tries again (it is not set by the job itself)
+	statusPhaseFailed = "failed"
+)
+
+// maximum count of recent errors kept for the status of a job
+const statusMaxErrors = 20
+
+// jobStatus holds the live state of a single mirror job, which is updated concurrently by the operations
+type jobStatus struct {
+	mutex sync.Mutex
+
+	// control of the job, to report whether it is paused
+	control *jobControl
+	// limit of all jobs of the supervisor, nil if the job is not limited
+	totalWorkers *workerLimit
+
+	source        string
+	destinations  []string
+	phase         string
+	lastIteration time.Time
+	nextRun       time.Time
+	interval      time.Duration
+	iterations    int64
+	filesCopied   int64
+	bytesCopied   int64
+	filesMoved    int64
+	filesDeleted  int64
+	filesFailed   int64
+
+	// latest clock probes, by destination directory
+	clockSkew map[string]clockSkewProbe
+
+	// most recent errors (oldest first), and operations currently running by their id
+	recentErrors    []statusError
+	inFlight        map[int64]statusOperation
+	nextOperationID int64
+}
+
+type statusError struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Path      string    `json:"path"`
+	Error     string    `json:"error"`
+}
+
+type statusOperation struct {
+	Operation string    `json:"operation"`
+	Path      string    `json:"path"`
+	Started   time.Time `json:"started"`
+}
+
+// statusSummary is the JSON state of a job, as listed by /status
+type statusSummary struct {
+	Name          string     `json:"name"`
+	Source        string     `json:"source"`
+	Destinations  []string   `json:"destinations"`
+	Phase         string     `json:"phase"`
+	Paused        bool       `json:"paused"`
+	Healthy       bool       `json:"healthy"`
+	FailedReason  string     `json:"failedReason,omitempty"`
+	LastIteration *time.Time `json:"lastIteration,omitempty"`
+	NextRun       *time.Time `json:"nextRun,omitempty"`
+	Iterations    int64      `json:"iterations"`
+	FilesCopied   int64      `json:"filesCopied"`
+	BytesCopied   int64      `json:"bytesCopied"`
+	FilesMoved    int64      `json:"filesMoved"`
+	FilesDeleted  int64      `json:"filesDeleted"`
+	FilesFailed   int64      `json:"filesFailed"`
+	// the interval the job waits between scans, unless scheduled (in adaptive interval mode, it changes along with the changes found)
+	IntervalMS int64 `json:"intervalMS,omitempty"`
+	// latest clock probes of the destinations, if probed
+	ClockSkew []statusClockSkew `json:"clockSkew,omitempty"`
+	// operations of all jobs running within the total workers limit, if limited
+	TotalWorkers *statusWorkers `json:"totalWorkers,omitempty"`
+}
+
+// statusClockSkew is the latest clock probe of a destination
+type statusClockSkew struct {
+	Destination    string    `json:"destination"`
+	SkewMS         int64     `json:"skewMS"`
+	ModTimeErrorMS int64     `json:"modTimeErrorMS"`
+	Probed         time.Time `json:"probed"`
+}
+
+type statusWorkers struct {
+	Active int `json:"active"`
+	Limit  int `json:"limit"`
+}
+
+// statusDetails is the JSON state of a job, as returned by /status/<job>
+type statusDetails struct {
+	statusSummary
+	RecentErrors []statusError     `json:"recentErrors"`
+	InFlight     []statusOperation `json:"inFlight"`
+}
+
+// registry of status of all jobs, by job name
+var (
+	statusMutex sync.Mutex
+	statusJobs  = make(map[string]*jobStatus)
+)
+
+// registerJobStatus returns the status of the job, or nil if the status server is not enabled for it
+func registerJobStatus(configs Config) *jobStatus {
+	if len(configs.General.StatusListenAddr) < 1 {
+		return nil
+	}
+
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	// jobs of the same name share their status
+	name := getJobName(configs)
+	status, exists := statusJobs[name]
+	if !exists {
+		status = &jobStatus{phase: statusPhaseIdle, inFlight: make(map[int64]statusOperation)}
+		statusJobs[name] = status
+	}
+
+	// directories could change when the job is restarted with new settings
+	status.mutex.Lock()
+	status.control = configs.General.control
+	status.totalWorkers = configs.General.totalWorkers
+	status.source = configs.General.SourceDirectory
+	status.destinations = nil
+	for _, destConfigs := range getDestinationConfigs(configs) {
+		status.destinations = append(status.destinations, destConfigs.General.DestinationDirectory)
+	}
+	status.mutex.Unlock()
+
+	return status
+}
+
+func (status *jobStatus) setPhase(phase string) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+
+	status.phase = phase
+}
+
+// setNextRun sets the time of the next iteration (scheduled, or due once the interval passed)
+func (status *jobStatus) setNextRun(nextRun time.Time) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+
+	status.nextRun = nextRun
+}
+
+// setInterval sets the interval the job currently waits between scans
+func (status *jobStatus) setInterval(interval time.Duration) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+
+	status.interval = interval
+}
+
+// setClockSkew sets the latest clock probe of the destination
+func (status *jobStatus) setClockSkew(destination string, probe clockSkewProbe) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+
+	if status.clockSkew == nil {
+		status.clockSkew = make(map[string]clockSkewProbe)
+	}
+	status.clockSkew[destination] = probe
+}
+
+// recordIteration adds the counters of an ended iteration, and marks the job idle
+func (status *jobStatus) recordIteration(stats *iterationStats) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+
+	status.phase = statusPhaseIdle
+	status.lastIteration = time.Now()
+	status.iterations++
+	status.filesCopied += stats.filesCopied
+	status.bytesCopied += stats.bytesCopied
+	status.filesMoved += stats.filesMoved
+	status.filesDeleted += stats.filesDeleted
+	status.filesFailed += stats.filesFailed
+}
+
+// startOperation records the operation as in-flight, and returns a function to call once it ends with its error
+func (status *jobStatus) startOperation(operation string, path string) func(err error) {
+	if status == nil {
+		return func(err error) {}
+	}
+
+	status.mutex.Lock()
+	status.nextOperationID++
+	id := status.nextOperationID
+	status.inFlight[id] = statusOperation{Operation: operation, Path: path, Started: time.Now()}
+	status.mutex.Unlock()
+
+	return func(err error) {
+		status.mutex.Lock()
+		defer status.mutex.Unlock()
+
+		delete(status.inFlight, id)
+
+		if err != nil {
+			status.recentErrors = append(status.recentErrors, statusError{Time: time.Now(), Operation: operation, Path: path, Error: err.Error()})
+			if len(status.recentErrors) > statusMaxErrors {
+				status.recentErrors = status.recentErrors[len(status.recentErrors)-statusMaxErrors:]
+			}
+		}
+	}
+}
+
+func (status *jobStatus) getSummary(name string) statusSummary {
+	summary := statusSummary{
+		Name:         name,
+		Source:       status.source,
+		Destinations: status.destinations,
+		Phase:        status.phase,
+		Paused:       status.control.isPaused(),
+		Healthy:      true,
+		Iterations:   status.iterations,
+		FilesCopied:  status.filesCopied,
+		BytesCopied:  status.bytesCopied,
+		FilesMoved:   status.filesMoved,
+		FilesDeleted: status.filesDeleted,
+		FilesFailed:  status.filesFailed,
+		IntervalMS:   status.interval.Milliseconds(),
+	}
+	if failed, reason := status.control.isFailed(); failed {
+		summary.Phase = statusPhaseFailed
+		summary.Healthy = false
+		summary.FailedReason = reason
+	}
+	if !status.lastIteration.IsZero() {
+		lastIteration := status.lastIteration
+		summary.LastIteration = &lastIteration
+	}
+	if !status.nextRun.IsZero() {
+		nextRun := status.nextRun
+		summary.NextRun = &nextRun
+	}
+	for _, destination := range status.destinations {
+		if probe, exists := status.clockSkew[destination]; exists {
+			summary.ClockSkew = append(summary.ClockSkew, statusClockSkew{Destination: destination, SkewMS: probe.skew.Milliseconds(),
+				ModTimeErrorMS: probe.modTimeError.Milliseconds(), Probed: probe.probed})
+		}
+	}
+	if active, limit := status.totalWorkers.getUsage(); limit > 0 {
+		summary.TotalWorkers = &statusWorkers{Active: active, Limit: limit}
+	}
+
+	return summary
+}
+
+func (status *jobStatus) getDetails(name string) statusDetails {
+	details := statusDetails{
+		statusSummary: status.getSummary(name),
+		RecentErrors:  append([]statusError{}, status.recentErrors...),
+		InFlight:      make([]statusOperation, 0, len(status.inFlight)),
+	}
+
+	for _, operation := range status.inFlight {
+		details.InFlight = append(details.InFlight, operation)
+	}
+	sort.Slice(details.InFlight, func(i, j int) bool {
+		return details.InFlight[i].Started.Before(details.InFlight[j].Started)
+	})
+
+	return details
+}
+
+// startStatusServers starts an HTTP server exposing /status for every distinct listen address of the configurations
+func startStatusServers(configs []Config) error {
+	started := make(map[string]bool)
+
+	for _, config := range configs {
+		addr := config.General.StatusListenAddr
+		if len(addr) < 1 || started[addr] {
+			continue
+		}
+		started[addr] = true
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("Error starting status server; %w", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/status", serveStatus)
+		mux.HandleFunc("/status/", serveJobStatus)
+		mux.HandleFunc("/jobs/", serveJobControl)
+
+		go func(addr string) {
+			if err := http.Serve(listener, mux); err != nil {
+				slog.Error("Status server failed", "address", addr, "error", err)
+			}
+		}(addr)
+
+		slog.Info("Serving status", "url", fmt.Sprintf("http://%s/status", listener.Addr()))
+	}
+	return nil
+}
+
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	summaries := make([]statusSummary, 0, len(statusJobs))
+	for name, status := range statusJobs {
+		status.mutex.Lock()
+		summaries = append(summaries, status.getSummary(name))
+		status.mutex.Unlock()
+	}
+
+	// keep a stable order of jobs
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+
+	writeStatusJSON(w, summaries)
+}
+
+func serveJobStatus(w http.ResponseWriter, r *http.Request) {
+	requested := strings.TrimPrefix(r.URL.Path, "/status/")
+
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	for name, status := range statusJobs {
+		if !isJobNamed(name, requested) {
+			continue
+		}
+
+		status.mutex.Lock()
+		details := status.getDetails(name)
+		status.mutex.Unlock()
+
+		// a failed job is reported unhealthy by the status code too, for health checks which only look at it
+		if !details.Healthy {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeStatusJSON(w, details)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+func writeStatusJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
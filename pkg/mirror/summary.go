@@ -0,0 +1,135 @@
+package mirror
+
+import (
+	"sync"
+	"time"
+)
+
+// jobTotals holds counters of all iterations of a job since startup, for the summary on termination
+type jobTotals struct {
+	mutex         sync.Mutex
+	iterations    int64
+	copied        int64
+	copiedBytes   int64
+	deleted       int64
+	failed        int64
+	lastIteration time.Time
+	lastError     error
+	// durations of the phases of all iterations, and the largest file copied by any of them
+	srcScanDuration  time.Duration
+	destScanDuration time.Duration
+	planDuration     time.Duration
+	transferDuration time.Duration
+	largestFileBytes int64
+	largestFilePath  string
+}
+
+// Stats holds the totals of all iterations of a job since it started (a restarted job keeps its totals)
+type Stats struct {
+	Job           string
+	Iterations    int64
+	FilesCopied   int64
+	BytesCopied   int64
+	FilesDeleted  int64
+	FilesFailed   int64
+	LastIteration time.Time
+	// error of the last failed operation (or of the job itself), nil if none failed
+	LastError error
+	// time spent in every phase of all iterations: listing the source and the destination directories, planning the operations and running
+	// them
+	SourceScanDuration      time.Duration
+	DestinationScanDuration time.Duration
+	PlanDuration            time.Duration
+	TransferDuration        time.Duration
+	// the largest file copied by any iteration (an empty path if none was)
+	LargestFileBytes int64
+	LargestFilePath  string
+}
+
+var (
+	totalsMutex sync.Mutex
+	// totals of every job by its name, and the names in the order the jobs started
+	totalsJobs  = make(map[string]*jobTotals)
+	totalsNames []string
+)
+
+// registerJobTotals returns the totals of the job, which are kept when the job is restarted
+func registerJobTotals(configs Config) *jobTotals {
+	totalsMutex.Lock()
+	defer totalsMutex.Unlock()
+
+	// jobs of the same name share their totals
+	name := getJobName(configs)
+	if _, exists := totalsJobs[name]; !exists {
+		totalsJobs[name] = &jobTotals{}
+		totalsNames = append(totalsNames, name)
+	}
+
+	return totalsJobs[name]
+}
+
+// recordIteration adds the counters of an ended iteration
+func (totals *jobTotals) recordIteration(stats *iterationStats) {
+	if totals == nil {
+		return
+	}
+
+	totals.mutex.Lock()
+	defer totals.mutex.Unlock()
+
+	totals.iterations++
+	totals.copied += stats.filesCopied
+	totals.copiedBytes += stats.bytesCopied
+	totals.deleted += stats.filesDeleted
+	totals.failed += stats.filesFailed
+	totals.lastIteration = time.Now()
+	if stats.lastError != nil {
+		totals.lastError = stats.lastError
+	}
+
+	totals.srcScanDuration += stats.srcScanDuration
+	totals.destScanDuration += stats.destScanDuration
+	totals.planDuration += stats.planDuration
+	totals.transferDuration += stats.transferDuration
+	if stats.largestFileBytes > totals.largestFileBytes {
+		totals.largestFileBytes = stats.largestFileBytes
+		totals.largestFilePath = stats.largestFilePath
+	}
+}
+
+// recordFailure records a failure of the job itself (rather than of one of its operations)
+func (totals *jobTotals) recordFailure(err error) {
+	if totals == nil {
+		return
+	}
+
+	totals.mutex.Lock()
+	defer totals.mutex.Unlock()
+
+	totals.failed++
+	totals.lastError = err
+}
+
+// getStats returns a snapshot of the totals
+func (totals *jobTotals) getStats(name string) Stats {
+	totals.mutex.Lock()
+	defer totals.mutex.Unlock()
+
+	return Stats{Job: name, Iterations: totals.iterations, FilesCopied: totals.copied, BytesCopied: totals.copiedBytes, FilesDeleted: totals.deleted,
+		FilesFailed: totals.failed, LastIteration: totals.lastIteration, LastError: totals.lastError, SourceScanDuration: totals.srcScanDuration,
+		DestinationScanDuration: totals.destScanDuration, PlanDuration: totals.planDuration, TransferDuration: totals.transferDuration,
+		LargestFileBytes: totals.largestFileBytes, LargestFilePath: totals.largestFilePath}
+}
+
+// JobStats returns the totals of every job which ran in the process, in the order the jobs started
+func JobStats() []Stats {
+	totalsMutex.Lock()
+	defer totalsMutex.Unlock()
+
+	stats := make([]Stats, 0, len(totalsNames))
+	for _, name := range totalsNames {
+		stats = append(stats, totalsJobs[name].getStats(name))
+	}
+
+	return stats
+}
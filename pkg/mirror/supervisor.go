@@ -0,0 +1,161 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrJobsFailed is returned by Supervisor.Run when any of the jobs failed, each failure was logged by its job
+var ErrJobsFailed = errors.New("Mirroring failed")
+
+// Supervisor runs the jobs of config files together, the way the command line does: the metrics and status of the jobs are served (where
+// enabled), the jobs can be paused by signals, and changes of the config files are applied to the running jobs
+type Supervisor struct {
+	files []string
+	// the latest configurations of every config file, guarded by the mutex once the jobs run
+	mutex   sync.Mutex
+	configs [][]Config
+	// applied to the configurations of every config file, whenever the file is read
+	override func(configs []Config) []Config
+	// limit of the operations of all jobs together
+	totalWorkers *workerLimit
+}
+
+// NewSupervisor reads the config files, applying the override (if any) to the configurations of every file. an invalid configuration of
+// any of the files is returned as an error
+func NewSupervisor(configFiles []string, override func(configs []Config) []Config) (*Supervisor, error) {
+	if override == nil {
+		override = func(configs []Config) []Config { return configs }
+	}
+	supervisor := &Supervisor{files: configFiles, configs: make([][]Config, len(configFiles)), override: override, totalWorkers: newWorkerLimit()}
+
+	// read the configurations of every config file
+	var configs []Config
+	for i, configFile := range configFiles {
+		loaded, err := LoadConfigFile(configFile, false)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid configuration in '%s'; %w", configFile, err)
+		}
+
+		supervisor.configs[i] = override(loaded)
+		configs = append(configs, supervisor.configs[i]...)
+	}
+
+	// jobs are told apart by their names (e.g. by the control API), so the names must be unique, and so must their files
+	if err := ValidateJobs(configs); err != nil {
+		return nil, fmt.Errorf("Invalid configuration; %w", err)
+	}
+
+	return supervisor, nil
+}
+
+// Run starts a job for every configuration and waits until all of them end, which happens once the context is cancelled (or once every job
+// ran once, in run once mode). an error is returned if the servers failed to start, or if any of the jobs failed
+func (supervisor *Supervisor) Run(ctx context.Context) error {
+	var configs []Config
+	for _, fileConfigs := range supervisor.configs {
+		configs = append(configs, fileConfigs...)
+	}
+
+	// jobs whose destinations overlap delete the files of each other, which is allowed yet most likely a mistake
+	warnOverlappingDestinations(configs)
+	// limit the operations of all jobs together, if any config file does
+	supervisor.limitTotalWorkers()
+
+	// expose metrics, for configurations which enable them
+	if err := startMetricsServers(configs); err != nil {
+		return err
+	}
+	// expose the live state of jobs, for configurations which enable it
+	if err := startStatusServers(configs); err != nil {
+		return err
+	}
+	// allow to pause and resume all jobs by signals
+	handlePauseSignals(ctx)
+
+	// use a WaitGroup to be able to wait for all jobs to finish their in-flight operations before returning
+	var jobsWg sync.WaitGroup
+	// count jobs which had failed operations
+	var failedJobs int32
+
+	// start a job for every configuration
+	startJob := func(ctx context.Context, config Config, done chan struct{}) {
+		jobsWg.Add(1)
+
+		// run watcher job in coroutine to allow multiple jobs to run concurrently
+		go func() {
+			defer jobsWg.Done()
+			defer close(done)
+
+			logger := slog.Default().With("job", getJobName(config))
+			// the operations of the job take their places within the limit of all jobs
+			config.General.totalWorkers = supervisor.totalWorkers
+			mirror, err := New(config)
+			if err == nil {
+				logger = mirror.configs.General.logger
+				err = mirror.Run(ctx)
+			}
+			// a job stopped by termination (or by a restart) did not fail, unless its operations did
+			if isJobFailure(err) {
+				atomic.AddInt32(&failedJobs, 1)
+
+				logger.Error("Mirroring failed", "source", config.General.SourceDirectory, "destination", getDestinationsDescription(config), "error", err)
+			}
+		}()
+	}
+
+	for i, configFile := range supervisor.files {
+		group := &jobGroup{wg: &jobsWg, startJob: startJob}
+		group.start(ctx, supervisor.configs[i])
+
+		// apply changes of the config file to its jobs, while they run (and to the limit of all jobs)
+		go watchConfigFile(ctx, configFile, func(configs []Config) {
+			configs = supervisor.override(configs)
+
+			supervisor.mutex.Lock()
+			supervisor.configs[i] = configs
+			supervisor.mutex.Unlock()
+			supervisor.limitTotalWorkers()
+
+			group.reload(ctx, configs)
+		})
+	}
+
+	jobsWg.Wait()
+
+	// let pending notifications be delivered (they are posted in the background)
+	webhooksWg.Wait()
+
+	if failedJobs > 0 {
+		return fmt.Errorf("%d jobs failed; %w", failedJobs, ErrJobsFailed)
+	}
+	return nil
+}
+
+// limitTotalWorkers applies the lowest total workers limit set by the configurations of all config files, or removes the limit if none
+// sets it
+func (supervisor *Supervisor) limitTotalWorkers() {
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	limit := 0
+	for _, fileConfigs := range supervisor.configs {
+		for _, config := range fileConfigs {
+			if size := config.Global.MaxTotalConcurrentWorkers; size > 0 && (limit < 1 || size < limit) {
+				limit = size
+			}
+		}
+	}
+
+	supervisor.totalWorkers.setLimit(limit)
+}
+
+// isJobFailure reports whether the error of an ended job is a failure, rather than the cancellation which stopped it
+func isJobFailure(err error) bool {
+	var failedErr *operationsError
+	return errors.As(err, &failedErr) || (err != nil && !errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,227 @@
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	symlinkModeSkip   = "skip"
+	symlinkModeCopy   = "copy"
+	symlinkModeFollow = "follow"
+)
+
+func isSymlink(file os.FileInfo) bool {
+	return file.Mode()&os.ModeSymlink != 0
+}
+
+// isSymlinkTarget reports whether the path is a symlink pointing at the target
+func isSymlinkTarget(path string, target string) bool {
+	destTarget, err := os.Readlink(path)
+	return err == nil && destTarget == target
+}
+
+func writeSymlink(configs Config, stats *iterationStats, srcPath string, srcFile os.FileInfo, path string) error {
+	// symlinks are not mirrored unless requested, but make sure it leaves a trace
+	if configs.General.SymlinkMode != symlinkModeCopy {
+		configs.General.logger.Debug("Skip", "path", srcPath, "reason", "symlink")
+		return nil
+	}
+
+	// get the path the source symlink points at
+	target, err := os.Readlink(srcPath)
+	if err != nil {
+		return err
+	}
+
+	// check destination path (without following it, if it is a symlink itself)
+	file, err := os.Lstat(path)
+	if err == nil {
+		// symlink exists, but compare its target against the source symlink
+		if isSymlink(file) && isSymlinkTarget(path, target) {
+			// symlink is unchanged
+			stats.addUnchanged()
+
+			return nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) { // check if the error is of expected type (ErrNotExist)
+		// unexpected error
+		return err
+	}
+
+	// in dry run mode, only report the symlink would be created
+	if configs.General.DryRun {
+		stats.addCopied(path, 0)
+
+		configs.General.logger.Info("WOULD Write", "path", path, "target", target)
+		return nil
+	}
+
+	// make sure destination parent directory exists (the symlink itself must not be followed)
+	if err := validateDirExistance(configs, stats, filepath.Dir(srcPath), filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	// remove whatever exists in the destination path, the symlink replaces it (this removes a symlink itself, never its target)
+	if file != nil {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	// recreate the symlink pointing at the same target
+	if err := os.Symlink(target, path); err != nil {
+		return err
+	}
+	// set same owner as source symlink, if requested
+	if err := preserveOwnership(configs, stats, srcFile, path); err != nil {
+		return err
+	}
+
+	stats.addCopied(path, 0)
+
+	configs.General.logger.Info("Write", "path", path, "target", target)
+	emitEvent(configs, eventActionWrite, path, 0, 0, nil)
+	return nil
+}
+
+// symlinkResolver is a file system which resolves the symlinks of paths (e.g. the local one)
+type symlinkResolver interface {
+	EvalSymlinks(path string) (string, error)
+}
+
+func (localFS) EvalSymlinks(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+
+// evalSymlinks returns the path with its symlinks resolved through the file system, or the existing path itself if the file system does not
+// resolve symlinks
+func evalSymlinks(fsys readableFS, path string) (string, error) {
+	if resolver, ok := fsys.(symlinkResolver); ok {
+		return resolver.EvalSymlinks(path)
+	}
+	if _, err := fsys.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func getFollowedDirFiles(logger *slog.Logger, fsys readableFS, srcDir string, scope pathScope) map[string]os.FileInfo {
+	// create a container for files
+	files := make(map[string]os.FileInfo)
+
+	// resolve the root itself, in case it is a symlink
+	realDir, err := evalSymlinks(fsys, srcDir)
+	if err != nil {
+		addUnreadableFile(logger, srcDir, srcDir, nil, err, files)
+		return files
+	}
+
+	// walk the tree, while the root is the only directory in the chain of followed directories
+	addFollowedDirFiles(logger, fsys, realDir, "", map[string]bool{realDir: true}, scope, files)
+
+	return files
+}
+
+func addFollowedDirFiles(logger *slog.Logger, fsys readableFS, dir string, relativeDir string, ancestors map[string]bool, scope pathScope, files map[string]os.FileInfo) {
+	// try to get all directory files (including subdirs or subfiles)
+	walkFS(fsys, dir, func(path string, entry fs.DirEntry, err error) error {
+		// get relative file path, as seen from the root of the walk
+		relativePath := filepath.Join(relativeDir, getRelativePath(dir, path))
+
+		// mark entries which could not be read, so their counterparts are left alone
+		if err != nil {
+			if dir != path || !errors.Is(err, fs.ErrNotExist) {
+				logger.Warn("Skip", "path", path, "reason", "unreadable", "error", err)
+				files[relativePath] = unreadableFile{info: getEntryInfo(entry)}
+			}
+			return nil
+		}
+
+		// ignore root path dir
+		if dir == path {
+			return nil
+		}
+
+		// paths out of scope are not walked at all (so their info is never read)
+		if !scope.contains(relativePath) {
+			return skipWalkedPath(entry)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			logger.Warn("Skip", "path", path, "reason", "unreadable", "error", err)
+			files[relativePath] = unreadableFile{}
+			return nil
+		}
+
+		// add regular entries to container as is (a directory whose contents are out of scope is mirrored itself only)
+		if !isSymlink(info) {
+			files[relativePath] = info
+			if info.IsDir() && !scope.isWalked(relativePath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// get info of the symlink target
+		targetInfo, err := fsys.Stat(path)
+		if err != nil {
+			logger.Warn("Skip", "path", path, "reason", "broken symlink", "error", err)
+			return nil
+		}
+
+		// a symlink to a file is mirrored as the target file itself
+		if !targetInfo.IsDir() {
+			files[relativePath] = targetInfo
+			return nil
+		}
+
+		// a symlink to a directory is walked into, unless it would recurse forever
+		realPath, err := evalSymlinks(fsys, path)
+		if err != nil {
+			logger.Warn("Skip", "path", path, "reason", "broken symlink", "error", err)
+			return nil
+		}
+		realParent, err := evalSymlinks(fsys, filepath.Dir(path))
+		if err != nil {
+			return nil
+		}
+
+		// a loop exists when the target contains the symlink itself, or is already being walked by the chain of followed directories
+		if ancestors[realPath] || isSubPath(realPath, realParent) {
+			logger.Warn("Skip", "path", path, "reason", "symlink loop", "target", realPath)
+			return nil
+		}
+
+		files[relativePath] = targetInfo
+		if !scope.isWalked(relativePath) {
+			return nil
+		}
+
+		// extend the chain of followed directories for the target subtree only
+		targetAncestors := make(map[string]bool, len(ancestors)+1)
+		for ancestor := range ancestors {
+			targetAncestors[ancestor] = true
+		}
+		targetAncestors[realPath] = true
+
+		addFollowedDirFiles(logger, fsys, realPath, relativePath, targetAncestors, scope, files)
+
+		return nil
+	})
+}
+
+// isSubPath reports whether the path is the parent path itself, or is located under it
+func isSubPath(parent string, path string) bool {
+	relativePath, err := filepath.Rel(parent, path)
+	if err != nil {
+		return false
+	}
+
+	return relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,40 @@
+package mirror
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+const (
+	deleteModePermanent = "permanent"
+	deleteModeTrash     = "trash"
+)
+
+func trashFile(logger *slog.Logger, file os.FileInfo, path string) (bool, error) {
+	// try to move the file into the platform trash
+	err := moveToTrash(path)
+	if err == nil {
+		return true, nil
+	}
+
+	// trash is not available (e.g. on network shares), so fall back to permanent removal
+	logger.Warn("Trash unavailable, removing permanently", "path", path, "error", err)
+
+	return false, removePath(localFS{}, file, path)
+}
+
+func removePath(fsys destinationFS, file os.FileInfo, path string) error {
+	// remove by type
+	if file.IsDir() {
+		// directory
+		return fsys.RemoveAll(path)
+	}
+
+	// file (or symlink, in which case only the symlink is removed and never its target), which is already removed if it does not exist
+	if err := fsys.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build darwin
+// +build darwin
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// moveToTrash moves the path into the user trash
+func moveToTrash(path string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	trashDir := filepath.Join(home, ".Trash")
+	if _, err := os.Stat(trashDir); err != nil {
+		return err
+	}
+
+	// find a unique name in the trash
+	name := filepath.Base(path)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(filepath.Join(trashDir, name)); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+
+		name = filepath.Base(path) + " " + strconv.Itoa(i)
+	}
+
+	// renaming fails across volumes, in which case there is no trash available for the file
+	return os.Rename(path, filepath.Join(trashDir, name))
+}
@@ -0,0 +1,141 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// moveToTrash moves the path into the trash as described by the FreeDesktop.org trash specification
+func moveToTrash(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	// find a trash directory on the same volume, so the file can be moved without copying
+	trashDir, err := getTrashDir(absPath)
+	if err != nil {
+		return err
+	}
+
+	filesDir := filepath.Join(trashDir, "files")
+	infoDir := filepath.Join(trashDir, "info")
+	if err := os.MkdirAll(filesDir, 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infoDir, 0700); err != nil {
+		return err
+	}
+
+	// reserve a unique name in the trash by exclusively creating its info file
+	name := filepath.Base(absPath)
+	var infoFile *os.File
+	for i := 1; ; i++ {
+		infoFile, err = os.OpenFile(filepath.Join(infoDir, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		name = filepath.Base(absPath) + "." + strconv.Itoa(i)
+	}
+
+	// record the original location, so the file can be restored
+	_, err = fmt.Fprintf(infoFile, "[Trash Info]\nPath=%s\nDeletionDate=%s\n", (&url.URL{Path: absPath}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+	if closeErr := infoFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(absPath, filepath.Join(filesDir, name))
+	}
+	if err != nil {
+		// release the reserved name
+		os.Remove(filepath.Join(infoDir, name+".trashinfo"))
+		return err
+	}
+
+	return nil
+}
+
+func getTrashDir(absPath string) (string, error) {
+	// get the device of the file, the trash must be on the same device
+	device, err := getDevice(filepath.Dir(absPath))
+	if err != nil {
+		return "", err
+	}
+
+	// prefer the home trash, if it is on the same device
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if len(dataHome) < 1 {
+		if home, err := os.UserHomeDir(); err == nil {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+	}
+	if len(dataHome) > 0 {
+		if homeDevice, err := getDevice(getExistingParent(dataHome)); err == nil && homeDevice == device {
+			return filepath.Join(dataHome, "Trash"), nil
+		}
+	}
+
+	// otherwise use the trash in the top directory of the volume
+	topDir := filepath.Dir(absPath)
+	for {
+		parent := filepath.Dir(topDir)
+		if parent == topDir {
+			break
+		}
+		if parentDevice, err := getDevice(parent); err != nil || parentDevice != device {
+			break
+		}
+		topDir = parent
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+
+	// an administrator provided shared trash must be a sticky directory, and not a symlink
+	if info, err := os.Lstat(filepath.Join(topDir, ".Trash")); err == nil && info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		return filepath.Join(topDir, ".Trash", uid), nil
+	}
+
+	return filepath.Join(topDir, ".Trash-"+uid), nil
+}
+
+func getDevice(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errors.New("device is unknown")
+	}
+
+	return uint64(stat.Dev), nil
+}
+
+func getExistingParent(path string) string {
+	// go up until an existing directory is found
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
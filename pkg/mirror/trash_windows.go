@@ -0,0 +1,64 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	foDelete          = 0x0003
+	fofSilent         = 0x0004
+	fofNoConfirmation = 0x0010
+	fofAllowUndo      = 0x0040
+	fofNoErrorUI      = 0x0400
+)
+
+// shFileOpStruct matches the SHFILEOPSTRUCTW structure
+type shFileOpStruct struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+var procSHFileOperationW = syscall.NewLazyDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// moveToTrash moves the path into the recycle bin
+func moveToTrash(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	// source paths list must be terminated by double null
+	from, err := syscall.UTF16FromString(absPath)
+	if err != nil {
+		return err
+	}
+	from = append(from, 0)
+
+	op := shFileOpStruct{
+		wFunc:  foDelete,
+		pFrom:  &from[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofSilent | fofNoErrorUI,
+	}
+
+	result, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op)))
+	if result != 0 {
+		return fmt.Errorf("SHFileOperation failed with code 0x%x", result)
+	}
+	if op.fAnyOperationsAborted != 0 {
+		return fmt.Errorf("SHFileOperation was aborted")
+	}
+
+	return nil
+}
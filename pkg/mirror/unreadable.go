@@ -0,0 +1,105 @@
+package mirror
+
+import (
+	"os"
+	"time"
+)
+
+// unreadableFile stands for an entry of a scanned tree which could not be read, so its counterpart in the other tree is left alone.
+// when the info is known (a directory whose contents could not be listed), the entry itself is mirrored but its contents are left alone
+type unreadableFile struct {
+	info os.FileInfo
+}
+
+func (file unreadableFile) Name() string {
+	if file.info == nil {
+		return ""
+	}
+	return file.info.Name()
+}
+
+func (file unreadableFile) Size() int64 {
+	if file.info == nil {
+		return 0
+	}
+	return file.info.Size()
+}
+
+func (file unreadableFile) Mode() os.FileMode {
+	if file.info == nil {
+		return 0
+	}
+	return file.info.Mode()
+}
+
+func (file unreadableFile) ModTime() time.Time {
+	if file.info == nil {
+		return time.Time{}
+	}
+	return file.info.ModTime()
+}
+
+func (file unreadableFile) IsDir() bool {
+	return file.info != nil && file.info.IsDir()
+}
+
+func (file unreadableFile) Sys() interface{} {
+	if file.info == nil {
+		return nil
+	}
+	return file.info.Sys()
+}
+
+// excludeUnreadableFiles removes the unreadable entries from both containers, along with the destination files which correspond to unreadable source entries,
+// so a file which could not be read in the source does not cause its destination counterpart to be deleted
+func excludeUnreadableFiles(srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) {
+	// relative paths of source entries which are unknown (including their subtree), and of source directories whose contents are unknown
+	var unknownPaths []string
+	var unknownContents []string
+
+	for srcPath, srcFile := range srcFiles {
+		unreadable, ok := srcFile.(unreadableFile)
+		if !ok {
+			continue
+		}
+
+		// the root directory itself is never mirrored
+		if unreadable.info != nil && len(srcPath) > 0 {
+			srcFiles[srcPath] = unreadable.info
+			unknownContents = append(unknownContents, srcPath)
+		} else {
+			delete(srcFiles, srcPath)
+
+			if unreadable.info != nil {
+				unknownContents = append(unknownContents, srcPath)
+			} else {
+				unknownPaths = append(unknownPaths, srcPath)
+			}
+		}
+	}
+
+	for dstPath, dstFile := range destFiles {
+		// an unreadable destination entry is left alone, but a directory whose contents could not be listed is still mirrored itself
+		if unreadable, ok := dstFile.(unreadableFile); ok {
+			if unreadable.info != nil && len(dstPath) > 0 {
+				destFiles[dstPath] = unreadable.info
+			} else {
+				delete(destFiles, dstPath)
+				continue
+			}
+		}
+
+		for _, unknownPath := range unknownPaths {
+			if isSubPath(unknownPath, dstPath) {
+				delete(destFiles, dstPath)
+				break
+			}
+		}
+		for _, unknownPath := range unknownContents {
+			if dstPath != unknownPath && isSubPath(unknownPath, dstPath) {
+				delete(destFiles, dstPath)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ValidateConfigFiles checks the config files without mirroring anything: unknown options, invalid values, an unreadable source directory
+// and destination directories which can not be written. it returns the problems found in all of them
+func ValidateConfigFiles(configFiles []string) []string {
+	var problems []string
+	var allConfigs []Config
+
+	for _, configFile := range configFiles {
+		// load in strict mode first, so unknown (e.g. misspelled) options are reported
+		configs, err := LoadConfigFile(configFile, true)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", configFile, err))
+
+			// the directories can still be checked, as long as the configuration is valid otherwise
+			if configs, err = LoadConfigFile(configFile, false); err != nil {
+				continue
+			}
+		}
+
+		for _, config := range configs {
+			for _, problem := range validateDirectories(config) {
+				problems = append(problems, fmt.Sprintf("%s: %s", configFile, problem))
+			}
+		}
+		allConfigs = append(allConfigs, configs...)
+	}
+
+	// the files are run together, so the names (and files) of their jobs must be unique across them
+	if err := ValidateJobs(allConfigs); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	return problems
+}
+
+// validateDirectories checks the source can be read and every destination can be written (overlapping directories are rejected when the
+// configuration is read)
+func validateDirectories(configs Config) []string {
+	var problems []string
+
+	srcDir := configs.General.SourceDirectory
+	if err := checkReadableDir(localFS{}, srcDir); err != nil {
+		problems = append(problems, fmt.Sprintf("Source directory '%s' is not readable; %s", srcDir, err))
+	}
+
+	// a remote destination is connected to for the check
+	fsys := newDestinationFS(configs)
+	defer fsys.Close()
+
+	for _, destConfigs := range getDestinationConfigs(configs) {
+		destDir := destConfigs.General.DestinationDirectory
+
+		if err := checkWritableDir(fsys, destDir); err != nil {
+			problems = append(problems, fmt.Sprintf("Destination directory '%s' is not writable; %s", getDestinationName(destConfigs), err))
+		}
+	}
+
+	return problems
+}
+
+func checkReadableDir(fsys readableFS, dir string) error {
+	info, err := fsys.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+
+	// make sure its entries can be listed (a local directory by its first entry only, as it may hold many)
+	if _, local := fsys.(localFS); !local {
+		_, err := fsys.ReadDir(dir)
+		return err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func checkWritableDir(fsys destinationFS, dir string) error {
+	// a missing directory is created when mirroring, so check its nearest existing parent can be written instead
+	for {
+		info, err := fsys.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("'%s' is not a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(dir) == dir {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	// the only reliable check (across platforms) is to actually write, so create a file and remove it right away
+	// (named as a temporary file, so a leftover one is cleaned up by mirroring)
+	path := filepath.Join(dir, fmt.Sprintf(".validate-%d-%d%s", os.Getpid(), time.Now().UnixNano(), tempFileSuffix))
+	f, err := fsys.Create(path, nil)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return fsys.Remove(path)
+}
@@ -0,0 +1,1713 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"path/filepath"
+)
+
+// runScanLoop mirrors the configured source directory into the destination directory until the context is cancelled (or once, in run once mode).
+// an error is returned if any of the operations failed
+func runScanLoop(ctx context.Context, configs Config) error {
+	configs, closeJob, err := openJob(configs)
+	if err != nil {
+		return err
+	}
+	defer closeJob()
+
+	// check if event driven watching is requested, instead of polling (there is nothing to watch when running once)
+	if configs.General.WatchMode == watchModeEvents && !configs.General.RunOnce {
+		configs.General.logger.Info("Watching for events", "source", configs.General.SourceDirectory, "destination", getDestinationsDescription(configs), "fullRescanIntervalMS", configs.General.FullRescanIntervalMS)
+
+		return runEventLoop(ctx, configs)
+	}
+
+	if configs.General.RunOnce {
+		configs.General.logger.Info("Mirroring once", "source", configs.General.SourceDirectory, "destination", getDestinationsDescription(configs))
+	} else if configs.General.schedule != nil {
+		configs.General.logger.Info("Watching", "source", configs.General.SourceDirectory, "destination", getDestinationsDescription(configs), "schedule", configs.General.Schedule)
+	} else if adaptive := configs.General.AdaptiveInterval; adaptive != nil {
+		configs.General.logger.Info("Watching", "source", configs.General.SourceDirectory, "destination", getDestinationsDescription(configs), "minIntervalMS", adaptive.MinIntervalMS,
+			"maxIntervalMS", adaptive.MaxIntervalMS)
+	} else {
+		configs.General.logger.Info("Watching", "source", configs.General.SourceDirectory, "destination", getDestinationsDescription(configs), "loopIntervalMS", configs.General.LoopIntervalMS)
+	}
+
+	// count failed operations of all iterations
+	var failed int64
+	// the interval of the previous iteration, in adaptive interval mode
+	var adaptiveInterval time.Duration
+
+	// run loop until termination is requested, to scan for changes continuously
+	for {
+		// a paused job idles until resumed, then scans right away
+		waitWhilePaused(ctx, configs)
+		if ctx.Err() != nil {
+			return getJobError(ctx, failed)
+		}
+
+		// mirror any changes of the whole directory
+		iterationStart := time.Now()
+		stats := syncDirectories(ctx, configs)
+		failed += stats.filesFailed
+
+		// in run once mode, a single iteration is enough
+		if configs.General.RunOnce {
+			return getJobError(ctx, failed)
+		}
+
+		// get the time to wait before the next iteration, which is the next scheduled time if scheduled, or adapts to the changes found
+		interval := time.Duration(configs.General.LoopIntervalMS) * time.Millisecond
+		if configs.General.schedule != nil {
+			interval = getScheduledInterval(configs, iterationStart)
+		} else {
+			if configs.General.AdaptiveInterval != nil {
+				adaptiveInterval = getAdaptiveInterval(configs, adaptiveInterval, stats.hasChanges())
+				interval = adaptiveInterval
+			}
+			configs.General.status.setInterval(interval)
+			configs.General.status.setNextRun(time.Now().Add(interval))
+		}
+
+		// wait some time before running the next iteration, unless termination is requested or the job is paused in the meantime
+		paused, pauseChanged := configs.General.control.state()
+		if paused {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return getJobError(ctx, failed)
+		case update := <-configs.General.updates:
+			// the config file changed, so run the next iteration with the new settings right away
+			configs = applyConfigUpdate(configs, update)
+		case <-pauseChanged:
+			// paused, which is handled at the start of the next iteration
+		case <-time.After(interval):
+		}
+	}
+}
+
+// openJob creates the state shared by all operations of the job, and starts its workers and destination, which are closed by the returned
+// function once the job ends
+func openJob(configs Config) (Config, func(), error) {
+	// create the state shared by all operations of the job
+	configs, err := initJobState(configs)
+	if err != nil {
+		return configs, nil, err
+	}
+	// lock the destination directories, so other instances do not mirror into them at the same time
+	unlock, err := lockDestinations(configs)
+	if err != nil {
+		return configs, nil, err
+	}
+	// start the workers of the job, which run the operations of all iterations
+	configs.General.workers = newWorkerPool(configs.General.MaxConcurrentWorkers)
+	// open the file system of the destination, which keeps its connections (if remote) across iterations (unless the job was given one)
+	given := configs.General.destination != nil
+	if !given {
+		configs.General.destination = newDestinationFS(configs)
+	}
+
+	return configs, func() {
+		if !given {
+			configs.General.destination.Close()
+		}
+		configs.General.workers.close()
+		unlock()
+	}, nil
+}
+
+func initJobState(configs Config) (Config, error) {
+	// read the source through the local file system, unless another one was set
+	if configs.General.source == nil {
+		configs.General.source = localFS{}
+	}
+	// create the bandwidth limiter shared by all copy operations of the job, if limited
+	configs.General.limiter = newBandwidthLimiter(configs.General)
+	// create the pool of copy buffers shared by all copy operations of the job
+	configs.General.buffers = newBufferPool(configs.General.CopyBufferKB * 1024)
+	// get the metrics of the job, if enabled
+	configs.General.metrics = registerJobMetrics(configs)
+	// create the container of files skipped by their size
+	configs.General.skipped = newSkippedFiles()
+	// create the container of destination files missing from the source, unless the job keeps one across its runs
+	if configs.General.missing == nil {
+		configs.General.missing = newMissingFiles()
+	}
+	// create the container of written files, if drift is detected, unless the job keeps one across its runs
+	if configs.General.written == nil || (!configs.General.DetectDrift && !configs.General.EnforceDestination) {
+		configs.General.written = newWrittenFiles(configs.General)
+	}
+	// get the control of the job, so it can be paused
+	configs.General.control = registerJobControl(configs)
+	// parse the schedule of iterations, if scheduled (it is validated when the configuration is read)
+	configs.General.schedule = nil
+	if len(configs.General.Schedule) > 0 {
+		configs.General.schedule, _ = parseCronSchedule(configs.General.Schedule)
+	}
+	// get the status of the job, if enabled
+	configs.General.status = registerJobStatus(configs)
+	// create the logger of the job, unless already created by the caller
+	if configs.General.logger == nil {
+		logger, err := newLogger(configs)
+		if err != nil {
+			return configs, err
+		}
+		configs.General.logger = logger
+	}
+	// ACLs and attributes are ignored where they can not be preserved
+	checkSecuritySupport(configs)
+	checkCreationTimeSupport(configs)
+	configs.General.securityWarned = new(int32)
+	// read the hashes of files kept by previous runs, if the state file is enabled
+	configs.General.hashes = loadHashCache(configs)
+	// read the manifests of the destination directories, if written
+	configs.General.manifests = loadManifests(configs)
+	// open the output of the event stream, if requested
+	events, err := newEventStream(configs)
+	if err != nil {
+		return configs, err
+	}
+	configs.General.events = events
+	// create the container of ignore files of the source directory, if respected
+	configs.General.ignores = newIgnoreRules(configs)
+	// create the container of directory listings, if unchanged directories are skipped
+	configs.General.listings = newDirListings(configs.General)
+	// create the container of clock probes of the destinations, if probed
+	configs.General.clockSkew = newClockSkewProbes(configs.General)
+
+	return configs, nil
+}
+
+// getJobError returns the error of an ended job (or iteration): the cancellation which stopped it (if stopped), and its failed operations (if
+// any), so a caller can tell a shutdown from a failure
+func getJobError(ctx context.Context, failed int64) error {
+	// no failures
+	if failed < 1 {
+		return ctx.Err()
+	}
+
+	return &operationsError{failed: failed, cancellation: ctx.Err()}
+}
+
+// operationsError reports the failed operations of a job, it wraps the cancellation which stopped the job (if it was stopped)
+type operationsError struct {
+	failed       int64
+	cancellation error
+}
+
+func (err *operationsError) Error() string {
+	return fmt.Sprintf("%v operations failed", err.failed)
+}
+
+func (err *operationsError) Unwrap() error {
+	return err.cancellation
+}
+
+func syncDirectories(ctx context.Context, configs Config) *iterationStats {
+	return syncFiles(ctx, configs, getFullScan(configs), true)
+}
+
+// getFullScan returns the scan of the whole source directory and of every destination directory
+func getFullScan(configs Config) func(destConfigsList []Config) []*scannedTree {
+	// following symlinks needs the complete source tree (to detect symlink loops), and so does planning both directions of a bidirectional
+	// sync, so the trees are scanned completely (a single source scan serves all destinations)
+	if configs.General.SymlinkMode == symlinkModeFollow || configs.General.SyncMode == syncModeBidirectional {
+		return func(destConfigsList []Config) []*scannedTree {
+			return scanTrees(configs, destConfigsList, func() map[string]os.FileInfo {
+				return getDirFiles(configs.General.logger, configs.General.source, configs.General.SourceDirectory, configs.General.SymlinkMode == symlinkModeFollow, newPathScope(configs.General))
+			}, func(destConfigs Config) map[string]os.FileInfo {
+				return getDestFiles(configs.General.logger, destConfigs.General.destination, destConfigs.General.DestinationDirectory, newPathScope(configs.General),
+					getInternalPaths(destConfigs))
+			})
+		}
+	}
+
+	// otherwise the trees are compared while they are scanned, so only their differences are kept in memory
+	return func(destConfigsList []Config) []*scannedTree {
+		return scanDifferences(configs, destConfigsList)
+	}
+}
+
+func syncFiles(ctx context.Context, configs Config, scanFiles func(destConfigsList []Config) []*scannedTree, fullScan bool) *iterationStats {
+	// measure the duration of the iteration
+	start := time.Now()
+
+	// the pre-sync command runs before anything is scanned, and skips the iteration if it fails (hooks run around full scans only)
+	if fullScan && !runPreSyncHook(ctx, configs) {
+		return &iterationStats{skipped: true}
+	}
+
+	configs.General.status.setPhase(statusPhaseScanning)
+
+	// nothing is planned against an unavailable source (or an unreachable destination), the iteration is skipped and retried by the next one
+	if !isSourceAvailable(configs) || !isDestinationAvailable(configs) {
+		configs.General.status.setPhase(statusPhaseIdle)
+		return &iterationStats{skipped: true}
+	}
+
+	// use a WaitGroup to be able to wait for all jobs (of all destinations) to end before running the next iteration
+	var wg sync.WaitGroup
+
+	// create a container for the operations of all destinations, so they share the concurrent workers limit
+	var jobFuncs []func()
+
+	destConfigsList := getDestinationConfigs(configs)
+	// modification times are compared as precisely as every destination keeps them
+	destConfigsList = setModTimeTolerances(destConfigsList)
+	// in snapshot mode, every destination is written into a new snapshot
+	if configs.General.SnapshotMode {
+		destConfigsList = prepareSnapshots(destConfigsList)
+	}
+	// get the files of the source directory and of every destination directory
+	trees := scanFiles(destConfigsList)
+	// a source which turned out empty is suspicious as well, unless only some paths were scanned
+	if fullScan && (isEmptySourceSuspicious(configs, destConfigsList, trees) || isEmptyDestinationSuspicious(configs, destConfigsList, trees)) {
+		configs.General.status.setPhase(statusPhaseIdle)
+		return &iterationStats{skipped: true}
+	}
+	// the operations of the iteration are bracketed by its events
+	emitIterationEvent(configs, eventActionIterationStart, nil, 0)
+	configs.General.control.startIteration()
+	// ignore files could change between iterations
+	configs.General.ignores.reset()
+
+	// create a container for the iteration counters of every destination
+	destStats := make([]*iterationStats, len(destConfigsList))
+	// and for the deletions of every destination, unless they run along with the other operations
+	deleteFuncs := make([][]func(), len(destConfigsList))
+	// what every bidirectional destination planned, which its synced state is updated by
+	plans := make([]*bidirectionalPlan, len(destConfigsList))
+
+	for i, destConfigs := range destConfigsList {
+		// measure the duration of planning the operations of the destination
+		planStart := time.Now()
+
+		destSrcFiles := trees[i].srcFiles
+		destFiles := trees[i].destFiles
+
+		destStats[i] = &iterationStats{filesScannedSource: trees[i].srcScanned, filesScannedDest: trees[i].destScanned, filesUnchanged: trees[i].filesUnchanged, destMatched: trees[i].destMatched}
+		destStats[i].scanDuration = trees[i].scanDuration
+		destStats[i].srcScanDuration = trees[i].srcScanDuration
+		destStats[i].destScanDuration = trees[i].destScanDuration
+
+		// files which are not mirrored (or are used by the mirror itself) are neither copied nor deleted
+		excludeUnmirroredFiles(destConfigs, destSrcFiles, destFiles)
+
+		// add count of jobs as sum of files in both directories
+		wg.Add(len(destSrcFiles) + len(destFiles))
+
+		// in bidirectional mode, the changes of both directories are mirrored into each other
+		var copies, deletions []func()
+		if configs.General.SyncMode == syncModeBidirectional {
+			copies, deletions, plans[i] = processBidirectionalChanges(ctx, destConfigs, destStats[i], destSrcFiles, destFiles, &wg)
+		} else {
+			// get a list of operations (functions) to execute (files to write\remove in destination directory, based on current source directory contents)
+			copies, deletions = processChanges(ctx, destConfigs, destStats[i], destSrcFiles, destFiles, fullScan, &wg)
+		}
+
+		jobFuncs = append(jobFuncs, copies...)
+		if configs.General.DeletePhase == deletePhaseInterleaved {
+			jobFuncs = append(jobFuncs, deletions...)
+		} else {
+			deleteFuncs[i] = deletions
+		}
+
+		destStats[i].planDuration = time.Since(planStart)
+	}
+
+	// execute the operations and wait for all of them to end
+	transferStart := time.Now()
+	configs.General.status.setPhase(statusPhaseCopying)
+	runJobs(ctx, configs, jobFuncs, &wg)
+	// the deletions run once all other operations ended, so a file which is recreated elsewhere (e.g. by a reorganization) is never missing
+	// from the destination meanwhile
+	runDeletions(ctx, configs, destConfigsList, destStats, deleteFuncs, &wg)
+	// wait for all operations of the iteration to end
+	wg.Wait()
+	transferDuration := time.Since(transferStart)
+
+	// create a container for the totals of all destinations (the source is scanned once for all of them)
+	stats := &iterationStats{scanDuration: transferStart.Sub(start), transferDuration: transferDuration}
+	if len(trees) > 0 {
+		stats.filesScannedSource = trees[0].srcScanned
+		stats.srcScanDuration = trees[0].srcScanDuration
+	}
+
+	for i, destConfigs := range destConfigsList {
+		destStats[i].transferDuration = transferDuration
+
+		// directories are modified by writing their contents, so their modification times are synced once all operations ended
+		syncDirTimes(destConfigs, destStats[i], trees[i].srcFiles, trees[i].destFiles)
+
+		// a complete snapshot replaces the oldest one
+		if configs.General.SnapshotMode {
+			completeSnapshot(destConfigs, destStats[i], start)
+		}
+
+		// remove expired backups, and purge expired quarantined files
+		pruneBackups(destConfigs)
+		pruneQuarantine(destConfigs)
+
+		// list the mirrored files of the destination
+		configs.General.manifests.save(destConfigs, fullScan)
+
+		// the paths in sync tell the deletions of the next bidirectional iteration
+		if plans[i] != nil && !configs.General.DryRun {
+			saveSyncedState(destConfigs, plans[i])
+		}
+
+		// the totals are broken out by destination
+		if configs.General.DryRun {
+			// in dry run mode, report the totals of the planned operations
+			configs.General.logger.Info("Dry run", "destination", destConfigs.General.DestinationDirectory, "scannedSource", destStats[i].filesScannedSource, "scannedDestination", destStats[i].filesScannedDest,
+				"wouldCopy", destStats[i].filesCopied, "wouldCopyBytes", destStats[i].bytesCopied, "wouldLink", destStats[i].filesLinked, "wouldMove", destStats[i].filesMoved, "wouldDelete", destStats[i].filesDeleted, "unchanged", destStats[i].filesUnchanged, "failed", destStats[i].filesFailed, "deferred", destStats[i].filesDeferred,
+				"scanDuration", destStats[i].scanDuration, "sourceScanDuration", destStats[i].srcScanDuration, "destinationScanDuration", destStats[i].destScanDuration,
+				"planDuration", destStats[i].planDuration, "transferDuration", destStats[i].transferDuration)
+		} else if destStats[i].hasChanges() || configs.General.LogIdleIterations {
+			// report the totals of the iteration, if anything happened (or when requested)
+			configs.General.logger.Info("Summary", append([]any{"destination", destConfigs.General.DestinationDirectory, "scannedSource", destStats[i].filesScannedSource, "scannedDestination", destStats[i].filesScannedDest,
+				"copied", destStats[i].filesCopied, "copiedBytes", destStats[i].bytesCopied, "verifiedBytes", destStats[i].bytesVerified, "linked", destStats[i].filesLinked, "cloned", destStats[i].filesCloned, "moved", destStats[i].filesMoved, "deleted", destStats[i].filesDeleted, "unchanged", destStats[i].filesUnchanged, "failed", destStats[i].filesFailed, "deferred", destStats[i].filesDeferred,
+				"drifted", destStats[i].filesDrifted, "scanDuration", destStats[i].scanDuration, "sourceScanDuration", destStats[i].srcScanDuration,
+				"destinationScanDuration", destStats[i].destScanDuration, "planDuration", destStats[i].planDuration, "transferDuration", destStats[i].transferDuration, "bytesPerSecond", destStats[i].getBytesPerSecond(),
+				"largestFileBytes", destStats[i].largestFileBytes, "largestFile", destStats[i].largestFilePath}, getSpaceDetails(destStats[i])...)...)
+		}
+
+		// notify about the iteration, if requested
+		notifyIteration(destConfigs, destStats[i])
+
+		stats.add(destStats[i])
+	}
+
+	// keep the hashes of files for the next iterations
+	configs.General.hashes.save(configs.General.logger, fullScan)
+
+	emitIterationEvent(configs, eventActionIterationEnd, stats, time.Since(start))
+	// a job whose iterations keep failing is stopped
+	recordIterationHealth(configs, stats)
+	// while an iteration which succeeded refreshes the health file
+	writeHealthFile(configs, stats)
+
+	if fullScan {
+		runPostSyncHook(ctx, configs, stats)
+	}
+
+	configs.General.metrics.recordIteration(stats, time.Since(start))
+	configs.General.totals.recordIteration(stats)
+	configs.General.status.recordIteration(stats)
+
+	return stats
+}
+
+// excludeUnmirroredFiles removes the files which are never mirrored from the scanned files of a destination
+func excludeUnmirroredFiles(configs Config, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) {
+	// unreadable entries are not mirrored, and their counterparts must be left alone (on both sides, in bidirectional mode)
+	if configs.General.SyncMode == syncModeBidirectional {
+		excludeUnreadableFiles(destFiles, srcFiles)
+	}
+	excludeUnreadableFiles(srcFiles, destFiles)
+	// remove temporary files left over by a previous run, so they are neither mirrored nor planned as deletions
+	cleanupTempFiles(configs, srcFiles, destFiles)
+	// partial files of interrupted copies are kept (while their source exists) to resume the copy
+	excludePartialFiles(configs, srcFiles, destFiles)
+	// so are the copies of destination files which conflicted with their source files (in bidirectional mode, they are mirrored back)
+	if configs.General.SyncMode != syncModeBidirectional {
+		excludeConflictFiles(srcFiles, destFiles)
+	}
+	// paths used by the mirror itself inside the destination directory must be left alone
+	excludeInternalPaths(configs, destFiles)
+	// and so are the lock files of the directories, which belong to the instances mirroring into them
+	excludeLockFiles(srcFiles, destFiles)
+	// as are clock probes
+	excludeClockProbeFiles(srcFiles, destFiles)
+	// the manifest of the destination is its own, so the one of the source is not mirrored
+	excludeManifestFile(configs, srcFiles)
+}
+
+func runJobs(ctx context.Context, configs Config, jobFuncs []func(), wg *sync.WaitGroup) {
+	// count the operations as queued
+	configs.General.metrics.addQueued(int64(len(jobFuncs)))
+
+	// wait for these operations only, since the WaitGroup of the iteration counts the operations of its later phases too
+	var done sync.WaitGroup
+	done.Add(len(jobFuncs))
+
+	// wrap every operation, so operations which did not start yet are skipped once termination is requested or the job failed (in-flight
+	// operations are finished)
+	for i, jobFunc := range jobFuncs {
+		// cache the operation locally, so the wrapper will not run a different one
+		job := jobFunc
+		jobFuncs[i] = func() {
+			// the operation is no longer queued once it ends (or is skipped), and its place within the total workers limit is freed
+			defer configs.General.metrics.addQueued(-1)
+			defer configs.General.totalWorkers.release()
+			defer done.Done()
+
+			if failed, _ := configs.General.control.isFailed(); ctx.Err() != nil || failed {
+				// operation skipped, so count as -1 in WaitGroup counter (and release the operations which may wait for it, e.g. the links
+				// of a skipped copy)
+				configs.General.control.skipOperation()
+				wg.Done()
+				return
+			}
+
+			job()
+		}
+	}
+
+	// check if concurrent workers limit is set (0 to disable)
+	if configs.General.MaxConcurrentWorkers < 1 {
+		// no limit, so run every operation in its own goroutine
+		for _, jobFunc := range jobFuncs {
+			// the operations still share the total workers limit of all jobs, which they take in their order
+			configs.General.totalWorkers.acquire()
+
+			// to allow for concurrent processing, run operation in new coroutine
+			go jobFunc()
+		}
+
+		// wait for all created jobs to end
+		done.Wait()
+	} else {
+		// run the operations on the workers of the job, which are limited to the concurrent workers count
+		configs.General.workers.schedule(configs.General.totalWorkers, jobFuncs)
+
+		// wait for all scheduled jobs to end
+		done.Wait()
+	}
+}
+
+func processChanges(ctx context.Context, configs Config, stats *iterationStats, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo, fullScan bool, wg *sync.WaitGroup) ([]func(), []func()) {
+	// create a container for operations, and for the deletions (which may run once all other operations ended)
+	var jobFunctions []func()
+	var deleteFunctions []func()
+
+	// remove any filtered (excluded, not included or out of size limits) paths from both containers, so such files are neither copied nor deleted
+	filterFiles(configs, srcFiles, destFiles, fullScan, wg)
+	// source directories which hold no files are only created in the destination if requested
+	dropEmptyDirs(configs, srcFiles, destFiles, wg)
+
+	// count of destination files, used to check the deletion safety threshold (a partial set of targeted paths is no reference for a percentage)
+	// (a scan which kept only the differences counts the matching destination entries it left out)
+	destTotal := 0
+	if fullScan {
+		destTotal = len(destFiles) + int(stats.destMatched)
+	}
+
+	// detect files which were moved (or renamed) in the source, so they are moved in the destination instead of being copied again
+	moves := detectMoves(configs, srcFiles, destFiles, wg)
+
+	// detect hard links between source files, so they are linked in the destination instead of being copied again (a moved file is moved rather than linked)
+	links := getHardLinks(configs, srcFiles)
+	for srcPath := range moves {
+		delete(links, srcPath)
+	}
+	// copies which do not fit the free space of the destination are left for a later iteration, if requested
+	checkFreeSpace(configs, stats, srcFiles, destFiles, moves, links, wg)
+
+	// links are made once their target was written, so their operations run after all others, and every target signals its end
+	var linkFunctions []func()
+	targetsDone := make(map[string]chan struct{})
+	for _, targetPath := range links {
+		if _, exists := targetsDone[targetPath]; !exists {
+			targetsDone[targetPath] = make(chan struct{})
+		}
+	}
+
+	// iterate every file in source directory (in the copy order), and mirror any changes to destination directory
+	for _, srcPath := range getCopyOrder(configs, srcFiles) {
+		srcFile := srcFiles[srcPath]
+
+		// since we will write any updates of the specific path to the destination directory, should remove any idential (relative) path
+		// in destination files container so it will not be mistakenly removed later (any files in destFiles container will later be removed)
+		if _, exists := destFiles[srcPath]; exists {
+			delete(destFiles, srcPath)
+
+			// since we remove record from container, count as -1 in WaitGroup counter
+			wg.Done()
+		}
+
+		// since operation context will run at later time, parameters must be cached locally otherwise when the function executes, it will be called with corrupted data
+		p1 := filepath.Join(configs.General.SourceDirectory, srcPath)
+		p2 := srcFile
+		p3 := filepath.Join(configs.General.DestinationDirectory, srcPath)
+		// signal of the end of the operation, if the file is the target of hard links
+		done := targetsDone[srcPath]
+
+		// check if the file is a hard link of another source file
+		if targetPath, exists := links[srcPath]; exists {
+			p4 := filepath.Join(configs.General.DestinationDirectory, targetPath)
+			p5 := targetsDone[targetPath]
+
+			// append 'link' operation to the list of link functions
+			linkFunctions = append(linkFunctions, func() {
+				// signal job done at end of func
+				defer wg.Done()
+
+				// run the operation with cached values (retrying on failure), a failure is recorded and the remaining operations continue
+				err := retryOperation(ctx, configs, "Link", p3, func() error {
+					return linkFile(ctx, configs, stats, p1, p2, p4, p5, p3)
+				})
+				if err != nil {
+					recordOperationFailure(configs, stats, "Link", p3, err)
+				}
+			})
+			continue
+		}
+
+		// check if the file was moved from another destination path
+		if moved, exists := moves[srcPath]; exists {
+			p4 := filepath.Join(configs.General.DestinationDirectory, moved.path)
+			p5 := moved.info
+
+			// append 'move' operation to functions list
+			jobFunctions = append(jobFunctions, func() {
+				// signal job done at end of func
+				defer wg.Done()
+				// signal the links of the file at end of func
+				if done != nil {
+					defer close(done)
+				}
+
+				// run the operation with cached values (retrying on failure), a failure is recorded and the remaining operations continue
+				err := retryOperation(ctx, configs, "Move", p3, func() error {
+					return moveDestFile(ctx, configs, stats, p1, p2, p4, p5, p3)
+				})
+				if err != nil {
+					recordOperationFailure(configs, stats, "Move", p3, err)
+				}
+			})
+			continue
+		}
+
+		// append 'write' operation to functions list
+		jobFunctions = append(jobFunctions, func() {
+			// signal job done at end of func
+			defer wg.Done()
+			// signal the links of the file at end of func
+			if done != nil {
+				defer close(done)
+			}
+
+			// run the operation with cached values (retrying on failure), a failure is recorded and the remaining operations continue
+			err := retryOperation(ctx, configs, "Write", p3, func() error {
+				return writeFile(ctx, configs, stats, p1, p2, p3)
+			})
+			if err != nil {
+				recordOperationFailure(configs, stats, "Write", p3, err)
+			}
+		})
+	}
+
+	// links are scheduled after their targets, so a running link never waits for a target which did not start yet
+	jobFunctions = append(jobFunctions, linkFunctions...)
+
+	// files which were not missing from the source for enough consecutive scans are kept for now
+	// (since we remove records from container, count them as -1 each in WaitGroup counter)
+	wg.Add(-configs.General.missing.keepRecent(configs, srcFiles, destFiles, fullScan))
+
+	// make sure the planned deletions are within the safety threshold (e.g. an unmounted source would otherwise wipe the destination), otherwise skip the deletion phase
+	if !isDeletionAllowed(configs, len(destFiles), destTotal) {
+		stats.deletionsSkipped = int64(len(destFiles))
+
+		for dstPath := range destFiles {
+			configs.General.logger.Debug("Keep", "path", filepath.Join(configs.General.DestinationDirectory, dstPath), "reason", "deletions skipped")
+
+			delete(destFiles, dstPath)
+
+			// since we remove record from container, count as -1 in WaitGroup counter
+			wg.Done()
+		}
+	}
+
+	// a removed directory takes its contents along, so only the topmost removed paths are removed (removing the contents concurrently would race the directory removal)
+	for dstPath := range destFiles {
+		for dir := filepath.Dir(dstPath); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			if parent, exists := destFiles[dir]; exists && parent.IsDir() {
+				delete(destFiles, dstPath)
+
+				// since we remove record from container, count as -1 in WaitGroup counter
+				wg.Done()
+				break
+			}
+		}
+	}
+
+	// any files which still remain in destFiles array, should be removed since no reference of them was iterated previously in srcFiles array
+	// (or since they expired, which is logged distinctly)
+	now := time.Now()
+	for dstPath, dstFile := range destFiles {
+		// since operation context will run at later time, parameters must be cached locally otherwise when the function executes, it will be called with corrupted data
+		p1 := dstFile
+		p2 := filepath.Join(configs.General.DestinationDirectory, dstPath)
+		operation, reason := "Remove", "source missing"
+		if isExpired(configs.General, dstFile, now) {
+			operation, reason = "Expire", "expired"
+		}
+
+		configs.General.logger.Debug("Changed", "path", p2, "reason", reason)
+
+		// append 'delete' operation to deletions list
+		deleteFunctions = append(deleteFunctions, func() {
+			// signal job done at end of func
+			defer wg.Done()
+
+			// run the operation with cached values (retrying on failure), a failure is recorded and the remaining operations continue
+			err := retryOperation(ctx, configs, operation, p2, func() error {
+				return deleteFile(ctx, configs, stats, p1, p2, operation)
+			})
+			if err != nil {
+				recordOperationFailure(configs, stats, operation, p2, err)
+			}
+		})
+	}
+
+	return jobFunctions, deleteFunctions
+}
+
+func filterFiles(configs Config, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo, fullScan bool, wg *sync.WaitGroup) {
+	// nothing to filter
+	scope := newPathScope(configs.General)
+	if len(configs.General.ExcludePatterns) < 1 && len(configs.General.IncludePatterns) < 1 && configs.General.MaxFileSizeMB < 1 && configs.General.MinFileSizeKB < 1 && !scope.isLimited() &&
+		!configs.General.RespectMirrorIgnore && configs.General.InvalidNameHandling != invalidNameHandlingSkip && configs.General.MaxSourceAgeDays < 1 &&
+		configs.General.DestinationRetentionDays < 1 {
+		return
+	}
+
+	// create a container for source files skipped by their size, whose destination files are left alone too
+	sizeSkipped := make(map[string]bool)
+	// and one for source files skipped by their age, whose destination files are left alone unless they expired
+	ageSkipped := make(map[string]bool)
+	now := time.Now()
+
+	// the reason of every filtered file is logged in debug level only
+	debug := isDebugEnabled(configs.General.logger)
+
+	// ignore filtered source files, they will not be copied
+	for srcPath, srcFile := range srcFiles {
+		reason, pattern := getFilterReason(configs.General, srcPath, srcFile.IsDir())
+		filtered := len(reason) > 0
+		if filtered && debug {
+			logFilterDecision(configs.General.logger, "Skip", filepath.Join(configs.General.SourceDirectory, srcPath), reason, pattern)
+		}
+
+		if sizeReason := getSizeFilterReason(configs.General, srcFile); !filtered && len(sizeReason) > 0 {
+			filtered = true
+			sizeSkipped[srcPath] = true
+
+			// log a skipped file once, rather than on every iteration
+			if configs.General.skipped.add(srcPath, srcFile.Size()) {
+				configs.General.logger.Debug("Skip", "path", filepath.Join(configs.General.SourceDirectory, srcPath), "reason", sizeReason, "size", srcFile.Size())
+				emitSkipEvent(configs, srcPath, srcFile.Size(), sizeReason)
+			}
+		}
+
+		if ageReason := getAgeFilterReason(configs.General, srcFile, now); !filtered && len(ageReason) > 0 {
+			filtered = true
+			ageSkipped[srcPath] = true
+
+			// log a skipped file once, rather than on every iteration
+			if configs.General.skipped.add(srcPath, srcFile.Size()) {
+				configs.General.logger.Debug("Skip", "path", filepath.Join(configs.General.SourceDirectory, srcPath), "reason", ageReason, "modTime", srcFile.ModTime())
+				emitSkipEvent(configs, srcPath, srcFile.Size(), ageReason)
+			}
+		}
+
+		if filtered {
+			delete(srcFiles, srcPath)
+
+			// since we remove record from container, count as -1 in WaitGroup counter
+			wg.Done()
+		}
+	}
+
+	// forget skipped files which are gone, so they are logged again once they reappear (a partial set of targeted paths is no reference)
+	if fullScan {
+		skippedPaths := make(map[string]bool, len(sizeSkipped)+len(ageSkipped))
+		for srcPath := range sizeSkipped {
+			skippedPaths[srcPath] = true
+		}
+		for srcPath := range ageSkipped {
+			skippedPaths[srcPath] = true
+		}
+		configs.General.skipped.retain(skippedPaths)
+	}
+
+	// filtered destination files should be left alone, so also keep any parent directory of them from being removed
+	protectedDirs := make(map[string]bool)
+	for dstPath, dstFile := range destFiles {
+		reason, pattern := getFilterReason(configs.General, dstPath, dstFile.IsDir())
+		if len(reason) < 1 && sizeSkipped[dstPath] {
+			reason = "source skipped by size"
+		}
+		if len(reason) < 1 {
+			reason = getSizeFilterReason(configs.General, dstFile)
+		}
+		// a destination file old enough to be filtered is left alone too, unless it expired (as does the copy of a source file skipped by its age)
+		if len(reason) < 1 && !isExpired(configs.General, dstFile, now) {
+			if ageSkipped[dstPath] {
+				reason = "source skipped by age"
+			} else {
+				reason = getAgeFilterReason(configs.General, dstFile, now)
+			}
+		}
+
+		if len(reason) > 0 {
+			if debug {
+				logFilterDecision(configs.General.logger, "Keep", filepath.Join(configs.General.DestinationDirectory, dstPath), reason, pattern)
+			}
+
+			delete(destFiles, dstPath)
+
+			// since we remove record from container, count as -1 in WaitGroup counter
+			wg.Done()
+
+			// mark every parent directory as protected
+			for dir := path.Dir(normalizeRelativePath(dstPath)); dir != "."; dir = path.Dir(dir) {
+				protectedDirs[dir] = true
+			}
+		}
+	}
+
+	// so is a directory whose contents are partly out of scope (which are not even scanned), along with its parent directories
+	for dstPath, dstFile := range destFiles {
+		if dstFile.IsDir() && !scope.isComplete(dstPath) {
+			for dir := normalizeRelativePath(dstPath); dir != "."; dir = path.Dir(dir) {
+				protectedDirs[dir] = true
+			}
+		}
+	}
+
+	// a protected directory must not be removed (which would remove its filtered contents), but it is still left untouched if it exists in the source
+	for dstPath := range destFiles {
+		if protectedDirs[normalizeRelativePath(dstPath)] {
+			if _, exists := srcFiles[dstPath]; !exists {
+				configs.General.logger.Debug("Keep", "path", filepath.Join(configs.General.DestinationDirectory, dstPath), "reason", "contents filtered")
+
+				delete(destFiles, dstPath)
+
+				// since we remove record from container, count as -1 in WaitGroup counter
+				wg.Done()
+			}
+		}
+	}
+}
+
+func validateDirExistance(configs Config, stats *iterationStats, srcPath, destPath string) error {
+	// get source file info
+	srcPathInfo, err := configs.General.source.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+
+	// make sure directory has been specified
+	if srcPathInfo.IsDir() {
+		// a symlink in the destination (e.g. left over by a different symlink mode) must be replaced by a directory, rather than written through
+		if destPathInfo, err := configs.General.destination.Lstat(destPath); err == nil && isSymlink(destPathInfo) && !configs.General.DryRun {
+			if err := configs.General.destination.Remove(destPath); err != nil {
+				return err
+			}
+		}
+
+		if destPathInfo, err := configs.General.destination.Lstat(destPath); err == nil {
+			// no error, so directory exists, but make sure it matches the source directory permissions
+			if !isSamePermissions(configs, srcPathInfo, destPathInfo) {
+				if err := chmodFile(configs, srcPathInfo, destPath); err != nil {
+					return err
+				}
+			}
+
+			// in dry run mode, the destination must not be touched
+			if configs.General.DryRun {
+				return nil
+			}
+
+			// make sure it matches the source directory owner, if requested
+			if err := preserveOwnership(configs, stats, srcPathInfo, destPath); err != nil {
+				return err
+			}
+			// make sure it matches the source directory ACLs and attributes, if requested
+			if err := preserveSecurity(configs, stats, srcPath, srcPathInfo, destPath); err != nil {
+				return err
+			}
+			// make sure it matches the source directory creation time, if requested
+			return preserveCreationTime(configs, srcPathInfo, destPath)
+		} else if errors.Is(err, fs.ErrNotExist) { // check if the error is of expected type (ErrNotExist)
+			// in dry run mode, only report the directory would be created
+			if configs.General.DryRun {
+				configs.General.logger.Info("WOULD Write", "path", destPath)
+				return nil
+			}
+
+			// directory does not exist, so create it with source directory permissions
+			err = configs.General.destination.MkdirAll(destPath, srcPathInfo.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			// set same owner as source directory, if requested
+			if err := preserveOwnership(configs, stats, srcPathInfo, destPath); err != nil {
+				return err
+			}
+			// set same ACLs and attributes as source directory, if requested
+			if err := preserveSecurity(configs, stats, srcPath, srcPathInfo, destPath); err != nil {
+				return err
+			}
+			// set same creation time as source directory, if requested
+			if err := preserveCreationTime(configs, srcPathInfo, destPath); err != nil {
+				return err
+			}
+
+			configs.General.logger.Info("Write", "path", destPath)
+			emitEvent(configs, eventActionMkdir, destPath, 0, 0, nil)
+			return nil
+		} else {
+			// unexpected error
+			return err
+		}
+	}
+
+	// extract file's parent directory name from provided path, and validate its existance
+	return validateDirExistance(configs, stats, filepath.Dir(srcPath), filepath.Dir(destPath))
+}
+
+// syncDirTimes sets the modification times of the source directories on the destination directories which differ, or whose contents were
+// written or deleted by the iteration (the parent directories of its paths). the deepest directories are synced first, so their parents are
+// synced once nothing below them changes anymore
+func syncDirTimes(configs Config, stats *iterationStats, srcFiles map[string]os.FileInfo, destFiles map[string]os.FileInfo) {
+	// in dry run mode, the destination must not be touched
+	if configs.General.DryRun || !configs.General.PreserveDirTimes {
+		return
+	}
+
+	// get the touched directories, the info of those which are not source paths themselves is read below
+	dirs := make(map[string]os.FileInfo)
+	for srcPath, srcFile := range srcFiles {
+		if srcFile.IsDir() && !isSymlink(srcFile) {
+			dirs[srcPath] = srcFile
+		}
+	}
+	for _, files := range []map[string]os.FileInfo{srcFiles, destFiles} {
+		for relativePath := range files {
+			if dir := filepath.Dir(relativePath); dir != "." {
+				if _, exists := dirs[dir]; !exists {
+					dirs[dir] = nil
+				}
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		paths = append(paths, dir)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if depth := strings.Count(paths[i], string(os.PathSeparator)) - strings.Count(paths[j], string(os.PathSeparator)); depth != 0 {
+			return depth > 0
+		}
+		return paths[i] < paths[j]
+	})
+
+	for _, srcPath := range paths {
+		// a directory which is missing from the source (e.g. it was deleted meanwhile) has no time to sync
+		srcFile := dirs[srcPath]
+		if srcFile == nil {
+			file, err := configs.General.source.Lstat(filepath.Join(configs.General.SourceDirectory, srcPath))
+			if err != nil || !file.IsDir() {
+				continue
+			}
+			srcFile = file
+		}
+
+		// nothing to do if the directory is missing (its creation failed), or its modification time already matches
+		path := filepath.Join(configs.General.DestinationDirectory, srcPath)
+		file, err := configs.General.destination.Lstat(path)
+		if err != nil || !file.IsDir() || isSameModTime(configs, srcFile.ModTime(), file.ModTime()) {
+			continue
+		}
+
+		if err := configs.General.destination.Chtimes(path, srcFile.ModTime(), srcFile.ModTime()); err != nil {
+			recordOperationFailure(configs, stats, "Write", path, err)
+		}
+	}
+}
+
+// preservePermissions sets the permissions of the source file on the destination file, if requested
+func preservePermissions(configs Config, srcFile os.FileInfo, path string) error {
+	if !configs.General.PreservePermissions {
+		return nil
+	}
+
+	return configs.General.destination.Chmod(path, srcFile.Mode().Perm())
+}
+
+// chmodFile updates the permissions of an existing destination file (or directory) whose contents are unchanged, to those of the source file
+func chmodFile(configs Config, srcFile os.FileInfo, path string) error {
+	mode := srcFile.Mode().Perm()
+
+	// in dry run mode, only report the permissions would be changed
+	if configs.General.DryRun {
+		configs.General.logger.Info("WOULD Chmod", "path", path, "mode", mode)
+		return nil
+	}
+
+	if err := configs.General.destination.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	configs.General.logger.Info("Chmod", "path", path, "mode", mode)
+	emitEvent(configs, eventActionChmod, path, 0, 0, nil)
+	return nil
+}
+
+// deferUnstableFile leaves the source file for a later iteration, since it is still written (or locked) by another process
+func deferUnstableFile(configs Config, stats *iterationStats, srcPath string, srcFile os.FileInfo, reason string) {
+	stats.addDeferred(getRelativePath(configs.General.SourceDirectory, srcPath))
+
+	configs.General.logger.Debug("Skip", "path", srcPath, "reason", reason)
+	emitSkipEvent(configs, getRelativePath(configs.General.SourceDirectory, srcPath), srcFile.Size(), reason)
+}
+
+func writeFile(ctx context.Context, configs Config, stats *iterationStats, srcPath string, srcFile os.FileInfo, path string) error {
+	// symlinks are handled by the configured symlink mode (in follow mode, the source file is the symlink target rather than the symlink)
+	if isSymlink(srcFile) {
+		return writeSymlink(configs, stats, srcPath, srcFile, path)
+	}
+
+	// make sure destination directory exists (in dry run mode, parent directories are reported by their own operation)
+	if !configs.General.DryRun || srcFile.IsDir() {
+		if err := validateDirExistance(configs, stats, srcPath, path); err != nil {
+			return err
+		}
+	}
+
+	// ignore directories
+	if srcFile.IsDir() {
+		return nil
+	}
+
+	// a recently modified file may still be written by another process, so leave it for a later iteration, once it settles (a file modified
+	// in the future, e.g. by a source with a skewed clock, counts as settled, otherwise it would never be mirrored)
+	if age := time.Since(srcFile.ModTime()); configs.General.MinFileAgeSeconds > 0 && age >= 0 && age < time.Duration(configs.General.MinFileAgeSeconds)*time.Second {
+		stats.addDeferred(getRelativePath(configs.General.SourceDirectory, srcPath))
+
+		configs.General.logger.Debug("Skip", "path", srcPath, "reason", "recently modified", "age", age)
+		emitSkipEvent(configs, getRelativePath(configs.General.SourceDirectory, srcPath), srcFile.Size(), "recently modified")
+		return nil
+	}
+
+	srcFileModTime := srcFile.ModTime()
+	// reason the file should be copied, used for verbose logging
+	reason := "destination missing"
+	// whether an existing destination file will be overwritten, and its info
+	overwrite := false
+	var destFile os.FileInfo
+	// check destination file (a symlink in the destination is never followed, so it is replaced by the copy)
+	if file, err := configs.General.destination.Lstat(path); err == nil && isSymlink(file) {
+		reason = "destination is a symlink"
+
+		if !configs.General.DryRun {
+			if err := configs.General.destination.Remove(path); err != nil {
+				return err
+			}
+		}
+	} else if err == nil {
+		// file exists, but compare it against source file using the configured compare mode
+		reason, err = getChangeReason(configs, srcPath, srcFile, path, file)
+		if err != nil {
+			return err
+		}
+		// a file which was modified out of band is restored when the destination is enforced, even if the comparison sees no change
+		if drift := checkDrift(configs, stats, path, srcFile, file); len(drift) > 0 && len(reason) < 1 && configs.General.EnforceDestination {
+			reason = drift
+		}
+		if len(reason) < 1 {
+			// the contents are unchanged, yet the permissions could have changed alone, which needs no copy
+			if !isSamePermissions(configs, srcFile, file) {
+				if err := chmodFile(configs, srcFile, path); err != nil {
+					return err
+				}
+			}
+
+			// file is unchanged (it is hashed for the manifest, unless listed already), and tracked from now on if it was not yet (e.g. it was
+			// in sync when the job started)
+			stats.addUnchanged()
+			configs.General.written.record(path, srcFile)
+			configs.General.manifests.keep(configs, path, file)
+
+			configs.General.logger.Debug("Unchanged", "path", path, "reason", getUnchangedReason(configs))
+			return nil
+		}
+
+		overwrite = true
+		destFile = file
+
+		// a destination file changed on its own (e.g. edited in place) is only replaced if the overwrite policy allows it
+		if conflict := getOverwriteConflict(configs, srcFile, file); len(conflict) > 0 {
+			replace, err := resolveConflict(configs, srcPath, srcFile, path, file, conflict)
+			if err != nil || !replace {
+				return err
+			}
+
+			// the destination file was kept as its conflict copy, so the source file is written as a new file
+			overwrite = false
+			destFile = nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) { // check if the error is of expected type (ErrNotExist)
+		// unexpected error
+		return err
+	} else {
+		// a file which was deleted out of band is restored as any missing file, yet it is reported
+		checkDrift(configs, stats, path, srcFile, nil)
+	}
+
+	if isDebugEnabled(configs.General.logger) {
+		configs.General.logger.Debug("Changed", append([]any{"path", path, "reason", reason}, getChangeDetails(reason, srcFile, destFile)...)...)
+	}
+
+	// in snapshot mode, a file unchanged since the latest snapshot is linked from it rather than copied
+	if linked, err := linkFromSnapshot(configs, stats, srcPath, srcFile, path, overwrite); err != nil || linked {
+		return err
+	}
+
+	// in dry run mode, only report the file would be copied
+	if configs.General.DryRun {
+		stats.addCopied(path, srcFile.Size())
+
+		configs.General.logger.Info("WOULD Write", "path", path, "bytes", srcFile.Size())
+		return nil
+	}
+
+	// a file which changed since it was scanned is still written by another process, so leave it for a later iteration, once it is stable
+	if configs.General.SkipUnstableFiles {
+		if current, err := configs.General.source.Stat(srcPath); err == nil && (current.Size() != srcFile.Size() || !current.ModTime().Equal(srcFile.ModTime())) {
+			deferUnstableFile(configs, stats, srcPath, srcFile, "changed since scanned")
+			return nil
+		}
+	}
+
+	// once a copy ran out of space, the copies which follow would fail the same way
+	if isOutOfSpace(stats) {
+		deferUnstableFile(configs, stats, srcPath, srcFile, "out of space")
+		return nil
+	}
+
+	// on the file system of the source, the file could be cloned (reflinked or hard linked) instead of copied
+	if cloned, err := cloneFile(configs, stats, srcPath, srcFile, path, overwrite); err != nil || cloned {
+		if err == nil {
+			configs.General.written.record(path, srcFile)
+			configs.General.manifests.record(configs, path, nil)
+		}
+		return err
+	}
+
+	// the duration of the copy is reported with its event
+	start := time.Now()
+
+	// with atomic writes, the file is completely written into a temporary file which then replaces the destination file,
+	// so readers of the destination never observe a partial file
+	writePath := path
+	options := getCopyOptions(configs)
+	options.srcFile = srcFile
+	// a durable copy is flushed to stable storage before its modification time is set (as is any atomically written copy, so the rename
+	// never replaces a file by one whose contents are lost)
+	options.syncToDisk = configs.General.AtomicWrites || isDurableCopy(configs, srcFile)
+	// the contents are hashed for the manifest while they are copied, so the written file is not read again (and the copy is verified by
+	// the same hash)
+	if configs.General.manifests != nil {
+		options.hash = newHash(configs.General.ManifestHashAlgorithm)
+		options.hashAlgorithm = configs.General.ManifestHashAlgorithm
+	}
+	if configs.General.ResumePartialCopies && srcFile.Size() >= resumeMinFileSize {
+		// a large file is written into a partial file, which is kept on failure so a later copy continues where this one stopped
+		writePath = getPartialPath(path)
+
+		offset, err := getResumeOffset(configs.General.destination, writePath, srcFile)
+		if err != nil {
+			return err
+		}
+		if offset > 0 {
+			configs.General.logger.Info("Resume", "path", path, "offset", offset)
+		}
+
+		options.resumeOffset = offset
+		options.keepPartial = true
+	} else if configs.General.AtomicWrites {
+		writePath = getTempPath(path)
+
+		// make sure the temporary file does not survive a failure (after a successful rename, there is nothing left to remove)
+		defer configs.General.destination.Remove(writePath)
+	}
+
+	// a destination file which is a hard link of the source file (e.g. created in hardlink copy mode) must not be truncated, since that truncates the source file too
+	if overwrite && writePath == path && os.SameFile(destFile, srcFile) {
+		if err := configs.General.destination.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	// when writing in place, the existing file must be backed up before it is truncated
+	if overwrite && writePath == path {
+		if err := backupFile(configs, path); err != nil {
+			return err
+		}
+	}
+
+	// at this point, file does not exist (or removed previously) so create it (copy source file)
+	if err := copyFile(ctx, srcPath, writePath, options); err != nil {
+		// a file locked by another process is copied by a later iteration, once it is released
+		if configs.General.SkipUnstableFiles && isLockedFileError(err) {
+			deferUnstableFile(configs, stats, srcPath, srcFile, "locked")
+			return nil
+		}
+		recordOutOfSpace(configs, stats, path, err)
+		return err
+	}
+	// set same permission as source file, if requested
+	if err := preservePermissions(configs, srcFile, writePath); err != nil {
+		return err
+	}
+	// set same owner as source file, if requested
+	if err := preserveOwnership(configs, stats, srcFile, writePath); err != nil {
+		return err
+	}
+	// set same ACLs and attributes as source file, if requested
+	if err := preserveSecurity(configs, stats, srcPath, srcFile, writePath); err != nil {
+		return err
+	}
+	// set same creation time as source file, if requested
+	if err := preserveCreationTime(configs, srcFile, writePath); err != nil {
+		return err
+	}
+	// set same 'last modified' value as source file so it wont be falsely detected as 'changed' on next iteration
+	if err := configs.General.destination.Chtimes(writePath, srcFileModTime, srcFileModTime); err != nil {
+		return err
+	}
+	// replace the destination file with the completed temporary file (backing up the existing file first)
+	if writePath != path {
+		if overwrite {
+			if err := backupFile(configs, path); err != nil {
+				return err
+			}
+		}
+
+		if err := configs.General.destination.Rename(writePath, path); err != nil {
+			return err
+		}
+
+		// the completed partial file no longer needs its sidecar
+		if options.keepPartial {
+			configs.General.destination.Remove(getSidecarPath(writePath))
+		}
+	}
+	// a durable copy survives a power loss along with its entry in the directory
+	if isDurableCopy(configs, srcFile) {
+		if err := syncDir(configs.General.destination, filepath.Dir(path)); err != nil {
+			return err
+		}
+	}
+
+	stats.addCopied(path, srcFile.Size())
+	// remember what was written, so a later change of the destination file is told apart as drift
+	configs.General.written.record(path, srcFile)
+	configs.General.manifests.record(configs, path, options.hash)
+	// the whole written file was read again to verify it
+	if configs.General.VerifyAfterCopy {
+		stats.addVerified(srcFile.Size())
+	}
+
+	configs.General.logger.Info("Write", "path", path)
+	emitEvent(configs, eventActionWrite, path, srcFile.Size(), time.Since(start), nil)
+	return nil
+}
+
+// seekableFile is a file which can be read from any offset
+type seekableFile interface {
+	io.Reader
+	io.Seeker
+	io.ReaderAt
+}
+
+// copyOptions controls how the contents of a file are copied, the zero value copies without any extras
+type copyOptions struct {
+	// flush the contents to stable storage before the copy is complete
+	syncToDisk bool
+	// limiter of the throughput, nil for unlimited
+	limiter *bandwidthLimiter
+	// pool of copy buffers, nil for the default pool
+	buffers *sync.Pool
+	// logger of the progress of files of at least the threshold size, nil for no progress
+	logger            *slog.Logger
+	progressThreshold int64
+	// re-read the written file and compare it against the source contents, deleting it on mismatch
+	verify bool
+	// hash of the source contents, written as they are copied (nil for none), and the hash algorithm of the verification (which is the one
+	// of the hash, if any)
+	hash          hash.Hash
+	hashAlgorithm string
+	// offset to continue an interrupted copy from, the destination contents before it are kept
+	resumeOffset int64
+	// keep the destination file on failure (unless its contents are wrong), so the copy can be resumed
+	keepPartial bool
+	// abandon the copy once it transferred no bytes for this long, 0 for never
+	idleTimeout time.Duration
+	// allocate a local destination file to its final size before copying into it
+	preallocate bool
+	// files of at least the threshold size are copied in chunks of the size by concurrent streams, 0 streams for none
+	chunkThreshold int64
+	chunkSize      int64
+	chunkStreams   int
+	// counter of the transferred bytes of a copy watched for its idle timeout, nil for none
+	transfer *idleTransfer
+	// info of the source file as it was scanned, so its type is known without reading it again (nil to read it). its size is read from the
+	// opened file, which could have changed since it was scanned
+	srcFile os.FileInfo
+	// file systems of the source file and of the destination file, nil for the local file system
+	source      readableFS
+	destination destinationFS
+}
+
+func getCopyOptions(configs Config) copyOptions {
+	options := copyOptions{
+		syncToDisk:    configs.General.AtomicWrites,
+		limiter:       configs.General.limiter,
+		buffers:       configs.General.buffers,
+		verify:        configs.General.VerifyAfterCopy,
+		hashAlgorithm: configs.General.HashAlgorithm,
+		preallocate:   configs.General.Preallocate,
+		idleTimeout:   time.Duration(configs.General.OperationTimeoutSeconds) * time.Second,
+		source:        configs.General.source,
+		destination:   configs.General.destination,
+	}
+
+	// copy large files by concurrent streams, if requested
+	if chunks := configs.General.ParallelChunkCopy; chunks != nil {
+		options.chunkThreshold = int64(chunks.ThresholdMB) * 1024 * 1024
+		options.chunkSize = int64(chunks.ChunkMB) * 1024 * 1024
+		options.chunkStreams = chunks.Streams
+	}
+
+	// report progress of large files, if requested
+	if configs.General.ProgressThresholdMB > 0 {
+		options.logger = configs.General.logger
+		options.progressThreshold = int64(configs.General.ProgressThresholdMB) * 1024 * 1024
+	}
+
+	return options
+}
+
+// copyFile copies the contents of the source file into the destination file. a copy interrupted by the context stops between chunks, and
+// its partial destination file is removed as on any failure (unless it is kept to resume the copy). a copy with an idle timeout is abandoned
+// once it stalls
+func copyFile(ctx context.Context, src string, dst string, options copyOptions) error {
+	if options.idleTimeout > 0 {
+		return copyFileWithIdleTimeout(ctx, src, dst, options)
+	}
+
+	srcFS := options.source
+	if srcFS == nil {
+		srcFS = localFS{}
+	}
+
+	// try to get source file info, unless it is known already
+	sourceFileStat := options.srcFile
+	if sourceFileStat == nil {
+		var err error
+		if sourceFileStat, err = srcFS.Stat(src); err != nil {
+			return err
+		}
+	}
+
+	// make sure its a file and not something else (directory)
+	if !sourceFileStat.Mode().IsRegular() {
+		return nil
+	}
+
+	// try to open source file for read
+	source, err := srcFS.Open(src)
+	if err != nil {
+		return err
+	}
+	// make sure to close file before end of context
+	defer source.Close()
+
+	// the current info of a known source file is read from the opened file, which takes no lookup of its path
+	if options.srcFile != nil {
+		if opened, ok := source.(interface{ Stat() (os.FileInfo, error) }); ok {
+			sourceFileStat, err = opened.Stat()
+		} else {
+			sourceFileStat, err = srcFS.Stat(src)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// resuming reads the source from the offset, which is supported by source files that can be read at any offset only (as local ones)
+	seekableSource, seekable := source.(seekableFile)
+	if options.resumeOffset > 0 && !seekable {
+		return errors.New("Source file can not be read from an offset, so its copy can not be resumed")
+	}
+
+	fsys := options.destination
+	if fsys == nil {
+		fsys = localFS{}
+	}
+
+	// try to create dest file (when resuming, its existing contents are kept, which is supported by local destinations only)
+	var destination destinationFile
+	if options.resumeOffset > 0 {
+		reopenable, ok := fsys.(reopenableFS)
+		if !ok {
+			return errors.New("Destination file can not be reopened, so its copy can not be resumed")
+		}
+		if destination, err = reopenable.OpenExisting(dst); err != nil {
+			return err
+		}
+	} else if destination, err = fsys.Create(dst, sourceFileStat); err != nil {
+		return err
+	}
+	// make sure to close file before end of context
+	defer destination.Close()
+
+	// when resuming, continue both files from the offset
+	if options.resumeOffset > 0 {
+		if _, err := seekableSource.Seek(options.resumeOffset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := destination.Seek(options.resumeOffset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	remaining := sourceFileStat.Size() - options.resumeOffset
+	// a large file is copied by concurrent streams, each writing its own chunks
+	chunked := isChunkedCopy(source, destination, remaining, options)
+
+	// allocate the whole file before the copy, so concurrent copies do not fragment the destination (a file of another file system, e.g. an
+	// uploaded object, is written as it is). a chunked copy writes into a preallocated file anyway
+	preallocatedFile, preallocated := destination.(*os.File)
+	preallocated = preallocated && (options.preallocate || chunked) && remaining > 0
+	if preallocated {
+		if err := preallocateFile(preallocatedFile, sourceFileStat.Size()); err != nil {
+			destination.Close()
+			if !options.keepPartial {
+				fsys.Remove(dst)
+			}
+			return err
+		}
+	}
+
+	// hide the files behind plain reader and writer, otherwise the copy is delegated to the files, which allocate a buffer of their own
+	var reader io.Reader = struct{ io.Reader }{source}
+	writer := struct{ io.Writer }{destination}
+
+	// when the bandwidth is limited, read the source in throttled chunks
+	if options.limiter != nil {
+		reader = &throttledReader{reader: source, limiter: options.limiter}
+	}
+
+	// report the progress of a large file periodically, since its copy takes a while
+	var progress *progressReader
+	var stopProgress func(finished bool)
+	if options.logger != nil && remaining >= options.progressThreshold {
+		progress = &progressReader{reader: reader}
+		reader = progress
+
+		stopProgress = reportProgress(options.logger, src, remaining, progress)
+	}
+
+	// when verifying (or when requested), hash the source contents while they are copied, so the source is read once
+	srcHash := options.hash
+	if options.verify && srcHash == nil {
+		srcHash = newHash(options.hashAlgorithm)
+	}
+	if srcHash != nil {
+		reader = io.TeeReader(reader, srcHash)
+
+		// when resuming, the source contents before the offset are hashed too (the whole written file is compared against them)
+		if options.resumeOffset > 0 {
+			if _, err := io.Copy(srcHash, io.NewSectionReader(seekableSource, 0, options.resumeOffset)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// count the transferred bytes, so a stalled copy is abandoned
+	if options.transfer != nil {
+		options.transfer.reader = reader
+		reader = options.transfer
+	}
+
+	// stop between chunks once the copy is interrupted, rather than when the whole file is copied
+	reader = &contextReader{ctx: ctx, reader: reader}
+
+	// get a copy buffer from the pool, and return it once done
+	buffers := options.buffers
+	if buffers == nil {
+		buffers = defaultBufferPool
+	}
+	buffer := buffers.Get().(*[]byte)
+	defer buffers.Put(buffer)
+
+	// copy src binary contents to dst (the chunks of a chunked copy are read through readers of their own)
+	var written int64
+	var chunkHashes [][]byte
+	if chunked {
+		written, chunkHashes, err = copyChunks(ctx, source.(*os.File), preallocatedFile, remaining, options, progress)
+	} else {
+		written, err = io.CopyBuffer(writer, reader, *buffer)
+	}
+	if options.transfer != nil {
+		close(options.transfer.ended)
+	}
+	if stopProgress != nil {
+		stopProgress(err == nil)
+	}
+	// whether the destination file holds wrong contents, rather than just being incomplete
+	mismatch := false
+	// a copy which ended short (e.g. the source shrank meanwhile, or the copy failed) cuts off the preallocated tail, so a partial file kept
+	// to resume the copy holds the copied contents only
+	if preallocated && written < remaining {
+		if truncateErr := preallocatedFile.Truncate(options.resumeOffset + written); truncateErr != nil {
+			mismatch = true
+		}
+	}
+	if err == nil && written != remaining {
+		// make sure all bytes were written
+		err = fmt.Errorf("written != sourceFileStat.Size(); %v != %v", options.resumeOffset+written, sourceFileStat.Size())
+	}
+	if err == nil && options.syncToDisk {
+		// make sure the contents were flushed to stable storage
+		err = destination.Sync()
+	}
+	if err == nil {
+		// make sure the file was closed properly (the deferred close result is ignored)
+		err = destination.Close()
+	}
+	if err == nil && options.verify && chunked {
+		// re-read the chunks of the written file, and make sure each matches its source contents
+		mismatch, err = verifyChunks(ctx, fsys, dst, remaining, chunkHashes, options)
+	} else if err == nil && options.verify {
+		// re-read the written file, and make sure it matches the source contents
+		var destHash []byte
+		if destHash, err = hashFile(fsys, dst, options.hashAlgorithm); err == nil && !bytes.Equal(destHash, srcHash.Sum(nil)) {
+			err = fmt.Errorf("verification failed, contents of '%s' differ from the source", dst)
+			mismatch = true
+		}
+	}
+	if err == nil && chunked && options.hash != nil {
+		// the contents of a chunked copy were written out of order, so they are hashed by reading the written file
+		err = hashWrittenFile(options.hash, fsys, dst)
+	}
+	if err != nil {
+		// a file whose contents are stored once it is closed is aborted instead, so the partial contents are never stored
+		if file, ok := destination.(abortableFile); ok {
+			file.Abort()
+		} else {
+			destination.Close()
+		}
+
+		// remove the partial destination file, so a truncated file never survives the failure (unless it is kept to resume the copy)
+		if !options.keepPartial || mismatch {
+			fsys.Remove(dst)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// deleteFile removes the destination file, logged as the given operation (e.g. "Remove")
+func deleteFile(ctx context.Context, configs Config, stats *iterationStats, file os.FileInfo, path string, operation string) error {
+	// an operation queued before termination was requested is not started
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// in dry run mode, only report the file would be removed
+	if configs.General.DryRun {
+		stats.addDeleted(path)
+
+		if file.IsDir() {
+			configs.General.logger.Info("WOULD "+operation, "path", path)
+		} else {
+			configs.General.logger.Info("WOULD "+operation, "path", path, "bytes", file.Size())
+		}
+		return nil
+	}
+
+	// the file could be removed in the meantime (e.g. along with its parent directory, or by another process), which is the requested outcome anyway
+	if _, err := configs.General.destination.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	// the path is no longer written by the mirror, so it can not drift, and it is no longer listed by the manifest
+	configs.General.written.forget(path)
+	configs.General.manifests.forget(configs, path)
+
+	// when backups are requested, the file is moved into the backup directory instead of being removed
+	if len(configs.General.BackupDirectory) > 0 {
+		if err := backupFile(configs, path); err != nil {
+			return err
+		}
+	} else if len(configs.General.QuarantineDirectory) > 0 {
+		// when quarantine is requested, the file is moved into the quarantine directory, where it is kept for the retention
+		quarantinePath, err := quarantineFile(configs, path)
+		if err != nil {
+			return err
+		}
+
+		stats.addDeleted(path)
+
+		configs.General.logger.Info("Quarantine", "path", path, "quarantine", quarantinePath)
+		emitEvent(configs, eventActionRemove, path, 0, 0, nil)
+		return nil
+	} else if configs.General.DeleteMode == deleteModeTrash {
+		// move the file to the trash, falling back to permanent removal
+		trashed, err := trashFile(configs.General.logger, file, path)
+		if err != nil {
+			return err
+		}
+
+		if trashed {
+			stats.addDeleted(path)
+
+			configs.General.logger.Info("Trash", "path", path)
+			emitEvent(configs, eventActionRemove, path, 0, 0, nil)
+			return nil
+		}
+	} else if err := removePath(configs.General.destination, file, path); err != nil {
+		return err
+	}
+
+	stats.addDeleted(path)
+
+	configs.General.logger.Info(operation, "path", path)
+	emitEvent(configs, eventActionRemove, path, 0, 0, nil)
+	return nil
+}
+
+// logFilterDecision logs the decision about a filtered path, along with the pattern which excludes it (if any)
+func logFilterDecision(logger *slog.Logger, decision string, path string, reason string, pattern string) {
+	if len(pattern) > 0 {
+		logger.Debug(decision, "path", path, "reason", reason, "pattern", pattern)
+	} else {
+		logger.Debug(decision, "path", path, "reason", reason)
+	}
+}
+
+// contextReader reads from the underlying reader until the context is done, so a long copy can be interrupted
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (reader *contextReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return reader.reader.Read(p)
+}
+
+func getDirFiles(logger *slog.Logger, fsys readableFS, srcDir string, followSymlinks bool, scope pathScope) map[string]os.FileInfo {
+	// walk into symlinks only when requested
+	if followSymlinks {
+		return getFollowedDirFiles(logger, fsys, srcDir, scope)
+	}
+
+	// create a container for files
+	files := make(map[string]os.FileInfo)
+	// try to get all directory files (including subdirs or subfiles)
+	walkFS(fsys, srcDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			addUnreadableFile(logger, srcDir, path, getEntryInfo(entry), err, files)
+			return nil
+		}
+
+		// ignore root path dir
+		if srcDir == path {
+			return nil
+		}
+
+		// paths out of scope are not walked at all (so their info is never read)
+		relativePath := getRelativePath(srcDir, path)
+		if !scope.contains(relativePath) {
+			return skipWalkedPath(entry)
+		}
+
+		// add file to container
+		info, err := entry.Info()
+		if err != nil {
+			addUnreadableFile(logger, srcDir, path, nil, err, files)
+			return nil
+		}
+		files[relativePath] = info
+
+		// a directory whose contents are out of scope is mirrored itself only
+		if info.IsDir() && !scope.isWalked(relativePath) {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	return files
+}
+
+// skipWalkedPath returns the result of a walk function which skips the entry, along with its contents if it is a directory
+func skipWalkedPath(entry fs.DirEntry) error {
+	if entry.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// getEntryInfo returns the info of a walked entry, or nil if it is unknown (or can not be read)
+func getEntryInfo(entry fs.DirEntry) os.FileInfo {
+	if entry == nil {
+		return nil
+	}
+
+	info, _ := entry.Info()
+	return info
+}
+
+// addUnreadableFile marks an entry which could not be read (e.g. permission denied, or removed during the scan) in the container, so its counterpart is left alone
+func addUnreadableFile(logger *slog.Logger, rootDir string, path string, info os.FileInfo, err error, files map[string]os.FileInfo) {
+	// a missing root directory is an empty tree (e.g. a destination directory which was not created yet)
+	if path == rootDir && errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+
+	logger.Warn("Skip", "path", path, "reason", "unreadable", "error", err)
+
+	// the root directory is marked by an empty relative path
+	files[getRelativePath(rootDir, path)] = unreadableFile{info: info}
+}
+
+func getRelativePath(rootDir string, path string) string {
+	// get relative file path from the root dir, the root dir itself is marked by an empty relative path
+	relativePath, err := filepath.Rel(rootDir, path)
+	if err != nil {
+		// the path can not be made relative to the root dir (e.g. only one of them is absolute), so the root dir is removed from its start
+		return strings.TrimLeft(strings.TrimPrefix(path, rootDir), string(filepath.Separator))
+	}
+	if relativePath == "." {
+		return ""
+	}
+	return relativePath
+}
@@ -0,0 +1,115 @@
+package mirror
+
+import (
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGetRelativePath(t *testing.T) {
+	tests := []struct {
+		rootDir  string
+		path     string
+		expected string
+	}{
+		{rootDir: "/data/src", path: "/data/src/a.txt", expected: "a.txt"},
+		{rootDir: "/data/src/", path: "/data/src/a.txt", expected: "a.txt"},
+		{rootDir: "/data/src//", path: "/data/src/dir/a.txt", expected: filepath.Join("dir", "a.txt")},
+		{rootDir: "/data/other/../src", path: "/data/src/dir/a.txt", expected: filepath.Join("dir", "a.txt")},
+		{rootDir: "/data/src", path: "/data/src/../src/a.txt", expected: "a.txt"},
+		// the root appears again further in the path
+		{rootDir: "/data/src", path: "/data/src/data/src/a.txt", expected: filepath.Join("data", "src", "a.txt")},
+		// the root itself
+		{rootDir: "/data/src", path: "/data/src", expected: ""},
+		{rootDir: "/data/src/", path: "/data/src", expected: ""},
+	}
+
+	for _, test := range tests {
+		rootDir, path := filepath.FromSlash(test.rootDir), filepath.FromSlash(test.path)
+		if relativePath := getRelativePath(rootDir, path); relativePath != test.expected {
+			t.Errorf("getRelativePath(%q, %q) = %q, expected %q", rootDir, path, relativePath, test.expected)
+		}
+	}
+}
+
+func TestGetDirFilesOfConfiguredRoot(t *testing.T) {
+	root := t.TempDir()
+	source := filepath.Join(root, "source")
+	writeTestFile(t, filepath.Join(source, "a.txt"), "a")
+	writeTestFile(t, filepath.Join(source, "source", "b.txt"), "b")
+
+	expected := []string{"a.txt", "source", filepath.Join("source", "b.txt")}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// the root is configured with a trailing separator, or with dot segments, and is normalized as a configuration is
+	for _, configured := range []string{source, source + string(filepath.Separator), source + "/", filepath.Join(root, "other") + "/../source/."} {
+		files := getDirFiles(logger, localFS{}, normalizeDirectory(configured), false, pathScope{})
+
+		relativePaths := make([]string, 0, len(files))
+		for relativePath := range files {
+			relativePaths = append(relativePaths, relativePath)
+		}
+		sort.Strings(relativePaths)
+
+		if !reflect.DeepEqual(relativePaths, expected) {
+			t.Errorf("files of '%s' are %q, expected %q", configured, relativePaths, expected)
+		}
+	}
+}
+
+func TestGetDestFilesSkipsInternalPaths(t *testing.T) {
+	fsys := newMemFS(memDestination)
+	fsys.writeFile("/dst/a.txt", "a", modTime)
+	fsys.writeFile("/dst/backup/a.txt", "old", modTime)
+	fsys.writeFile("/dst/dir/b.txt", "b", modTime)
+	fsys.writeFile("/dst/dir/versions/b.txt", "old", modTime)
+	// listing the internal directories fails, so walking them would report them as unreadable
+	fsys.fail(memOpReadDir, "/dst/backup", fs.ErrPermission)
+	fsys.fail(memOpReadDir, "/dst/dir/versions", fs.ErrPermission)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	internalPaths := []string{"backup", filepath.Join("dir", "versions")}
+	getRelativePaths := func(files map[string]os.FileInfo) []string {
+		relativePaths := make([]string, 0, len(files))
+		for relativePath := range files {
+			relativePaths = append(relativePaths, relativePath)
+		}
+		sort.Strings(relativePaths)
+		return relativePaths
+	}
+
+	// the internal directories are neither walked nor added, by a scan of the whole tree
+	expected := []string{"a.txt", "dir", filepath.Join("dir", "b.txt")}
+	if relativePaths := getRelativePaths(getDestFiles(logger, fsys, memDestination, pathScope{}, internalPaths)); !reflect.DeepEqual(relativePaths, expected) {
+		t.Errorf("destination files are %q, expected %q", relativePaths, expected)
+	}
+
+	// nor by a scan of the subtree of a path
+	files := make(map[string]os.FileInfo)
+	addDestPathFiles(logger, fsys, memDestination, "dir", pathScope{}, internalPaths, files)
+	addDestPathFiles(logger, fsys, memDestination, "backup", pathScope{}, internalPaths, files)
+	expected = []string{"dir", filepath.Join("dir", "b.txt")}
+	if relativePaths := getRelativePaths(files); !reflect.DeepEqual(relativePaths, expected) {
+		t.Errorf("destination files of the paths are %q, expected %q", relativePaths, expected)
+	}
+}
+
+func BenchmarkGetDirFiles(b *testing.B) {
+	// a tree of 100k files, which is walked by entries and reads the info of every file in scope
+	source := b.TempDir()
+	writeBenchmarkTree(b, 1000, 100, source)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if files := getDirFiles(logger, localFS{}, source, false, pathScope{}); len(files) != 1000*100+1000+100 {
+			b.Fatalf("walk found %d files and directories", len(files))
+		}
+	}
+}
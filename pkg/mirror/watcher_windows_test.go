@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package mirror
+
+import (
+	"testing"
+)
+
+func TestGetRelativeWindowsPath(t *testing.T) {
+	tests := []struct {
+		rootDir  string
+		path     string
+		expected string
+	}{
+		{rootDir: `C:\data\src`, path: `C:\data\src\dir\a.txt`, expected: `dir\a.txt`},
+		{rootDir: `C:\data\src\`, path: `C:\data\src\dir\a.txt`, expected: `dir\a.txt`},
+		// mixed separators, and dot segments
+		{rootDir: `C:/data\src/`, path: `C:\data\src\dir\a.txt`, expected: `dir\a.txt`},
+		{rootDir: `C:\data\other\..\src`, path: `C:\data/src/dir/a.txt`, expected: `dir\a.txt`},
+		// the extended-length form of a configured root
+		{rootDir: `\\?\C:\data\src`, path: `\\?\C:\data\src\dir\a.txt`, expected: `dir\a.txt`},
+	}
+
+	for _, test := range tests {
+		if relativePath := getRelativePath(test.rootDir, test.path); relativePath != test.expected {
+			t.Errorf("getRelativePath(%q, %q) = %q, expected %q", test.rootDir, test.path, relativePath, test.expected)
+		}
+	}
+}
+
+func TestNormalizeMixedSeparators(t *testing.T) {
+	if normalized := normalizeDirectory(`C:/data\src/`); normalized != `\\?\C:\data\src` {
+		t.Errorf("normalizeDirectory() = %q, expected %q", normalized, `\\?\C:\data\src`)
+	}
+}
@@ -0,0 +1,195 @@
+package mirror
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	webhookEventError            = "error"
+	webhookEventDelete           = "delete"
+	webhookEventIterationSummary = "iterationSummary"
+	webhookEventJobFailed        = "jobFailed"
+	// posted while planned, rather than once the iteration ended
+	webhookEventInsufficientSpace = "insufficientSpace"
+)
+
+const (
+	// maximum count of paths in a webhook payload
+	webhookMaxPaths = 100
+	// count of attempts to post a webhook payload, before it is dropped
+	webhookAttempts = 3
+)
+
+// pending webhook posts, which should be finished before the process exits
+var webhooksWg sync.WaitGroup
+
+// client used to post webhooks, with a timeout so a stuck receiver does not hold on to the payloads
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookPayload is the JSON body posted to the webhook
+type webhookPayload struct {
+	Job         string           `json:"job"`
+	Event       string           `json:"event"`
+	Time        time.Time        `json:"time"`
+	Source      string           `json:"source"`
+	Destination string           `json:"destination"`
+	DryRun      bool             `json:"dryRun"`
+	Paths       []string         `json:"paths,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+	Counts      map[string]int64 `json:"counts"`
+}
+
+// notifyIteration posts the enabled webhook events of an ended iteration of a destination
+func notifyIteration(configs Config, stats *iterationStats) {
+	// nothing to do unless requested
+	if len(configs.General.WebhookURL) < 1 {
+		return
+	}
+
+	counts := map[string]int64{
+		"copied":           stats.filesCopied,
+		"copiedBytes":      stats.bytesCopied,
+		"moved":            stats.filesMoved,
+		"deleted":          stats.filesDeleted,
+		"deletionsSkipped": stats.deletionsSkipped,
+		"failed":           stats.filesFailed,
+	}
+
+	for _, event := range configs.General.WebhookEvents {
+		var paths []string
+
+		switch event {
+		case webhookEventError:
+			if stats.filesFailed < 1 {
+				continue
+			}
+			paths = stats.failedPaths
+		case webhookEventDelete:
+			if stats.filesDeleted < 1 && stats.deletionsSkipped < 1 {
+				continue
+			}
+			paths = stats.deletedPaths
+		case webhookEventIterationSummary:
+			if !stats.hasChanges() {
+				continue
+			}
+		case webhookEventJobFailed, webhookEventInsufficientSpace:
+			// posted once the job fails (or the copies do not fit the destination), rather than by an iteration
+			continue
+		}
+
+		postWebhook(configs, webhookPayload{
+			Job:         getJobName(configs),
+			Event:       event,
+			Time:        time.Now(),
+			Source:      configs.General.SourceDirectory,
+			Destination: configs.General.DestinationDirectory,
+			DryRun:      configs.General.DryRun,
+			Paths:       paths,
+			Counts:      counts,
+		})
+	}
+}
+
+// notifyJobFailed posts the failure of the job, if requested
+func notifyJobFailed(configs Config, reason string) {
+	if len(configs.General.WebhookURL) < 1 || !slices.Contains(configs.General.WebhookEvents, webhookEventJobFailed) {
+		return
+	}
+
+	postWebhook(configs, webhookPayload{
+		Job:         getJobName(configs),
+		Event:       webhookEventJobFailed,
+		Time:        time.Now(),
+		Source:      configs.General.SourceDirectory,
+		Destination: configs.General.DestinationDirectory,
+		DryRun:      configs.General.DryRun,
+		Reason:      reason,
+		Counts:      map[string]int64{},
+	})
+}
+
+// notifyInsufficientSpace posts that the planned copies of the destination do not fit its free space, if requested
+func notifyInsufficientSpace(configs Config, required int64, free int64, skipped int) {
+	if len(configs.General.WebhookURL) < 1 || !slices.Contains(configs.General.WebhookEvents, webhookEventInsufficientSpace) {
+		return
+	}
+
+	postWebhook(configs, webhookPayload{
+		Job:         getJobName(configs),
+		Event:       webhookEventInsufficientSpace,
+		Time:        time.Now(),
+		Source:      configs.General.SourceDirectory,
+		Destination: configs.General.DestinationDirectory,
+		DryRun:      configs.General.DryRun,
+		Reason:      fmt.Sprintf("%d bytes are required, %d bytes are free", required, free),
+		Counts:      map[string]int64{"requiredBytes": required, "freeBytes": free, "skipped": int64(skipped)},
+	})
+}
+
+// postWebhook posts the payload in the background (retrying on failure), so the mirror is never blocked by the receiver
+func postWebhook(configs Config, payload webhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		configs.General.logger.Warn("Webhook dropped", "event", payload.Event, "error", err)
+		return
+	}
+
+	webhooksWg.Add(1)
+	go func() {
+		defer webhooksWg.Done()
+
+		delay := time.Second
+
+		for attempt := 1; ; attempt++ {
+			err := sendWebhook(configs, body)
+			if err == nil {
+				return
+			}
+
+			if attempt >= webhookAttempts {
+				configs.General.logger.Warn("Webhook dropped", "event", payload.Event, "error", err)
+				return
+			}
+
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}()
+}
+
+func sendWebhook(configs Config, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, configs.General.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	// sign the body, so the receiver can authenticate the sender
+	if len(configs.General.WebhookSecret) > 0 {
+		mac := hmac.New(sha256.New, []byte(configs.General.WebhookSecret))
+		mac.Write(body)
+		request.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status '%s'", response.Status)
+	}
+
+	return nil
+}
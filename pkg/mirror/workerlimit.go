@@ -0,0 +1,72 @@
+package mirror
+
+import (
+	"sync"
+)
+
+// workerLimit limits the count of operations running at once across all jobs of a supervisor, in addition to the workers limit of every
+// job (so jobs mirroring into the same disk do not thrash it). a nil limit lets every operation start
+type workerLimit struct {
+	mutex sync.Mutex
+	freed *sync.Cond
+	// 0 when unlimited
+	limit  int
+	active int
+}
+
+func newWorkerLimit() *workerLimit {
+	limit := &workerLimit{}
+	limit.freed = sync.NewCond(&limit.mutex)
+
+	return limit
+}
+
+// setLimit changes the limit (0 to remove it), operations which are running already keep running
+func (limit *workerLimit) setLimit(size int) {
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	limit.limit = size
+	limit.freed.Broadcast()
+}
+
+// acquire blocks until an operation can start within the limit, and counts it as running. a freed place goes to any of the waiting
+// operations, not necessarily the one which waited the longest
+func (limit *workerLimit) acquire() {
+	if limit == nil {
+		return
+	}
+
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	for limit.limit > 0 && limit.active >= limit.limit {
+		limit.freed.Wait()
+	}
+	limit.active++
+}
+
+// release counts the operation as ended, letting the next waiting operation start
+func (limit *workerLimit) release() {
+	if limit == nil {
+		return
+	}
+
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	limit.active--
+	limit.freed.Signal()
+}
+
+// getUsage returns the count of running operations, and the limit (0 when unlimited)
+func (limit *workerLimit) getUsage() (int, int) {
+	if limit == nil {
+		return 0, 0
+	}
+
+	limit.mutex.Lock()
+	defer limit.mutex.Unlock()
+
+	return limit.active, limit.limit
+}
@@ -0,0 +1,94 @@
+package mirror
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerLimit(t *testing.T) {
+	limit := newWorkerLimit()
+	limit.setLimit(2)
+
+	// operations beyond the limit wait until running ones end
+	var mutex sync.Mutex
+	running, highest := 0, 0
+	var done sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+
+			limit.acquire()
+			defer limit.release()
+
+			mutex.Lock()
+			running++
+			highest = max(highest, running)
+			mutex.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}()
+	}
+	done.Wait()
+
+	if highest > 2 {
+		t.Errorf("%d operations ran at once, expected at most 2", highest)
+	}
+	if active, size := limit.getUsage(); active != 0 || size != 2 {
+		t.Errorf("getUsage() = %d, %d, expected 0, 2", active, size)
+	}
+
+	// removing the limit lets waiting operations start
+	limit.acquire()
+	limit.acquire()
+	started := make(chan struct{})
+	go func() {
+		limit.acquire()
+		close(started)
+	}()
+	limit.setLimit(0)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("operation still waits once the limit was removed")
+	}
+	if active, size := limit.getUsage(); active != 3 || size != 0 {
+		t.Errorf("getUsage() = %d, %d, expected 3, 0", active, size)
+	}
+
+	// jobs which are not run by a supervisor are not limited
+	var unlimited *workerLimit
+	unlimited.acquire()
+	unlimited.release()
+	if active, size := unlimited.getUsage(); active != 0 || size != 0 {
+		t.Errorf("getUsage() of no limit = %d, %d, expected 0, 0", active, size)
+	}
+}
+
+func TestJobsShareTotalWorkers(t *testing.T) {
+	limit := newWorkerLimit()
+	limit.setLimit(1)
+
+	mirror, fsys := newMemMirror(t, func(config *Config) {
+		config.General.totalWorkers = limit
+	})
+	if mirror.configs.General.totalWorkers != limit {
+		t.Fatal("job does not keep the limit of its supervisor")
+	}
+
+	// the operations of the job take their places within the limit, and free them once they end
+	for i := 0; i < 10; i++ {
+		fsys.writeFile("/src/"+string(rune('a'+i))+".txt", "a", modTime)
+	}
+	if summary := mustSyncOnce(t, mirror); summary.FilesCopied != 10 {
+		t.Errorf("SyncOnce() copied %d files, expected 10", summary.FilesCopied)
+	}
+	if active, _ := limit.getUsage(); active != 0 {
+		t.Errorf("%d places are held once the iteration ended, expected none", active)
+	}
+}
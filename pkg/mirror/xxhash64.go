@@ -0,0 +1,130 @@
+package mirror
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// primes of the XXH64 algorithm
+const (
+	xxhashPrime1 uint64 = 11400714785074694791
+	xxhashPrime2 uint64 = 14029467366897019727
+	xxhashPrime3 uint64 = 1609587929392839161
+	xxhashPrime4 uint64 = 9650029242287828579
+	xxhashPrime5 uint64 = 2870177450012600261
+)
+
+// size of the stripe XXH64 consumes at once, by four lanes of 8 bytes
+const xxhashStripeSize = 32
+
+// xxhash64 is the XXH64 hash (with a zero seed), a fast non-cryptographic hash. its sum is the 64-bit value in big-endian order
+type xxhash64 struct {
+	lanes [4]uint64
+	// contents which do not fill a stripe yet
+	buffer   [xxhashStripeSize]byte
+	buffered int
+	total    uint64
+}
+
+func newXXHash64() hash.Hash64 {
+	digest := &xxhash64{}
+	digest.Reset()
+	return digest
+}
+
+func (digest *xxhash64) Reset() {
+	// the initial lanes wrap around, which constants can not
+	prime1, prime2 := xxhashPrime1, xxhashPrime2
+	digest.lanes = [4]uint64{prime1 + prime2, prime2, 0, -prime1}
+	digest.buffered = 0
+	digest.total = 0
+}
+
+func (digest *xxhash64) Size() int {
+	return 8
+}
+
+func (digest *xxhash64) BlockSize() int {
+	return xxhashStripeSize
+}
+
+func (digest *xxhash64) Write(data []byte) (int, error) {
+	n := len(data)
+	digest.total += uint64(n)
+
+	// fill the buffered stripe first
+	if digest.buffered > 0 {
+		copied := copy(digest.buffer[digest.buffered:], data)
+		digest.buffered += copied
+		data = data[copied:]
+		if digest.buffered < xxhashStripeSize {
+			return n, nil
+		}
+		digest.consumeStripe(digest.buffer[:])
+		digest.buffered = 0
+	}
+
+	for ; len(data) >= xxhashStripeSize; data = data[xxhashStripeSize:] {
+		digest.consumeStripe(data)
+	}
+	digest.buffered = copy(digest.buffer[:], data)
+
+	return n, nil
+}
+
+func (digest *xxhash64) consumeStripe(stripe []byte) {
+	for i := range digest.lanes {
+		digest.lanes[i] = xxhashRound(digest.lanes[i], binary.LittleEndian.Uint64(stripe[i*8:]))
+	}
+}
+
+func (digest *xxhash64) Sum64() uint64 {
+	var sum uint64
+	if digest.total >= xxhashStripeSize {
+		lanes := digest.lanes
+		sum = bits.RotateLeft64(lanes[0], 1) + bits.RotateLeft64(lanes[1], 7) + bits.RotateLeft64(lanes[2], 12) + bits.RotateLeft64(lanes[3], 18)
+		for _, lane := range lanes {
+			sum ^= xxhashRound(0, lane)
+			sum = sum*xxhashPrime1 + xxhashPrime4
+		}
+	} else {
+		sum = xxhashPrime5
+	}
+	sum += digest.total
+
+	// mix in the contents which did not fill a stripe
+	tail := digest.buffer[:digest.buffered]
+	for ; len(tail) >= 8; tail = tail[8:] {
+		sum ^= xxhashRound(0, binary.LittleEndian.Uint64(tail))
+		sum = bits.RotateLeft64(sum, 27)*xxhashPrime1 + xxhashPrime4
+	}
+	if len(tail) >= 4 {
+		sum ^= uint64(binary.LittleEndian.Uint32(tail)) * xxhashPrime1
+		sum = bits.RotateLeft64(sum, 23)*xxhashPrime2 + xxhashPrime3
+		tail = tail[4:]
+	}
+	for _, b := range tail {
+		sum ^= uint64(b) * xxhashPrime5
+		sum = bits.RotateLeft64(sum, 11) * xxhashPrime1
+	}
+
+	// avalanche the bits
+	sum ^= sum >> 33
+	sum *= xxhashPrime2
+	sum ^= sum >> 29
+	sum *= xxhashPrime3
+	sum ^= sum >> 32
+
+	return sum
+}
+
+func (digest *xxhash64) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, digest.Sum64())
+}
+
+func xxhashRound(lane uint64, input uint64) uint64 {
+	lane += input * xxhashPrime2
+	lane = bits.RotateLeft64(lane, 31)
+	return lane * xxhashPrime1
+}
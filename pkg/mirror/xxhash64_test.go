@@ -0,0 +1,45 @@
+package mirror
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestXXHash64Vectors(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		expected uint64
+	}{
+		// the examples of the reference implementation
+		{input: []byte(""), expected: 0xef46db3751d8e999},
+		{input: []byte("a"), expected: 0xd24ec4f1a98c6e5b},
+		{input: []byte("abc"), expected: 0x44bc2cf5ad770999},
+		{input: []byte("Nobody inspects the spammish repetition"), expected: 0xfbcea83c8a378bf1},
+		// around the size of a stripe and of several of them, so the lanes, the remaining words and the remaining bytes are all hashed
+		{input: getHashInput(1), expected: 0xe934a84adb052768},
+		{input: getHashInput(3), expected: 0xe5c7bb4533bc65dd},
+		{input: getHashInput(31), expected: 0xc346d2b59b4d8ee1},
+		{input: getHashInput(32), expected: 0xcbf59c5116ff32b4},
+		{input: getHashInput(33), expected: 0x0c535d1acafb8ead},
+		{input: getHashInput(63), expected: 0xe26aa9e2a95f8e4f},
+		{input: getHashInput(64), expected: 0xf7c67301db6713f0},
+		{input: getHashInput(100), expected: 0x6ac1e58032166597},
+		{input: getHashInput(1000), expected: 0xf306f04aa88b54d3},
+		{input: getHashInput(4096), expected: 0x122a8c8d994ad3ec},
+	}
+
+	digest := newXXHash64()
+	for _, test := range tests {
+		for _, size := range []int{1, 5, xxhashStripeSize, len(test.input) + 1} {
+			hashInPieces(digest, test.input, size)
+
+			if sum := digest.Sum64(); sum != test.expected {
+				t.Errorf("XXH64 of %d bytes written in pieces of %d bytes is %016x, expected %016x", len(test.input), size, sum, test.expected)
+			}
+			// the sum is the value in big-endian order
+			if sum := digest.Sum(nil); binary.BigEndian.Uint64(sum) != test.expected {
+				t.Errorf("sum of %d bytes is %x, expected %016x", len(test.input), sum, test.expected)
+			}
+		}
+	}
+}
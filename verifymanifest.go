@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go/mirror_backup/pkg/mirror"
+)
+
+// runVerifyManifest hashes the files listed by the manifests of the destination directories again, and reports the files which do not
+// match them, without touching anything (the source is not needed). the exit code is 0 if all files match, 1 if any does not, or 2 if a
+// manifest could not be read
+func runVerifyManifest(args []string) int {
+	flags := flag.NewFlagSet("verify-manifest", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage:\n  %s verify-manifest <destination directory> [destination directory ...]\n", os.Args[0])
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	verified, mismatched := 0, 0
+	for _, directory := range flags.Args() {
+		count, mismatches, err := mirror.VerifyManifest(directory)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		for _, mismatch := range mismatches {
+			fmt.Printf("%-8s %s", mismatch.Status, filepath.Join(directory, filepath.FromSlash(mismatch.Path)))
+			if len(mismatch.Reason) > 0 {
+				fmt.Printf(" (%s)", mismatch.Reason)
+			}
+			fmt.Println()
+		}
+
+		verified += count
+		mismatched += len(mismatches)
+	}
+
+	if mismatched > 0 {
+		fmt.Printf("Verified %d files, mismatched %d\n", verified, mismatched)
+		return 1
+	}
+
+	fmt.Printf("Verified %d files, all match\n", verified)
+	return 0
+}